@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+)
+
+// Operator aliases. Some operators have an older spelling that still
+// reads the same: filter for where, yield for values, and summarize for
+// aggregate. The registry marks each older spelling with the name it is an
+// alias of, so hover on either side explains the pair, completion offers
+// the canonical name first, and a hint with a quick fix points out the
+// older spelling where a query uses it, keeping a workspace's queries to
+// one spelling. The hint is on unless initializationOptions turn it off.
+
+// Settings for operatorAliases in initializationOptions
+const (
+	aliasesCanonical = "canonical" // hint at older spellings, the default
+	aliasesOff       = "off"       // leave spellings alone
+)
+
+// aliasNote returns the paragraph a builtin's hover ends with when it is
+// one side of an alias pair, in style, or ""
+func aliasNote(b *Builtin, style docStyle) string {
+	quote := func(name string) string {
+		if style == docPlainText {
+			return name
+		}
+		return "`" + name + "`"
+	}
+	if b.AliasOf != "" {
+		return "\n\nAs an operator, " + quote(b.Name) + " is an older spelling of " +
+			quote(b.AliasOf) + ", which does the same and is preferred."
+	}
+	if b.Deprecated != "" {
+		return "\n\nDeprecated: write " + quote(b.Deprecated) + " instead."
+	}
+	if len(b.aliases) == 0 {
+		return ""
+	}
+	older := make([]string, len(b.aliases))
+	for i, name := range b.aliases {
+		older[i] = quote(name)
+	}
+	return "\n\nAs an operator, also spelled " + strings.Join(older, " or ") + ", an older spelling."
+}
+
+// aliasUse is an operator written in its older spelling
+type aliasUse struct {
+	rng       Range  // the operator's name
+	alias     string // as written
+	canonical string
+}
+
+// aliasUses returns the operators in text written in an older spelling.
+// The parser takes filter and yield for calls of a user op, so a query
+// that declares an op of that name is calling its own op, not an alias.
+func aliasUses(text string) []aliasUse {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	ops := declaredOps(symbolTableOf(text, tree))
+	var uses []aliasUse
+	add := func(rng Range, name string) {
+		if b := Builtins.Lookup(name); b != nil && b.AliasOf != "" && ops[name] == nil {
+			uses = append(uses, aliasUse{rng: rng, alias: name, canonical: b.AliasOf})
+		}
+	}
+	walkTree(tree, func(node map[string]interface{}) {
+		switch nodeKind(node) {
+		case "CallOp":
+			name, _ := node["name"].(map[string]interface{})
+			value, _ := name["name"].(string)
+			add(locRange(text, name["loc"]), value)
+		case "AggregateOp":
+			// summarize and aggregate parse alike, so the text tells them
+			// apart
+			start := locRange(text, node["loc"]).Start
+			offset, ok := offsetAt(text, start)
+			if !ok {
+				return
+			}
+			word := leadingWord(text[offset:])
+			add(Range{Start: start, End: positionAt(text, offset+len(word))}, word)
+		}
+	})
+	return uses
+}
+
+// leadingWord returns the identifier s starts with
+func leadingWord(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// aliasUses returns the older spellings in text whose canonical names the
+// targeted dialect has; one that targets an older version can't be
+// pointed at a newer spelling
+func (s *Server) aliasUses(text string) []aliasUse {
+	version := s.settings().DialectVersion
+	uses := aliasUses(text)
+	kept := uses[:0]
+	for _, use := range uses {
+		if Builtins.Lookup(use.canonical).inDialect(version) {
+			kept = append(kept, use)
+		}
+	}
+	return kept
+}
+
+// aliasDiagnostics hints at each operator in text written in an older
+// spelling
+func (s *Server) aliasDiagnostics(text string) []Diagnostic {
+	if s.settings().OperatorAliases == aliasesOff {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	for _, use := range s.aliasUses(text) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    use.rng,
+			Severity: DiagnosticSeverityHint,
+			Code:     "operator-alias",
+			Source:   "superdb-lsp",
+			Message:  s.messages.format("operator-alias", "alias", use.alias, "name", use.canonical),
+			Tags:     []int{DiagnosticTagDeprecated},
+		})
+	}
+	return diagnostics
+}
+
+// aliasFixes returns the quick fixes for the older spellings in text that
+// rng touches: one for each, and when text has more than one, one that
+// respells them all
+func (s *Server) aliasFixes(uri, text string, rng Range) []CodeAction {
+	if s.settings().OperatorAliases == aliasesOff {
+		return nil
+	}
+	uses := s.aliasUses(text)
+	var actions []CodeAction
+	for _, use := range uses {
+		if positionLess(rng.End, use.rng.Start) || positionLess(use.rng.End, rng.Start) {
+			continue
+		}
+		actions = append(actions, CodeAction{
+			Title:       "Use " + use.canonical + " instead of " + use.alias,
+			Kind:        CodeActionKindQuickFix,
+			IsPreferred: true,
+			Edit: &WorkspaceEdit{Changes: map[string][]TextEdit{
+				uri: {{Range: use.rng, NewText: use.canonical}},
+			}},
+		})
+	}
+	if len(actions) > 0 && len(uses) > 1 {
+		edits := make([]TextEdit, len(uses))
+		for i, use := range uses {
+			edits[i] = TextEdit{Range: use.rng, NewText: use.canonical}
+		}
+		actions = append(actions, CodeAction{
+			Title: "Use canonical operator names throughout",
+			Kind:  CodeActionKindQuickFix,
+			Edit:  &WorkspaceEdit{Changes: map[string][]TextEdit{uri: edits}},
+		})
+	}
+	return actions
+}
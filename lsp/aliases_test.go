@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAliasHover(t *testing.T) {
+	yield := getHover("values 1 | yield this", Position{Line: 0, Character: 12}, docMarkdown)
+	if yield == nil || !strings.Contains(yield.Contents.Value, "`yield` is an older spelling of `values`") {
+		t.Errorf("Expected yield's hover to name values, got %+v", yield)
+	}
+	values := getHover("values 1", Position{Line: 0, Character: 2}, docPlainText)
+	if values == nil || !strings.Contains(values.Contents.Value, "also spelled yield") {
+		t.Errorf("Expected values' hover to name yield, got %+v", values)
+	}
+	head := getHover("values 1 | head 1", Position{Line: 0, Character: 12}, docMarkdown)
+	if head == nil || strings.Contains(head.Contents.Value, "spell") {
+		t.Errorf("Expected no alias note for head, got %+v", head)
+	}
+}
+
+func TestAliasCompletionSortsLast(t *testing.T) {
+	var values, yield *CompletionItem
+	items := getCompletions(context.Background(), "values 1 | ", Position{Line: 0, Character: 11})
+	for i := range items {
+		switch items[i].Label {
+		case "values":
+			values = &items[i]
+		case "yield":
+			yield = &items[i]
+		}
+	}
+	if values == nil || yield == nil {
+		t.Fatalf("Expected values and yield in completions")
+	}
+	if values.SortText >= yield.SortText {
+		t.Errorf("Expected yield to sort after values, got %q and %q", values.SortText, yield.SortText)
+	}
+	if !strings.Contains(yield.Detail, "older spelling of values") {
+		t.Errorf("Unexpected detail for yield: %q", yield.Detail)
+	}
+}
+
+func TestAliasUses(t *testing.T) {
+	text := "from logs | filter x > 1 | summarize count() | yield {n: count} | aggregate sum(x) | where true"
+	var got []string
+	for _, use := range aliasUses(text) {
+		got = append(got, use.alias+"->"+use.canonical+"@"+rangeText(text, use.rng))
+	}
+	want := "filter->where@filter summarize->aggregate@summarize yield->values@yield"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+
+	// A query that declares its own yield is calling it
+	if uses := aliasUses("op yield: ( pass )\nvalues 1 | yield"); len(uses) != 0 {
+		t.Errorf("Expected a declared op to be left alone, got %+v", uses)
+	}
+}
+
+func TestAliasDiagnosticsAndFixes(t *testing.T) {
+	s := NewServer()
+	text := "values 1 | yield this | summarize count()"
+	diags := s.aliasDiagnostics(text)
+	if len(diags) != 2 || diags[0].Code != "operator-alias" || diags[0].Severity != DiagnosticSeverityHint {
+		t.Fatalf("Expected two hints, got %+v", diags)
+	}
+	if diags[0].Message != "yield is an older spelling of values, which this workspace uses" {
+		t.Errorf("Unexpected message: %q", diags[0].Message)
+	}
+
+	actions := s.aliasFixes("file:///q.spq", text, diags[0].Range)
+	if len(actions) != 2 {
+		t.Fatalf("Expected a fix and a fix-all, got %+v", actions)
+	}
+	fix := actions[0]
+	if fix.Title != "Use values instead of yield" || fix.Kind != CodeActionKindQuickFix || !fix.IsPreferred {
+		t.Errorf("Unexpected fix: %+v", fix)
+	}
+	all := actions[1].Edit.Changes["file:///q.spq"]
+	if got := applyEdits(text, all); got != "values 1 | values this | aggregate count()" {
+		t.Errorf("Unexpected fix-all result: %q", got)
+	}
+
+	s.config.OperatorAliases = aliasesOff
+	if diags := s.aliasDiagnostics(text); len(diags) != 0 {
+		t.Errorf("Expected no hints when off, got %+v", diags)
+	}
+	if actions := s.aliasFixes("file:///q.spq", text, diags[0].Range); len(actions) != 0 {
+		t.Errorf("Expected no fixes when off, got %+v", actions)
+	}
+}
+
+func TestAliasQuickFixAction(t *testing.T) {
+	h := NewTestHelper()
+	opts := json.RawMessage(`{"operatorAliases": "off"}`)
+	uri := "file:///q.spq"
+	text := "values 1 | yield this"
+	h.openDocument(t, uri, text)
+
+	request := func() []CodeAction {
+		resp, err := h.ProcessRequest(2, "textDocument/codeAction", CodeActionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Range:        Range{Start: posOf(t, text, "yield", 1, 0), End: posOf(t, text, "yield", 1, 0)},
+			Context:      CodeActionContext{Only: []string{CodeActionKindQuickFix}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []CodeAction
+		data, _ := json.Marshal(resp.Result)
+		json.Unmarshal(data, &actions)
+		return actions
+	}
+	if actions := request(); len(actions) != 1 || actions[0].Title != "Use values instead of yield" {
+		t.Errorf("Expected the yield fix, got %+v", actions)
+	}
+
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatal(err)
+	}
+	if actions := request(); len(actions) != 0 {
+		t.Errorf("Expected no fixes once turned off, got %+v", actions)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// benchDocument is a multi-line query so line lookups have work to skip over
+var benchDocument = strings.Repeat("from test | where x > 5\n", 50) +
+	"from test | summarize count() by host | put y := replace(s, old, "
+
+func TestLookupDoesNotAllocate(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() {
+		if Builtins.Lookup("Count") == nil {
+			t.Fatal("Expected to find count")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expected Lookup to be allocation-free, got %v allocs", allocs)
+	}
+}
+
+func TestHoverDoesNotFormatPerRequest(t *testing.T) {
+	// One allocation for the returned *Hover; content comes from the registry
+	allocs := testing.AllocsPerRun(100, func() {
+		getHover(benchDocument, Position{Line: 50, Character: 24}, docMarkdown)
+	})
+	if allocs > 1 {
+		t.Errorf("Expected at most 1 alloc per hover, got %v", allocs)
+	}
+}
+
+func TestCompletionItemsPrebuilt(t *testing.T) {
+	b := Builtins.Lookup("ceil")
+	if b.item.InsertText != "ceil($1)" || b.item.Detail != b.sig.Label() {
+		t.Errorf("Unexpected prebuilt item: %+v", b.item)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Builtins.Lookup("Summarize")
+	}
+}
+
+func BenchmarkGetCompletions(b *testing.B) {
+	pos := Position{Line: 50, Character: 32}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getCompletions(context.Background(), benchDocument, pos)
+	}
+}
+
+func BenchmarkGetCompletionsGeneral(b *testing.B) {
+	pos := Position{Line: 0, Character: 12}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getCompletions(context.Background(), benchDocument, pos)
+	}
+}
+
+func BenchmarkGetHover(b *testing.B) {
+	pos := Position{Line: 50, Character: 24}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getHover(benchDocument, pos, docMarkdown)
+	}
+}
+
+func BenchmarkGetSignatureHelp(b *testing.B) {
+	pos := Position{Line: 50, Character: 66}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getSignatureHelp(context.Background(), benchDocument, pos, docMarkdown)
+	}
+}
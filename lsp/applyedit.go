@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Edits the server applies. A code action hands its edit to the client to
+// apply, but a command that changes many files, like
+// superdb.fixDeprecatedSyntax, asks the client to apply the edit with
+// workspace/applyEdit when it can. The client answers whether it did; an
+// edit it refused or failed to apply is logged and shown to the user.
+// Clients that can't be asked get the edit in the command's result.
+
+// applyEdit asks the client to apply edit, under label in its undo
+// history, and calls onResult, if not nil, with the client's answer
+func (s *Server) applyEdit(label string, edit WorkspaceEdit, onResult func(ApplyWorkspaceEditResult)) error {
+	params := ApplyWorkspaceEditParams{Label: label, Edit: edit}
+	return s.request("workspace/applyEdit", params, func(msg RPCMessage) {
+		var result ApplyWorkspaceEditResult
+		if msg.Error != nil {
+			result.FailureReason = msg.Error.Message
+		} else if data, err := json.Marshal(msg.Result); err == nil {
+			json.Unmarshal(data, &result)
+		}
+		if !result.Applied {
+			message := label + ": the edit wasn't applied"
+			if result.FailureReason != "" {
+				message += ": " + result.FailureReason
+			}
+			log.Print(message)
+			s.showMessage(MessageTypeWarning, message)
+		}
+		if onResult != nil {
+			onResult(result)
+		}
+	})
+}
+
+// fixDeprecatedSyntax respells the operators written in an older spelling
+// in every query under the workspace folders
+func (s *Server) fixDeprecatedSyntax(ctx context.Context, args []json.RawMessage) HandlerResult {
+	folders := s.workspaceFolders()
+	if len(folders) == 0 {
+		return failure(&RPCError{Code: RequestFailed, Message: "no workspace folder is open"})
+	}
+
+	result := FixDeprecatedResult{Files: []string{}, ReadOnly: []string{}}
+	changes := make(map[string][]TextEdit)
+	// Nested folders would visit a file twice
+	seen := make(map[string]bool)
+	visit := func(file, text string) {
+		uri := pathToURI(file)
+		if seen[uri] {
+			return
+		}
+		seen[uri] = true
+		if current, _, ok := s.document(uri); ok {
+			text = current
+		}
+		uses := aliasUses(text)
+		if len(uses) == 0 {
+			return
+		}
+		if s.isReadOnly(uri) {
+			result.ReadOnly = append(result.ReadOnly, uri)
+			return
+		}
+		edits := make([]TextEdit, len(uses))
+		for i, use := range uses {
+			edits[i] = TextEdit{Range: use.rng, NewText: use.canonical}
+		}
+		changes[uri] = edits
+		result.Files = append(result.Files, uri)
+		result.Uses += len(uses)
+	}
+	p := s.startProgress(ctx, "Fixing deprecated syntax", true)
+	for _, folder := range folders {
+		walkQueryFiles(folder, p, visit)
+	}
+	if p.cancelled() {
+		p.end("Cancelled")
+		return failure(&RPCError{Code: RequestCancelled, Message: "fix cancelled"})
+	}
+	p.end(fmt.Sprintf("Found %d uses", result.Uses))
+	sort.Strings(result.Files)
+
+	if len(result.ReadOnly) > 0 {
+		s.warnReadOnly(result.ReadOnly)
+	}
+	log.Printf("Deprecated syntax is used %d times in %d queries", result.Uses, len(result.Files))
+	if len(changes) == 0 {
+		return success(result)
+	}
+	edit := WorkspaceEdit{Changes: changes}
+	if s.applyEdits {
+		err := s.applyEdit("Fix deprecated syntax", edit, nil)
+		if err == nil {
+			result.Applying = true
+			return success(result)
+		}
+		log.Printf("Error asking to apply the edit: %v", err)
+	}
+	result.Edit = &edit
+	return success(result)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixDeprecatedSyntax(t *testing.T) {
+	root := t.TempDir()
+	text := "values 1 | yield this | filter true"
+	os.WriteFile(filepath.Join(root, "a.spq"), []byte(text), 0o644)
+	os.WriteFile(filepath.Join(root, "b.spq"), []byte("values 1 | where true"), 0o644)
+	uri := pathToURI(filepath.Join(root, "a.spq"))
+
+	fix := func(h *TestHelper) FixDeprecatedResult {
+		t.Helper()
+		response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{Command: CommandFixDeprecated})
+		if err != nil || response.Error != nil {
+			t.Fatalf("executeCommand failed: %v %+v", err, response)
+		}
+		var result FixDeprecatedResult
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &result)
+		return result
+	}
+	want := []TextEdit{
+		{Range: rangeOfText(t, text, "yield"), NewText: "values"},
+		{Range: rangeOfText(t, text, "filter"), NewText: "where"},
+	}
+
+	// Without applyEdit, the client gets the edit to apply
+	h := NewTestHelper()
+	h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)})
+	result := fix(h)
+	if result.Uses != 2 || len(result.Files) != 1 || result.Files[0] != uri || result.Applying || result.Edit == nil {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if edits := result.Edit.Changes[uri]; len(edits) != 2 || edits[0] != want[0] || edits[1] != want[1] {
+		t.Errorf("Expected %+v, got %+v", want, edits)
+	}
+
+	h = NewTestHelper()
+	params := InitializeParams{RootURI: pathToURI(root)}
+	params.Capabilities.Workspace.ApplyEdit = true
+	h.ProcessRequest(1, "initialize", params)
+	out := &bytes.Buffer{}
+	h.server.out = out
+	result = fix(h)
+	if !result.Applying || result.Edit != nil {
+		t.Fatalf("Expected the client asked to apply the edit, got %+v", result)
+	}
+	var request *RPCMessage
+	for _, msg := range drainMessages(t, out) {
+		if msg.Method == "workspace/applyEdit" {
+			request = &msg
+		}
+	}
+	if request == nil || request.ID == nil {
+		t.Fatal("Expected a workspace/applyEdit request")
+	}
+	var apply ApplyWorkspaceEditParams
+	json.Unmarshal(request.Params, &apply)
+	if apply.Label != "Fix deprecated syntax" || len(apply.Edit.Changes[uri]) != 2 {
+		t.Errorf("Unexpected applyEdit params: %+v", apply)
+	}
+
+	// A refusal is shown to the user
+	reply, _ := json.Marshal(RPCMessage{JSONRPC: "2.0", ID: request.ID, Result: ApplyWorkspaceEditResult{FailureReason: "file changed"}})
+	if response, err := h.server.handleMessage(reply); err != nil || response != nil {
+		t.Fatalf("Expected the reply to be consumed, got %+v, %v", response, err)
+	}
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 || msgs[0].Method != "window/showMessage" {
+		t.Fatalf("Expected the failure shown, got %+v", msgs)
+	}
+	var shown ShowMessageParams
+	json.Unmarshal(msgs[0].Params, &shown)
+	if shown.Type != MessageTypeWarning || !strings.Contains(shown.Message, "file changed") {
+		t.Errorf("Unexpected message: %+v", shown)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/brimdata/super"
+	"github.com/brimdata/super/sup"
+)
+
+// Assertion failures from a run. A failed assert doesn't stop the query:
+// the value is replaced by an error carrying the text of the condition, so
+// a run can tell which assert failed and the server can point at it. The
+// failures are published with the document's diagnostics and go away with
+// the next edit, which republishes them without.
+
+// maxAssertExamples caps how many failing values a diagnostic quotes
+const maxAssertExamples = 3
+
+// assertFailures tallies the failures of one assert condition in a run
+type assertFailures struct {
+	expr     string
+	count    int
+	examples []string // SUP of the first values that failed
+}
+
+// noteAssertion records val if it is an assertion failure
+func (run *queryRun) noteAssertion(val super.Value) {
+	if !val.IsError() {
+		return
+	}
+	typ, ok := super.TypeUnder(val.Type()).(*super.TypeError)
+	if !ok {
+		return
+	}
+	inner := super.NewValue(typ.Type, val.Bytes())
+	message := inner.Ptr().Deref("message")
+	expr := inner.Ptr().Deref("expr")
+	if message == nil || expr == nil || !message.IsString() || message.AsString() != "assertion failed" {
+		return
+	}
+	text := expr.AsString()
+	var failures *assertFailures
+	for i := range run.assertions {
+		if run.assertions[i].expr == text {
+			failures = &run.assertions[i]
+		}
+	}
+	if failures == nil {
+		run.assertions = append(run.assertions, assertFailures{expr: text})
+		failures = &run.assertions[len(run.assertions)-1]
+	}
+	failures.count++
+	if on := inner.Ptr().Deref("on"); on != nil && len(failures.examples) < maxAssertExamples {
+		failures.examples = append(failures.examples, sup.FormatValue(*on))
+	}
+}
+
+// assertDiagnostics returns a diagnostic at each assert in text whose
+// condition failed in run
+func (s *Server) assertDiagnostics(text string, run queryRun) []Diagnostic {
+	if len(run.assertions) == 0 {
+		return nil
+	}
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	walkTree(tree, func(node map[string]interface{}) {
+		if nodeKind(node) != "AssertOp" {
+			return
+		}
+		cond, _ := node["text"].(string)
+		for _, f := range run.assertions {
+			if f.expr != cond {
+				continue
+			}
+			expr, _ := node["expr"].(map[string]interface{})
+			message := s.messages.format("assert-failed", "expr", f.expr, "count", fmt.Sprint(f.count))
+			for _, example := range f.examples {
+				message += "\non: " + example
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    locRange(text, expr["loc"]),
+				Severity: DiagnosticSeverityError,
+				Code:     "assert-failed",
+				Source:   "superdb-lsp",
+				Message:  message,
+			})
+		}
+	})
+	return diagnostics
+}
+
+// publishRunDiagnostics republishes the diagnostics of uri with the
+// assertion failures of a run of text added, so they show at the asserts
+// that failed. Nothing is published if the document changed during the
+// run or has no asserts to report on or clear.
+func (s *Server) publishRunDiagnostics(ctx context.Context, uri, text string, run queryRun) {
+	current, version, ok := s.document(uri)
+	if !ok || current != text || !strings.Contains(strings.ToLower(text), "assert") {
+		return
+	}
+	diagnostics := append(s.diagnose(ctx, uri, text), s.assertDiagnostics(text, run)...)
+	msg, err := s.diagnosticsNotification(uri, text, version, diagnostics)
+	if err == nil {
+		err = s.send(msg)
+	}
+	if err != nil {
+		log.Printf("Error publishing assertion failures for %s: %v", uri, err)
+	}
+}
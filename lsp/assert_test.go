@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunQueryNotesAssertions(t *testing.T) {
+	query := "values 1, 2, 3, 4, 5 | assert this > 4"
+	run, err := runQuery(context.Background(), "", query, 2)
+	if err != nil {
+		t.Fatalf("runQuery failed: %v", err)
+	}
+	if len(run.assertions) != 1 {
+		t.Fatalf("Expected failures of one assert, got %+v", run.assertions)
+	}
+	// Failures past the cap on values still count
+	failures := run.assertions[0]
+	if failures.expr != "this > 4" || failures.count != 4 || strings.Join(failures.examples, " ") != "1 2 3" {
+		t.Errorf("Unexpected failures %+v", failures)
+	}
+
+	// An error value that isn't an assertion failure doesn't count
+	run, _ = runQuery(context.Background(), "", `values error("boom")`, 10)
+	if len(run.assertions) != 0 {
+		t.Errorf("Expected no assertion failures, got %+v", run.assertions)
+	}
+}
+
+func TestAssertDiagnostics(t *testing.T) {
+	s := NewServer()
+	text := "values 1, 2\n| assert this > 1\n| where true"
+	run, _ := runQuery(context.Background(), "", text, 10)
+	diags := s.assertDiagnostics(text, run)
+	if len(diags) != 1 {
+		t.Fatalf("Expected one diagnostic, got %+v", diags)
+	}
+	d := diags[0]
+	want := Range{Start: Position{Line: 1, Character: 9}, End: Position{Line: 1, Character: 17}}
+	if d.Code != "assert-failed" || d.Severity != DiagnosticSeverityError || d.Range != want {
+		t.Errorf("Unexpected diagnostic %+v", d)
+	}
+	if d.Message != "assert this > 1 failed on 1 values in the last run\non: 1" {
+		t.Errorf("Unexpected message %q", d.Message)
+	}
+}
+
+func TestRunQueryCommandPublishesAssertFailures(t *testing.T) {
+	h := NewTestHelper()
+	out := &bytes.Buffer{}
+	h.server.out = out
+	uri := "file:///assert.spq"
+	h.openDocument(t, uri, "values 1, 2 | assert this > 1")
+	out.Reset()
+
+	args, _ := json.Marshal(RunQueryArgs{URI: uri})
+	if _, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRunQuery,
+		Arguments: []json.RawMessage{args},
+	}); err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 || msgs[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected diagnostics to be published, got %+v", msgs)
+	}
+	var params PublishDiagnosticsParams
+	json.Unmarshal(msgs[0].Params, &params)
+	if params.Version != 1 || len(params.Diagnostics) != 1 || params.Diagnostics[0].Code != "assert-failed" {
+		t.Errorf("Expected the assert failure, got %+v", params)
+	}
+}
+
+func TestAssertHover(t *testing.T) {
+	hover := getHover("values 1 | assert this > 0", Position{Line: 0, Character: 12}, docMarkdown)
+	if hover == nil || !strings.Contains(hover.Contents.Value, "keeps running") {
+		t.Errorf("Expected hover to explain failures, got %+v", hover)
+	}
+}
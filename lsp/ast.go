@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/brimdata/super/compiler/parser"
+)
+
+// Generic access to the parser's AST. Rules that look for a few node kinds
+// walk the AST as decoded JSON, keyed by the "kind" each node carries, so
+// they don't depend on the Go types of every node in between.
+
+// parseTree parses text and returns its AST as decoded JSON, reporting
+// false when text doesn't parse
+func parseTree(text string) (interface{}, bool) {
+	ast, err := parser.ParseQuery(text)
+	if err != nil {
+		return nil, false
+	}
+	data, err := json.Marshal(ast.Parsed())
+	if err != nil {
+		return nil, false
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, false
+	}
+	return tree, true
+}
+
+// walkTree calls visit for every object in tree, parents before children.
+// Fields are visited in order of their names, so results don't vary from
+// run to run.
+func walkTree(tree interface{}, visit func(node map[string]interface{})) {
+	switch node := tree.(type) {
+	case []interface{}:
+		for _, child := range node {
+			walkTree(child, visit)
+		}
+	case map[string]interface{}:
+		visit(node)
+		for _, key := range sortedKeys(node) {
+			walkTree(node[key], visit)
+		}
+	}
+}
+
+// sortedKeys returns the field names of an AST node in order
+func sortedKeys(node map[string]interface{}) []string {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeKind returns the kind of an AST node, or "" if it has none
+func nodeKind(node interface{}) string {
+	m, _ := node.(map[string]interface{})
+	kind, _ := m["kind"].(string)
+	return kind
+}
+
+// handleAst processes superdb/ast requests, returning the document's parse
+// tree as the brimdata/super parser builds it, or the diagnostic for the
+// error that stopped parsing. Positions inside the tree are the parser's
+// byte offsets into the document.
+func (s *Server) handleAst(msg RPCMessage) HandlerResult {
+	var params AstParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.TextDocument.URI)})
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("not a query: %s", params.TextDocument.URI)})
+	}
+
+	ast, err := parser.ParseQuery(text)
+	if err != nil {
+		diag := errorToDiagnostic(text, err)
+		diag.Range = s.rangeToClient(text, diag.Range)
+		return success(AstResult{Error: &diag})
+	}
+	data, err := json.Marshal(ast.Parsed())
+	if err != nil {
+		return failure(err)
+	}
+	return success(AstResult{AST: data})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAstRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///ast.spq"
+	h.openDocument(t, uri, "from data.json | count()")
+
+	response, err := h.ProcessRequest(2, "superdb/ast", AstParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if err != nil {
+		t.Fatalf("superdb/ast failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result AstResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Error != nil || !strings.Contains(string(result.AST), `"kind":"FromOp"`) {
+		t.Errorf("Expected the parsed query, got %s", resultBytes)
+	}
+
+	bad := "file:///bad.spq"
+	h.openDocument(t, bad, "from data.json\n| where (")
+	response, _ = h.ProcessRequest(3, "superdb/ast", AstParams{TextDocument: TextDocumentIdentifier{URI: bad}})
+	resultBytes, _ = json.Marshal(response.Result)
+	result = AstResult{}
+	json.Unmarshal(resultBytes, &result)
+	if result.Error == nil || result.AST != nil || result.Error.Range.Start.Line != 1 {
+		t.Errorf("Expected a syntax error on line 2, got %s", resultBytes)
+	}
+
+	response, _ = h.ProcessRequest(4, "superdb/ast", AstParams{TextDocument: TextDocumentIdentifier{URI: "file:///missing.spq"}})
+	if response.Error == nil || response.Error.Code != RequestFailed {
+		t.Errorf("Expected a document that isn't open to fail, got %+v", response)
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "strings"
+
 // builtins.go - Unified registry of SuperSQL language elements
 // This is the single source of truth for all keywords, operators, functions,
 // aggregates, and types. Used by completion, hover, and signature help.
@@ -23,6 +25,42 @@ type Builtin struct {
 	Doc        string       // Full documentation for hover
 	Signature  string       // Function signature (for functions/aggregates)
 	Parameters []ParamDef   // Parameter definitions (for signature help)
+	Examples   []string     // Example queries, each one that parses on its own
+	AliasOf    string       // Canonical name, when this is an older spelling of it
+	Deprecated string       // What to write instead, when this is on its way out
+	SQLAlias   bool         // A SQL spelling of a type, like varchar for string
+	Since      string       // Dialect version that introduced it, when not every one has it
+	Until      string       // Dialect version that removed it
+
+	// Derived at registry build time so hot paths don't allocate per item
+	lowerName  string
+	sig        *FuncSignature
+	item       CompletionItem
+	sortTexts  [rankCount]string // the item's sortText at each rank
+	hover      string
+	plainHover string // hover in the plain-text style
+	plainDoc   string // documentation with a Parameters: section, plain text
+	aliases    []string // older spellings, on a canonical entry
+}
+
+// Snippet is a template for a common query pattern, offered by completion
+type Snippet struct {
+	Name  string // what the word typed is matched against
+	Brief string
+	Body  string // in snippet syntax, like summarize count() by ${1:field}
+	Stage bool   // starts a pipeline stage or a declaration, rather than an expression
+
+	item      CompletionItem
+	sortTexts [rankCount]string
+}
+
+// Format is a data format super reads, as the format argument of a source
+// names it
+type Format struct {
+	Name  string
+	Brief string
+
+	item CompletionItem
 }
 
 // ParamDef defines a function parameter
@@ -33,13 +71,31 @@ type ParamDef struct {
 
 // Registry holds all builtins indexed for fast lookup
 type Registry struct {
-	byName map[string]*Builtin
-	byKind map[BuiltinKind][]*Builtin
+	byName   map[string]*Builtin
+	byKind   map[BuiltinKind][]*Builtin
+	snippets []*Snippet
+	formats  []*Format
 }
 
-// Lookup finds a builtin by name (case-insensitive)
+// maxLookupLen bounds the stack buffer used by Lookup; no builtin name is
+// anywhere near this long, so longer names can't match anyway
+const maxLookupLen = 64
+
+// Lookup finds a builtin by name (case-insensitive) without allocating
 func (r *Registry) Lookup(name string) *Builtin {
-	return r.byName[toLower(name)]
+	if len(name) > maxLookupLen {
+		return nil
+	}
+	var buf [maxLookupLen]byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf[i] = c
+	}
+	// The compiler recognizes m[string(bytes)] and skips the conversion copy
+	return r.byName[string(buf[:len(name)])]
 }
 
 // ByKind returns all builtins of a given kind
@@ -62,6 +118,12 @@ func (r *Registry) Aggregates() []*Builtin { return r.byKind[KindAggregate] }
 // Types returns all types
 func (r *Registry) Types() []*Builtin { return r.byKind[KindType] }
 
+// Snippets returns all snippet templates
+func (r *Registry) Snippets() []*Snippet { return r.snippets }
+
+// Formats returns all data formats
+func (r *Registry) Formats() []*Format { return r.formats }
+
 // Builtins is the global registry instance
 var Builtins = buildRegistry()
 
@@ -73,13 +135,60 @@ func buildRegistry() *Registry {
 
 	for i := range allBuiltins {
 		b := &allBuiltins[i]
-		r.byName[toLower(b.Name)] = b
+		b.lowerName = toLower(b.Name)
+		r.byName[b.lowerName] = b
 		r.byKind[b.Kind] = append(r.byKind[b.Kind], b)
 	}
+	// Each side of an alias pair names the other in its hover, so the
+	// pairs are known before anything is rendered
+	for i := range allBuiltins {
+		if b := &allBuiltins[i]; b.AliasOf != "" {
+			canonical := r.byName[b.AliasOf]
+			canonical.aliases = append(canonical.aliases, b.Name)
+		}
+	}
+	for i := range allBuiltins {
+		b := &allBuiltins[i]
+		b.sig = newFuncSignature(b)
+		b.item = newCompletionItem(b)
+		b.sortTexts = builtinSortTexts(b)
+		b.hover = formatHoverContent(b) + aliasNote(b, docMarkdown)
+		b.plainDoc = formatPlainDoc(b)
+		b.plainHover = formatPlainHoverContent(b) + aliasNote(b, docPlainText)
+	}
+	for i := range allSnippets {
+		sn := &allSnippets[i]
+		sn.item = newSnippetItem(sn)
+		for r := range sn.sortTexts {
+			sn.sortTexts[r] = completionRank(r).sortText(sn.Name)
+		}
+		r.snippets = append(r.snippets, sn)
+	}
+	for i := range allFormats {
+		f := &allFormats[i]
+		f.item = CompletionItem{Label: f.Name, Kind: CompletionItemKindEnumMember, Detail: "format: " + f.Brief}
+		r.formats = append(r.formats, f)
+	}
 
 	return r
 }
 
+// replacement returns what to write instead of b, or "" when b isn't
+// deprecated. An older spelling of an operator is deprecated in favor of
+// the canonical one.
+func (b *Builtin) replacement() string {
+	if b.AliasOf != "" {
+		return b.AliasOf
+	}
+	return b.Deprecated
+}
+
+// hasPrefix reports whether the builtin's name starts with lowerPrefix,
+// which must already be lowercase
+func (b *Builtin) hasPrefix(lowerPrefix string) bool {
+	return strings.HasPrefix(b.lowerName, lowerPrefix)
+}
+
 func toLower(s string) string {
 	// Fast ASCII lowercase
 	b := make([]byte, len(s))
@@ -103,7 +212,7 @@ var allBuiltins = []Builtin{
 	{Name: "const", Kind: KindKeyword, Brief: "Declare a constant"},
 	{Name: "file", Kind: KindKeyword, Brief: "File source"},
 	{Name: "from", Kind: KindKeyword, Brief: "Data source"},
-	{Name: "func", Kind: KindKeyword, Brief: "Define a function"},
+	{Name: "func", Kind: KindKeyword, Brief: "Define a function", Deprecated: "fn"},
 	{Name: "op", Kind: KindKeyword, Brief: "Define an operator"},
 	{Name: "this", Kind: KindKeyword, Brief: "Current value reference"},
 	{Name: "type", Kind: KindKeyword, Brief: "Type definition"},
@@ -158,7 +267,7 @@ var allBuiltins = []Builtin{
 	{Name: "null", Kind: KindKeyword, Brief: "Null value"},
 
 	// Other keywords
-	{Name: "aggregate", Kind: KindKeyword, Brief: "Aggregate expression"},
+	{Name: "aggregate", Kind: KindKeyword, Brief: "Aggregate expression", Since: "5ea0cb5d"},
 	{Name: "nulls", Kind: KindKeyword, Brief: "Null ordering"},
 	{Name: "first", Kind: KindKeyword, Brief: "First value"},
 	{Name: "last", Kind: KindKeyword, Brief: "Last value"},
@@ -171,7 +280,7 @@ var allBuiltins = []Builtin{
 	{Name: "error", Kind: KindKeyword, Brief: "Error value"},
 	{Name: "exists", Kind: KindKeyword, Brief: "SQL EXISTS"},
 	{Name: "extract", Kind: KindKeyword, Brief: "Extract component"},
-	{Name: "fn", Kind: KindKeyword, Brief: "Function shorthand"},
+	{Name: "fn", Kind: KindKeyword, Brief: "Function shorthand", Since: "5ea0cb5d"},
 	{Name: "for", Kind: KindKeyword, Brief: "For iteration"},
 	{Name: "lambda", Kind: KindKeyword, Brief: "Lambda expression"},
 	{Name: "materialized", Kind: KindKeyword, Brief: "Materialized view"},
@@ -183,40 +292,44 @@ var allBuiltins = []Builtin{
 	{Name: "substring", Kind: KindKeyword, Brief: "Substring function"},
 	{Name: "union", Kind: KindKeyword, Brief: "SQL UNION"},
 	{Name: "value", Kind: KindKeyword, Brief: "Value keyword"},
-	{Name: "filter", Kind: KindKeyword, Brief: "Filter expression"},
+	{Name: "filter", Kind: KindKeyword, Brief: "Filter expression", AliasOf: "where"},
 	{Name: "map", Kind: KindKeyword, Brief: "Map type constructor"},
 
 	// =========================================================================
 	// OPERATORS (pipeline operators)
 	// =========================================================================
 
-	{Name: "assert", Kind: KindOperator, Brief: "Assert condition"},
-	{Name: "cut", Kind: KindOperator, Brief: "Select and reorder fields"},
-	{Name: "debug", Kind: KindOperator, Brief: "Debug output"},
-	{Name: "drop", Kind: KindOperator, Brief: "Remove fields from records"},
-	{Name: "explode", Kind: KindOperator, Brief: "Explode array into records"},
-	{Name: "fork", Kind: KindOperator, Brief: "Fork the data flow"},
-	{Name: "fuse", Kind: KindOperator, Brief: "Fuse schemas together"},
-	{Name: "head", Kind: KindOperator, Brief: "Take first N records"},
-	{Name: "load", Kind: KindOperator, Brief: "Load data into pool"},
-	{Name: "merge", Kind: KindOperator, Brief: "Merge sorted streams"},
-	{Name: "output", Kind: KindOperator, Brief: "Output to destination"},
-	{Name: "over", Kind: KindOperator, Brief: "Iterate over values"},
-	{Name: "pass", Kind: KindOperator, Brief: "Pass through unchanged"},
-	{Name: "put", Kind: KindOperator, Brief: "Add/update fields"},
-	{Name: "rename", Kind: KindOperator, Brief: "Rename fields"},
-	{Name: "sample", Kind: KindOperator, Brief: "Sample random records"},
-	{Name: "search", Kind: KindOperator, Brief: "Search expression"},
-	{Name: "skip", Kind: KindOperator, Brief: "Skip N records"},
-	{Name: "sort", Kind: KindOperator, Brief: "Sort records"},
-	{Name: "summarize", Kind: KindOperator, Brief: "Aggregate data"},
-	{Name: "switch", Kind: KindOperator, Brief: "Conditional branching"},
-	{Name: "tail", Kind: KindOperator, Brief: "Take last N records"},
-	{Name: "top", Kind: KindOperator, Brief: "Top N by field"},
-	{Name: "uniq", Kind: KindOperator, Brief: "Remove duplicates"},
-	{Name: "unnest", Kind: KindOperator, Brief: "Unnest nested values"},
-	{Name: "values", Kind: KindOperator, Brief: "Extract values"},
-	{Name: "yield", Kind: KindOperator, Brief: "Output values"},
+	{Name: "assert", Kind: KindOperator, Brief: "Assert condition",
+		Doc: "Passes each value through when the condition is true. Otherwise the value is replaced by an error " +
+			"`{message:\"assertion failed\",expr:<condition text>,on:<value>}` and the query keeps running, " +
+			"so a failed assertion shows up in the output rather than stopping the query.",
+		Examples: []string{"from test | assert len(name) > 0"}},
+	{Name: "cut", Kind: KindOperator, Brief: "Select and reorder fields", Examples: []string{"from test | cut name, age"}},
+	{Name: "debug", Kind: KindOperator, Brief: "Debug output", Examples: []string{"from test | debug {id, status} | count()"}},
+	{Name: "drop", Kind: KindOperator, Brief: "Remove fields from records", Examples: []string{"from test | drop password"}},
+	{Name: "explode", Kind: KindOperator, Brief: "Explode array into records", Examples: []string{"from test | explode tags by string as tag"}},
+	{Name: "fork", Kind: KindOperator, Brief: "Fork the data flow", Examples: []string{"from test | fork ( where ok ) ( where not ok )"}},
+	{Name: "fuse", Kind: KindOperator, Brief: "Fuse schemas together", Examples: []string{"from test | fuse"}},
+	{Name: "head", Kind: KindOperator, Brief: "Take first N records", Examples: []string{"from test | head 10"}},
+	{Name: "load", Kind: KindOperator, Brief: "Load data into pool", Examples: []string{"from test.json | load logs@main"}},
+	{Name: "merge", Kind: KindOperator, Brief: "Merge sorted streams", Examples: []string{"from test | fork ( sort ts ) ( sort ts ) | merge ts"}},
+	{Name: "output", Kind: KindOperator, Brief: "Output to destination", Examples: []string{"from test | fork ( output main ) ( count() | output stats )"}},
+	{Name: "over", Kind: KindOperator, Brief: "Iterate over values", Examples: []string{"from test | over tags"}},
+	{Name: "pass", Kind: KindOperator, Brief: "Pass through unchanged", Examples: []string{"from test | fork ( pass ) ( count() )"}},
+	{Name: "put", Kind: KindOperator, Brief: "Add/update fields", Examples: []string{"from test | put total := price * qty"}},
+	{Name: "rename", Kind: KindOperator, Brief: "Rename fields", Examples: []string{"from test | rename name := username"}},
+	{Name: "sample", Kind: KindOperator, Brief: "Sample random records", Examples: []string{"from test | sample"}},
+	{Name: "search", Kind: KindOperator, Brief: "Search expression", Examples: []string{`from test | search "timeout"`}},
+	{Name: "skip", Kind: KindOperator, Brief: "Skip N records", Examples: []string{"from test | sort ts | skip 10"}},
+	{Name: "sort", Kind: KindOperator, Brief: "Sort records", Examples: []string{"from test | sort -r ts"}},
+	{Name: "summarize", Kind: KindOperator, Brief: "Aggregate data", AliasOf: "aggregate", Examples: []string{"from test | summarize count() by host"}},
+	{Name: "switch", Kind: KindOperator, Brief: "Conditional branching", Examples: []string{"from test | switch case status >= 500 ( values 'error' ) default ( values 'ok' )"}},
+	{Name: "tail", Kind: KindOperator, Brief: "Take last N records", Examples: []string{"from test | tail 5"}},
+	{Name: "top", Kind: KindOperator, Brief: "Top N by field", Examples: []string{"from test | top 3 bytes"}},
+	{Name: "uniq", Kind: KindOperator, Brief: "Remove duplicates", Examples: []string{"from test | sort x | uniq"}},
+	{Name: "unnest", Kind: KindOperator, Brief: "Unnest nested values", Examples: []string{"from test | unnest tags"}},
+	{Name: "values", Kind: KindOperator, Brief: "Extract values", Since: "5ea0cb5d", Examples: []string{"from test | values {id, name}"}},
+	{Name: "yield", Kind: KindOperator, Brief: "Output values", AliasOf: "values", Examples: []string{"from test | yield {id, name}"}},
 
 	// =========================================================================
 	// FUNCTIONS (scalar functions)
@@ -227,300 +340,350 @@ var allBuiltins = []Builtin{
 		Brief: "Absolute value", Doc: "Returns the absolute value of a number",
 		Signature: "abs(value: number) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}},
+		Examples:   []string{"values abs(-5)"},
 	},
 	{
 		Name: "base64", Kind: KindFunction,
 		Brief: "Base64 encode/decode", Doc: "Encode or decode base64 data",
 		Signature: "base64(value: bytes|string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to encode/decode"}},
+		Examples:   []string{"from test | put encoded := base64(payload)"},
 	},
 	{
 		Name: "bucket", Kind: KindFunction,
 		Brief: "Bucket values into ranges", Doc: "Bucket numeric values into fixed-size ranges",
 		Signature: "bucket(value: number, size: number) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to bucket"}, {Name: "size", Doc: "Bucket size"}},
+		Examples:   []string{"from test | count() by bucket(ts, 5m)"},
 	},
 	{
 		Name: "ceil", Kind: KindFunction,
 		Brief: "Ceiling function", Doc: "Round up to the nearest integer",
 		Signature: "ceil(value: number) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}},
+		Examples:   []string{"values ceil(1.5)"},
 	},
 	{
 		Name: "cidr_match", Kind: KindFunction,
 		Brief: "Match IP against CIDR", Doc: "Check if an IP address matches a CIDR network",
 		Signature: "cidr_match(network: net, ip: ip) -> bool",
 		Parameters: []ParamDef{{Name: "network", Doc: "CIDR network"}, {Name: "ip", Doc: "IP address to check"}},
+		Examples:   []string{"from test | where cidr_match(10.0.0.0/8, src)"},
 	},
 	{
 		Name: "coalesce", Kind: KindFunction,
 		Brief: "First non-null value", Doc: "Return the first non-null value from arguments",
 		Signature: "coalesce(value: any, ...) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to check"}},
+		Examples:   []string{`yield coalesce(nickname, name, "unknown")`},
 	},
 	{
 		Name: "compare", Kind: KindFunction,
 		Brief: "Compare two values", Doc: "Compare two values, returning -1, 0, or 1",
 		Signature: "compare(a: any, b: any) -> int64",
 		Parameters: []ParamDef{{Name: "a", Doc: "First value"}, {Name: "b", Doc: "Second value"}},
+		Examples:   []string{"values compare(a, b)"},
 	},
 	{
 		Name: "date_part", Kind: KindFunction,
 		Brief: "Extract date component", Doc: "Extract a component (year, month, day, etc.) from a timestamp",
 		Signature: "date_part(part: string, time: time) -> int64",
 		Parameters: []ParamDef{{Name: "part", Doc: "Part name (year, month, day, hour, minute, second)"}, {Name: "time", Doc: "Timestamp value"}},
+		Examples:   []string{`from test | put year := date_part("year", ts)`},
 	},
 	{
 		Name: "fields", Kind: KindFunction,
 		Brief: "Get record field names", Doc: "Return the field names of a record as an array",
 		Signature: "fields(record: record) -> [string]",
 		Parameters: []ParamDef{{Name: "record", Doc: "Record value"}},
+		Examples:   []string{"from test | values fields(this)"},
 	},
 	{
 		Name: "flatten", Kind: KindFunction,
 		Brief: "Flatten nested records", Doc: "Flatten nested record structure into dotted field names",
 		Signature: "flatten(record: record) -> record",
 		Parameters: []ParamDef{{Name: "record", Doc: "Record to flatten"}},
+		Examples:   []string{"from test | values flatten(this)"},
 	},
 	{
 		Name: "floor", Kind: KindFunction,
 		Brief: "Floor function", Doc: "Round down to the nearest integer",
 		Signature: "floor(value: number) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}},
+		Examples:   []string{"values floor(1.5)"},
 	},
 	{
 		Name: "grep", Kind: KindFunction,
 		Brief: "Search with pattern", Doc: "Search for a pattern in a value",
 		Signature: "grep(pattern: string|regexp, value: any) -> bool",
 		Parameters: []ParamDef{{Name: "pattern", Doc: "Search pattern"}, {Name: "value", Doc: "Value to search"}},
+		Examples:   []string{`from test | where grep("timeout", message)`},
 	},
 	{
 		Name: "grok", Kind: KindFunction,
 		Brief: "Parse with grok pattern", Doc: "Parse a string using a grok pattern",
 		Signature: "grok(pattern: string, value: string) -> record",
 		Parameters: []ParamDef{{Name: "pattern", Doc: "Grok pattern"}, {Name: "value", Doc: "String to parse"}},
+		Examples:   []string{`from test | values grok("%{IP:client} %{WORD:method}", line)`},
 	},
 	{
 		Name: "has", Kind: KindFunction,
 		Brief: "Check if field exists", Doc: "Check if a record has a specific field",
 		Signature: "has(record: record, field: string) -> bool",
 		Parameters: []ParamDef{{Name: "record", Doc: "Record to check"}, {Name: "field", Doc: "Field name"}},
+		Examples:   []string{"from test | where has(user.email)"},
 	},
 	{
 		Name: "has_error", Kind: KindFunction,
 		Brief: "Check for error", Doc: "Check if a value contains a nested error",
 		Signature: "has_error(value: any) -> bool",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"from test | where has_error(this)"},
 	},
 	{
 		Name: "hex", Kind: KindFunction,
 		Brief: "Hexadecimal conversion", Doc: "Convert bytes or string to hexadecimal",
 		Signature: "hex(value: bytes|string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to convert"}},
+		Examples:   []string{`values hex("hello")`},
 	},
 	{
 		Name: "is", Kind: KindFunction,
 		Brief: "Type check function", Doc: "Check if a value is of a specific type",
 		Signature: "is(value: any, type: type) -> bool",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}, {Name: "type", Doc: "Type to check against"}},
+		Examples:   []string{"from test | where is(id, <int64>)"},
 	},
 	{
 		Name: "is_error", Kind: KindFunction,
 		Brief: "Check if value is error", Doc: "Check if a value is an error",
 		Signature: "is_error(value: any) -> bool",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"from test | where is_error(result)"},
 	},
 	{
 		Name: "join", Kind: KindFunction,
 		Brief: "Join strings", Doc: "Join an array of strings with a separator",
 		Signature: "join(array: [string], sep: string) -> string",
 		Parameters: []ParamDef{{Name: "array", Doc: "Array of strings"}, {Name: "sep", Doc: "Separator"}},
+		Examples:   []string{`values join(["a", "b"], ",")`},
 	},
 	{
 		Name: "kind", Kind: KindFunction,
 		Brief: "Get value kind", Doc: "Return the kind of a value (primitive, record, array, etc.)",
 		Signature: "kind(value: any) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"from test | values kind(this)"},
 	},
 	{
 		Name: "ksuid", Kind: KindFunction,
 		Brief: "Generate KSUID", Doc: "Generate a K-Sortable Unique Identifier",
 		Signature: "ksuid() -> string",
 		Parameters: []ParamDef{},
+		Examples:   []string{"values ksuid()"},
 	},
 	{
 		Name: "len", Kind: KindFunction,
 		Brief: "Length of value", Doc: "Return the length of a string, bytes, or array",
 		Signature: "len(value: string|bytes|array) -> int64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to measure"}},
+		Examples:   []string{`from test | where len(tags) > 2`},
 	},
 	{
 		Name: "length", Kind: KindFunction,
 		Brief: "Length of value (alias)", Doc: "Return the length of a string, bytes, or array (alias for len)",
 		Signature: "length(value: string|bytes|array) -> int64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to measure"}},
+		Examples:   []string{`values length("hello")`},
 	},
 	{
 		Name: "levenshtein", Kind: KindFunction,
 		Brief: "Levenshtein distance", Doc: "Calculate the Levenshtein edit distance between two strings",
 		Signature: "levenshtein(a: string, b: string) -> int64",
 		Parameters: []ParamDef{{Name: "a", Doc: "First string"}, {Name: "b", Doc: "Second string"}},
+		Examples:   []string{`values levenshtein("kitten", "sitting")`},
 	},
 	{
 		Name: "log", Kind: KindFunction,
 		Brief: "Logarithm", Doc: "Calculate the logarithm of a number",
 		Signature: "log(value: number, base?: number) -> float64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}, {Name: "base", Doc: "Log base (default: e)"}},
+		Examples:   []string{"values log(100, 10)"},
 	},
 	{
 		Name: "lower", Kind: KindFunction,
 		Brief: "Convert to lowercase", Doc: "Convert a string to lowercase",
 		Signature: "lower(value: string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "String to convert"}},
+		Examples:   []string{`from test | put email := lower(email)`},
 	},
 	{
 		Name: "missing", Kind: KindFunction,
 		Brief: "Create missing value", Doc: "Create a missing value of optional type",
 		Signature: "missing(type?: type) -> missing",
 		Parameters: []ParamDef{{Name: "type", Doc: "Optional type"}},
+		Examples:   []string{"from test | where missing(user.email)"},
 	},
 	{
 		Name: "nameof", Kind: KindFunction,
 		Brief: "Get type name", Doc: "Return the name of a value's type",
 		Signature: "nameof(value: any) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"from test | values nameof(this)"},
 	},
 	{
 		Name: "nest_dotted", Kind: KindFunction,
 		Brief: "Nest dotted field names", Doc: "Convert dotted field names into nested records",
 		Signature: "nest_dotted(record: record) -> record",
 		Parameters: []ParamDef{{Name: "record", Doc: "Record with dotted names"}},
+		Examples:   []string{"from test | values nest_dotted(this)"},
 	},
 	{
 		Name: "network_of", Kind: KindFunction,
 		Brief: "Get network from IP", Doc: "Get the network address from an IP and mask",
 		Signature: "network_of(ip: ip, mask: net) -> net",
 		Parameters: []ParamDef{{Name: "ip", Doc: "IP address"}, {Name: "mask", Doc: "Network mask"}},
+		Examples:   []string{"from test | put net := network_of(src, 255.255.255.0)"},
 	},
 	{
 		Name: "now", Kind: KindFunction,
 		Brief: "Current timestamp", Doc: "Return the current timestamp",
 		Signature: "now() -> time",
 		Parameters: []ParamDef{},
+		Examples:   []string{"values now()"},
 	},
 	{
 		Name: "nullif", Kind: KindFunction,
 		Brief: "Return null if equal", Doc: "Return null if two values are equal, otherwise return the first value",
 		Signature: "nullif(a: any, b: any) -> any",
 		Parameters: []ParamDef{{Name: "a", Doc: "First value"}, {Name: "b", Doc: "Value to compare"}},
+		Examples:   []string{`from test | put status := nullif(status, "")`},
 	},
 	{
-		Name: "parse_sup", Kind: KindFunction,
+		Name: "parse_sup", Kind: KindFunction, Since: "5ea0cb5d",
 		Brief: "Parse Super format", Doc: "Parse a string in Super format",
 		Signature: "parse_sup(value: string) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "String to parse"}},
+		Examples:   []string{`values parse_sup("{a:1}")`},
 	},
 	{
 		Name: "parse_uri", Kind: KindFunction,
 		Brief: "Parse URI string", Doc: "Parse a URI string into its components",
 		Signature: "parse_uri(uri: string) -> record",
 		Parameters: []ParamDef{{Name: "uri", Doc: "URI to parse"}},
+		Examples:   []string{`values parse_uri("http://example.com/path?q=1")`},
 	},
 	{
 		Name: "position", Kind: KindFunction,
 		Brief: "Find substring position", Doc: "Find the position of a substring in a string",
 		Signature: "position(substr: string, str: string) -> int64",
 		Parameters: []ParamDef{{Name: "substr", Doc: "Substring to find"}, {Name: "str", Doc: "String to search"}},
+		Examples:   []string{`values position("l", "hello")`},
 	},
 	{
 		Name: "pow", Kind: KindFunction,
 		Brief: "Power function", Doc: "Calculate base raised to the power of exponent",
 		Signature: "pow(base: number, exp: number) -> number",
 		Parameters: []ParamDef{{Name: "base", Doc: "Base value"}, {Name: "exp", Doc: "Exponent"}},
+		Examples:   []string{"values pow(2, 10)"},
 	},
 	{
 		Name: "quiet", Kind: KindFunction,
 		Brief: "Suppress errors", Doc: "Suppress errors and return null instead",
 		Signature: "quiet(value: any) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to quiet"}},
+		Examples:   []string{"from test | values quiet(x)"},
 	},
 	{
 		Name: "regexp", Kind: KindFunction,
 		Brief: "Regular expression match", Doc: "Match a string against a regular expression",
 		Signature: "regexp(pattern: string, value: string) -> bool",
 		Parameters: []ParamDef{{Name: "pattern", Doc: "Regex pattern"}, {Name: "value", Doc: "String to match"}},
+		Examples:   []string{`values regexp("[0-9]+", "abc123")`},
 	},
 	{
 		Name: "regexp_replace", Kind: KindFunction,
 		Brief: "Regex replacement", Doc: "Replace matches of a regex pattern",
 		Signature: "regexp_replace(value: string, pattern: string, replacement: string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Input string"}, {Name: "pattern", Doc: "Regex pattern"}, {Name: "replacement", Doc: "Replacement string"}},
+		Examples:   []string{`values regexp_replace("a1b2", "[0-9]", "")`},
 	},
 	{
 		Name: "replace", Kind: KindFunction,
 		Brief: "String replacement", Doc: "Replace occurrences of a substring",
 		Signature: "replace(value: string, old: string, new: string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Input string"}, {Name: "old", Doc: "String to replace"}, {Name: "new", Doc: "Replacement string"}},
+		Examples:   []string{`from test | put path := replace(path, "\\", "/")`},
 	},
 	{
 		Name: "round", Kind: KindFunction,
 		Brief: "Round to precision", Doc: "Round a number to a specified precision",
 		Signature: "round(value: number, precision?: int64) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}, {Name: "precision", Doc: "Decimal places (default: 0)"}},
+		Examples:   []string{"values round(3.14159, 2)"},
 	},
 	{
 		Name: "split", Kind: KindFunction,
 		Brief: "Split string", Doc: "Split a string by a separator",
 		Signature: "split(value: string, sep: string) -> [string]",
 		Parameters: []ParamDef{{Name: "value", Doc: "String to split"}, {Name: "sep", Doc: "Separator"}},
+		Examples:   []string{`from test | put parts := split(path, "/")`},
 	},
 	{
 		Name: "sqrt", Kind: KindFunction,
 		Brief: "Square root", Doc: "Calculate the square root of a number",
 		Signature: "sqrt(value: number) -> float64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric value"}},
+		Examples:   []string{"values sqrt(16)"},
 	},
 	{
 		Name: "strftime", Kind: KindFunction,
 		Brief: "Format time as string", Doc: "Format a timestamp as a string using a format specifier",
 		Signature: "strftime(format: string, time: time) -> string",
 		Parameters: []ParamDef{{Name: "format", Doc: "Format string"}, {Name: "time", Doc: "Timestamp value"}},
+		Examples:   []string{`values strftime("%Y-%m-%d", now())`},
 	},
 	{
 		Name: "trim", Kind: KindFunction,
 		Brief: "Trim whitespace", Doc: "Remove leading and trailing whitespace from a string",
 		Signature: "trim(value: string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "String to trim"}},
+		Examples:   []string{`values trim("  hello  ")`},
 	},
 	{
 		Name: "typename", Kind: KindFunction,
 		Brief: "Get type name", Doc: "Return the name of a value's type as a string",
 		Signature: "typename(value: any) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"values typename(this)"},
 	},
 	{
 		Name: "typeof", Kind: KindFunction,
 		Brief: "Get type of value", Doc: "Return the type of a value",
 		Signature: "typeof(value: any) -> type",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to check"}},
+		Examples:   []string{"from test | values typeof(this)"},
 	},
 	{
 		Name: "under", Kind: KindFunction,
 		Brief: "Get underlying value", Doc: "Unwrap a value to get its underlying representation",
 		Signature: "under(value: any) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to unwrap"}},
+		Examples:   []string{"from test | values under(id)"},
 	},
 	{
 		Name: "unflatten", Kind: KindFunction,
 		Brief: "Unflatten records", Doc: "Convert dotted field names back into nested records",
 		Signature: "unflatten(record: record) -> record",
 		Parameters: []ParamDef{{Name: "record", Doc: "Record to unflatten"}},
+		Examples:   []string{`values unflatten({"a.b": 1})`},
 	},
 	{
 		Name: "upper", Kind: KindFunction,
 		Brief: "Convert to uppercase", Doc: "Convert a string to uppercase",
 		Signature: "upper(value: string) -> string",
 		Parameters: []ParamDef{{Name: "value", Doc: "String to convert"}},
+		Examples:   []string{`from test | put code := upper(code)`},
 	},
 
 	// Additional functions that need signatures
@@ -529,24 +692,28 @@ var allBuiltins = []Builtin{
 		Brief: "Cast value to type", Doc: "Convert a value to a specified type",
 		Signature: "cast(value: any, type: type) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Value to cast"}, {Name: "type", Doc: "Target type"}},
+		Examples:   []string{`values cast("42", <int64>)`},
 	},
 	{
 		Name: "error", Kind: KindFunction,
 		Brief: "Create error value", Doc: "Create an error value with a message",
 		Signature: "error(message: string) -> error",
 		Parameters: []ParamDef{{Name: "message", Doc: "Error message"}},
+		Examples:   []string{`from test | values error("bad input")`},
 	},
 	{
 		Name: "max", Kind: KindFunction,
 		Brief: "Maximum of values", Doc: "Return the maximum of two values",
 		Signature: "max(a: number, b: number) -> number",
 		Parameters: []ParamDef{{Name: "a", Doc: "First value"}, {Name: "b", Doc: "Second value"}},
+		Examples:   []string{"values max(a, b)"},
 	},
 	{
 		Name: "min", Kind: KindFunction,
 		Brief: "Minimum of values", Doc: "Return the minimum of two values",
 		Signature: "min(a: number, b: number) -> number",
 		Parameters: []ParamDef{{Name: "a", Doc: "First value"}, {Name: "b", Doc: "Second value"}},
+		Examples:   []string{"values min(a, b)"},
 	},
 
 	// =========================================================================
@@ -556,80 +723,93 @@ var allBuiltins = []Builtin{
 	{
 		Name: "count", Kind: KindAggregate,
 		Brief: "Count records", Doc: "Count the number of records in a group",
-		Signature: "count() -> int64",
-		Parameters: []ParamDef{},
+		Signature: "count(value?: any) -> int64",
+		Parameters: []ParamDef{{Name: "value", Doc: "Values to count; counts every record when omitted"}},
+		Examples:   []string{`from test | summarize count(distinct user) by host`},
 	},
 	{
 		Name: "sum", Kind: KindAggregate,
 		Brief: "Sum of values", Doc: "Calculate the sum of numeric values",
 		Signature: "sum(value: number) -> number",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric values"}},
+		Examples:   []string{`from test | summarize sum(bytes) filter (status >= 400)`},
 	},
 	{
 		Name: "avg", Kind: KindAggregate,
 		Brief: "Average of values", Doc: "Calculate the average of numeric values",
 		Signature: "avg(value: number) -> float64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Numeric values"}},
+		Examples:   []string{`from test | summarize avg(duration) by endpoint`},
 	},
 	{
 		Name: "collect", Kind: KindAggregate,
 		Brief: "Collect values into array", Doc: "Collect all values into an array",
 		Signature: "collect(value: any) -> [any]",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to collect"}},
+		Examples:   []string{`from test | summarize collect(name) by team`},
 	},
 	{
 		Name: "collect_map", Kind: KindAggregate,
 		Brief: "Collect into map", Doc: "Collect key-value pairs into a map",
 		Signature: "collect_map(key: any, value: any) -> map",
 		Parameters: []ParamDef{{Name: "key", Doc: "Map keys"}, {Name: "value", Doc: "Map values"}},
+		Examples:   []string{"from test | summarize collect_map(name, score)"},
 	},
 	{
 		Name: "dcount", Kind: KindAggregate,
 		Brief: "Distinct count", Doc: "Count the number of distinct values",
 		Signature: "dcount(value: any) -> int64",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to count"}},
+		Examples:   []string{"from test | summarize dcount(user) by host"},
 	},
 	{
 		Name: "any", Kind: KindAggregate,
 		Brief: "Any value from group", Doc: "Return any arbitrary value from a group",
 		Signature: "any(value: any) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to choose from"}},
+		Examples:   []string{"from test | summarize any(name) by id"},
 	},
 	{
 		Name: "union", Kind: KindAggregate,
 		Brief: "Union of values", Doc: "Create a set union of all values",
 		Signature: "union(value: any) -> set",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to union"}},
+		Examples:   []string{"from test | summarize union(tag) by host"},
 	},
 	{
 		Name: "fuse", Kind: KindAggregate,
 		Brief: "Fuse schemas in group", Doc: "Fuse schemas together within a group",
 		Signature: "fuse(value: any) -> type",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to fuse"}},
+		Examples:   []string{"from test | summarize fuse(this)"},
 	},
 	{
 		Name: "and", Kind: KindAggregate,
 		Brief: "Logical AND aggregate", Doc: "Returns true if all values in the group are true",
 		Signature: "and(value: bool) -> bool",
 		Parameters: []ParamDef{{Name: "value", Doc: "Boolean values"}},
+		Examples:   []string{"from test | summarize and(ok) by job"},
 	},
 	{
 		Name: "or", Kind: KindAggregate,
 		Brief: "Logical OR aggregate", Doc: "Returns true if any value in the group is true",
 		Signature: "or(value: bool) -> bool",
 		Parameters: []ParamDef{{Name: "value", Doc: "Boolean values"}},
+		Examples:   []string{"from test | summarize or(failed) by job"},
 	},
 	{
 		Name: "first", Kind: KindAggregate,
 		Brief: "First value in group", Doc: "Return the first value encountered in a group",
 		Signature: "first(value: any) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to select from"}},
+		Examples:   []string{"from test | summarize first(ts) by host"},
 	},
 	{
 		Name: "last", Kind: KindAggregate,
 		Brief: "Last value in group", Doc: "Return the last value encountered in a group",
 		Signature: "last(value: any) -> any",
 		Parameters: []ParamDef{{Name: "value", Doc: "Values to select from"}},
+		Examples:   []string{"from test | summarize last(ts) by host"},
 	},
 
 	// =========================================================================
@@ -681,20 +861,56 @@ var allBuiltins = []Builtin{
 	{Name: "null", Kind: KindType, Brief: "Null type"},
 
 	// SQL type aliases
-	{Name: "bigint", Kind: KindType, Brief: "64-bit integer (alias for int64)"},
-	{Name: "smallint", Kind: KindType, Brief: "16-bit integer (alias for int16)"},
-	{Name: "integer", Kind: KindType, Brief: "32-bit integer (alias for int32)"},
-	{Name: "int", Kind: KindType, Brief: "32-bit integer (alias for int32)"},
-	{Name: "boolean", Kind: KindType, Brief: "Boolean (alias for bool)"},
-	{Name: "text", Kind: KindType, Brief: "Text (alias for string)"},
-	{Name: "varchar", Kind: KindType, Brief: "Variable character (alias for string)"},
-	{Name: "char", Kind: KindType, Brief: "Character (alias for string)"},
-	{Name: "bytea", Kind: KindType, Brief: "Byte array (alias for bytes)"},
-	{Name: "real", Kind: KindType, Brief: "32-bit float (alias for float32)"},
-	{Name: "float", Kind: KindType, Brief: "64-bit float (alias for float64)"},
-	{Name: "double", Kind: KindType, Brief: "64-bit float (alias for float64)"},
-	{Name: "inet", Kind: KindType, Brief: "IP address (alias for ip)"},
-	{Name: "cidr", Kind: KindType, Brief: "Network CIDR (alias for net)"},
-	{Name: "interval", Kind: KindType, Brief: "Time interval (alias for duration)"},
+	{Name: "bigint", Kind: KindType, Brief: "64-bit integer (alias for int64)", SQLAlias: true},
+	{Name: "smallint", Kind: KindType, Brief: "16-bit integer (alias for int16)", SQLAlias: true},
+	{Name: "integer", Kind: KindType, Brief: "32-bit integer (alias for int32)", SQLAlias: true},
+	{Name: "int", Kind: KindType, Brief: "32-bit integer (alias for int32)", SQLAlias: true},
+	{Name: "boolean", Kind: KindType, Brief: "Boolean (alias for bool)", SQLAlias: true},
+	{Name: "text", Kind: KindType, Brief: "Text (alias for string)", SQLAlias: true},
+	{Name: "varchar", Kind: KindType, Brief: "Variable character (alias for string)", SQLAlias: true},
+	{Name: "char", Kind: KindType, Brief: "Character (alias for string)", SQLAlias: true},
+	{Name: "bytea", Kind: KindType, Brief: "Byte array (alias for bytes)", SQLAlias: true},
+	{Name: "real", Kind: KindType, Brief: "32-bit float (alias for float32)", SQLAlias: true},
+	{Name: "float", Kind: KindType, Brief: "64-bit float (alias for float64)", SQLAlias: true},
+	{Name: "double", Kind: KindType, Brief: "64-bit float (alias for float64)", SQLAlias: true},
+	{Name: "inet", Kind: KindType, Brief: "IP address (alias for ip)", SQLAlias: true},
+	{Name: "cidr", Kind: KindType, Brief: "Network CIDR (alias for net)", SQLAlias: true},
+	{Name: "interval", Kind: KindType, Brief: "Time interval (alias for duration)", SQLAlias: true},
+}
+
+// allSnippets is the list of snippet templates for common query patterns
+var allSnippets = []Snippet{
+	{Name: "summarize count", Brief: "Count values by a field", Stage: true,
+		Body: "summarize count() by ${1:field}"},
+	{Name: "sort head", Brief: "Keep the first values in order", Stage: true,
+		Body: "sort ${1:field} | head ${2:10}"},
+	{Name: "switch", Brief: "Branch on conditions", Stage: true,
+		Body: "switch\n  case ${1:value > 0} ( ${2:pass} )\n  default ( ${3:pass} )"},
+	{Name: "fork", Brief: "Run branches on the same input", Stage: true,
+		Body: "fork\n  ( ${1:pass} )\n  ( ${2:pass} )"},
+	{Name: "join", Brief: "Join with another source on a key", Stage: true,
+		Body: "join (\n  from ${1:source}\n) on left.${2:key}=right.${2:key}"},
+	{Name: "select from", Brief: "SQL query", Stage: true,
+		Body: "select ${1:*}\nfrom ${2:source}\nwhere ${3:condition}"},
+	{Name: "op", Brief: "Declare an operator", Stage: true,
+		Body: "op ${1:name} ${2:x}: (\n  ${3:pass}\n)"},
+	{Name: "fn", Brief: "Declare a function", Stage: true,
+		Body: "fn ${1:name}(${2:x}): ${3:x}"},
+	{Name: "case when", Brief: "Conditional expression",
+		Body: "case when ${1:condition} then ${2:value} else ${3:value} end"},
 }
 
+// allFormats is the list of data formats super reads
+var allFormats = []Format{
+	{Name: "arrows", Brief: "Arrow IPC stream"},
+	{Name: "bsup", Brief: "Super binary"},
+	{Name: "csup", Brief: "Super columnar"},
+	{Name: "csv", Brief: "comma-separated values with a header"},
+	{Name: "json", Brief: "JSON values, one after another"},
+	{Name: "jsup", Brief: "Super values encoded as JSON"},
+	{Name: "line", Brief: "each line as a string"},
+	{Name: "parquet", Brief: "Parquet columnar"},
+	{Name: "sup", Brief: "Super text"},
+	{Name: "tsv", Brief: "tab-separated values with a header"},
+	{Name: "zeek", Brief: "Zeek logs"},
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"slices"
+)
+
+// Client capabilities. Clients differ in what they can show, so the
+// server reads what it needs from the capabilities sent with initialize
+// and degrades rather than sending what the client can't use: completion
+// items lose their snippet placeholders unless the client expands
+// snippets, and their label details unless the client shows them, docs
+// are plain text when the client lists the formats it takes and markdown
+// isn't one, code actions go as commands to a client without code action
+// literals, and diagnostics and completion items carry only the tags the
+// client knows.
+
+// clientSupport is what the client can do, as far as the server cares
+type clientSupport struct {
+	snippets       bool  // completion items may hold snippet placeholders
+	plainText      bool  // docs can't be markdown
+	commandActions bool  // code actions must be sent as commands
+	diagnosticTags []int // tags a diagnostic may carry
+	completionTags []int // tags a completion item may carry
+	deprecated     bool  // completion items may be marked deprecated without a tag
+	labelDetails   bool  // completion items may carry label details
+}
+
+// supportFrom reads what the client can do from its capabilities
+func supportFrom(c ClientCapabilities) clientSupport {
+	markdown := func(formats []string) bool {
+		return len(formats) == 0 || slices.Contains(formats, MarkupKindMarkdown)
+	}
+	td := c.TextDocument
+	return clientSupport{
+		snippets:       td.Completion.CompletionItem.SnippetSupport,
+		plainText:      !markdown(td.Hover.ContentFormat) || !markdown(td.Completion.CompletionItem.DocumentationFormat),
+		commandActions: td.CodeAction.CodeActionLiteralSupport == nil,
+		diagnosticTags: td.PublishDiagnostics.TagSupport.ValueSet,
+		completionTags: td.Completion.CompletionItem.TagSupport.ValueSet,
+		deprecated:     td.Completion.CompletionItem.DeprecatedSupport,
+		labelDetails:   td.Completion.CompletionItem.LabelDetailsSupport,
+	}
+}
+
+// snippetPattern matches a snippet's tab stops and placeholders, like $1
+// or ${1:field}
+var snippetPattern = regexp.MustCompile(`\$(\d+|\{\d+(:([^}]*))?\})`)
+
+// plainInsertText returns a snippet's text without its tab stops, keeping
+// what a placeholder would show
+func plainInsertText(snippet string) string {
+	return snippetPattern.ReplaceAllString(snippet, "$3")
+}
+
+// plainCompletions turns the snippets of items into plain text, for a
+// client that would insert their placeholders as typed
+func plainCompletions(items []CompletionItem) {
+	for i := range items {
+		if items[i].InsertTextFormat == InsertTextFormatSnippet {
+			items[i].InsertText = plainInsertText(items[i].InsertText)
+			items[i].InsertTextFormat = 0
+		}
+	}
+}
+
+// unlabeledCompletions drops the label details of items, for a client
+// that would ignore them; the detail still holds what they show
+func unlabeledCompletions(items []CompletionItem) {
+	for i := range items {
+		items[i].LabelDetails = nil
+	}
+}
+
+// taggedCompletions leaves items only the tags the client knows. A
+// deprecated item for a client that doesn't take the tag is marked with
+// the older deprecated property instead, when the client takes that.
+func (c clientSupport) taggedCompletions(items []CompletionItem) {
+	if slices.Contains(c.completionTags, CompletionItemTagDeprecated) {
+		return
+	}
+	for i := range items {
+		if items[i].Tags != nil {
+			// Tags is shared with the registry, so it is replaced, not edited
+			items[i].Deprecated = c.deprecated
+			items[i].Tags = nil
+		}
+	}
+}
+
+// actionCommands turns code actions into the commands a client without
+// code action literals takes. Each runs superdb.applyEdit, which has the
+// client apply the action's edit, so none are left for a client that
+// can't be asked to apply one.
+func (s *Server) actionCommands(actions []CodeAction) []Command {
+	commands := []Command{}
+	if !s.applyEdits {
+		if len(actions) > 0 {
+			log.Printf("Dropping %d code actions the client can't apply", len(actions))
+		}
+		return commands
+	}
+	for _, a := range actions {
+		if a.Edit == nil {
+			continue
+		}
+		commands = append(commands, Command{
+			Title:     a.Title,
+			Command:   CommandApplyEdit,
+			Arguments: []interface{}{ApplyEditArgs{Label: a.Title, Edit: *a.Edit}},
+		})
+	}
+	return commands
+}
+
+// applyEditCommand asks the client to apply the edit of a code action
+// sent as a command
+func (s *Server) applyEditCommand(_ context.Context, args []json.RawMessage) HandlerResult {
+	var params ApplyEditArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if err := s.applyEdit(params.Label, params.Edit, nil); err != nil {
+		return failure(err)
+	}
+	return success(nil)
+}
+
+// supportedTags keeps the tags of diagnostics the client knows
+func (s *Server) supportedTags(diagnostics []Diagnostic) []Diagnostic {
+	for i, d := range diagnostics {
+		if len(d.Tags) == 0 {
+			continue
+		}
+		var tags []int
+		for _, tag := range d.Tags {
+			if slices.Contains(s.client.diagnosticTags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		diagnostics[i].Tags = tags
+	}
+	return diagnostics
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPlainInsertText(t *testing.T) {
+	tests := []struct{ snippet, want string }{
+		{"ceil($1)", "ceil()"},
+		{"count() by ${1:field}", "count() by field"},
+		{"case when ${1} then $2 end$0", "case when  then  end"},
+		{"price", "price"},
+	}
+	for _, tt := range tests {
+		if got := plainInsertText(tt.snippet); got != tt.want {
+			t.Errorf("plainInsertText(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
+
+func TestCallSnippet(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"ceil", "ceil($1)"},
+		{"pow", "pow(${1:base}, ${2:exp})"},
+		{"now", "now($1)"},
+		{"missing", "missing($1)"},
+		{"count", "count($1)"},
+	}
+	for _, tt := range tests {
+		b := Builtins.Lookup(tt.name)
+		if b.item.InsertText != tt.want || b.item.InsertTextFormat != InsertTextFormatSnippet {
+			t.Errorf("%s: expected snippet %q, got %q (format %d)", tt.name, tt.want, b.item.InsertText, b.item.InsertTextFormat)
+		}
+	}
+	if got := callSnippet("scale", []string{"x", "by"}); got != "scale(${1:x}, ${2:by})" {
+		t.Errorf("Expected tab stops for each parameter, got %q", got)
+	}
+}
+
+func TestCapabilityDegradation(t *testing.T) {
+	initialize := func(c ClientCapabilities) *TestHelper {
+		t.Helper()
+		h := NewTestHelper()
+		if _, err := h.ProcessRequest(1, "initialize", InitializeParams{Capabilities: c}); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	uri := "file:///caps.spq"
+	text := "values 1 | yield ceil(this)"
+	ceil := func(h *TestHelper) CompletionItem {
+		t.Helper()
+		h.openDocument(t, uri, text)
+		response, _ := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		var list CompletionList
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &list)
+		for _, item := range list.Items {
+			if item.Label == "ceil" {
+				return item
+			}
+		}
+		t.Fatal("Expected ceil in completions")
+		return CompletionItem{}
+	}
+
+	var full ClientCapabilities
+	full.TextDocument.Completion.CompletionItem.SnippetSupport = true
+	full.TextDocument.Completion.CompletionItem.LabelDetailsSupport = true
+	full.TextDocument.CodeAction.CodeActionLiteralSupport = &CodeActionLiteralSupport{}
+	full.TextDocument.PublishDiagnostics.TagSupport.ValueSet = []int{DiagnosticTagUnnecessary, DiagnosticTagDeprecated}
+	h := initialize(full)
+	got := ceil(h)
+	if got.InsertText != "ceil($1)" || got.InsertTextFormat != InsertTextFormatSnippet {
+		t.Errorf("Expected a snippet, got %q (format %d)", got.InsertText, got.InsertTextFormat)
+	}
+	if got.LabelDetails == nil || got.LabelDetails.Detail != "(value: number) -> number" {
+		t.Errorf("Expected the signature beside the label, got %+v", got.LabelDetails)
+	}
+	msg := h.openDocument(t, uri, text)
+	var published PublishDiagnosticsParams
+	json.Unmarshal(msg.Params, &published)
+	if len(published.Diagnostics) != 1 || len(published.Diagnostics[0].Tags) != 1 || published.Diagnostics[0].Tags[0] != DiagnosticTagDeprecated {
+		t.Errorf("Expected yield tagged deprecated, got %+v", published.Diagnostics)
+	}
+
+	var bare ClientCapabilities
+	bare.TextDocument.Hover.ContentFormat = []string{MarkupKindPlainText}
+	bare.Workspace.ApplyEdit = true
+	h = initialize(bare)
+	got = ceil(h)
+	if got.InsertText != "ceil()" || got.InsertTextFormat != 0 {
+		t.Errorf("Expected plain text, got %q (format %d)", got.InsertText, got.InsertTextFormat)
+	}
+	if got.LabelDetails != nil || got.Detail != "ceil(value: number) -> number" {
+		t.Errorf("Expected the signature in the detail only, got %q %+v", got.Detail, got.LabelDetails)
+	}
+	msg = h.openDocument(t, uri, text)
+	published = PublishDiagnosticsParams{}
+	json.Unmarshal(msg.Params, &published)
+	if len(published.Diagnostics) != 1 || published.Diagnostics[0].Tags != nil {
+		t.Errorf("Expected no tags, got %+v", published.Diagnostics)
+	}
+
+	response, _ := h.ProcessRequest(3, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 12},
+	})
+	var hover Hover
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &hover)
+	if hover.Contents.Kind != MarkupKindPlainText {
+		t.Errorf("Expected a plain text hover, got %+v", hover.Contents)
+	}
+
+	response, _ = h.ProcessRequest(4, "textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        published.Diagnostics[0].Range,
+		Context:      CodeActionContext{Only: []string{CodeActionKindQuickFix}},
+	})
+	var commands []Command
+	data, _ = json.Marshal(response.Result)
+	json.Unmarshal(data, &commands)
+	if len(commands) != 1 || commands[0].Command != CommandApplyEdit || commands[0].Title != "Use values instead of yield" {
+		t.Errorf("Expected the quick fix as a command, got %+v", commands)
+	}
+}
+
+func TestCompletionDeprecatedTags(t *testing.T) {
+	text := "values 1 | yie"
+	complete := func(c ClientCapabilities) CompletionItem {
+		t.Helper()
+		h := NewTestHelper()
+		if _, err := h.ProcessRequest(1, "initialize", InitializeParams{Capabilities: c}); err != nil {
+			t.Fatal(err)
+		}
+		uri := "file:///tags.spq"
+		h.openDocument(t, uri, text)
+		response, _ := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		var list CompletionList
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &list)
+		for _, item := range list.Items {
+			if item.Label == "yield" {
+				return item
+			}
+		}
+		t.Fatal("Expected yield in completions")
+		return CompletionItem{}
+	}
+
+	var tags ClientCapabilities
+	tags.TextDocument.Completion.CompletionItem.TagSupport.ValueSet = []int{CompletionItemTagDeprecated}
+	if item := complete(tags); len(item.Tags) != 1 || item.Tags[0] != CompletionItemTagDeprecated || item.Deprecated {
+		t.Errorf("Expected yield tagged deprecated, got %+v", item)
+	}
+
+	var flag ClientCapabilities
+	flag.TextDocument.Completion.CompletionItem.DeprecatedSupport = true
+	if item := complete(flag); item.Tags != nil || !item.Deprecated {
+		t.Errorf("Expected yield marked deprecated without a tag, got %+v", item)
+	}
+
+	if item := complete(ClientCapabilities{}); item.Tags != nil || item.Deprecated {
+		t.Errorf("Expected no marks for a client that takes neither, got %+v", item)
+	}
+	if b := Builtins.Lookup("yield"); len(b.item.Tags) != 1 {
+		t.Errorf("Expected the registry's item left tagged, got %+v", b.item)
+	}
+
+	// Keywords on their way out are tagged too, and say what replaces them
+	b := Builtins.Lookup("func")
+	if len(b.item.Tags) != 1 || !strings.Contains(b.item.Detail, "use fn") || !strings.Contains(b.hover, "write `fn` instead") {
+		t.Errorf("Expected func deprecated in favor of fn, got %+v", b.item)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Query catalogs. superdb.exportCatalog writes one document listing every
+// query in the workspace, for teams that keep a shared library of them.
+// Each entry takes its title and any deprecation notice from the comment
+// at the top of the file, and lists the file's declarations with their doc
+// comments:
+//
+//	-- Top talkers by host
+//	-- @deprecated use top_talkers_v2.spq
+//	from conn | count() by host | sort -r count | head 10
+
+// Catalog formats for superdb.exportCatalog
+const (
+	CatalogFormatMarkdown = "markdown"
+	CatalogFormatJSON     = "json"
+)
+
+// defaultCatalogPath is where superdb.exportCatalog writes, relative to
+// the workspace root, when no path is given; the extension follows the
+// format
+const defaultCatalogPath = "docs/query-catalog"
+
+// catalogEntry describes one query
+func catalogEntry(root, path, text string) CatalogEntry {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	entry := CatalogEntry{URI: pathToURI(path), Path: filepath.ToSlash(rel), Sources: []string{}}
+	for _, line := range leadingComments(text) {
+		lower := strings.ToLower(line)
+		switch {
+		case strings.HasPrefix(lower, "@deprecated"):
+			entry.Deprecated = true
+			entry.Deprecation = strings.TrimSpace(line[len("@deprecated"):])
+		case entry.Title == "" && line != "" && !strings.HasPrefix(line, "@"):
+			entry.Title = line
+		}
+	}
+
+	summary, ok := summarizeQuery(text)
+	if !ok {
+		entry.Error = "the query doesn't parse"
+		return entry
+	}
+	entry.Summary = summary
+	entry.Sources = querySources(text)
+	for _, sym := range buildSymbolTable(text).symbols {
+		if sym.kind != symbolParam {
+			entry.Declarations = append(entry.Declarations, CatalogDecl{
+				Name:      sym.name,
+				Signature: sym.signature(),
+				Doc:       sym.doc,
+			})
+		}
+	}
+	return entry
+}
+
+// leadingComments returns the lines of the comments before a query's first
+// token, without their comment markers
+func leadingComments(text string) []string {
+	var lines []string
+	for _, tok := range tokenize(text) {
+		if tok.typ == tokWhitespace || tok.typ == tokNewline {
+			continue
+		}
+		if tok.typ != tokComment {
+			break
+		}
+		body := tok.value
+		if strings.HasPrefix(body, "--") {
+			body = body[2:]
+		} else {
+			body = strings.TrimSuffix(strings.TrimPrefix(body, "/*"), "*/")
+		}
+		for _, line := range strings.Split(body, "\n") {
+			lines = append(lines, strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "*")))
+		}
+	}
+	return lines
+}
+
+// querySources returns the distinct sources a query reads with from,
+// whether as an operator or in a SQL FROM clause, in the order they appear
+func querySources(text string) []string {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	sources := []string{}
+	seen := make(map[string]bool)
+	walkTree(tree, func(node map[string]interface{}) {
+		var item map[string]interface{}
+		switch nodeKind(node) {
+		case "FromOp":
+			item, _ = node["item"].(map[string]interface{})
+		case "SQLFromItem":
+			item, _ = node["input"].(map[string]interface{})
+		default:
+			return
+		}
+		source, _ := item["source"].(map[string]interface{})
+		name, _ := source["value"].(string)
+		if name == "" {
+			name, _ = source["pattern"].(string)
+		}
+		if name == "" {
+			name = nodeText(text, source["loc"])
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			sources = append(sources, name)
+		}
+	})
+	return sources
+}
+
+// buildCatalog describes every query under root, with open documents as
+// edited, sorted by path
+func (s *Server) buildCatalog(root string, p *workDone) []CatalogEntry {
+	entries := []CatalogEntry{}
+	walkQueryFiles(root, p, func(path, text string) {
+		if current, _, ok := s.document(pathToURI(path)); ok {
+			text = current
+		}
+		entries = append(entries, catalogEntry(root, path, text))
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// renderCatalog renders a catalog as markdown
+func renderCatalog(entries []CatalogEntry) string {
+	var b strings.Builder
+	b.WriteString("# Query Catalog\n\n")
+	b.WriteString("<!-- Generated by the superdb.exportCatalog command. Do not edit by hand. -->\n")
+	for _, e := range entries {
+		title := e.Title
+		if title == "" {
+			title = e.Path
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		fmt.Fprintf(&b, "`%s`\n", e.Path)
+		if e.Deprecated {
+			b.WriteString("\n> **Deprecated.**")
+			if e.Deprecation != "" {
+				b.WriteString(" " + e.Deprecation)
+			}
+			b.WriteString("\n")
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&b, "\n_%s._\n", capitalize(e.Error))
+			continue
+		}
+		if e.Summary != "" {
+			fmt.Fprintf(&b, "\n%s\n", e.Summary)
+		}
+		if len(e.Sources) > 0 {
+			quoted := make([]string, len(e.Sources))
+			for i, source := range e.Sources {
+				quoted[i] = "`" + source + "`"
+			}
+			fmt.Fprintf(&b, "\nSources: %s\n", strings.Join(quoted, ", "))
+		}
+		if len(e.Declarations) > 0 {
+			b.WriteString("\nDeclares:\n\n")
+			for _, d := range e.Declarations {
+				fmt.Fprintf(&b, "- `%s`", d.Signature)
+				if d.Doc != "" {
+					b.WriteString(": " + strings.Join(strings.Fields(d.Doc), " "))
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// exportCatalog writes a catalog of the workspace's queries
+func (s *Server) exportCatalog(ctx context.Context, args []json.RawMessage) HandlerResult {
+	var params ExportCatalogArgs
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &params); err != nil {
+			return invalidParams(err)
+		}
+	}
+	var ext string
+	switch params.Format {
+	case CatalogFormatMarkdown, "":
+		params.Format, ext = CatalogFormatMarkdown, ".md"
+	case CatalogFormatJSON:
+		ext = ".json"
+	default:
+		return failure(&RPCError{Code: InvalidParams, Message: fmt.Sprintf("unknown catalog format: %s", params.Format)})
+	}
+	if params.Path == "" {
+		params.Path = defaultCatalogPath + ext
+	}
+
+	path, err := s.workspacePath(params.Path)
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	p := s.startProgress(ctx, "Exporting the query catalog", true)
+	entries := s.buildCatalog(s.rootPath, p)
+	if p.cancelled() {
+		p.end("Cancelled")
+		return failure(&RPCError{Code: RequestCancelled, Message: "catalog export cancelled"})
+	}
+	p.end("Cataloged " + fileCount(len(entries)))
+	var data []byte
+	if params.Format == CatalogFormatJSON {
+		data, err = json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return failure(err)
+		}
+		data = append(data, '\n')
+	} else {
+		data = []byte(renderCatalog(entries))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return failure(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return failure(err)
+	}
+
+	log.Printf("Wrote catalog of %d queries to %s", len(entries), path)
+	return success(ExportCatalogResult{URI: pathToURI(path), Queries: len(entries)})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalogEntry(t *testing.T) {
+	root := t.TempDir()
+	text := "-- Top talkers by host\n-- @owner netops\n-- @deprecated use talkers_v2.spq\nfrom conn | count() by host | sort -r count | head 10"
+	entry := catalogEntry(root, filepath.Join(root, "q", "talkers.spq"), text)
+
+	if entry.Path != "q/talkers.spq" || entry.Title != "Top talkers by host" {
+		t.Errorf("Unexpected path or title: %+v", entry)
+	}
+	if !entry.Deprecated || entry.Deprecation != "use talkers_v2.spq" {
+		t.Errorf("Expected the deprecation notice, got %+v", entry)
+	}
+	if !strings.HasPrefix(entry.Summary, "Reads conn, aggregates count() by host") {
+		t.Errorf("Unexpected summary: %q", entry.Summary)
+	}
+
+	entry = catalogEntry(root, filepath.Join(root, "lib.spq"), "-- Helpers\n\n-- @doc Doubles x\nfn double(x): ( x * 2 )\nvalues double(1)")
+	want := []CatalogDecl{{Name: "double", Signature: "fn double(x)", Doc: "Doubles x"}}
+	if entry.Title != "Helpers" || !reflect.DeepEqual(entry.Declarations, want) {
+		t.Errorf("Expected the documented fn, got %+v", entry)
+	}
+	if md := renderCatalog([]CatalogEntry{entry}); !strings.Contains(md, "- `fn double(x)`: Doubles x\n") {
+		t.Errorf("Expected the fn in the markdown, got:\n%s", md)
+	}
+
+	entry = catalogEntry(root, filepath.Join(root, "broken.spq"), "/* Broken */ from |")
+	if entry.Title != "Broken" || entry.Error == "" {
+		t.Errorf("Expected a title and a parse error, got %+v", entry)
+	}
+}
+
+func TestQuerySources(t *testing.T) {
+	text := "from a.json | join (from 'b.json') on left.id=right.id\n| fork ( from *.sup ) ( from a.json )"
+	if got, want := querySources(text), []string{"a.json", "b.json", "*.sup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, want := querySources("select x from t join u on t.id=u.id"), []string{"t", "u"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected SQL sources %v, got %v", want, got)
+	}
+}
+
+func TestExportCatalogCommand(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "b.spq"), []byte("-- Second\nvalues 1"), 0o644)
+	os.WriteFile(filepath.Join(root, "a.spq"), []byte("-- First\nfrom data.json | head 5"), 0o644)
+
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	// An open document is cataloged as edited
+	h.openDocument(t, pathToURI(filepath.Join(root, "b.spq")), "-- Second, edited\nvalues 1")
+
+	for _, format := range []string{CatalogFormatMarkdown, CatalogFormatJSON} {
+		args, _ := json.Marshal(ExportCatalogArgs{Format: format})
+		response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+			Command:   CommandExportCatalog,
+			Arguments: []json.RawMessage{args},
+		})
+		if err != nil {
+			t.Fatalf("executeCommand failed: %v", err)
+		}
+		if response.Error != nil {
+			t.Fatalf("Unexpected error: %s", response.Error.Message)
+		}
+		result := response.Result.(map[string]interface{})
+		if result["queries"] != float64(2) {
+			t.Errorf("Expected 2 queries, got %v", result["queries"])
+		}
+		path, _ := uriToPath(result["uri"].(string))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected the catalog at %s: %v", path, err)
+		}
+
+		if format == CatalogFormatJSON {
+			var entries []CatalogEntry
+			if err := json.Unmarshal(data, &entries); err != nil {
+				t.Fatalf("Catalog isn't JSON: %v", err)
+			}
+			if len(entries) != 2 || entries[0].Title != "First" || entries[1].Title != "Second, edited" {
+				t.Errorf("Unexpected entries: %+v", entries)
+			}
+			continue
+		}
+		want := "\n## First\n\n`a.spq`\n\nReads data.json and returns the first 5.\n\nSources: `data.json`\n"
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected markdown to contain %q, got:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkContext is how many lines around each diagnostic -check shows
+const checkContext = 1
+
+// checkOptions are what -check looks for beyond diagnostics, and what
+// fails it
+type checkOptions struct {
+	format bool // also report files the formatter would change
+	failOn int  // the least severe diagnostic that fails the check
+	// lines, when set, limits each file's report to the spans of lines
+	// that a diff changed in it, keyed by path
+	lines map[string][]lineSpan
+	// read returns the text of a file to check; os.ReadFile when nil
+	read func(path string) ([]byte, error)
+}
+
+// runCheck prints the diagnostics for each file the way the editor would
+// show them and returns the exit status: 0 when no file has errors, 1 when
+// one does, and 2 when a file can't be read. Warnings alone don't fail.
+func runCheck(paths []string, w io.Writer) int {
+	return checkFiles(paths, checkOptions{failOn: DiagnosticSeverityError}, w)
+}
+
+// checkFiles is runCheck with options: status 1 means a file has a
+// diagnostic at least as severe as opts.failOn
+func checkFiles(paths []string, opts checkOptions, w io.Writer) int {
+	s := NewServer()
+	read := opts.read
+	if read == nil {
+		read = os.ReadFile
+	}
+	status := 0
+	for _, path := range paths {
+		data, err := read(path)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", path, err)
+			status = 2
+			continue
+		}
+		text := string(data)
+		diagnostics := s.diagnose(context.Background(), path, text)
+		if opts.format {
+			if d, ok := s.formatDiagnostic(path, text); ok {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+		if opts.lines != nil {
+			diagnostics = diagnosticsOnLines(diagnostics, opts.lines[path])
+		}
+		if len(diagnostics) == 0 {
+			continue
+		}
+		counts := make(map[int]int)
+		for _, d := range diagnostics {
+			counts[d.Severity]++
+			if d.Severity <= opts.failOn && status == 0 {
+				status = 1
+			}
+		}
+		fmt.Fprintf(w, "%s: %s\n", path, summarizeCounts(counts))
+		fmt.Fprint(w, renderDiagnostics(text, diagnostics, checkContext))
+	}
+	return status
+}
+
+// formatDiagnostic reports the lines the formatter would change in text,
+// with the options an editor uses by default and any the settings fix
+func (s *Server) formatDiagnostic(path, text string) (Diagnostic, bool) {
+	settings := s.settings()
+	options := settings.Format.apply(FormattingOptions{TabSize: 2, InsertSpaces: true})
+	var formatted string
+	if s.isDataFile(path) {
+		formatted = formatDataDocument(text, options)
+	} else {
+		formatted = formatStyled(text, options, settings.FormatterStyle)
+	}
+	if formatted == text {
+		return Diagnostic{}, false
+	}
+	before := strings.Split(text, "\n")
+	after := strings.Split(formatted, "\n")
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	// Lines only added by the formatter are marked where they go
+	prefix = min(prefix, len(before)-1)
+	last := max(prefix, len(before)-1-suffix)
+	lines := fmt.Sprintf("line %d", prefix+1)
+	if last > prefix {
+		lines = fmt.Sprintf("lines %d-%d", prefix+1, last+1)
+	}
+	return Diagnostic{
+		Range:    Range{Start: Position{Line: prefix}, End: Position{Line: last, Character: len(before[last])}},
+		Severity: DiagnosticSeverityWarning,
+		Code:     "unformatted",
+		Source:   "superdb-lsp",
+		Message:  s.messages.format("unformatted", "lines", lines),
+	}, true
+}
+
+// diagnosticsOnLines returns the diagnostics that touch any of spans
+func diagnosticsOnLines(diagnostics []Diagnostic, spans []lineSpan) []Diagnostic {
+	var kept []Diagnostic
+	for _, d := range diagnostics {
+		for _, span := range spans {
+			if d.Range.Start.Line <= span.last && span.first <= d.Range.End.Line {
+				kept = append(kept, d)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// summarizeCounts describes diagnostic counts by severity, most severe
+// first, e.g. "1 error, 2 warnings"
+func summarizeCounts(counts map[int]int) string {
+	var parts []string
+	for severity := DiagnosticSeverityError; severity <= DiagnosticSeverityHint; severity++ {
+		n := counts[severity]
+		if n == 0 {
+			continue
+		}
+		name := severityName(severity)
+		if n != 1 && severity <= DiagnosticSeverityWarning {
+			name += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, name))
+	}
+	return strings.Join(parts, ", ")
+}
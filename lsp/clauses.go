@@ -0,0 +1,113 @@
+package main
+
+import "strings"
+
+// Clause gating. Some keywords only mean something inside a clause that
+// comes before them: having after a group by, then, else, and end in a
+// case expression, and the join modifiers in a join or a from clause a
+// join can follow. Completion leaves them out anywhere else. The query is
+// seldom whole while it's typed and so seldom parses, so clauseAt tracks
+// the clauses open at the cursor from the tokens of its stage instead.
+
+// clauseKeywords are the keywords completion offers only in a clause
+// that allows them
+var clauseKeywords = map[string]bool{
+	"having": true, "then": true, "else": true, "end": true,
+	"inner": true, "left": true, "right": true, "outer": true, "full": true,
+	"cross": true, "anti": true, "on": true, "using": true,
+}
+
+// joinStarts are the words a join operator can start with
+var joinStarts = map[string]bool{
+	"join": true, "inner": true, "left": true, "right": true, "full": true,
+	"cross": true, "anti": true,
+}
+
+// clauseState is what the clauses open where a stage ends allow
+type clauseState struct {
+	grouped bool // a group by of the current select
+	cases   int  // case expressions not yet ended
+	joining bool // in a join or a from clause
+}
+
+// clauseAt returns the clauses open at the end of stage, the text of a
+// stage up to the word typed. Only the stage's own tokens count: those in
+// brackets it holds, like a subquery, are in clauses of their own.
+func clauseAt(stage string) clauseState {
+	var c clauseState
+	depth := 0
+	first, prev := "", ""
+	for _, tok := range tokenize(stage) {
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+			continue
+		case tokPunctuation:
+			switch tok.value[0] {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				depth--
+			}
+		}
+		word := strings.ToLower(tok.value)
+		if first == "" {
+			first = word
+			// A stage that starts with a join modifier is a join, like
+			// left join (from b) on a=b
+			c.joining = joinStarts[word]
+		}
+		if depth == 0 && (tok.typ == tokKeyword || tok.typ == tokIdentifier) {
+			switch word {
+			case "select", "union":
+				c.grouped, c.joining = false, false
+			case "by":
+				c.grouped = c.grouped || prev == "group"
+			case "case":
+				// A switch's cases are its branches
+				if first != "switch" {
+					c.cases++
+				}
+			case "end":
+				if c.cases > 0 {
+					c.cases--
+				}
+			case "from", "join":
+				c.joining = true
+			case "where", "group", "having", "order", "limit", "offset":
+				c.joining = false
+			}
+		}
+		prev = word
+	}
+	return c
+}
+
+// allows reports whether the clauses c holds allow keyword
+func (c clauseState) allows(keyword string) bool {
+	switch keyword {
+	case "having":
+		return c.grouped
+	case "then", "else", "end":
+		return c.cases > 0
+	default:
+		return !clauseKeywords[keyword] || c.joining
+	}
+}
+
+// withoutStrayKeywords drops from items the keywords the clauses open at
+// offset in text don't allow
+func withoutStrayKeywords(items []CompletionItem, text string, offset int) []CompletionItem {
+	stage, _, _, ok := stageAt(text, offset)
+	if !ok {
+		return items
+	}
+	c := clauseAt(stage)
+	kept := items[:0]
+	for _, item := range items {
+		if item.Kind == CompletionItemKindKeyword && !c.allows(strings.ToLower(item.Label)) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClauseAt(t *testing.T) {
+	tests := []struct {
+		stage string
+		want  clauseState
+	}{
+		{"select a, count() from t group by a ", clauseState{grouped: true}},
+		{"select a from t where ", clauseState{}},
+		{"select a from (select b from u group by b) ", clauseState{joining: true}},
+		{"select a from t group by a union select b from u where ", clauseState{}},
+		{"values case when a ", clauseState{cases: 1}},
+		{"values case when a then case when b then 1 end ", clauseState{cases: 1}},
+		{"values case when a then 1 end ", clauseState{}},
+		{"switch case a > 1 ( values 1 ) ", clauseState{}},
+		{"select * from t ", clauseState{joining: true}},
+		{"select * from t join u ", clauseState{joining: true}},
+		{"left join (from b) ", clauseState{joining: true}},
+		{"SELECT * FROM t ORDER BY ", clauseState{}},
+		{"where a ", clauseState{}},
+	}
+	for _, tt := range tests {
+		if got := clauseAt(tt.stage); got != tt.want {
+			t.Errorf("clauseAt(%q) = %+v, want %+v", tt.stage, got, tt.want)
+		}
+	}
+}
+
+func TestClauseKeywordCompletions(t *testing.T) {
+	tests := []struct {
+		text    string // | marks the cursor
+		offered []string
+		left    []string
+	}{
+		{"select a, count() from t group by a |", []string{"having", "order"}, []string{"then", "else", "end", "on", "left"}},
+		{"select a from t where a > 1 |", []string{"and", "group"}, []string{"having", "then", "inner", "using"}},
+		{"values case when a |", []string{"then"}, []string{"having", "cross"}},
+		{"values case when a then 1 |", []string{"else", "end"}, nil},
+		{"values case when a then 1 end |", nil, []string{"then", "else", "end"}},
+		{"select * from t |", []string{"left", "inner", "join"}, []string{"having", "end"}},
+		{"select * from t join u |", []string{"on", "using"}, nil},
+		{"from t | where a |", []string{"and"}, []string{"on", "anti", "outer", "else"}},
+	}
+	for _, tt := range tests {
+		offset := strings.LastIndex(tt.text, "|")
+		text := tt.text[:offset] + tt.text[offset+1:]
+		labels := completionLabels(getCompletions(context.Background(), text, positionAt(text, offset)))
+		for _, want := range tt.offered {
+			if !slices.Contains(labels, want) {
+				t.Errorf("%q: expected %s to be offered", tt.text, want)
+			}
+		}
+		for _, stray := range tt.left {
+			if slices.Contains(labels, stray) {
+				t.Errorf("%q: expected %s to be left out", tt.text, stray)
+			}
+		}
+	}
+}
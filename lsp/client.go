@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Requests from the server to the client, such as window/showMessageRequest.
+// The reply arrives on the same connection as client requests and is routed
+// back by ID to the callback registered when the request was sent.
+
+// request sends a request to the client and calls onResponse with the reply
+func (s *Server) request(method string, params interface{}, onResponse func(RPCMessage)) error {
+	msg, err := notification(method, params)
+	if err != nil {
+		return err
+	}
+	req := msg.(RPCMessage)
+
+	s.pendingMu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("superdb-%d", s.nextID)
+	s.pending[id] = onResponse
+	s.pendingMu.Unlock()
+
+	req.ID = id
+	if err := s.send(req); err != nil {
+		s.pendingMu.Lock()
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// handleResponse routes a client's reply to the request it answers
+func (s *Server) handleResponse(msg RPCMessage) {
+	id := fmt.Sprint(msg.ID)
+	s.pendingMu.Lock()
+	onResponse, ok := s.pending[id]
+	delete(s.pending, id)
+	s.pendingMu.Unlock()
+	if !ok {
+		log.Printf("Response to unknown request: id=%v", msg.ID)
+		return
+	}
+	onResponse(msg)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ClientSession is a recorded editor configuration: the initialize payload
+// the editor sends, any notifications it sends after initialized, and a
+// document plus requests to replay against it
+type ClientSession struct {
+	Client        string                 `json:"client"`
+	Description   string                 `json:"description"`
+	Initialize    json.RawMessage        `json:"initialize"`
+	Notifications []ClientNotification   `json:"notifications"`
+	Document      TextDocumentItem       `json:"document"`
+	Requests      []ClientSessionRequest `json:"requests"`
+}
+
+// ClientNotification is a notification the client sends unprompted
+type ClientNotification struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// ClientSessionRequest is a request to replay and what its result must hold
+type ClientSessionRequest struct {
+	Method         string          `json:"method"`
+	Params         json.RawMessage `json:"params"`
+	ExpectContains string          `json:"expectContains,omitempty"`
+}
+
+// TestClientSessions replays each recorded editor session in
+// testdata/clients through the full server loop, so capability shapes and
+// payload quirks specific to one editor are caught here
+func TestClientSessions(t *testing.T) {
+	files, err := filepath.Glob("testdata/clients/*.json")
+	if err != nil {
+		t.Fatalf("failed to glob client sessions: %v", err)
+	}
+	if len(files) == 0 {
+		t.Skip("no client sessions found in testdata/clients/")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", file, err)
+			}
+			var session ClientSession
+			if err := json.Unmarshal(data, &session); err != nil {
+				t.Fatalf("failed to parse %s: %v", file, err)
+			}
+			replayClientSession(t, session)
+		})
+	}
+}
+
+func replayClientSession(t *testing.T, session ClientSession) {
+	t.Helper()
+
+	in := &bytes.Buffer{}
+	nextID := 1
+	requestMethods := make(map[float64]ClientSessionRequest)
+	write := func(id interface{}, method string, params json.RawMessage) {
+		msg := RPCMessage{JSONRPC: "2.0", ID: id, Method: method, Params: params}
+		if err := writeMessage(in, msg); err != nil {
+			t.Fatalf("writeMessage failed: %v", err)
+		}
+	}
+	request := func(req ClientSessionRequest) {
+		requestMethods[float64(nextID)] = req
+		write(nextID, req.Method, req.Params)
+		nextID++
+	}
+
+	request(ClientSessionRequest{Method: "initialize", Params: session.Initialize})
+	write(nil, "initialized", json.RawMessage(`{}`))
+	for _, n := range session.Notifications {
+		write(nil, n.Method, n.Params)
+	}
+	openParams, err := json.Marshal(DidOpenTextDocumentParams{TextDocument: session.Document})
+	if err != nil {
+		t.Fatalf("Marshal didOpen: %v", err)
+	}
+	write(nil, "textDocument/didOpen", openParams)
+	for _, req := range session.Requests {
+		request(req)
+	}
+	request(ClientSessionRequest{Method: "shutdown"})
+
+	out := &bytes.Buffer{}
+	s := NewServer()
+	// The sessions' processIds are the recording editor's, long gone
+	s.orphaned = nil
+	if err := s.Run(in, out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	answered := make(map[float64]bool)
+	for _, msg := range drainMessages(t, out) {
+		if msg.ID == nil || msg.Method != "" {
+			// Notifications and the server's own requests
+			continue
+		}
+		id, ok := msg.ID.(float64)
+		if !ok {
+			t.Errorf("Unexpected response ID %v", msg.ID)
+			continue
+		}
+		req := requestMethods[id]
+		answered[id] = true
+		if msg.Error != nil {
+			t.Errorf("%s (%s): error response %d: %s",
+				session.Client, req.Method, msg.Error.Code, msg.Error.Message)
+			continue
+		}
+		if req.ExpectContains == "" {
+			continue
+		}
+		result, err := json.Marshal(msg.Result)
+		if err != nil {
+			t.Fatalf("Marshal result: %v", err)
+		}
+		if !strings.Contains(string(result), req.ExpectContains) {
+			t.Errorf("%s (%s): expected result to contain %s, got %s",
+				session.Client, req.Method, req.ExpectContains, result)
+		}
+	}
+	for id, req := range requestMethods {
+		if !answered[id] {
+			t.Errorf("%s (%s): no response", session.Client, req.Method)
+		}
+	}
+}
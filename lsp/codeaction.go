@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Refactoring code actions. The refactors move the stage at the cursor up
+// or down, or filter by a selected shape (see shapeFilter). Either can
+// change what a query returns, so they are checked against a sample of
+// the query's source data when the client turns on verifyRefactors; see
+// verifyOnSample.
+// Running the sample takes a while, so a refactor is checked only when the
+// client resolves it in codeAction/resolve, not for every list of actions.
+// The quick fixes for operators in an older spelling are in aliases.go.
+
+// refactor is a candidate rewrite of a whole document
+type refactor struct {
+	title string
+	edit  TextEdit
+	text  string // the document with edit applied
+}
+
+// codeActionData identifies a refactor to check on sample data when it
+// is resolved
+type codeActionData struct {
+	URI     string   `json:"uri"`
+	Version int      `json:"version"` // of the document the edit was made for
+	Edit    TextEdit `json:"edit"`    // in byte offsets
+}
+
+// handleCodeAction processes textDocument/codeAction requests
+func (s *Server) handleCodeAction(msg RPCMessage) HandlerResult {
+	var params CodeActionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	uri := params.TextDocument.URI
+	s.promote(uri)
+	text, version, ok := s.document(uri)
+	if !ok {
+		log.Printf("Document not found: %s", uri)
+		return success([]CodeAction{})
+	}
+	if s.isDataFile(uri) || s.isReadOnly(uri) {
+		return success([]CodeAction{})
+	}
+
+	log.Printf("Code action request: %s at line=%d, char=%d",
+		uri, params.Range.Start.Line, params.Range.Start.Character)
+
+	params.Range = s.rangeFromClient(text, params.Range)
+	actions := s.codeActions(uri, text, version, params)
+	for _, action := range actions {
+		if action.Edit != nil {
+			s.editToClient(uri, text, action.Edit)
+		}
+	}
+	if s.client.commandActions {
+		return success(s.actionCommands(actions))
+	}
+	return success(actions)
+}
+
+// codeActions returns the quick fixes and refactors for the range of text,
+// at version, that params ask about
+func (s *Server) codeActions(uri, text string, version int, params CodeActionParams) []CodeAction {
+	actions := []CodeAction{}
+	if wantsKind(params.Context.Only, CodeActionKindQuickFix) {
+		actions = append(actions, s.aliasFixes(uri, text, params.Range)...)
+	}
+	offset, ok := offsetAt(text, params.Range.Start)
+	if !ok || !wantsKind(params.Context.Only, CodeActionKindRefactorRewrite) {
+		return actions
+	}
+	verify := s.settings().VerifyRefactors
+	for _, r := range stageRefactors(text, offset) {
+		action := CodeAction{
+			Title: r.title,
+			Kind:  CodeActionKindRefactorRewrite,
+			Edit:  &WorkspaceEdit{Changes: map[string][]TextEdit{uri: {r.edit}}},
+		}
+		if verify {
+			action.Data = codeActionData{URI: uri, Version: version, Edit: r.edit}
+		}
+		actions = append(actions, action)
+	}
+	if r, ok := shapeFilter(text, params.Range); ok {
+		actions = append(actions, CodeAction{
+			Title: r.title,
+			Kind:  CodeActionKindRefactorRewrite,
+			Edit:  &WorkspaceEdit{Changes: map[string][]TextEdit{uri: {r.edit}}},
+		})
+	}
+	return actions
+}
+
+// handleCodeActionResolve processes codeAction/resolve requests, checking
+// a refactor on sample data. A refactor whose output matches says so in
+// its title; one whose output differs is still offered, but only as a
+// suggestion under the general refactor kind. One made for an older
+// version of its document is left as it is.
+func (s *Server) handleCodeActionResolve(ctx context.Context, msg RPCMessage) HandlerResult {
+	var action struct {
+		CodeAction
+		Data *codeActionData `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Params, &action); err != nil {
+		return invalidParams(err)
+	}
+	resolved, data := action.CodeAction, action.Data
+	if data == nil {
+		return success(resolved)
+	}
+	text, version, ok := s.document(data.URI)
+	if !ok || version != data.Version {
+		return success(resolved)
+	}
+	start, ok := offsetAt(text, data.Edit.Range.Start)
+	if !ok {
+		return success(resolved)
+	}
+	end, ok := offsetAt(text, data.Edit.Range.End)
+	if !ok || end < start {
+		return success(resolved)
+	}
+	rewritten := text[:start] + data.Edit.NewText + text[end:]
+
+	switch verifyOnSample(ctx, s.settings().Lake, text, rewritten) {
+	case sampleMatches:
+		resolved.Title += " (verified on sample)"
+	case sampleDiffers:
+		resolved.Title += " (suggestion: results differ on sample)"
+		resolved.Kind = CodeActionKindRefactor
+	}
+	return success(resolved)
+}
+
+// wantsKind reports whether a client filter of code action kinds admits
+// kind. An empty filter admits everything, and a filter entry admits its
+// sub-kinds, so "refactor" admits "refactor.rewrite".
+func wantsKind(only []string, kind string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, k := range only {
+		if kind == k || strings.HasPrefix(kind, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// stageRefactors returns the stage reordering refactors for the top-level
+// stage at offset. The first stage is the query's source and stays put.
+func stageRefactors(text string, offset int) []refactor {
+	stages := splitStages(tokenize(text))
+	current := -1
+	for i, st := range stages {
+		if offset >= st.start && offset <= st.end {
+			current = i
+			break
+		}
+	}
+	if current < 1 {
+		return nil
+	}
+
+	var refactors []refactor
+	op := stages[current].operator()
+	if current > 1 {
+		refactors = append(refactors, swapStages(text, stages[current-1], stages[current],
+			fmt.Sprintf("Move %s stage up", op)))
+	}
+	if current < len(stages)-1 {
+		refactors = append(refactors, swapStages(text, stages[current], stages[current+1],
+			fmt.Sprintf("Move %s stage down", op)))
+	}
+	return refactors
+}
+
+// swapStages exchanges the text of two adjacent stages, keeping the pipe,
+// whitespace, and comments between them where they are
+func swapStages(text string, first, second pipelineStage, title string) refactor {
+	aStart, aEnd := stageBody(text, first)
+	bStart, bEnd := stageBody(text, second)
+	newText := text[bStart:bEnd] + text[aEnd:bStart] + text[aStart:aEnd]
+	return refactor{
+		title: title,
+		edit: TextEdit{
+			Range:   Range{Start: positionAt(text, aStart), End: positionAt(text, bEnd)},
+			NewText: newText,
+		},
+		text: text[:aStart] + newText + text[bEnd:],
+	}
+}
+
+// stageBody returns the offsets of a stage's text without its pipe or
+// surrounding whitespace
+func stageBody(text string, st pipelineStage) (start, end int) {
+	start = st.start + len(st.pipe)
+	span := text[start:st.end]
+	start += len(span) - len(strings.TrimLeft(span, " \t\r\n"))
+	return start, start + len(strings.TrimSpace(span))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStageRefactors(t *testing.T) {
+	text := "values 1, 2\n| sort this -- ascending\n| where this > 1\n| head 1"
+
+	refactors := stageRefactors(text, strings.Index(text, "where"))
+	if len(refactors) != 2 {
+		t.Fatalf("Expected up and down refactors, got %+v", refactors)
+	}
+	up, down := refactors[0], refactors[1]
+	if up.title != "Move where stage up" || down.title != "Move where stage down" {
+		t.Errorf("Unexpected titles: %q, %q", up.title, down.title)
+	}
+	if want := "values 1, 2\n| where this > 1\n| sort this -- ascending\n| head 1"; up.text != want {
+		t.Errorf("Move up:\nexpected %q\ngot      %q", want, up.text)
+	}
+	if want := "values 1, 2\n| sort this -- ascending\n| head 1\n| where this > 1"; down.text != want {
+		t.Errorf("Move down:\nexpected %q\ngot      %q", want, down.text)
+	}
+	wantRange := Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 2, Character: 16}}
+	if up.edit.Range != wantRange {
+		t.Errorf("Expected move up to replace %+v, got %+v", wantRange, up.edit.Range)
+	}
+
+	// The source stage stays first, and the stage after it can't move above it
+	if got := stageRefactors(text, 2); len(got) != 0 {
+		t.Errorf("Expected no refactors on the source stage, got %+v", got)
+	}
+	if got := stageRefactors(text, strings.Index(text, "sort")); len(got) != 1 || got[0].title != "Move sort stage down" {
+		t.Errorf("Expected only move down for the second stage, got %+v", got)
+	}
+}
+
+func TestVerifyOnSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.sup")
+	if err := os.WriteFile(path, []byte("{x:3}\n{x:1}\n{x:2}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := "from '" + path + "'"
+
+	tests := []struct {
+		name      string
+		original  string
+		rewritten string
+		want      sampleVerdict
+	}{
+		{"equivalent", source + " | sort x | where x > 1", source + " | where x > 1 | sort x", sampleMatches},
+		{"different", source + " | sort x | head 1", source + " | head 1 | sort x", sampleDiffers},
+		{"source changed", source + " | sort x", "values 1 | sort x", sampleUnchecked},
+		{"no source data", "from '/no/such/file.sup' | sort x | head 1", "from '/no/such/file.sup' | head 1 | sort x", sampleUnchecked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyOnSample(context.Background(), "", tt.original, tt.rewritten); got != tt.want {
+				t.Errorf("Expected verdict %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCodeActionVerifiesOnSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample.sup")
+	if err := os.WriteFile(path, []byte("{x:3}\n{x:1}\n{x:2}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewTestHelper()
+	params := InitializeParams{
+		InitializationOptions: json.RawMessage(`{"verifyRefactors": true}`),
+	}
+	params.Capabilities.TextDocument.CodeAction.CodeActionLiteralSupport = &CodeActionLiteralSupport{}
+	if _, err := h.ProcessRequest(1, "initialize", params); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	uri := "file:///refactor.spq"
+	text := "from '" + path + "'\n| sort x\n| head 1\n| where x > 0"
+	h.openDocument(t, uri, text)
+
+	response, err := h.ProcessRequest(2, "textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: Position{Line: 2, Character: 3}, End: Position{Line: 2, Character: 3}},
+	})
+	if err != nil {
+		t.Fatalf("codeAction failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var actions []CodeAction
+	if err := json.Unmarshal(resultBytes, &actions); err != nil {
+		t.Fatalf("Unmarshal actions: %v", err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("Expected two actions, got %+v", actions)
+	}
+	// Listing actions runs nothing; resolving one checks it
+	if actions[0].Title != "Move head stage up" || actions[0].Data == nil {
+		t.Fatalf("Expected an unchecked action to resolve, got %+v", actions[0])
+	}
+	resolve := func(id int, action CodeAction) CodeAction {
+		response, err := h.ProcessRequest(id, "codeAction/resolve", action)
+		if err != nil || response.Error != nil {
+			t.Fatalf("codeAction/resolve failed: %+v, %v", response, err)
+		}
+		var resolved CodeAction
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &resolved)
+		return resolved
+	}
+
+	// Moving head above sort changes which record comes out; moving it below
+	// where doesn't
+	up, down := resolve(3, actions[0]), resolve(4, actions[1])
+	if up.Title != "Move head stage up (suggestion: results differ on sample)" || up.Kind != CodeActionKindRefactor {
+		t.Errorf("Expected move up downgraded to a suggestion, got %q (%s)", up.Title, up.Kind)
+	}
+	if down.Title != "Move head stage down (verified on sample)" || down.Kind != CodeActionKindRefactorRewrite {
+		t.Errorf("Expected move down verified, got %q (%s)", down.Title, down.Kind)
+	}
+	if edits := down.Edit.Changes[uri]; len(edits) != 1 || edits[0].NewText != "where x > 0\n| head 1" {
+		t.Errorf("Unexpected move down edit: %+v", down.Edit)
+	}
+
+	// After an edit the action is stale and isn't checked
+	h.ProcessNotification("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text + "\n"}},
+	})
+	if stale := resolve(5, actions[0]); stale.Title != "Move head stage up" {
+		t.Errorf("Expected a stale action left alone, got %q", stale.Title)
+	}
+}
+
+func TestCodeActionWithoutVerification(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///refactor.spq"
+	h.openDocument(t, uri, "values 1 | sort this | head 1")
+
+	params := CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: Position{Line: 0, Character: 13}, End: Position{Line: 0, Character: 13}},
+	}
+	response, err := h.ProcessRequest(1, "textDocument/codeAction", params)
+	if err != nil {
+		t.Fatalf("codeAction failed: %v", err)
+	}
+	actions := response.Result.([]interface{})
+	if len(actions) != 1 || actions[0].(map[string]interface{})["title"] != "Move sort stage down" {
+		t.Errorf("Expected an unannotated move down, got %+v", actions)
+	}
+
+	// A client asking only for quick fixes gets no refactors
+	params.Context.Only = []string{"quickfix"}
+	response, err = h.ProcessRequest(2, "textDocument/codeAction", params)
+	if err != nil {
+		t.Fatalf("codeAction failed: %v", err)
+	}
+	if actions := response.Result.([]interface{}); len(actions) != 0 {
+		t.Errorf("Expected no actions for quickfix only, got %+v", actions)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Code lenses. Each top-level stage of a pipeline of two or more gets three
+// lenses above it: its index, "Run to here", which runs the query through
+// the end of the stage with superdb.runQuery, and "Explain", which
+// describes the stage with superdb.summarizeQuery. Lenses come back bare
+// and get their commands in codeLens/resolve, so a client that only shows
+// the visible ones doesn't pay for the rest.
+
+// Code lens kinds, carried in a lens's data until it is resolved
+const (
+	codeLensStage   = "stage"
+	codeLensRun     = "run"
+	codeLensExplain = "explain"
+)
+
+// codeLensData identifies what a lens does once resolved
+type codeLensData struct {
+	URI    string `json:"uri"`
+	Kind   string `json:"kind"`
+	Stage  int    `json:"stage"`  // 1-based
+	Stages int    `json:"stages"` // in the pipeline
+	Range  Range  `json:"range"`  // the stage's text
+}
+
+// codeLenses returns the unresolved lenses for the stages of text
+func codeLenses(uri, text string) []CodeLens {
+	lenses := []CodeLens{}
+	stages := splitStages(tokenize(text))
+	if len(stages) < 2 {
+		return lenses
+	}
+	for i, st := range stages {
+		start, end := stageBody(text, st)
+		if start >= end {
+			continue
+		}
+		rng := Range{Start: positionAt(text, start), End: positionAt(text, end)}
+		for _, kind := range []string{codeLensStage, codeLensRun, codeLensExplain} {
+			lenses = append(lenses, CodeLens{
+				Range: rng,
+				Data:  codeLensData{URI: uri, Kind: kind, Stage: i + 1, Stages: len(stages), Range: rng},
+			})
+		}
+	}
+	return lenses
+}
+
+// resolveCodeLens gives a lens its command
+func resolveCodeLens(lens CodeLens, data codeLensData) CodeLens {
+	switch data.Kind {
+	case codeLensStage:
+		// A title alone; there is nothing to run
+		lens.Command = &Command{Title: fmt.Sprintf("Stage %d of %d", data.Stage, data.Stages)}
+	case codeLensRun:
+		end := data.Range.End
+		lens.Command = &Command{
+			Title:     "Run to here",
+			Command:   CommandRunQuery,
+			Arguments: []interface{}{RunQueryArgs{URI: data.URI, Through: &end}},
+		}
+	case codeLensExplain:
+		rng := data.Range
+		lens.Command = &Command{
+			Title:     "Explain",
+			Command:   CommandSummarizeQuery,
+			Arguments: []interface{}{SummarizeQueryArgs{URI: data.URI, Range: &rng}},
+		}
+	}
+	return lens
+}
+
+// handleCodeLens processes textDocument/codeLens requests
+func (s *Server) handleCodeLens(msg RPCMessage) HandlerResult {
+	var params CodeLensParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success([]CodeLens{})
+	}
+	lenses := codeLenses(params.TextDocument.URI, text)
+	for i, lens := range lenses {
+		data := lens.Data.(codeLensData)
+		data.Range = s.rangeToClient(text, data.Range)
+		lenses[i].Range, lenses[i].Data = data.Range, data
+	}
+	return success(lenses)
+}
+
+// handleCodeLensResolve processes codeLens/resolve requests
+func (s *Server) handleCodeLensResolve(msg RPCMessage) HandlerResult {
+	var lens struct {
+		CodeLens
+		Data codeLensData `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Params, &lens); err != nil {
+		return invalidParams(err)
+	}
+	lens.CodeLens.Data = lens.Data
+	return success(resolveCodeLens(lens.CodeLens, lens.Data))
+}
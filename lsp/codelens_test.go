@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCodeLenses(t *testing.T) {
+	text := "values 1, 2, 3\n| where this > 1\n| count()"
+	lenses := codeLenses("file:///l.spq", text)
+	if len(lenses) != 9 {
+		t.Fatalf("Expected 3 lenses for each of 3 stages, got %d", len(lenses))
+	}
+	if got := lenses[3].Range; got.Start != (Position{Line: 1, Character: 2}) || got.End != (Position{Line: 1, Character: 16}) {
+		t.Errorf("Expected the second stage's lenses on its text, got %+v", got)
+	}
+	for _, lens := range lenses {
+		if lens.Command != nil {
+			t.Errorf("Expected lenses to be resolved later, got %+v", lens.Command)
+		}
+	}
+
+	if lenses := codeLenses("file:///l.spq", "values 1"); len(lenses) != 0 {
+		t.Errorf("Expected no lenses for a single stage, got %d", len(lenses))
+	}
+}
+
+func TestCodeLensResolve(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///resolve.spq"
+	text := "values 1, 2, 3\n| where this > 1\n| count()"
+	h.openDocument(t, uri, text)
+
+	response, err := h.ProcessRequest(1, "textDocument/codeLens", CodeLensParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if err != nil || response.Error != nil {
+		t.Fatalf("codeLens failed: %v %v", err, response.Error)
+	}
+	data, _ := json.Marshal(response.Result)
+	var lenses []json.RawMessage
+	json.Unmarshal(data, &lenses)
+
+	resolve := func(lens json.RawMessage) CodeLens {
+		response, err := h.ProcessRequest(2, "codeLens/resolve", lens)
+		if err != nil || response.Error != nil {
+			t.Fatalf("codeLens/resolve failed: %v %v", err, response.Error)
+		}
+		data, _ := json.Marshal(response.Result)
+		var resolved CodeLens
+		json.Unmarshal(data, &resolved)
+		return resolved
+	}
+	if c := resolve(lenses[3]).Command; c == nil || c.Title != "Stage 2 of 3" {
+		t.Errorf("Expected a stage index, got %+v", c)
+	}
+	run := resolve(lenses[4]).Command
+	if run == nil || run.Command != CommandRunQuery {
+		t.Fatalf("Expected a run command, got %+v", run)
+	}
+	if c := resolve(lenses[5]).Command; c == nil || c.Command != CommandSummarizeQuery {
+		t.Errorf("Expected an explain command, got %+v", c)
+	}
+
+	// Running the resolved command runs the query through the stage
+	args, _ := json.Marshal(run.Arguments[0])
+	response, err = h.ProcessRequest(3, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   run.Command,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil || response.Error != nil {
+		t.Fatalf("runQuery failed: %v %v", err, response.Error)
+	}
+	data, _ = json.Marshal(response.Result)
+	var result QueryResultParams
+	json.Unmarshal(data, &result)
+	if len(result.Values) != 2 || string(result.Values[0]) != "2" {
+		t.Errorf("Expected the values through the where, got %s", result.Values)
+	}
+}
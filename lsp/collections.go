@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/brimdata/super"
+)
+
+// Checks of set and map literals, |[ ... ]| and |{ ... }|. A set keeps one
+// of each value and a map one value per key, so a repeated literal is
+// dropped without a word when the query runs. Keys of different types are
+// allowed but give the map a union key type, which is worth pointing out.
+
+// collectionDiagnostics checks the set and map literals in text
+func (s *Server) collectionDiagnostics(text string) []Diagnostic {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	report := func(loc interface{}, severity int, code string, kv ...string) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    locRange(text, loc),
+			Severity: severity,
+			Code:     code,
+			Source:   "superdb-lsp",
+			Message:  s.messages.format(code, kv...),
+		})
+	}
+	walkTree(tree, func(node map[string]interface{}) {
+		switch nodeKind(node) {
+		case "SetExpr":
+			seen := make(map[string]bool)
+			elems, _ := node["elems"].([]interface{})
+			for _, elem := range elems {
+				elem, _ := elem.(map[string]interface{})
+				expr, _ := elem["expr"].(map[string]interface{})
+				key, _, ok := literalKey(expr)
+				if !ok {
+					continue
+				}
+				if seen[key] {
+					report(expr["loc"], DiagnosticSeverityWarning, "set-duplicate", "element", nodeText(text, expr["loc"]))
+				}
+				seen[key] = true
+			}
+		case "MapExpr":
+			seen := make(map[string]bool)
+			var types []super.Type
+			allLiteral := true
+			entries, _ := node["entries"].([]interface{})
+			for _, entry := range entries {
+				entry, _ := entry.(map[string]interface{})
+				keyExpr, _ := entry["key"].(map[string]interface{})
+				key, typ, ok := literalKey(keyExpr)
+				if !ok {
+					allLiteral = false
+					continue
+				}
+				if seen[key] {
+					report(keyExpr["loc"], DiagnosticSeverityWarning, "map-duplicate-key", "key", nodeText(text, keyExpr["loc"]))
+				}
+				seen[key] = true
+				if t := super.LookupPrimitive(typ); t != nil {
+					types = append(types, t)
+				} else {
+					allLiteral = false
+				}
+			}
+			if union := super.UniqueTypes(types); allLiteral && len(union) > 1 {
+				names := make([]string, len(union))
+				for i, t := range union {
+					names[i] = super.PrimitiveName(t)
+				}
+				report(node["loc"], DiagnosticSeverityHint, "map-key-union", "type", "("+strings.Join(names, ",")+")")
+			}
+		}
+	})
+	return diagnostics
+}
+
+// literalKey returns a key that is equal for equal literal values, and
+// the name of the value's type, reporting false when expr isn't a literal
+func literalKey(expr map[string]interface{}) (key, typ string, ok bool) {
+	if s, ok := stringLiteral(expr); ok {
+		return "string:" + s, "string", true
+	}
+	if nodeKind(expr) != "Primitive" {
+		return "", "", false
+	}
+	typ, _ = expr["type"].(string)
+	text, _ := expr["text"].(string)
+	return typ + ":" + text, typ, true
+}
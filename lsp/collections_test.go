@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollectionDiagnostics(t *testing.T) {
+	s := NewServer()
+	tests := []struct {
+		name string
+		text string
+		want []string // code and the text under the range
+	}{
+		{"set duplicate", `values |[1, "a", 1, 'a']|`, []string{"set-duplicate 1", "set-duplicate 'a'"}},
+		{"distinct types", `values |[1, 1.0, "1"]|`, nil},
+		{"map duplicate", `values |{"a":1, "a":2}|`, []string{"map-duplicate-key \"a\""}},
+		{"map union", `values |{1:"x", "b":"y", 2:"z"}|`, []string{"map-key-union |{1:\"x\", \"b\":\"y\", 2:\"z\"}|"}},
+		{"non-literal key", `values |{1:"x", a:"y"}|`, nil},
+		{"non-literal elements", `values |[a, a]|`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range s.collectionDiagnostics(tt.text) {
+				start, _ := offsetAt(tt.text, d.Range.Start)
+				end, _ := offsetAt(tt.text, d.Range.End)
+				got = append(got, d.Code+" "+tt.text[start:end])
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	diags := s.collectionDiagnostics(`values |{1:"x", "b":"y"}|`)
+	if len(diags) != 1 || diags[0].Severity != DiagnosticSeverityHint || !strings.Contains(diags[0].Message, "(int64,string)") {
+		t.Errorf("Expected a hint naming the union type, got %+v", diags)
+	}
+}
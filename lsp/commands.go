@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Commands run through workspace/executeCommand
+const (
+	CommandSplitPipeline = "superdb.splitPipeline"
+	CommandJoinPipeline  = "superdb.joinPipeline"
+
+	CommandGenerateReference = "superdb.generateReference"
+	CommandExportCatalog     = "superdb.exportCatalog"
+	CommandRunQuery          = "superdb.runQuery"
+	CommandDiffResults       = "superdb.diffResults"
+	CommandExploreShapes     = "superdb.exploreShapes"
+	CommandSummarizeQuery    = "superdb.summarizeQuery"
+	CommandRenameField       = "superdb.renameFieldEverywhere"
+	CommandFixDeprecated     = "superdb.fixDeprecatedSyntax"
+	CommandRefreshPools      = "superdb.refreshPools"
+
+	CommandRecordCompletion = "superdb.recordCompletion"
+	CommandExportUsageStats = "superdb.exportUsageStats"
+	CommandShowLastCrash    = "superdb.showLastCrash"
+
+	CommandApplyEdit = "superdb.applyEdit"
+)
+
+// commands maps each command to its handler, which gets the request's
+// context, cancelled with the request, and its arguments
+var commands = map[string]func(*Server, context.Context, []json.RawMessage) HandlerResult{
+	CommandSplitPipeline: (*Server).splitPipeline,
+	CommandJoinPipeline:  (*Server).joinPipeline,
+
+	CommandGenerateReference: (*Server).generateReference,
+	CommandExportCatalog:     (*Server).exportCatalog,
+	CommandRunQuery:          (*Server).runQueryCommand,
+	CommandDiffResults:       (*Server).diffResultsCommand,
+	CommandExploreShapes:     (*Server).exploreShapesCommand,
+	CommandSummarizeQuery:    (*Server).summarizeQueryCommand,
+	CommandRenameField:       (*Server).renameFieldEverywhere,
+	CommandFixDeprecated:     (*Server).fixDeprecatedSyntax,
+	CommandRefreshPools:      (*Server).refreshPools,
+
+	CommandRecordCompletion: (*Server).recordCompletion,
+	CommandExportUsageStats: (*Server).exportUsageStats,
+	CommandShowLastCrash:    (*Server).showLastCrash,
+
+	CommandApplyEdit: (*Server).applyEditCommand,
+}
+
+// commandNames returns the commands advertised in the initialize result
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleExecuteCommand processes workspace/executeCommand requests
+func (s *Server) handleExecuteCommand(ctx context.Context, msg RPCMessage) HandlerResult {
+	var params ExecuteCommandParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	log.Printf("Execute command: %s", params.Command)
+
+	run, ok := commands[params.Command]
+	if !ok {
+		return failure(&RPCError{
+			Code:    InvalidParams,
+			Message: fmt.Sprintf("unknown command: %s", params.Command),
+		})
+	}
+	return run(s, ctx, params.Arguments)
+}
+
+// splitPipeline puts each stage of a pipeline on its own line
+func (s *Server) splitPipeline(_ context.Context, args []json.RawMessage) HandlerResult {
+	return s.rearrangePipeline(args, func(text string, _ int) string {
+		return splitPipelineText(text)
+	})
+}
+
+// joinPipeline packs a pipeline's stages onto as few lines as fit
+func (s *Server) joinPipeline(_ context.Context, args []json.RawMessage) HandlerResult {
+	return s.rearrangePipeline(args, joinPipelineText)
+}
+
+// rearrangePipeline applies layout to the lines a pipeline command targets
+// and returns the edit, or no edits when nothing changes
+func (s *Server) rearrangePipeline(args []json.RawMessage, layout func(text string, width int) string) HandlerResult {
+	var params PipelineCommandArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.URI)
+	text, _, ok := s.document(params.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.URI)
+		return success([]TextEdit{})
+	}
+	if s.isReadOnly(params.URI) {
+		return success([]TextEdit{})
+	}
+
+	lines := splitLines(text)
+	if len(lines) == 0 {
+		return success([]TextEdit{})
+	}
+	first, last := 0, len(lines)-1
+	if params.Range != nil {
+		first = clampLine(params.Range.Start.Line, len(lines))
+		last = clampLine(params.Range.End.Line, len(lines))
+		// A selection ending at the start of a line doesn't include it
+		if last > first && params.Range.End.Character == 0 {
+			last--
+		}
+	}
+
+	start, _ := offsetAt(text, Position{Line: first, Character: 0})
+	end, _ := offsetAt(text, Position{Line: last, Character: len(lines[last])})
+	target := text[start:end]
+	updated := layout(target, params.Width)
+	if updated == target {
+		return success([]TextEdit{})
+	}
+
+	return success([]TextEdit{{
+		Range: Range{
+			Start: Position{Line: first, Character: 0},
+			End:   Position{Line: last, Character: len(lines[last])},
+		},
+		NewText: updated,
+	}})
+}
+
+// clampLine limits line to the lines of a document with n lines
+func clampLine(line, n int) int {
+	if line < 0 {
+		return 0
+	}
+	if line >= n {
+		return n - 1
+	}
+	return line
+}
@@ -1,20 +1,51 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"regexp"
 	"strings"
 )
 
 // getCompletions returns completion items based on the current context
-func getCompletions(text string, pos Position) []CompletionItem {
-	var items []CompletionItem
-
+func getCompletions(ctx context.Context, text string, pos Position) []CompletionItem {
 	// Get the current line and word being typed
-	lines := strings.Split(text, "\n")
-	if pos.Line >= len(lines) {
+	line, ok := lineAt(text, pos.Line)
+	if !ok {
+		return nil
+	}
+
+	// Arguments of an HTTP source have completions of their own; checking
+	// for a URL first keeps other documents from paying for the search
+	if strings.Contains(text, "://") {
+		if offset, ok := offsetAt(text, pos); ok {
+			if items, ok := httpCompletions(text, offset); ok {
+				return items
+			}
+		}
+	}
+
+	// The value of a file source's format argument is a format name
+	if items, ok := formatArgCompletions(line, pos.Character); ok {
 		return items
 	}
 
-	line := lines[pos.Line]
+	// Keys of a record literal that spreads this complete to the fields
+	// it doesn't set yet
+	if strings.Contains(text, "...this") {
+		if offset, ok := offsetAt(text, pos); ok {
+			if items, ok := recordKeyCompletions(text, offset); ok {
+				return items
+			}
+		}
+	}
+
+	// Nothing else is written inside a string or a comment
+	offset, hasOffset := offsetAt(text, pos)
+	if hasOffset && inStringOrComment(text, offset) {
+		return nil
+	}
+
 	prefix := ""
 	if pos.Character <= len(line) {
 		// Get the word prefix before cursor
@@ -27,25 +58,63 @@ func getCompletions(text string, pos Position) []CompletionItem {
 		}
 	}
 
-	// Check context for better completions
-	context := getCompletionContext(line, pos.Character)
+	// Inside an op or func, its parameters come first, then what the
+	// query declares
+	rc := rankContext{prefix: prefix, preferred: noKind}
+	if hasOffset {
+		rc = rankContextAt(text, offset, prefix)
+	}
 
-	// Add completions based on context
-	switch context {
+	// Check context for better completions
+	where := getCompletionContext(line, pos.Character)
+	if where == contextStageStart && rc.preferred != KindOperator {
+		// The line continues a stage begun on a line before it
+		where = contextGeneral
+	}
+	var kinds []BuiltinKind
+	switch where {
 	case contextType:
 		// After type-related keywords, suggest types
-		items = append(items, getTypeCompletions(prefix)...)
+		kinds = []BuiltinKind{KindType}
 	case contextFunction:
 		// After opening paren or in function context
-		items = append(items, getFunctionCompletions(prefix)...)
-		items = append(items, getAggregateCompletions(prefix)...)
+		kinds = []BuiltinKind{KindFunction, KindAggregate}
+	case contextStageStart:
+		// Only an operator starts a stage, or an aggregation with its
+		// summarize left out; the keywords that can are added below
+		kinds = []BuiltinKind{KindOperator, KindAggregate}
 	default:
 		// General context - suggest everything
-		items = append(items, getKeywordCompletions(prefix)...)
-		items = append(items, getOperatorCompletions(prefix)...)
-		items = append(items, getFunctionCompletions(prefix)...)
-		items = append(items, getAggregateCompletions(prefix)...)
-		items = append(items, getTypeCompletions(prefix)...)
+		kinds = []BuiltinKind{KindKeyword, KindOperator, KindFunction, KindAggregate, KindType}
+	}
+
+	// Add completions based on context. An empty prefix matches nearly the
+	// whole registry, so size the slice once instead of growing it.
+	var items []CompletionItem
+	if prefix == "" {
+		items = make([]CompletionItem, 0, len(allBuiltins)+len(allSnippets))
+	}
+	table := declarationsAt(text, pos)
+	if where != contextType && where != contextStageStart {
+		items = append(items, paramCompletions(table, pos, rc)...)
+	}
+	items = append(items, declarationCompletions(table, text, pos, rc, kinds)...)
+	for _, kind := range kinds {
+		if ctx.Err() != nil {
+			return nil
+		}
+		items = appendCompletionsByKind(items, kind, rc)
+	}
+	if where == contextStageStart {
+		items = appendBuiltinCompletions(items, stageKeywords, rc)
+	}
+	if where == contextGeneral || where == contextStageStart {
+		items = appendSnippetCompletions(items, rc, where == contextStageStart)
+	}
+	if where == contextGeneral && hasOffset {
+		// Keywords of a clause the cursor isn't in, like having with no
+		// group by before it
+		items = withoutStrayKeywords(items, text, offset)
 	}
 
 	return items
@@ -57,19 +126,43 @@ const (
 	contextGeneral completionContext = iota
 	contextType
 	contextFunction
+	contextStageStart
+)
+
+// stageKeywords are the keywords that can start a pipeline stage: those
+// of SQL queries and sources, declarations, and the operators spelled
+// like keywords
+var stageKeywords = builtinsNamed(
+	"aggregate", "anti", "call", "const", "cross", "distinct", "filter",
+	"fn", "from", "full", "func", "inner", "join", "left", "op", "pragma",
+	"right", "select", "shapes", "type", "where", "with",
 )
 
+// builtinsNamed returns the builtins with names, in order
+func builtinsNamed(names ...string) []*Builtin {
+	builtins := make([]*Builtin, 0, len(names))
+	for _, name := range names {
+		if b := Builtins.Lookup(name); b != nil {
+			builtins = append(builtins, b)
+		}
+	}
+	return builtins
+}
+
 // getCompletionContext analyzes the line to determine the completion context
 func getCompletionContext(line string, col int) completionContext {
 	if col > len(line) {
 		col = len(line)
 	}
-	prefix := strings.ToLower(line[:col])
+	prefix := line[:col]
 
-	// Check if we're after a type cast operator
-	if strings.Contains(prefix, "cast(") ||
+	// Check if we're after a type cast operator, in a type value, or in
+	// the type a declaration names
+	if containsFold(prefix, "cast(") ||
 		strings.Contains(prefix, "::") ||
-		strings.HasSuffix(strings.TrimSpace(prefix), "<") {
+		strings.HasSuffix(strings.TrimSpace(prefix), "<") ||
+		inTypeValue(prefix) ||
+		containsFold(prefix, "type") && typeDeclPattern.MatchString(prefix) {
 		return contextType
 	}
 
@@ -79,9 +172,115 @@ func getCompletionContext(line string, col int) completionContext {
 		return contextFunction
 	}
 
+	// Check if the word typed starts the line or follows a pipe
+	start := len(prefix)
+	for start > 0 && isIdentifierChar(prefix[start-1]) {
+		start--
+	}
+	before := strings.TrimSpace(prefix[:start])
+	if before == "" || strings.HasSuffix(before, "|") && !strings.HasSuffix(before, "||") {
+		return contextStageStart
+	}
+
 	return contextGeneral
 }
 
+// typeDeclPattern matches a line that ends in the type of a type
+// declaration, past its =
+var typeDeclPattern = regexp.MustCompile(`(?i)\btype\s+\w+\s*=(?:$|[^=])`)
+
+// inTypeValue reports whether prefix ends inside the angle brackets of a
+// type value, like <{a:int64}>. A < that follows an operand, as in x < y,
+// is a comparison instead.
+func inTypeValue(prefix string) bool {
+	open := strings.LastIndexByte(prefix, '<')
+	if open < 0 || strings.IndexByte(prefix[open:], '>') >= 0 {
+		return false
+	}
+	before := strings.TrimRight(prefix[:open], " \t")
+	if before == "" {
+		return true
+	}
+	if c := before[len(before)-1]; !isIdentifierChar(c) {
+		return strings.IndexByte("(,:=[{|", c) >= 0
+	}
+	start := len(before)
+	for start > 0 && isIdentifierChar(before[start-1]) {
+		start--
+	}
+	// Only a keyword or an operator, like values, comes before an operand
+	b := Builtins.Lookup(before[start:])
+	return b != nil && (b.Kind == KindKeyword || b.Kind == KindOperator)
+}
+
+// inStringOrComment reports whether offset in text is inside a string or
+// a comment. Strings don't span lines, so only the line up to offset is
+// tokenized, from the line a block comment opens on if one before it is
+// still open; the document is seldom tokenized whole on a keystroke.
+func inStringOrComment(text string, offset int) bool {
+	start := strings.LastIndexByte(text[:offset], '\n') + 1
+	// Most documents have no block comment, and a forward search says so
+	// faster than a backward one
+	if strings.Contains(text[:start], "/*") {
+		if open := strings.LastIndex(text[:start], "/*"); !strings.Contains(text[open:start], "*/") {
+			start = strings.LastIndexByte(text[:open], '\n') + 1
+		}
+	}
+	if strings.IndexAny(text[start:offset], `"'-/`) < 0 {
+		return false
+	}
+	tokens := tokenize(text[start:offset])
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, tok := range tokens {
+		// A block comment left open runs to offset; the tokenizer leaves
+		// the last character out of it
+		if tok.typ == tokComment && strings.HasPrefix(tok.value, "/*") &&
+			(len(tok.value) < 4 || !strings.HasSuffix(tok.value, "*/")) {
+			return true
+		}
+	}
+	last := tokens[len(tokens)-1].value
+	switch tokens[len(tokens)-1].typ {
+	case tokComment:
+		return strings.HasPrefix(last, "--")
+	case tokString:
+		// Inside the braces of an f-string is an expression
+		if last[0] == 'f' && strings.Count(last, "{") > strings.Count(last, "}") {
+			return false
+		}
+		return !closedString(last)
+	}
+	return false
+}
+
+// closedString reports whether a string token ends in its closing quote
+func closedString(s string) bool {
+	if s[0] == 'f' || s[0] == 'r' {
+		s = s[1:]
+	}
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return false
+	}
+	escapes := 0
+	for i := len(s) - 2; i > 0 && s[i] == '\\'; i-- {
+		escapes++
+	}
+	return escapes%2 == 0
+}
+
+// containsFold reports whether s contains the lowercase ASCII substr,
+// ignoring case in s, without allocating a lowered copy of s
+func containsFold(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return true
+		}
+	}
+	return false
+}
+
 func isIdentifierChar(b byte) bool {
 	return (b >= 'a' && b <= 'z') ||
 		(b >= 'A' && b <= 'Z') ||
@@ -89,63 +288,184 @@ func isIdentifierChar(b byte) bool {
 		b == '_'
 }
 
-func getKeywordCompletions(prefix string) []CompletionItem {
-	return getCompletionsByKind(KindKeyword, prefix, CompletionItemKindKeyword, "")
+// appendCompletionsByKind appends the prebuilt completion items for every
+// builtin of the given kind matching the word typed, ranked for rc. Items
+// and their sortTexts are copied from the registry, so the only allocation
+// besides growing the result slice is the sortText of a fuzzy match.
+func appendCompletionsByKind(items []CompletionItem, kind BuiltinKind, rc rankContext) []CompletionItem {
+	return appendBuiltinCompletions(items, Builtins.ByKind(kind), rc)
 }
 
-func getOperatorCompletions(prefix string) []CompletionItem {
-	return getCompletionsByKind(KindOperator, prefix, CompletionItemKindFunction, "operator")
+// appendBuiltinCompletions appends the completion items of the builtins
+// matching the word typed, ranked for rc
+func appendBuiltinCompletions(items []CompletionItem, builtins []*Builtin, rc rankContext) []CompletionItem {
+	for _, b := range builtins {
+		m, ok := matchWord(b.lowerName, rc.prefix)
+		if !ok {
+			continue
+		}
+		item := b.item
+		item.SortText = b.sortTexts[rc.rank(b.Name, builtinRank(b), b.Kind)]
+		m.apply(&item)
+		items = append(items, item)
+	}
+	return items
 }
 
-func getFunctionCompletions(prefix string) []CompletionItem {
-	var items []CompletionItem
-	for _, fn := range Builtins.Functions() {
-		if prefix == "" || strings.HasPrefix(strings.ToLower(fn.Name), prefix) {
-			items = append(items, CompletionItem{
-				Label:      fn.Name,
-				Kind:       CompletionItemKindFunction,
-				Detail:     "function: " + fn.Brief,
-				InsertText: fn.Name + "($1)",
-			})
+// appendSnippetCompletions appends the items of the snippets matching the
+// word typed, ranked for rc; at the start of a stage, only those that
+// start one
+func appendSnippetCompletions(items []CompletionItem, rc rankContext, stageOnly bool) []CompletionItem {
+	for _, sn := range Builtins.Snippets() {
+		if stageOnly && !sn.Stage {
+			continue
+		}
+		m, ok := matchWord(sn.Name, rc.prefix)
+		if !ok {
+			continue
 		}
+		item := sn.item
+		item.SortText = sn.sortTexts[rc.rank(sn.Name, rankOther, noKind)]
+		m.apply(&item)
+		items = append(items, item)
 	}
 	return items
 }
 
-func getAggregateCompletions(prefix string) []CompletionItem {
-	var items []CompletionItem
-	for _, agg := range Builtins.Aggregates() {
-		if prefix == "" || strings.HasPrefix(strings.ToLower(agg.Name), prefix) {
-			items = append(items, CompletionItem{
-				Label:      agg.Name,
-				Kind:       CompletionItemKindFunction,
-				Detail:     "aggregate: " + agg.Brief,
-				InsertText: agg.Name + "($1)",
-			})
+// newSnippetItem builds the completion item for a snippet, once, when the
+// registry is built
+func newSnippetItem(sn *Snippet) CompletionItem {
+	return CompletionItem{
+		Label:            sn.Name,
+		Kind:             CompletionItemKindSnippet,
+		Detail:           sn.Brief,
+		InsertText:       sn.Body,
+		InsertTextFormat: InsertTextFormatSnippet,
+	}
+}
+
+// docStyle selects how documentation is rendered
+type docStyle int
+
+const (
+	docMarkdown  docStyle = iota // markdown, the default
+	docPlainText                 // plain text with explicit sections
+)
+
+// completionDoc renders the documentation of a builtin's completion item
+// in style: what its hover shows, followed by its examples. It is only
+// rendered when the client resolves the item, so the many items sent on
+// each keystroke stay small.
+func completionDoc(b *Builtin, style docStyle) MarkupContent {
+	if style == docPlainText {
+		doc := b.plainDoc + aliasNote(b, docPlainText)
+		if len(b.Examples) > 0 {
+			doc += "\n\nExamples:\n  " + strings.Join(b.Examples, "\n  ")
 		}
+		return MarkupContent{Kind: MarkupKindPlainText, Value: doc}
 	}
-	return items
+	doc := b.hover
+	if examples := markdownExamples(b); examples != "" {
+		doc += "\n\n" + examples
+	}
+	return MarkupContent{Kind: MarkupKindMarkdown, Value: doc}
 }
 
-func getTypeCompletions(prefix string) []CompletionItem {
-	return getCompletionsByKind(KindType, prefix, CompletionItemKindClass, "type")
+// markdownExamples renders a builtin's examples as a code block under a
+// heading, or "" when it has none
+func markdownExamples(b *Builtin) string {
+	if len(b.Examples) == 0 {
+		return ""
+	}
+	return "**Examples**\n\n```spq\n" + strings.Join(b.Examples, "\n") + "\n```"
 }
 
-// getCompletionsByKind is a helper to build completion items from the registry
-func getCompletionsByKind(kind BuiltinKind, prefix string, itemKind int, labelPrefix string) []CompletionItem {
-	var items []CompletionItem
-	for _, b := range Builtins.ByKind(kind) {
-		if prefix == "" || strings.HasPrefix(strings.ToLower(b.Name), prefix) {
-			detail := b.Brief
-			if labelPrefix != "" {
-				detail = labelPrefix + ": " + detail
-			}
-			items = append(items, CompletionItem{
-				Label:  b.Name,
-				Kind:   itemKind,
-				Detail: detail,
-			})
+// resolveCompletion fills in the documentation of item
+func resolveCompletion(item CompletionItem, data CompletionItemData, settings Settings) CompletionItem {
+	if settings.CompletionDocs == completionDocsBrief || data.Builtin == "" {
+		return item
+	}
+	if b := Builtins.Lookup(data.Builtin); b != nil {
+		item.Documentation = completionDoc(b, settings.DocStyle)
+	}
+	return item
+}
+
+// callSnippet returns the snippet that calls the function name: a tab
+// stop holding the name of each of params, or a single tab stop between
+// the parentheses when it takes fewer than two
+func callSnippet(name string, params []string) string {
+	if len(params) < 2 {
+		return name + "($1)"
+	}
+	var b strings.Builder
+	b.WriteString(name + "(")
+	for i, param := range params {
+		if i > 0 {
+			b.WriteString(", ")
 		}
+		fmt.Fprintf(&b, "${%d:%s}", i+1, param)
 	}
-	return items
+	b.WriteString(")")
+	return b.String()
+}
+
+// requiredParams returns the names of the parameters a call to sig can't
+// leave out
+func requiredParams(sig *FuncSignature) []string {
+	if sig == nil {
+		return nil
+	}
+	var names []string
+	for _, p := range sig.Params {
+		if !p.Optional {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// newCompletionItem builds the completion item for a builtin. It runs once
+// per builtin when the registry is built, not on every keystroke.
+func newCompletionItem(b *Builtin) CompletionItem {
+	item := CompletionItem{Label: b.Name, Detail: b.Brief}
+	switch b.Kind {
+	case KindKeyword:
+		item.Kind = CompletionItemKindKeyword
+	case KindOperator:
+		item.Kind = CompletionItemKindFunction
+		item.Detail = "operator: " + b.Brief
+	case KindFunction:
+		item.Kind = CompletionItemKindFunction
+		item.Detail = "function: " + b.Brief
+		item.InsertText, item.InsertTextFormat = callSnippet(b.Name, requiredParams(b.sig)), InsertTextFormatSnippet
+	case KindAggregate:
+		item.Kind = CompletionItemKindFunction
+		item.Detail = "aggregate: " + b.Brief
+		item.InsertText, item.InsertTextFormat = callSnippet(b.Name, requiredParams(b.sig)), InsertTextFormatSnippet
+	case KindType:
+		item.Kind = CompletionItemKindClass
+		item.Detail = "type: " + b.Brief
+	}
+	// Functions and aggregates show their signature, like hover and
+	// signature help do, with what follows the name beside the label;
+	// the documentation waits for completionItem/resolve
+	if b.sig != nil {
+		item.Detail = b.sig.Label()
+		item.LabelDetails = &LabelDetails{Detail: strings.TrimPrefix(item.Detail, b.sig.Name)}
+	}
+	item.Data = &CompletionItemData{Builtin: b.Name}
+	// An older spelling ranks after everything else, so the canonical
+	// name is what a prefix completes to first, and like anything
+	// deprecated it is tagged so clients strike it through
+	switch {
+	case b.AliasOf != "":
+		item.Detail += " (older spelling of " + b.AliasOf + ")"
+	case b.Deprecated != "":
+		item.Detail += " (deprecated, use " + b.Deprecated + ")"
+	}
+	if b.replacement() != "" {
+		item.Tags = []int{CompletionItemTagDeprecated}
+	}
+	return item
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brimdata/super/compiler/parser"
+)
+
+var corpusMatrix = flag.String("matrix", "", "write the corpus compatibility matrix as markdown to this file")
+
+// corpusBinariesEnv lists super binaries, separated like PATH, to replay
+// the corpus against alongside the bundled parser
+const corpusBinariesEnv = "SUPERDB_CORPUS_BINARIES"
+
+// corpusQuery is a query in testdata/corpus/<version>/, where version is
+// the brimdata/super commit the query was written against
+type corpusQuery struct {
+	version string
+	name    string
+	text    string
+	// failsAt holds the versions the query is known not to parse at,
+	// from a "-- fails-at: <version> ..." line
+	failsAt map[string]bool
+}
+
+// corpusParser parses a query with one version of the grammar
+type corpusParser struct {
+	label string
+	parse func(query string) error
+}
+
+// TestCorpusReplay parses every query in testdata/corpus with the bundled
+// parser. A query that fails must say so with a fails-at line naming
+// SuperCommit, so a grammar sync that breaks an older query fails here
+// until the break is acknowledged. Binaries named in
+// SUPERDB_CORPUS_BINARIES are replayed too, but only reported: with -v or
+// -matrix the results form a compatibility matrix across versions.
+func TestCorpusReplay(t *testing.T) {
+	queries := loadCorpus(t)
+	if len(queries) == 0 {
+		t.Skip("no queries found in testdata/corpus/")
+	}
+
+	parsers := []corpusParser{{
+		label: "bundled (" + SuperCommit + ")",
+		parse: func(query string) error {
+			_, err := parser.ParseQuery(query)
+			return err
+		},
+	}}
+	for _, path := range filepath.SplitList(os.Getenv(corpusBinariesEnv)) {
+		if path != "" {
+			parsers = append(parsers, binaryParser(t, path))
+		}
+	}
+
+	results := make([][]error, len(queries))
+	for i, q := range queries {
+		results[i] = make([]error, len(parsers))
+		for j, p := range parsers {
+			results[i][j] = p.parse(q.text)
+		}
+		err := results[i][0]
+		switch {
+		case err != nil && !q.failsAt[SuperCommit]:
+			t.Errorf("%s/%s no longer parses: %s\nIf the break is intended, add \"-- fails-at: %s\" to the query.",
+				q.version, q.name, firstLine(err.Error()), SuperCommit)
+		case err == nil && q.failsAt[SuperCommit]:
+			t.Errorf("%s/%s parses but is marked fails-at %s", q.version, q.name, SuperCommit)
+		}
+	}
+
+	matrix := renderCorpusMatrix(queries, parsers, results)
+	t.Log("\n" + matrix)
+	if *corpusMatrix != "" {
+		if err := os.WriteFile(*corpusMatrix, []byte(matrix), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", *corpusMatrix, err)
+		}
+	}
+}
+
+// loadCorpus reads the queries under testdata/corpus, ordered by version
+// and name
+func loadCorpus(t *testing.T) []corpusQuery {
+	files, err := filepath.Glob("testdata/corpus/*/*.spq")
+	if err != nil {
+		t.Fatalf("failed to glob corpus: %v", err)
+	}
+	sort.Strings(files)
+	var queries []corpusQuery
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		q := corpusQuery{
+			version: filepath.Base(filepath.Dir(file)),
+			name:    strings.TrimSuffix(filepath.Base(file), ".spq"),
+			text:    string(data),
+			failsAt: make(map[string]bool),
+		}
+		scanner := bufio.NewScanner(strings.NewReader(q.text))
+		for scanner.Scan() {
+			if rest, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "-- fails-at:"); ok {
+				for _, v := range strings.Fields(rest) {
+					q.failsAt[v] = true
+				}
+			}
+		}
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// binaryParser parses queries by running "super compile" from path,
+// labeled with the version the binary reports
+func binaryParser(t *testing.T, path string) corpusParser {
+	label := path
+	out, err := exec.Command(path, "-version").Output()
+	if err != nil {
+		t.Logf("%s -version failed: %v", path, err)
+	} else if v := strings.TrimSpace(string(out)); v != "" {
+		label = strings.TrimPrefix(firstLine(v), "Version: ")
+	}
+	return corpusParser{
+		label: label,
+		parse: func(query string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			out, err := exec.CommandContext(ctx, path, "compile", query).CombinedOutput()
+			if err != nil {
+				if msg := strings.TrimSpace(string(out)); msg != "" {
+					return fmt.Errorf("%s", msg)
+				}
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// renderCorpusMatrix lays out results as a markdown table with a row per
+// query and a column per parser
+func renderCorpusMatrix(queries []corpusQuery, parsers []corpusParser, results [][]error) string {
+	var b strings.Builder
+	b.WriteString("| query |")
+	for _, p := range parsers {
+		fmt.Fprintf(&b, " %s |", p.label)
+	}
+	b.WriteString("\n|---|" + strings.Repeat("---|", len(parsers)) + "\n")
+	for i, q := range queries {
+		fmt.Fprintf(&b, "| %s/%s |", q.version, q.name)
+		for _, err := range results[i] {
+			if err == nil {
+				b.WriteString(" ok |")
+			} else {
+				b.WriteString(" error |")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// firstLine returns s up to its first newline
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Crash reports. When a handler panics, the panic is recovered and the
+// request fails with InternalError, so one bad request doesn't end the
+// editor session. The method, the stack, and the one line of the document
+// the request pointed at are saved to a local file first.
+// superdb.showLastCrash returns the report so a
+// user can attach it to a bug report. Literals and comments in the excerpt
+// are scrubbed, since they are where private data lives; the query's shape
+// is usually enough to reproduce a crash.
+
+// defaultCrashPath is where the last crash report is kept: the user's cache
+// directory, or nowhere when there isn't one
+func defaultCrashPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "superdb-lsp", "last-crash.json")
+}
+
+// safeDispatch dispatches msg, recovering from a panic in its handler. The
+// panic is recorded and becomes an InternalError for the request, which
+// handleMessage reports to the client like any other.
+func (s *Server) safeDispatch(ctx context.Context, msg RPCMessage) (result HandlerResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = failure(&RPCError{Code: InternalError, Message: s.recordPanic(msg, r, debug.Stack())})
+		}
+	}()
+	return s.dispatch(ctx, msg)
+}
+
+// recordPanic logs a panic while handling msg and saves a report for it.
+// It returns the message to fail the request with.
+func (s *Server) recordPanic(msg RPCMessage, r interface{}, stack []byte) string {
+	log.Printf("Panic handling %s (id=%v): %v\n%s", msg.Method, msg.ID, r, stack)
+	message := fmt.Sprintf("panic: %v", r)
+	if err := s.saveCrash(s.crashReport(msg, r, stack)); err != nil {
+		log.Printf("Error saving crash report: %v", err)
+	} else {
+		log.Printf("Saved crash report to %s", s.crashPath)
+		message += "; run " + CommandShowLastCrash + " for the report"
+	}
+	return message
+}
+
+// crashReport builds the report for a panic while handling msg
+func (s *Server) crashReport(msg RPCMessage, r interface{}, stack []byte) CrashReport {
+	report := CrashReport{
+		Time:          time.Now().UTC().Format(time.RFC3339),
+		ServerVersion: FullVersion(),
+		Method:        msg.Method,
+		Panic:         fmt.Sprint(r),
+		Stack:         string(stack),
+	}
+
+	// Most document requests carry a position or a range; the line it
+	// points at is the excerpt
+	var params struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+		Position     *Position              `json:"position"`
+		Range        *Range                 `json:"range"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.TextDocument.URI == "" {
+		return report
+	}
+	report.URI = params.TextDocument.URI
+	var pos *Position
+	switch {
+	case params.Position != nil:
+		pos = params.Position
+	case params.Range != nil:
+		pos = &params.Range.Start
+	default:
+		return report
+	}
+	text, _, ok := s.document(report.URI)
+	if !ok {
+		return report
+	}
+	if line, ok := lineAt(text, pos.Line); ok {
+		report.Line = pos.Line
+		report.Excerpt = scrubLine(line)
+	}
+	return report
+}
+
+// scrubLine replaces the literals and comments in a line of a query with
+// placeholders, keeping operators, keywords, and field names
+func scrubLine(line string) string {
+	var b strings.Builder
+	for _, tok := range tokenize(line) {
+		switch tok.typ {
+		case tokString:
+			prefix, value := "", tok.value
+			if value[0] == 'f' || value[0] == 'r' {
+				prefix, value = value[:1], value[1:]
+			}
+			b.WriteString(prefix + value[:1] + "…" + value[:1])
+		case tokRegexp:
+			b.WriteString("/…/")
+		case tokNumber:
+			b.WriteString("0")
+		case tokComment:
+			if strings.HasPrefix(tok.value, "--") {
+				b.WriteString("-- …")
+			} else {
+				b.WriteString("/* … */")
+			}
+		default:
+			b.WriteString(tok.value)
+		}
+	}
+	return b.String()
+}
+
+// saveCrash writes report as the last crash, replacing any earlier one
+func (s *Server) saveCrash(report CrashReport) error {
+	if s.crashPath == "" {
+		return errors.New("no location for crash reports")
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.crashPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.crashPath, data, 0o600)
+}
+
+// showLastCrash returns the last crash report, if there is one
+func (s *Server) showLastCrash(_ context.Context, args []json.RawMessage) HandlerResult {
+	if s.crashPath == "" {
+		return success(ShowLastCrashResult{})
+	}
+	data, err := os.ReadFile(s.crashPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return success(ShowLastCrashResult{})
+	}
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("reading crash report: %v", err)})
+	}
+	return success(ShowLastCrashResult{Crash: &report, Report: renderCrashReport(report)})
+}
+
+// renderCrashReport formats a report as markdown for a bug report
+func renderCrashReport(report CrashReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**superdb-lsp %s** crashed handling `%s` at %s\n\n", report.ServerVersion, report.Method, report.Time)
+	fmt.Fprintf(&b, "Panic: `%s`\n", report.Panic)
+	if report.Excerpt != "" {
+		fmt.Fprintf(&b, "\nLine %d of the document, with literals scrubbed:\n\n```spq\n%s\n```\n", report.Line+1, report.Excerpt)
+	}
+	fmt.Fprintf(&b, "\n<details><summary>Stack trace</summary>\n\n```\n%s\n```\n\n</details>\n", strings.TrimSpace(report.Stack))
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestScrubLine(t *testing.T) {
+	line := `where user == "alice@example.com" and id > 4211 and grep(/sec.*/, msg) -- who`
+	want := `where user == "…" and id > 0 and grep(/…/, msg) -- …`
+	if got := scrubLine(line); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := scrubLine(`put s := f'{x}' /* note */`); got != `put s := f'…' /* … */` {
+		t.Errorf("Unexpected scrub of f-string and block comment: %q", got)
+	}
+}
+
+func TestRecordPanicSavesReport(t *testing.T) {
+	h := NewTestHelper()
+	h.server.crashPath = filepath.Join(t.TempDir(), "crash", "last-crash.json")
+	uri := "file:///crash.spq"
+	h.openDocument(t, uri, "from 'secret.json'\n| where token == \"hunter2\"\n| count()")
+
+	params, _ := json.Marshal(HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 1, Character: 4},
+	})
+	msg := RPCMessage{JSONRPC: "2.0", ID: 1, Method: "textDocument/hover", Params: params}
+	h.server.recordPanic(msg, "index out of range", debug.Stack())
+
+	response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{Command: CommandShowLastCrash})
+	if err != nil || response.Error != nil {
+		t.Fatalf("showLastCrash failed: %v %+v", err, response)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result ShowLastCrashResult
+	json.Unmarshal(resultBytes, &result)
+	crash := result.Crash
+	if crash == nil {
+		t.Fatal("Expected a crash report")
+	}
+	if crash.Method != "textDocument/hover" || crash.Panic != "index out of range" || crash.URI != uri {
+		t.Errorf("Unexpected report: %+v", crash)
+	}
+	if crash.Line != 1 || crash.Excerpt != `| where token == "…"` {
+		t.Errorf("Expected only the scrubbed second line, got line %d %q", crash.Line, crash.Excerpt)
+	}
+	if !strings.Contains(crash.Stack, "TestRecordPanicSavesReport") {
+		t.Errorf("Expected a stack trace, got %q", crash.Stack)
+	}
+	if strings.Contains(result.Report, "hunter2") || strings.Contains(result.Report, "secret") {
+		t.Errorf("Report leaks document contents:\n%s", result.Report)
+	}
+	if !strings.Contains(result.Report, "`textDocument/hover`") {
+		t.Errorf("Expected the method in the report:\n%s", result.Report)
+	}
+}
+
+func TestPanicBecomesInternalError(t *testing.T) {
+	commands["superdb.testPanic"] = func(*Server, context.Context, []json.RawMessage) HandlerResult {
+		var items []CompletionItem
+		return success(items[3])
+	}
+	defer delete(commands, "superdb.testPanic")
+
+	h := NewTestHelper()
+	h.server.crashPath = filepath.Join(t.TempDir(), "last-crash.json")
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{Command: "superdb.testPanic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil || response.Error.Code != InternalError || !strings.Contains(response.Error.Message, "index out of range") {
+		t.Errorf("Expected an InternalError for the panic, got %+v", response)
+	}
+
+	// The server carries on with the next request
+	response, err = h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{Command: CommandShowLastCrash})
+	if err != nil || response.Error != nil {
+		t.Fatalf("showLastCrash failed: %v %+v", err, response)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result ShowLastCrashResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Crash == nil || result.Crash.Method != "workspace/executeCommand" {
+		t.Errorf("Expected the panic's report, got %+v", result.Crash)
+	}
+}
+
+func TestShowLastCrashWithoutCrash(t *testing.T) {
+	s := NewServer()
+	s.crashPath = filepath.Join(t.TempDir(), "last-crash.json")
+	result := s.showLastCrash(context.Background(), nil)
+	if result.Error != nil || result.Result.(ShowLastCrashResult).Crash != nil {
+		t.Errorf("Expected no crash, got %+v", result)
+	}
+}
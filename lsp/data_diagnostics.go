@@ -10,8 +10,26 @@ import (
 	"github.com/brimdata/super/sup"
 )
 
-// parseDataFileAndGetDiagnostics parses a SUP data file and returns diagnostics
-func parseDataFileAndGetDiagnostics(text string) []Diagnostic {
+// Patterns for locating positions in SUP parser error messages, compiled once
+var (
+	dataErrorPositionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`parse error at line (\d+), column (\d+)`),
+		regexp.MustCompile(`line (\d+), column (\d+)`),
+		regexp.MustCompile(`line (\d+):(\d+)`),
+		regexp.MustCompile(`(\d+):(\d+)`),
+	}
+	dataErrorPositionPrefixes = []*regexp.Regexp{
+		regexp.MustCompile(`parse error at line \d+, column \d+: `),
+		regexp.MustCompile(`parse error: `),
+		regexp.MustCompile(`line \d+:\d+: `),
+		regexp.MustCompile(`\d+:\d+: `),
+	}
+)
+
+// parseDataFileAndGetDiagnostics parses a SUP data file and returns
+// diagnostics, reporting how far it has read on p and stopping early if
+// it is cancelled
+func parseDataFileAndGetDiagnostics(text string, p *workDone) []Diagnostic {
 	var diagnostics []Diagnostic
 
 	reader := strings.NewReader(text)
@@ -20,7 +38,8 @@ func parseDataFileAndGetDiagnostics(text string) []Diagnostic {
 	analyzer := sup.NewAnalyzer()
 	builder := scode.NewBuilder()
 
-	for {
+	for !p.cancelled() {
+		p.report("", percent(len(text)-reader.Len(), len(text)))
 		ast, err := parser.ParseValue()
 		if err != nil {
 			diag := dataErrorToDiagnostic(text, err)
@@ -75,15 +94,8 @@ func extractDataErrorPosition(errStr string) (line, col int) {
 	col = 0
 
 	// Try various patterns used by the SUP parser
-	patterns := []string{
-		`parse error at line (\d+), column (\d+)`,
-		`line (\d+), column (\d+)`,
-		`line (\d+):(\d+)`,
-		`(\d+):(\d+)`,
-	}
-
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
+	errStr = truncate(errStr, maxErrorMessageLen)
+	for _, re := range dataErrorPositionPatterns {
 		matches := re.FindStringSubmatch(errStr)
 		if len(matches) >= 3 {
 			if l, err := strconv.Atoi(matches[1]); err == nil {
@@ -108,16 +120,8 @@ func extractDataErrorPosition(errStr string) (line, col int) {
 // cleanDataErrorMessage removes position info from error message for cleaner display
 func cleanDataErrorMessage(errStr string) string {
 	// Remove common position prefixes
-	patterns := []string{
-		`parse error at line \d+, column \d+: `,
-		`parse error: `,
-		`line \d+:\d+: `,
-		`\d+:\d+: `,
-	}
-
-	result := errStr
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
+	result := truncate(errStr, maxErrorMessageLen)
+	for _, re := range dataErrorPositionPrefixes {
 		result = re.ReplaceAllString(result, "")
 	}
 
@@ -110,7 +110,7 @@ func parseDataValuesForFormat(text string) ([]*super.Value, error) {
 	return values, nil
 }
 
-// isDataFile checks if a URI represents a .sup data file
-func isDataFile(uri string) bool {
+// hasDataExtension reports whether a URI names a .sup data file
+func hasDataExtension(uri string) bool {
 	return strings.HasSuffix(strings.ToLower(uri), ".sup")
 }
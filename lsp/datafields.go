@@ -0,0 +1,248 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brimdata/super"
+	"github.com/brimdata/super/sup"
+)
+
+// Field completion from data. A query's fields are whatever its data
+// holds, so completion offers the fields of a data file that goes with
+// the query: the one sampleFiles configures for its path, else a .sup or
+// .json file its from reads, else a .sup file of the same name next to
+// it. The record types in the data are fused into one list of field
+// paths, nested ones included, which is kept until the file changes.
+
+// maxSampleBytes caps how much of a data file is read for its fields
+const maxSampleBytes = 1 << 20
+
+// dataField is a field found in a data file
+type dataField struct {
+	path string // dotted, like id.orig_h
+	typ  string // its SUP type, or the types it has joined by |
+}
+
+// dataFieldCache holds the fields of each data file read, by path
+type dataFieldCache struct {
+	mu      sync.Mutex
+	entries map[string]dataFieldEntry
+}
+
+type dataFieldEntry struct {
+	modTime time.Time
+	size    int64
+	fields  []dataField
+}
+
+func newDataFieldCache() *dataFieldCache {
+	return &dataFieldCache{entries: make(map[string]dataFieldEntry)}
+}
+
+// fields returns the fields of the data file at path, reading it again
+// only when it has changed
+func (c *dataFieldCache) fields(path string) ([]dataField, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.fields, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSampleBytes))
+	if err != nil {
+		return nil, err
+	}
+	fields := fuseFields(string(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = dataFieldEntry{modTime: info.ModTime(), size: info.Size(), fields: fields}
+	return fields, nil
+}
+
+// size returns how many data files have their fields cached
+func (c *dataFieldCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// fuseFields returns the fields of the records in text, a SUP or JSON
+// data file, in the order they first appear. Values past a parse error,
+// like one cut off by maxSampleBytes, are left out.
+func fuseFields(text string) []dataField {
+	values, _ := parseDataValuesForFormat(text)
+	index := make(map[string]int)
+	var fields []dataField
+	var visit func(typ super.Type, prefix string)
+	visit = func(typ super.Type, prefix string) {
+		record := super.TypeRecordOf(typ)
+		if record == nil {
+			return
+		}
+		for _, f := range record.Fields {
+			path := prefix + f.Name
+			name := sup.FormatType(f.Type)
+			if i, ok := index[path]; !ok {
+				index[path] = len(fields)
+				fields = append(fields, dataField{path: path, typ: name})
+			} else if !containsType(fields[i].typ, name) {
+				fields[i].typ += "|" + name
+			}
+			visit(f.Type, path+".")
+		}
+	}
+	for _, val := range values {
+		visit(val.Type(), "")
+	}
+	return fields
+}
+
+// containsType reports whether the |-joined types hold typ
+func containsType(types, typ string) bool {
+	for _, t := range strings.Split(types, "|") {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// dataSourcePattern matches a from that reads a .sup or .json file
+var dataSourcePattern = regexp.MustCompile(`\bfrom\s+(?:file\s+)?["']?([\w./-]+\.(?:sup|json))\b`)
+
+// companionData returns the path of the data file whose fields complete
+// in the query at uri, or "" if there is none. The query is matched with
+// a pattern rather than parsed, since it seldom parses while being typed.
+func (s *Server) companionData(uri, text string) string {
+	file, err := uriToPath(uri)
+	if err != nil {
+		return ""
+	}
+	if sample, ok := s.configuredFor(uri, s.settings().SampleFiles); ok {
+		// A sample is named like readOnlyPaths, from the workspace folder
+		base := s.folderOf(file)
+		if base == "" {
+			base = filepath.Dir(file)
+		}
+		return findData(filepath.FromSlash(sample), []string{base})
+	}
+	dirs := s.sourceDirs(file)
+	for _, m := range dataSourcePattern.FindAllStringSubmatch(text, -1) {
+		if path := findData(filepath.FromSlash(m[1]), dirs); path != "" {
+			return path
+		}
+	}
+	return findData(strings.TrimSuffix(file, filepath.Ext(file))+".sup", nil)
+}
+
+// findData returns where the data file at path is, trying each of dirs in
+// turn when it is relative, or "" if it isn't anywhere
+func findData(path string, dirs []string) string {
+	if filepath.IsAbs(path) {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		return ""
+	}
+	for _, dir := range dirs {
+		if found := findData(filepath.Join(dir, path), nil); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// afterFrom matches a line that ends in the name of a source being typed
+var afterFrom = regexp.MustCompile(`\bfrom\s+\S*$`)
+
+// dataFieldCompletions returns the fields of the query's data that match
+// what is typed at pos. After a path and a dot, like id., only the fields
+// under that path are offered, and only reports true when there are
+// fields to offer there, so the caller offers nothing else.
+func (s *Server) dataFieldCompletions(uri, text string, pos Position) ([]CompletionItem, bool) {
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) {
+		return nil, false
+	}
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return nil, false
+	}
+	before := line[:pos.Character]
+	where := getCompletionContext(line, pos.Character)
+	if where == contextType || afterFrom.MatchString(before) || inStringOrComment(text, offset) {
+		return nil, false
+	}
+	start := len(before)
+	for start > 0 && (isIdentifierChar(before[start-1]) || before[start-1] == '.') {
+		start--
+	}
+	parent, word := "", before[start:]
+	if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+		parent, word = word[:dot+1], word[dot+1:]
+	}
+	parent = strings.TrimPrefix(parent, "this.")
+	if where == contextStageStart {
+		// Only operators start a stage, unless the line continues one
+		if rankContextAt(text, offset, word).preferred == KindOperator {
+			return nil, false
+		}
+	}
+
+	data := s.companionData(uri, text)
+	if data == "" {
+		return nil, false
+	}
+	fields, err := s.dataFields.fields(data)
+	if err != nil {
+		return nil, false
+	}
+	var items []CompletionItem
+	for _, f := range fields {
+		rest, ok := strings.CutPrefix(f.path, parent)
+		if !ok || rest == "" || !plainPath(rest) {
+			continue
+		}
+		m, ok := matchWord(strings.ToLower(rest), strings.ToLower(word))
+		if !ok {
+			continue
+		}
+		item := CompletionItem{
+			Label:    rest,
+			Kind:     CompletionItemKindField,
+			Detail:   f.typ + " (field of " + filepath.Base(data) + ")",
+			SortText: rankDeclared.sortText(rest),
+		}
+		m.apply(&item)
+		items = append(items, item)
+	}
+	return items, parent != "" && len(items) > 0
+}
+
+// plainPath reports whether each element of a dotted path can be written
+// without quoting
+func plainPath(path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if !identifierPattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFuseFields(t *testing.T) {
+	data := `{ts:2024-01-01T00:00:00Z,id:{orig_h:10.0.0.1,resp_p:80}}
+{ts:2024-01-01T00:00:01Z,id:{orig_h:10.0.0.2,resp_p:"http"},"odd name":1}
+`
+	var got []string
+	for _, f := range fuseFields(data) {
+		got = append(got, f.path+" "+f.typ)
+	}
+	want := []string{"ts time", "id {orig_h:ip,resp_p:int64}|{orig_h:ip,resp_p:string}", "id.orig_h ip", "id.resp_p int64|string", "odd name int64"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestDataFieldCompletions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "conn.sup"), []byte(`{host:"a",id:{orig_h:10.0.0.1,orig_p:80}}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "query.sup"), []byte(`{status:200}`), 0o644)
+
+	tests := []struct {
+		name string
+		file string
+		text string // | marks the cursor
+		want []string
+		only bool
+	}{
+		{"source", "a.spq", "from conn.sup | where |", []string{"host", "id", "id.orig_h", "id.orig_p"}, false},
+		{"prefix", "a.spq", "from conn.sup | cut id.o|", []string{"orig_h", "orig_p"}, true},
+		{"this", "a.spq", "from conn.sup | put x := this.h|", []string{"host"}, false},
+		{"same name", "query.spq", "where |", []string{"status"}, false},
+		{"no data", "a.spq", "where |", nil, false},
+		{"source name", "a.spq", "from conn.sup | join (from |", nil, false},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.LastIndex(tt.text, "|")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items, only := s.dataFieldCompletions(pathToURI(filepath.Join(dir, tt.file)), text, positionAt(text, offset))
+			if got := completionLabels(items); !slices.Equal(got, tt.want) || only != tt.only {
+				t.Errorf("Expected %q (only %v), got %q (only %v)", tt.want, tt.only, got, only)
+			}
+		})
+	}
+}
+
+func TestSampleFilesSetting(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "samples"), 0o755)
+	os.WriteFile(filepath.Join(dir, "samples", "events.sup"), []byte(`{kind:"click",user:{name:"x"}}`), 0o644)
+
+	h := NewTestHelper()
+	opts, _ := json.Marshal(map[string]interface{}{"sampleFiles": map[string]string{"queries": "samples/events.sup"}})
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(dir), InitializationOptions: opts}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	uri := pathToURI(filepath.Join(dir, "queries", "q.spq"))
+	text := "where user."
+	h.openDocument(t, uri, text)
+	response, err := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: len(text)},
+	})
+	if err != nil {
+		t.Fatalf("Completion failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var list CompletionList
+	json.Unmarshal(resultBytes, &list)
+	if got := completionLabels(list.Items); !slices.Equal(got, []string{"name"}) {
+		t.Errorf("Expected only the sample's nested field, got %q", got)
+	}
+}
@@ -1,32 +1,78 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/brimdata/super/compiler/parser"
 )
 
-// publishDiagnostics parses the document and publishes diagnostics
-func (s *Server) publishDiagnostics(uri, text string, version int) (interface{}, error) {
-	var diagnostics []Diagnostic
-	if isDataFile(uri) {
+// diagnose returns the diagnostics for a document, as a data file or a
+// query depending on its name
+func (s *Server) diagnose(ctx context.Context, uri, text string) []Diagnostic {
+	start := time.Now()
+	if s.isDataFile(uri) {
 		// Parse as SUP data file
-		diagnostics = parseDataFileAndGetDiagnostics(text)
-	} else {
-		// Parse as SuperSQL query
-		diagnostics = parseAndGetDiagnostics(text)
+		if len(text) < largeDataFile {
+			diagnostics := parseDataFileAndGetDiagnostics(text, noProgress(ctx))
+			s.parses.record(time.Since(start))
+			return diagnostics
+		}
+		p := s.startProgress(ctx, "Validating "+path.Base(uri), true)
+		diagnostics := parseDataFileAndGetDiagnostics(text, p)
+		p.end("")
+		s.parses.record(time.Since(start))
+		return diagnostics
+	}
+	// Parse as SuperSQL query
+	diagnostics := parseAndGetDiagnostics(text)
+	s.parses.record(time.Since(start))
+	if ctx.Err() != nil {
+		return nil
+	}
+	diagnostics = append(diagnostics, s.httpSourceDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.spreadOverrideDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.collectionDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.aliasDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.dialectDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.parameterDiagnostics(text)...)
+	diagnostics = append(diagnostics, s.sourceDiagnostics(uri, text)...)
+	diagnostics = append(diagnostics, s.lakeWriteDiagnostics(text)...)
+	return applySeverities(diagnostics, s.settings().Severities)
+}
+
+// publishDiagnostics parses the document and publishes diagnostics. It
+// returns no message when ctx is cancelled, as a newer version of the
+// document is on its way.
+func (s *Server) publishDiagnostics(ctx context.Context, uri, text string, version int) (interface{}, error) {
+	if ctx.Err() != nil {
+		log.Printf("Skipping diagnostics for superseded version %d of %s", version, uri)
+		return nil, nil
+	}
+	diagnostics := s.diagnose(ctx, uri, text)
+	if ctx.Err() != nil {
+		log.Printf("Abandoned diagnostics for superseded version %d of %s", version, uri)
+		return nil, nil
 	}
 
 	log.Printf("Publishing %d diagnostics for %s", len(diagnostics), uri)
+	return s.diagnosticsNotification(uri, text, version, diagnostics)
+}
 
+// diagnosticsNotification returns the publishDiagnostics notification for
+// version of uri, whose text is text, tagged with the version so stale
+// results can be dropped
+func (s *Server) diagnosticsNotification(uri, text string, version int, diagnostics []Diagnostic) (interface{}, error) {
 	params := PublishDiagnosticsParams{
 		URI:         uri,
 		Version:     version,
-		Diagnostics: diagnostics,
+		Diagnostics: s.diagnosticsToClient(text, s.supportedTags(diagnostics)),
 	}
 
 	paramsBytes, err := json.Marshal(params)
@@ -35,13 +81,31 @@ func (s *Server) publishDiagnostics(uri, text string, version int) (interface{},
 	}
 
 	// Return a notification (no ID, no response expected)
-	return RPCMessage{
-		JSONRPC: "2.0",
-		Method:  "textDocument/publishDiagnostics",
-		Params:  paramsBytes,
+	return diagnosticsMessage{
+		RPCMessage: RPCMessage{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params:  paramsBytes,
+		},
+		uri:     uri,
+		version: version,
 	}, nil
 }
 
+// Patterns for locating positions in parser error messages, compiled once
+var (
+	errorPositionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`line (\d+), column (\d+)`),
+		regexp.MustCompile(`line (\d+):(\d+)`),
+		regexp.MustCompile(`(\d+):(\d+)`),
+	}
+	errorPositionPrefixes = []*regexp.Regexp{
+		regexp.MustCompile(`error parsing at line \d+, column \d+: `),
+		regexp.MustCompile(`line \d+:\d+: `),
+		regexp.MustCompile(`\d+:\d+: `),
+	}
+)
+
 // parseAndGetDiagnostics parses SuperSQL code and returns diagnostics
 func parseAndGetDiagnostics(text string) []Diagnostic {
 	var diagnostics []Diagnostic
@@ -82,14 +146,8 @@ func extractPosition(errStr string) (line, col int) {
 	col = 0
 
 	// Try various patterns used by the parser
-	patterns := []string{
-		`line (\d+), column (\d+)`,
-		`line (\d+):(\d+)`,
-		`(\d+):(\d+)`,
-	}
-
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
+	errStr = truncate(errStr, maxErrorMessageLen)
+	for _, re := range errorPositionPatterns {
 		matches := re.FindStringSubmatch(errStr)
 		if len(matches) >= 3 {
 			if l, err := strconv.Atoi(matches[1]); err == nil {
@@ -160,15 +218,8 @@ func isWhitespace(b byte) bool {
 // cleanErrorMessage removes position info from error message for cleaner display
 func cleanErrorMessage(errStr string) string {
 	// Remove common position prefixes
-	patterns := []string{
-		`error parsing at line \d+, column \d+: `,
-		`line \d+:\d+: `,
-		`\d+:\d+: `,
-	}
-
-	result := errStr
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
+	result := truncate(errStr, maxErrorMessageLen)
+	for _, re := range errorPositionPrefixes {
 		result = re.ReplaceAllString(result, "")
 	}
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the .golden files in testdata/diagnostics")
+
+// TestDiagnosticsGolden renders the diagnostics for each query in
+// testdata/diagnostics and compares them with the .golden file beside it,
+// so a change to a diagnostic's range or message shows up in the diff.
+// Run with -update to rewrite the golden files.
+func TestDiagnosticsGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/diagnostics/*.spq")
+	if err != nil {
+		t.Fatalf("failed to glob test files: %v", err)
+	}
+	if len(files) == 0 {
+		t.Skip("no queries found in testdata/diagnostics/")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", file, err)
+			}
+			text := string(data)
+			path, _ := filepath.Abs(file)
+			got := renderDiagnostics(text, NewServer().diagnose(context.Background(), pathToURI(path), text), -1)
+
+			golden := strings.TrimSuffix(file, ".spq") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", golden, err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s (run with -update to create it): %v", golden, err)
+			}
+			if got != string(want) {
+				t.Errorf("diagnostics mismatch for %s\n\nExpected:\n%s\nGot:\n%s", file, want, got)
+			}
+		})
+	}
+}
+
+func TestRenderDiagnosticsContext(t *testing.T) {
+	text := "a\nb\nc\nd\ne\n"
+	diagnostics := []Diagnostic{{
+		Range:    Range{Start: Position{Line: 2, Character: 0}, End: Position{Line: 2, Character: 1}},
+		Severity: DiagnosticSeverityWarning,
+		Message:  "here",
+	}}
+	want := "...\n2 | b\n3 | c\n  | ^ warning: here\n4 | d\n...\n"
+	if got := renderDiagnostics(text, diagnostics, 1); got != want {
+		t.Errorf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+}
+
+func TestRunCheckStatus(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.spq")
+	bad := filepath.Join(dir, "bad.spq")
+	os.WriteFile(good, []byte("from data.json | count()\n"), 0o644)
+	os.WriteFile(bad, []byte("from data.json | where x ==\n"), 0o644)
+
+	var out strings.Builder
+	if status := runCheck([]string{good}, &out); status != 0 || out.Len() != 0 {
+		t.Errorf("Expected a clean file to pass silently, got %d: %q", status, out.String())
+	}
+	out.Reset()
+	if status := runCheck([]string{good, bad}, &out); status != 1 {
+		t.Errorf("Expected status 1 for a parse error, got %d", status)
+	}
+	if !strings.HasPrefix(out.String(), bad+": 1 error\n") {
+		t.Errorf("Expected a summary for %s, got %q", bad, out.String())
+	}
+	if status := runCheck([]string{filepath.Join(dir, "missing.spq")}, &out); status != 2 {
+		t.Errorf("Expected status 2 for a missing file, got %d", status)
+	}
+}
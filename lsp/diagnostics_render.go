@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Text rendering of diagnostics, like compiler output: each line of the
+// document with its number, and under a line with diagnostics a row of
+// carets beneath each range followed by its severity and message. The
+// output depends only on the text and the diagnostics, so it serves as a
+// golden file for snapshot tests as well as the output of -check.
+
+// severityName returns the lowercase name of an LSP diagnostic severity
+func severityName(severity int) string {
+	switch severity {
+	case DiagnosticSeverityError:
+		return "error"
+	case DiagnosticSeverityWarning:
+		return "warning"
+	case DiagnosticSeverityInformation:
+		return "info"
+	case DiagnosticSeverityHint:
+		return "hint"
+	}
+	return "diagnostic"
+}
+
+// severityNamed returns the severity severityName names, or 0
+func severityNamed(name string) int {
+	for severity := DiagnosticSeverityError; severity <= DiagnosticSeverityHint; severity++ {
+		if severityName(severity) == name {
+			return severity
+		}
+	}
+	return 0
+}
+
+// renderDiagnostics renders text with its diagnostics. With context < 0
+// every line is shown; otherwise only lines within context lines of a
+// diagnostic are, and skipped runs are marked with "...". A range that
+// spans lines is marked from its start to the end of its first line.
+func renderDiagnostics(text string, diagnostics []Diagnostic, context int) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	sorted := append([]Diagnostic(nil), diagnostics...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Range.Start, sorted[j].Range.Start
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Character != b.Character {
+			return a.Character < b.Character
+		}
+		return sorted[i].Severity < sorted[j].Severity
+	})
+
+	byLine := make(map[int][]Diagnostic)
+	for _, d := range sorted {
+		line := d.Range.Start.Line
+		if line >= len(lines) {
+			line = len(lines) - 1
+		}
+		byLine[line] = append(byLine[line], d)
+	}
+
+	width := len(fmt.Sprint(len(lines)))
+	gutter := strings.Repeat(" ", width) + " | "
+	var b strings.Builder
+	skipped := false
+	for i, line := range lines {
+		if !nearDiagnostic(byLine, i, context) {
+			skipped = true
+			continue
+		}
+		if skipped {
+			b.WriteString("...\n")
+		}
+		skipped = false
+		fmt.Fprintf(&b, "%*d | %s\n", width, i+1, line)
+		for _, d := range byLine[i] {
+			b.WriteString(gutter)
+			b.WriteString(caretLine(line, d.Range, i))
+			// Continuation lines of a message are indented under it
+			message := strings.ReplaceAll(d.Message, "\n", "\n"+gutter+"  ")
+			fmt.Fprintf(&b, " %s: %s\n", severityName(d.Severity), message)
+		}
+	}
+	if skipped && b.Len() > 0 {
+		b.WriteString("...\n")
+	}
+	return b.String()
+}
+
+// nearDiagnostic reports whether line is within context lines of a line
+// with diagnostics
+func nearDiagnostic(byLine map[int][]Diagnostic, line, context int) bool {
+	if context < 0 {
+		return true
+	}
+	for l := line - context; l <= line+context; l++ {
+		if len(byLine[l]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// caretLine returns the padding and carets that mark rng under line, which
+// is the line numbered lineNum. Tabs in the padding are kept so the carets
+// line up however the tabs are displayed.
+func caretLine(line string, rng Range, lineNum int) string {
+	start := clampColumn(rng.Start.Character, line)
+	if rng.Start.Line != lineNum {
+		start = len(line)
+	}
+	end := len(line)
+	if rng.End.Line == lineNum {
+		end = clampColumn(rng.End.Character, line)
+	}
+
+	var b strings.Builder
+	for _, r := range line[:start] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	n := len([]rune(line[start:max(start, end)]))
+	if n == 0 {
+		n = 1
+	}
+	b.WriteString(strings.Repeat("^", n))
+	return b.String()
+}
+
+// clampColumn limits a character offset to line
+func clampColumn(col int, line string) int {
+	if col < 0 {
+		return 0
+	}
+	if col > len(line) {
+		return len(line)
+	}
+	return col
+}
@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+// Dialect gating. The language has changed between versions of super:
+// values took over from yield, aggregate from summarize, and fn from
+// func. Registry entries that not every version has are tagged with the
+// version that introduced or removed them, and with dialectVersion set to
+// an older version, completion leaves them out, hover says so, and their
+// uses get a warning, so a workspace that targets an older engine isn't
+// led into syntax that engine rejects.
+
+// dialectIndex returns where version falls in DialectVersions, or -1 if
+// it isn't there
+func dialectIndex(version string) int {
+	for i, v := range DialectVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// inDialect reports whether version of the language has b. A version
+// DialectVersions doesn't list is taken to have everything.
+func (b *Builtin) inDialect(version string) bool {
+	at := dialectIndex(version)
+	if at < 0 {
+		return true
+	}
+	if b.Since != "" && at < dialectIndex(b.Since) {
+		return false
+	}
+	return b.Until == "" || at < dialectIndex(b.Until)
+}
+
+// dialectLacksBuiltins reports whether version lacks any builtin, which
+// the current version never does
+func dialectLacksBuiltins(version string) bool {
+	for i := range allBuiltins {
+		if !allBuiltins[i].inDialect(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialectNote returns why version lacks b
+func dialectNote(b *Builtin, version string) string {
+	if b.Until != "" && dialectIndex(version) >= dialectIndex(b.Until) {
+		return "Not in " + version + ", the dialect this workspace targets: removed in " + b.Until + "."
+	}
+	return "Not in " + version + ", the dialect this workspace targets: added in a later version."
+}
+
+// dialectHover adds to hover, when it documents a builtin that version
+// lacks, a note saying so. A declaration of the same name shadows the
+// builtin and gets its own hover, which is left alone.
+func dialectHover(hover *Hover, text string, pos Position, version string) {
+	b := Builtins.Lookup(getWordAtPosition(text, pos))
+	if b == nil || b.inDialect(version) ||
+		hover.Contents.Value != b.hover && hover.Contents.Value != b.plainHover {
+		return
+	}
+	hover.Contents.Value += "\n\n" + dialectNote(b, version)
+}
+
+// dialectDiagnostics warns at each builtin text uses that the targeted
+// dialect lacks. A builtin is used where semantic tokens color it as one:
+// a function where it's called, an operator where it starts a stage.
+func (s *Server) dialectDiagnostics(text string) []Diagnostic {
+	version := s.settings().DialectVersion
+	if !dialectLacksBuiltins(version) {
+		return nil
+	}
+	tokens := tokenize(text)
+	tree, _ := parseTree(text)
+	declared := declaredClasses(text, tree)
+	var diagnostics []Diagnostic
+	var pos Position
+	stageStart := true
+	for i, tok := range tokens {
+		c, ok := classifyToken(tokens, i, stageStart, declared, pos)
+		if ok && (c.typ == semKeyword || c.modifiers&semModDefaultLibrary != 0) {
+			if b := Builtins.Lookup(tok.value); b != nil && !b.inDialect(version) {
+				diagnostics = append(diagnostics, Diagnostic{
+					Range:    Range{Start: pos, End: Position{Line: pos.Line, Character: pos.Character + len(tok.value)}},
+					Severity: DiagnosticSeverityWarning,
+					Code:     "dialect-version",
+					Source:   "superdb-lsp",
+					Message:  s.messages.format("dialect-version", "name", tok.value, "version", version),
+				})
+			}
+		}
+		if n := strings.Count(tok.value, "\n"); n > 0 {
+			pos.Line += n
+			pos.Character = len(tok.value) - strings.LastIndexByte(tok.value, '\n') - 1
+		} else {
+			pos.Character += len(tok.value)
+		}
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+		case tokPipe:
+			stageStart = true
+		default:
+			stageStart = tok.value == "("
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDialectHistory(t *testing.T) {
+	if DialectVersions[len(DialectVersions)-1] != SuperCommit {
+		t.Errorf("Expected the versions to end at %s, got %v", SuperCommit, DialectVersions)
+	}
+	for _, b := range allBuiltins {
+		for _, v := range []string{b.Since, b.Until} {
+			if v != "" && dialectIndex(v) < 0 {
+				t.Errorf("%s: %s isn't in DialectVersions", b.Name, v)
+			}
+		}
+		if !b.inDialect(SuperCommit) {
+			t.Errorf("Expected the current dialect to have %s", b.Name)
+		}
+	}
+	if values := Builtins.Lookup("values"); values.inDialect("v1.18.0") || !values.inDialect("unknown") {
+		t.Error("Expected values outside v1.18.0 only")
+	}
+}
+
+func TestDialectGating(t *testing.T) {
+	h := NewTestHelper()
+	opts := json.RawMessage(`{"dialectVersion": "v1.18.0"}`)
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file:///q.spq"
+	text := "values 1 | yield this | sort this"
+	h.openDocument(t, uri, text)
+
+	// values gets a warning, and yield no hint to write values
+	diagnostics := h.server.diagnose(context.Background(), uri, text)
+	if len(diagnostics) != 1 || diagnostics[0].Code != "dialect-version" ||
+		diagnostics[0].Range != (Range{End: Position{Character: 6}}) || diagnostics[0].Severity != DiagnosticSeverityWarning {
+		t.Errorf("Expected a warning at values, got %+v", diagnostics)
+	}
+
+	response, _ := h.ProcessRequest(2, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 2},
+	})
+	data, _ := json.Marshal(response.Result)
+	var hover Hover
+	json.Unmarshal(data, &hover)
+	if !strings.HasSuffix(hover.Contents.Value, "Not in v1.18.0, the dialect this workspace targets: added in a later version.") {
+		t.Errorf("Expected a dialect note, got %q", hover.Contents.Value)
+	}
+
+	complete := func(text string) []string {
+		h.openDocument(t, uri, text)
+		response, _ := h.ProcessRequest(3, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		var list CompletionList
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &list)
+		return completionLabels(list.Items)
+	}
+	if got := complete("values 1 | "); slices.Contains(got, "values") || slices.Contains(got, "fn") || !slices.Contains(got, "yield") {
+		t.Errorf("Expected yield without values or the fn snippet, got %v", got)
+	}
+	if got := complete("values parse_"); slices.Contains(got, "parse_sup") || !slices.Contains(got, "parse_uri") {
+		t.Errorf("Expected parse_uri without parse_sup, got %v", got)
+	}
+}
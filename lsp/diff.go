@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Result diffing runs two versions of a query and compares their output
+// value by value, to check that a refactor didn't change what a query
+// returns.
+
+// diffMaxValues bounds how many values of each run are compared
+const diffMaxValues = 10000
+
+// defaultDiffLimit is how many added, removed, and changed values a diff
+// lists when the client doesn't say; all of them are counted
+const defaultDiffLimit = 50
+
+// Diff modes for superdb.diffResults
+const (
+	DiffModeSaved      = "saved"      // the buffer against the file on disk
+	DiffModeSelections = "selections" // two ranges of the buffer
+)
+
+// diffValues compares the values of two runs. Values are matched as a
+// multiset on their JSON text, so reordering alone is not a difference.
+// Unmatched values pair up as changed when they share a key field value,
+// or without a key when they are records with the same fields, in order.
+func diffValues(before, after []json.RawMessage, key string, limit int) DiffResultsResult {
+	result := DiffResultsResult{
+		Added:   []json.RawMessage{},
+		Removed: []json.RawMessage{},
+		Changed: []ValueChange{},
+	}
+
+	remaining := make(map[string]int)
+	for _, v := range after {
+		remaining[string(v)]++
+	}
+	var removed []json.RawMessage
+	for _, v := range before {
+		if remaining[string(v)] > 0 {
+			remaining[string(v)]--
+			result.Unchanged++
+			continue
+		}
+		removed = append(removed, v)
+	}
+	var added []json.RawMessage
+	for _, v := range after {
+		if remaining[string(v)] > 0 {
+			remaining[string(v)]--
+			added = append(added, v)
+		}
+	}
+
+	// Pair removed and added values that look like the same record
+	used := make([]bool, len(added))
+	for _, r := range removed {
+		id, ok := diffIdentity(r, key)
+		match := -1
+		if ok {
+			for i, a := range added {
+				if other, ok := diffIdentity(a, key); !used[i] && ok && other == id {
+					match = i
+					break
+				}
+			}
+		}
+		if match < 0 {
+			result.RemovedCount++
+			if len(result.Removed) < limit {
+				result.Removed = append(result.Removed, r)
+			}
+			continue
+		}
+		used[match] = true
+		result.ChangedCount++
+		if len(result.Changed) < limit {
+			result.Changed = append(result.Changed, ValueChange{Before: r, After: added[match]})
+		}
+	}
+	for i, a := range added {
+		if used[i] {
+			continue
+		}
+		result.AddedCount++
+		if len(result.Added) < limit {
+			result.Added = append(result.Added, a)
+		}
+	}
+	return result
+}
+
+// diffIdentity returns what identifies a value as the same record across
+// runs: its key field's value, or without a key its sorted field names
+func diffIdentity(v json.RawMessage, key string) (string, bool) {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(v, &record); err != nil {
+		return "", false
+	}
+	if key != "" {
+		id, ok := record[key]
+		return string(id), ok
+	}
+	fields := make([]string, 0, len(record))
+	for name := range record {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ","), true
+}
+
+// diffResultsCommand runs two versions of a document's query and returns
+// how their results differ
+func (s *Server) diffResultsCommand(ctx context.Context, args []json.RawMessage) HandlerResult {
+	var params DiffResultsArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultDiffLimit
+	}
+
+	s.promote(params.URI)
+	text, _, ok := s.document(params.URI)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.URI)})
+	}
+
+	var before, after string
+	switch params.Mode {
+	case DiffModeSaved, "":
+		path, err := uriToPath(params.URI)
+		if err != nil {
+			return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+		}
+		saved, err := os.ReadFile(path)
+		if err != nil {
+			return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("reading saved version: %v", err)})
+		}
+		before, after = string(saved), text
+	case DiffModeSelections:
+		if len(params.Ranges) != 2 {
+			return failure(&RPCError{Code: InvalidParams, Message: "selections mode needs two ranges"})
+		}
+		before = rangeText(text, s.rangeFromClient(text, params.Ranges[0]))
+		after = rangeText(text, s.rangeFromClient(text, params.Ranges[1]))
+	default:
+		return failure(&RPCError{Code: InvalidParams, Message: fmt.Sprintf("unknown diff mode: %s", params.Mode)})
+	}
+
+	if len(findLakeWrites(before)) > 0 || len(findLakeWrites(after)) > 0 {
+		return failure(&RPCError{Code: RequestFailed, Message: "queries that write to the lake can't be diffed"})
+	}
+
+	log.Printf("Diffing results: %s (mode=%s)", params.URI, params.Mode)
+	lake := s.settings().Lake
+	beforeRun, err := runQuery(ctx, lake, before, diffMaxValues)
+	if err != nil {
+		return success(DiffResultsResult{Error: fmt.Sprintf("before: %v", err)})
+	}
+	afterRun, err := runQuery(ctx, lake, after, diffMaxValues)
+	if err != nil {
+		return success(DiffResultsResult{Error: fmt.Sprintf("after: %v", err)})
+	}
+
+	result := diffValues(beforeRun.values, afterRun.values, params.Key, params.Limit)
+	result.Truncated = beforeRun.count > int64(len(beforeRun.values)) ||
+		afterRun.count > int64(len(afterRun.values))
+	return success(result)
+}
+
+// rangeText returns the text covered by rng
+func rangeText(text string, rng Range) string {
+	start, ok := offsetAt(text, rng.Start)
+	if !ok {
+		return ""
+	}
+	end, ok := offsetAt(text, rng.End)
+	if !ok {
+		end = len(text)
+	}
+	if end < start {
+		return ""
+	}
+	return text[start:end]
+}
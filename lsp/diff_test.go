@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func rawValues(values ...string) []json.RawMessage {
+	raw := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		raw[i] = json.RawMessage(v)
+	}
+	return raw
+}
+
+func TestDiffValues(t *testing.T) {
+	before := rawValues(`{"id":1,"n":"a"}`, `{"id":2,"n":"b"}`, `{"id":3,"n":"c"}`, `7`)
+	after := rawValues(`{"id":3,"n":"c"}`, `{"id":2,"n":"B"}`, `{"id":4,"n":"d"}`, `8`)
+
+	result := diffValues(before, after, "id", 10)
+	if result.Unchanged != 1 {
+		t.Errorf("Expected reordering alone not to count, got %d unchanged", result.Unchanged)
+	}
+	if result.ChangedCount != 1 || string(result.Changed[0].After) != `{"id":2,"n":"B"}` {
+		t.Errorf("Expected id 2 changed, got %+v", result.Changed)
+	}
+	if result.RemovedCount != 2 || result.AddedCount != 2 {
+		t.Errorf("Expected 2 removed and 2 added, got %d and %d", result.RemovedCount, result.AddedCount)
+	}
+}
+
+func TestDiffValuesWithoutKey(t *testing.T) {
+	before := rawValues(`{"a":1,"b":2}`, `{"x":1}`)
+	after := rawValues(`{"a":1,"b":3}`, `{"y":1}`)
+
+	result := diffValues(before, after, "", 10)
+	if result.ChangedCount != 1 || result.AddedCount != 1 || result.RemovedCount != 1 {
+		t.Errorf("Expected records with the same fields to pair up, got %+v", result)
+	}
+}
+
+func TestDiffValuesLimit(t *testing.T) {
+	result := diffValues(nil, rawValues(`1`, `2`, `3`), "", 2)
+	if result.AddedCount != 3 || len(result.Added) != 2 {
+		t.Errorf("Expected 3 counted and 2 listed, got %d and %d", result.AddedCount, len(result.Added))
+	}
+}
+
+func diffCommand(t *testing.T, h *TestHelper, args DiffResultsArgs) DiffResultsResult {
+	t.Helper()
+	raw, _ := json.Marshal(args)
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandDiffResults,
+		Arguments: []json.RawMessage{raw},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result DiffResultsResult
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	return result
+}
+
+func TestDiffResultsSelections(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///diff.spq"
+	h.openDocument(t, uri, "values 1, 2, 3 | where this > 1\nvalues 1, 2, 3 | where this >= 1")
+
+	result := diffCommand(t, h, DiffResultsArgs{
+		URI:  uri,
+		Mode: DiffModeSelections,
+		Ranges: []Range{
+			{Start: Position{Line: 0, Character: 0}, End: Position{Line: 0, Character: 31}},
+			{Start: Position{Line: 1, Character: 0}, End: Position{Line: 1, Character: 32}},
+		},
+	})
+	if result.Error != "" {
+		t.Fatalf("Unexpected query error: %s", result.Error)
+	}
+	if result.Unchanged != 2 || result.AddedCount != 1 || string(result.Added[0]) != "1" {
+		t.Errorf("Expected 1 added and 2 unchanged, got %+v", result)
+	}
+}
+
+func TestDiffResultsSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saved.spq")
+	if err := os.WriteFile(path, []byte("values 1, 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTestHelper()
+	uri := pathToURI(path)
+	h.openDocument(t, uri, "values 2, 3")
+
+	result := diffCommand(t, h, DiffResultsArgs{URI: uri})
+	if result.Unchanged != 1 || result.AddedCount != 1 || result.RemovedCount != 1 {
+		t.Errorf("Expected one value swapped, got %+v", result)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "strings"
+
+// Doc comments on declarations. The -- comment lines directly above a
+// const, fn, op, or type, with no blank line between, document it the way
+// a builtin's Doc does: hover shows them, and so does the query catalog.
+// A line may start with @doc to mark the comment as documentation; the
+// marker itself is dropped.
+//
+//	-- @doc Top n values of a field by count
+//	-- Ties keep their input order.
+//	op top_by field, n: ( count() by field | sort -r count | head n )
+
+// declDoc returns the doc comment for the declaration starting at offset,
+// or "" if it has none. The declaration must start its line.
+func declDoc(text string, offset int) string {
+	lineStart := strings.LastIndex(text[:offset], "\n") + 1
+	if strings.TrimSpace(text[lineStart:offset]) != "" {
+		return ""
+	}
+	var lines []string
+	for end := lineStart - 1; end >= 0; {
+		start := strings.LastIndex(text[:end], "\n") + 1
+		line := strings.TrimSpace(text[start:end])
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		line = strings.TrimSpace(line[2:])
+		if strings.HasPrefix(line, "@doc") {
+			line = strings.TrimSpace(line[len("@doc"):])
+		}
+		lines = append([]string{line}, lines...)
+		end = start - 1
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// signature returns how a declaration reads in hover: its kind, name, and
+// parameters
+func (sym *symbol) signature() string {
+	switch sym.kind {
+	case symbolFunc:
+		return "fn " + sym.name + "(" + strings.Join(sym.params, ", ") + ")"
+	case symbolOp:
+		return strings.TrimSpace("op " + sym.name + " " + strings.Join(sym.params, ", "))
+	case symbolParam:
+		return "(param) " + sym.name
+	}
+	return sym.kind.String() + " " + sym.name
+}
+
+// symbolHover returns hover content for the declaration named at pos, with
+// its doc comment, or nil if no declaration is named there
+func symbolHover(text string, pos Position, style docStyle) *Hover {
+	if !mayDeclare(text) {
+		return nil
+	}
+	sym := buildSymbolTable(text).symbolAt(pos)
+	if sym == nil {
+		return nil
+	}
+	content := "```spq\n" + sym.signature() + "\n```"
+	if style == docPlainText {
+		content = sym.signature()
+	}
+	if sym.doc != "" {
+		content += "\n\n" + sym.doc
+	}
+	return newHover(content, style)
+}
+
+// declKeywords start the declarations a query can make, including lambdas,
+// whose parameters are declarations too
+var declKeywords = []string{"const ", "fn ", "op ", "type ", "lambda "}
+
+// mayDeclare reports whether text could declare a name, so hovering over
+// a query that declares nothing doesn't pay for a parse
+func mayDeclare(text string) bool {
+	for _, kw := range declKeywords {
+		if strings.Contains(text, kw) {
+			return true
+		}
+	}
+	return false
+}
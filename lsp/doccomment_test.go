@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeclDoc(t *testing.T) {
+	text := "-- not this one\n\n-- @doc Top values by count\n-- Ties keep input order.\nop top_by field, n: ( count() by field | sort -r count | head n )\n" +
+		"const limit = 10 -- trailing, not a doc\n" +
+		"fn double(x): ( x * 2 )\n" +
+		"-- a plain comment documents too\ntype port = uint16\n" +
+		"values 1"
+	table := buildSymbolTable(text)
+	if table == nil {
+		t.Fatal("Expected text to parse")
+	}
+	docs := make(map[string]string)
+	for _, sym := range table.symbols {
+		docs[sym.name] = sym.doc
+	}
+	want := map[string]string{
+		"top_by": "Top values by count\nTies keep input order.",
+		"limit":  "",
+		"double": "",
+		"port":   "a plain comment documents too",
+	}
+	for name, doc := range want {
+		if docs[name] != doc {
+			t.Errorf("Doc of %s: expected %q, got %q", name, doc, docs[name])
+		}
+	}
+}
+
+func TestSymbolHover(t *testing.T) {
+	text := "-- @doc Doubles x\nfn double(x): ( x * 2 )\nop top_by f, n: ( head n )\nvalues double(2) | top_by a, 1"
+
+	hover := getHover(text, posOf(t, text, "double", 2, 1), docMarkdown)
+	if hover == nil || hover.Contents.Value != "```spq\nfn double(x)\n```\n\nDoubles x" {
+		t.Errorf("Unexpected hover on a use of a documented fn: %+v", hover)
+	}
+	hover = getHover(text, posOf(t, text, "top_by", 2, 1), docPlainText)
+	if hover == nil || hover.Contents.Value != "op top_by f, n" || hover.Contents.Kind != MarkupKindPlainText {
+		t.Errorf("Unexpected plain-text hover on an op: %+v", hover)
+	}
+	// Builtins still hover as before
+	if hover := getHover(text, posOf(t, text, "head", 1, 1), docMarkdown); hover == nil || !strings.Contains(hover.Contents.Value, "(operator)") {
+		t.Errorf("Expected builtin hover for head, got %+v", hover)
+	}
+}
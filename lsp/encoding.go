@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"unicode/utf8"
+)
+
+// Position encodings. The server counts a position's character in bytes
+// into its line, while LSP counts UTF-16 code units unless the client
+// offers another encoding in general.positionEncodings. The server picks
+// utf-8 when it is offered, so nothing needs converting; otherwise every
+// handler converts the positions and ranges it is sent with fromClient
+// and those it returns with toClient, and semantic tokens are measured in
+// the client's units. Lines of ASCII read the same either way.
+
+// Position encodings a client can offer
+const (
+	encodingUTF8  = "utf-8"
+	encodingUTF16 = "utf-16"
+)
+
+// chooseEncoding picks the position encoding from those the client
+// offers, utf-8 if it can, or the utf-16 every client supports
+func chooseEncoding(offered []string) string {
+	if slices.Contains(offered, encodingUTF8) {
+		return encodingUTF8
+	}
+	return encodingUTF16
+}
+
+// utf16Units returns the length of s in UTF-16 code units
+func utf16Units(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// isASCII reports whether s is all ASCII
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// fromClient converts pos, in the client's encoding, to the byte offset
+// into its line of text the server works with. A character past the end
+// of the line stays past it.
+func (s *Server) fromClient(text string, pos Position) Position {
+	line, ok := lineAt(text, pos.Line)
+	if s.encoding == encodingUTF8 || !ok || isASCII(line) || pos.Character <= 0 {
+		return pos
+	}
+	units := 0
+	for i, r := range line {
+		if units >= pos.Character {
+			return Position{Line: pos.Line, Character: i}
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return Position{Line: pos.Line, Character: len(line) + pos.Character - units}
+}
+
+// toClient converts pos, a byte offset into its line of text, to the
+// client's encoding
+func (s *Server) toClient(text string, pos Position) Position {
+	line, ok := lineAt(text, pos.Line)
+	if s.encoding == encodingUTF8 || !ok || isASCII(line) || pos.Character <= 0 {
+		return pos
+	}
+	if pos.Character >= len(line) {
+		return Position{Line: pos.Line, Character: utf16Units(line) + pos.Character - len(line)}
+	}
+	// An offset inside a character counts the whole of it
+	end := pos.Character
+	for end < len(line) && !utf8.RuneStart(line[end]) {
+		end++
+	}
+	return Position{Line: pos.Line, Character: utf16Units(line[:end])}
+}
+
+// columns returns the length of str in the client's encoding
+func (s *Server) columns(str string) int {
+	if s.encoding == encodingUTF8 {
+		return len(str)
+	}
+	return utf16Units(str)
+}
+
+// rangeFromClient converts a range of text from the client's encoding
+func (s *Server) rangeFromClient(text string, r Range) Range {
+	return Range{Start: s.fromClient(text, r.Start), End: s.fromClient(text, r.End)}
+}
+
+// rangeToClient converts a range of text to the client's encoding
+func (s *Server) rangeToClient(text string, r Range) Range {
+	return Range{Start: s.toClient(text, r.Start), End: s.toClient(text, r.End)}
+}
+
+// editToClient converts the ranges of the edits edit makes to uri, whose
+// text is text, to the client's encoding
+func (s *Server) editToClient(uri, text string, edit *WorkspaceEdit) {
+	for i, e := range edit.Changes[uri] {
+		edit.Changes[uri][i].Range = s.rangeToClient(text, e.Range)
+	}
+}
+
+// diagnosticsToClient converts the ranges of diagnostics of text to the
+// client's encoding
+func (s *Server) diagnosticsToClient(text string, diagnostics []Diagnostic) []Diagnostic {
+	if s.encoding == encodingUTF8 || isASCII(text) {
+		return diagnostics
+	}
+	converted := make([]Diagnostic, len(diagnostics))
+	for i, d := range diagnostics {
+		d.Range = s.rangeToClient(text, d.Range)
+		converted[i] = d
+	}
+	return converted
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestPositionEncodingNegotiation(t *testing.T) {
+	tests := []struct {
+		offered []string
+		want    string
+	}{
+		{nil, encodingUTF16},
+		{[]string{"utf-32", "utf-16"}, encodingUTF16},
+		{[]string{"utf-16", "utf-8"}, encodingUTF8},
+	}
+	for _, tt := range tests {
+		h := NewTestHelper()
+		params := InitializeParams{}
+		params.Capabilities.General.PositionEncodings = tt.offered
+		response, err := h.ProcessRequest(1, "initialize", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result InitializeResult
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &result)
+		if result.Capabilities.PositionEncoding != tt.want || h.server.encoding != tt.want {
+			t.Errorf("Offered %v, expected %s, got %s", tt.offered, tt.want, result.Capabilities.PositionEncoding)
+		}
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	// é is two bytes and one unit, 😀 four bytes and two units
+	text := `values "é😀" | yield this`
+	yield := Range{Start: Position{Line: 0, Character: 15}, End: Position{Line: 0, Character: 20}}
+
+	h := NewTestHelper()
+	h.ProcessRequest(1, "initialize", InitializeParams{})
+	uri := "file:///encoding.spq"
+	msg, err := h.ProcessNotification("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "superdb", Version: 1, Text: text},
+	})
+	if err != nil || msg == nil {
+		t.Fatalf("Expected diagnostics, got %+v, %v", msg, err)
+	}
+	var published PublishDiagnosticsParams
+	json.Unmarshal(msg.Params, &published)
+	if len(published.Diagnostics) != 1 || published.Diagnostics[0].Range != yield {
+		t.Errorf("Expected the alias hint at %+v, got %+v", yield, published.Diagnostics)
+	}
+
+	response, err := h.ProcessRequest(2, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 16},
+	})
+	if err != nil || response.Result == nil {
+		t.Fatalf("Expected a hover, got %+v, %v", response, err)
+	}
+	var hover Hover
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &hover)
+	if !strings.HasPrefix(hover.Contents.Value, "**yield**") {
+		t.Errorf("Expected yield's hover, got %+v", hover)
+	}
+
+	h.ProcessNotification("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{Range: &yield, Text: "values"}},
+	})
+	if got, _, _ := h.server.document(uri); got != `values "é😀" | values this` {
+		t.Errorf("Expected yield replaced, got %q", got)
+	}
+}
+
+func TestUTF8Positions(t *testing.T) {
+	s := NewServer()
+	s.encoding = encodingUTF8
+	text := `values "é😀" | yield this`
+	pos := Position{Line: 0, Character: 18}
+	if got := s.toClient(text, pos); got != pos {
+		t.Errorf("Expected byte offsets unchanged, got %+v", got)
+	}
+	if got := s.fromClient(text, pos); got != pos {
+		t.Errorf("Expected byte offsets unchanged, got %+v", got)
+	}
+}
+
+// utf16Query has non-ASCII text ahead of names on the same line, where
+// byte offsets and UTF-16 units differ
+const utf16Query = "const s = \"é😀\"\nconst n = 1\nvalues s, \"é😀\", n | put x := n | yield x"
+
+// utf16Pos returns the position, in UTF-16 units, of the nth occurrence
+// of substr in text
+func utf16Pos(t *testing.T, text, substr string, n int) Position {
+	t.Helper()
+	pos := posOf(t, text, substr, n, 0)
+	line, _ := lineAt(text, pos.Line)
+	return Position{Line: pos.Line, Character: utf16Units(line[:pos.Character])}
+}
+
+// utf16Text returns the text of rng, a range in UTF-16 units on one line
+func utf16Text(t *testing.T, text string, rng Range) string {
+	t.Helper()
+	line, _ := lineAt(text, rng.Start.Line)
+	units := utf16.Encode([]rune(line))
+	if rng.End.Line != rng.Start.Line || rng.Start.Character > rng.End.Character || rng.End.Character > len(units) {
+		t.Fatalf("Range %+v is outside line %q", rng, line)
+	}
+	return string(utf16.Decode(units[rng.Start.Character:rng.End.Character]))
+}
+
+// utf16Request opens text and answers a request about it from a server
+// that negotiated utf-16, decoding the result into result
+func utf16Request(t *testing.T, text, method string, params, result interface{}) {
+	t.Helper()
+	h := NewTestHelper()
+	init := InitializeParams{}
+	init.Capabilities.TextDocument.CodeAction.CodeActionLiteralSupport = &CodeActionLiteralSupport{}
+	h.ProcessRequest(1, "initialize", init)
+	h.ProcessNotification("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: "file:///tmp/q.spq", LanguageID: "superdb", Version: 1, Text: text},
+	})
+	response, err := h.ProcessRequest(2, method, params)
+	if err != nil || response.Error != nil {
+		t.Fatalf("%s failed: %+v, %v", method, response, err)
+	}
+	data, _ := json.Marshal(response.Result)
+	if err := json.Unmarshal(data, result); err != nil {
+		t.Fatalf("Decoding %s: %v", data, err)
+	}
+}
+
+func TestUTF16Handlers(t *testing.T) {
+	doc := TextDocumentIdentifier{URI: "file:///tmp/q.spq"}
+	use := DefinitionParams{TextDocument: doc, Position: utf16Pos(t, utf16Query, "n |", 1)}
+
+	t.Run("definition", func(t *testing.T) {
+		var loc Location
+		utf16Request(t, utf16Query, "textDocument/definition", use, &loc)
+		if loc.Range.Start.Line != 1 || utf16Text(t, utf16Query, loc.Range) != "n" {
+			t.Errorf("Expected n's declaration, got %+v", loc)
+		}
+	})
+
+	t.Run("references", func(t *testing.T) {
+		var locs []Location
+		utf16Request(t, utf16Query, "textDocument/references", ReferenceParams{
+			TextDocument: doc,
+			Position:     use.Position,
+			Context:      ReferenceContext{IncludeDeclaration: true},
+		}, &locs)
+		if len(locs) != 3 {
+			t.Fatalf("Expected 3 references, got %+v", locs)
+		}
+		for _, loc := range locs {
+			if got := utf16Text(t, utf16Query, loc.Range); got != "n" {
+				t.Errorf("Expected a reference to n, got %q at %+v", got, loc.Range)
+			}
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		var prepared PrepareRenameResult
+		utf16Request(t, utf16Query, "textDocument/prepareRename", PrepareRenameParams(use), &prepared)
+		if prepared.Range != (Range{Start: use.Position, End: Position{Line: 2, Character: use.Position.Character + 1}}) {
+			t.Errorf("Expected the range of n, got %+v", prepared.Range)
+		}
+		var edit WorkspaceEdit
+		utf16Request(t, utf16Query, "textDocument/rename", RenameParams{TextDocument: doc, Position: use.Position, NewName: "m"}, &edit)
+		if len(edit.Changes[doc.URI]) != 3 {
+			t.Fatalf("Expected 3 edits, got %+v", edit)
+		}
+		for _, e := range edit.Changes[doc.URI] {
+			if got := utf16Text(t, utf16Query, e.Range); got != "n" {
+				t.Errorf("Expected an edit of n, got %q at %+v", got, e.Range)
+			}
+		}
+	})
+
+	t.Run("semantic tokens", func(t *testing.T) {
+		var tokens SemanticTokens
+		utf16Request(t, utf16Query, "textDocument/semanticTokens/full", SemanticTokensParams{TextDocument: doc}, &tokens)
+		var got []string
+		line, char := 0, 0
+		for i := 0; i+4 < len(tokens.Data); i += 5 {
+			if tokens.Data[i] > 0 {
+				char = 0
+			}
+			line += int(tokens.Data[i])
+			char += int(tokens.Data[i+1])
+			if line == 2 {
+				rng := Range{Start: Position{Line: line, Character: char}, End: Position{Line: line, Character: char + int(tokens.Data[i+2])}}
+				got = append(got, utf16Text(t, utf16Query, rng))
+			}
+		}
+		want := []string{"values", "s", `"é😀"`, "n", "|", "put", "x", ":=", "n", "|", "yield", "x"}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("Expected tokens %q, got %q", want, got)
+		}
+	})
+
+	t.Run("inlay hints", func(t *testing.T) {
+		text := `values "é😀", upper("é😀")`
+		end := Position{Character: utf16Units(text)}
+		var hints []InlayHint
+		utf16Request(t, text, "textDocument/inlayHint", InlayHintParams{TextDocument: doc, Range: Range{End: end}}, &hints)
+		if len(hints) != 1 || hints[0].Position != end {
+			t.Errorf("Expected a hint at the end of the line, got %+v", hints)
+		}
+	})
+
+	t.Run("selection range", func(t *testing.T) {
+		var ranges []SelectionRange
+		utf16Request(t, utf16Query, "textDocument/selectionRange", SelectionRangeParams{
+			TextDocument: doc,
+			Positions:    []Position{use.Position},
+		}, &ranges)
+		if len(ranges) != 1 || utf16Text(t, utf16Query, ranges[0].Range) != "n" {
+			t.Errorf("Expected the selection to start at n, got %+v", ranges)
+		}
+	})
+
+	t.Run("linked editing", func(t *testing.T) {
+		text := `values "é😀", {foo: 1, bar: foo}`
+		var linked LinkedEditingRanges
+		utf16Request(t, text, "textDocument/linkedEditingRange", LinkedEditingRangeParams{
+			TextDocument: doc,
+			Position:     utf16Pos(t, text, "foo", 1),
+		}, &linked)
+		if len(linked.Ranges) != 2 {
+			t.Fatalf("Expected 2 linked ranges, got %+v", linked)
+		}
+		for _, rng := range linked.Ranges {
+			if got := utf16Text(t, text, rng); got != "foo" {
+				t.Errorf("Expected foo linked, got %q", got)
+			}
+		}
+	})
+
+	t.Run("document links", func(t *testing.T) {
+		text := `from "é😀/data.sup"`
+		var links []DocumentLink
+		utf16Request(t, text, "textDocument/documentLink", DocumentLinkParams{TextDocument: doc}, &links)
+		if len(links) != 1 || !strings.Contains(utf16Text(t, text, links[0].Range), "é😀/data.sup") {
+			t.Errorf("Expected a link over the path, got %+v", links)
+		}
+	})
+
+	t.Run("document symbols", func(t *testing.T) {
+		var symbols []DocumentSymbol
+		utf16Request(t, utf16Query, "textDocument/documentSymbol", DocumentSymbolParams{TextDocument: doc}, &symbols)
+		if len(symbols) == 0 || symbols[0].Name != "s" {
+			t.Fatalf("Expected s first, got %+v", symbols)
+		}
+		if got := utf16Text(t, utf16Query, symbols[0].Range); !strings.HasSuffix(got, `"é😀"`) {
+			t.Errorf("Expected s's range to end with its value, got %q", got)
+		}
+	})
+
+	t.Run("code lens", func(t *testing.T) {
+		var lenses []CodeLens
+		utf16Request(t, utf16Query, "textDocument/codeLens", CodeLensParams{TextDocument: doc}, &lenses)
+		var got []string
+		for _, lens := range lenses {
+			if lens.Range.Start.Line == 2 {
+				got = append(got, utf16Text(t, utf16Query, lens.Range))
+			}
+		}
+		if !slices.Contains(got, "put x := n") || !slices.Contains(got, "yield x") {
+			t.Errorf("Expected lenses over the stages, got %q", got)
+		}
+	})
+
+	t.Run("code action", func(t *testing.T) {
+		at := utf16Pos(t, utf16Query, "yield", 1)
+		var actions []CodeAction
+		utf16Request(t, utf16Query, "textDocument/codeAction", CodeActionParams{
+			TextDocument: doc,
+			Range:        Range{Start: at, End: at},
+			Context:      CodeActionContext{Only: []string{CodeActionKindQuickFix}},
+		}, &actions)
+		if len(actions) == 0 {
+			t.Fatal("Expected a quick fix for yield")
+		}
+		for _, e := range actions[0].Edit.Changes[doc.URI] {
+			if got := utf16Text(t, utf16Query, e.Range); got != "yield" {
+				t.Errorf("Expected the edit to replace yield, got %q", got)
+			}
+		}
+	})
+}
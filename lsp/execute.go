@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/brimdata/super"
+	"github.com/brimdata/super/compiler"
+	"github.com/brimdata/super/compiler/parser"
+	"github.com/brimdata/super/db"
+	"github.com/brimdata/super/pkg/storage"
+	"github.com/brimdata/super/runtime"
+	"github.com/brimdata/super/runtime/exec"
+	"github.com/brimdata/super/sio"
+	"github.com/brimdata/super/sio/jsonio"
+	"go.uber.org/zap"
+)
+
+// Query execution. Queries run in-process with the same brimdata/super
+// commit the parser comes from, against the local filesystem, so a query
+// that checks clean in the editor runs the way it was checked.
+
+// executionTimeout bounds how long a query run from the editor may take
+const executionTimeout = 30 * time.Second
+
+// defaultMaxValues caps the values a run returns when the client doesn't
+// say; the rest are counted but not converted
+const defaultMaxValues = 1000
+
+// queryRun is the outcome of running a query
+type queryRun struct {
+	values     []json.RawMessage
+	count      int64 // values produced, including any past the cap
+	elapsed    time.Duration
+	assertions []assertFailures // failed asserts, in the order they first failed
+}
+
+// runQuery compiles and runs query, converting up to max values to JSON
+func runQuery(ctx context.Context, lake, query string, max int) (queryRun, error) {
+	var run queryRun
+	var buf bytes.Buffer
+	writer := jsonio.NewWriter(sio.NopCloser(&buf), jsonio.WriterOpts{})
+	elapsed, err := pullQuery(ctx, lake, query, func(val super.Value) error {
+		run.count++
+		run.noteAssertion(val)
+		if len(run.values) >= max {
+			return nil
+		}
+		buf.Reset()
+		if err := writer.Write(val); err != nil {
+			return err
+		}
+		// Copy out of buf, which the next value reuses
+		value := append(json.RawMessage(nil), bytes.TrimSpace(buf.Bytes())...)
+		run.values = append(run.values, value)
+		return nil
+	})
+	run.elapsed = elapsed
+	return run, err
+}
+
+// pullQuery compiles and runs query, passing each value it produces to
+// emit, and returns how long the run took. It stops between batches once
+// ctx is cancelled.
+func pullQuery(ctx context.Context, lake, query string, emit func(super.Value) error) (time.Duration, error) {
+	ast, err := parser.ParseQuery(query)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, executionTimeout)
+	defer cancel()
+	start := time.Now()
+
+	env, err := newEnvironment(ctx, lake)
+	if err != nil {
+		return 0, err
+	}
+	q, err := runtime.CompileQuery(ctx, super.NewContext(), compiler.NewCompilerWithEnv(env), ast, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer q.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return time.Since(start), err
+		}
+		batch, err := q.Pull(false)
+		if err != nil {
+			return time.Since(start), err
+		}
+		if batch == nil {
+			return time.Since(start), nil
+		}
+		for _, val := range batch.Values() {
+			if err := emit(val); err != nil {
+				return time.Since(start), err
+			}
+		}
+	}
+}
+
+// newEnvironment returns the execution environment for a run: the local
+// filesystem, plus the lake at path when one is configured
+func newEnvironment(ctx context.Context, lake string) (*exec.Environment, error) {
+	engine := storage.NewLocalEngine()
+	if lake == "" {
+		return exec.NewEnvironment(engine, nil), nil
+	}
+	uri, err := storage.ParseURI(lake)
+	if err != nil {
+		return nil, err
+	}
+	root, err := db.Open(ctx, engine, zap.NewNop(), uri)
+	if err != nil {
+		return nil, fmt.Errorf("opening lake %s: %w", lake, err)
+	}
+	return exec.NewEnvironment(engine, root), nil
+}
+
+// stageStats measures each top-level stage of query by running every prefix
+// of the pipeline: the records a prefix produces are what its last stage
+// emits, and the time it adds over the previous prefix is that stage's
+// share. Measuring stops at the first prefix that doesn't run.
+func stageStats(ctx context.Context, lake, query string) ([]StageStats, error) {
+	stats := []StageStats{}
+	var previous time.Duration
+	for _, st := range splitStages(tokenize(query)) {
+		run, err := runQuery(ctx, lake, query[:st.end], 0)
+		if err != nil {
+			return stats, err
+		}
+		elapsed := run.elapsed - previous
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		previous = run.elapsed
+
+		span := query[st.start:st.end]
+		lead := len(span) - len(strings.TrimLeft(span, " \t\r\n"))
+		trimmed := strings.TrimSpace(span)
+		stats = append(stats, StageStats{
+			Operator: st.operator(),
+			Range: Range{
+				Start: positionAt(query, st.start+lead),
+				End:   positionAt(query, st.start+lead+len(trimmed)),
+			},
+			Records:   run.count,
+			ElapsedMs: float64(elapsed.Microseconds()) / 1000,
+		})
+	}
+	return stats, nil
+}
+
+// runQueryCommand runs a document's query and returns its values. With
+// stats set it also publishes superdb/stageStats so clients can show where
+// time and records go next to each operator.
+func (s *Server) runQueryCommand(ctx context.Context, args []json.RawMessage) HandlerResult {
+	var params RunQueryArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if params.MaxValues <= 0 {
+		params.MaxValues = defaultMaxValues
+	}
+
+	s.promote(params.URI)
+	text, version, ok := s.document(params.URI)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.URI)})
+	}
+	if s.isDataFile(params.URI) {
+		return failure(&RPCError{Code: RequestFailed, Message: "data files can't be run as queries"})
+	}
+	if params.Through != nil {
+		end, ok := offsetAt(text, s.fromClient(text, *params.Through))
+		if !ok {
+			return failure(&RPCError{Code: InvalidParams, Message: "position is outside the document"})
+		}
+		text = text[:end]
+	}
+	text, err := s.substituteParameters(text, params.Parameters)
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+
+	if writes := findLakeWrites(text); len(writes) > 0 {
+		return s.confirmLakeWrite(params, text, writes)
+	}
+
+	log.Printf("Running query: %s (stats=%v)", params.URI, params.Stats)
+	result, err := s.queryResult(ctx, params.URI, text, params.MaxValues)
+	if err != nil {
+		return success(result)
+	}
+
+	if params.Stats {
+		stats, err := stageStats(ctx, s.settings().Lake, text)
+		if err != nil {
+			log.Printf("Stage stats stopped early for %s: %v", params.URI, err)
+		}
+		msg, err := notification("superdb/stageStats", StageStatsParams{
+			URI:     params.URI,
+			Version: version,
+			Stages:  stats,
+		})
+		if err == nil {
+			err = s.send(msg)
+		}
+		if err != nil {
+			log.Printf("Error sending stage stats for %s: %v", params.URI, err)
+		}
+	}
+	return success(result)
+}
+
+// queryResult runs text and packages its values as a superdb/queryResult
+// payload. A query error is reported in the payload and also returned.
+func (s *Server) queryResult(ctx context.Context, uri, text string, max int) (QueryResultParams, error) {
+	result := QueryResultParams{URI: uri, Values: []json.RawMessage{}, Done: true}
+	run, err := runQuery(ctx, s.settings().Lake, text, max)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	s.publishRunDiagnostics(ctx, uri, text, run)
+	if run.values != nil {
+		result.Values = run.values
+	}
+	result.Truncated = run.count > int64(len(run.values))
+	result.ElapsedMs = run.elapsed.Milliseconds()
+	return result, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunQuery(t *testing.T) {
+	run, err := runQuery(context.Background(), "", "values 1, 2, 3, 4 | where this > 1", 2)
+	if err != nil {
+		t.Fatalf("runQuery failed: %v", err)
+	}
+	if run.count != 3 {
+		t.Errorf("Expected 3 values, got %d", run.count)
+	}
+	if len(run.values) != 2 || string(run.values[0]) != "2" || string(run.values[1]) != "3" {
+		t.Errorf("Expected values capped to [2 3], got %s", run.values)
+	}
+}
+
+func TestRunQueryError(t *testing.T) {
+	if _, err := runQuery(context.Background(), "", "values 1 |", 10); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
+
+func TestStageStats(t *testing.T) {
+	query := "values 1, 2, 3, 4\n| where this > 1\n| count()"
+	stats, err := stageStats(context.Background(), "", query)
+	if err != nil {
+		t.Fatalf("stageStats failed: %v", err)
+	}
+
+	expected := []struct {
+		operator string
+		records  int64
+		rng      Range
+	}{
+		{"values", 4, Range{Start: Position{Line: 0, Character: 0}, End: Position{Line: 0, Character: 17}}},
+		{"where", 3, Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 1, Character: 16}}},
+		{"count", 1, Range{Start: Position{Line: 2, Character: 0}, End: Position{Line: 2, Character: 9}}},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("Expected %d stages, got %+v", len(expected), stats)
+	}
+	for i, exp := range expected {
+		got := stats[i]
+		if got.Operator != exp.operator || got.Records != exp.records || got.Range != exp.rng {
+			t.Errorf("Stage %d: expected %s with %d records at %+v, got %+v",
+				i, exp.operator, exp.records, exp.rng, got)
+		}
+		if got.ElapsedMs < 0 {
+			t.Errorf("Stage %d: negative elapsed time %v", i, got.ElapsedMs)
+		}
+	}
+}
+
+func TestRunQueryCommandPublishesStageStats(t *testing.T) {
+	h := NewTestHelper()
+	out := &bytes.Buffer{}
+	h.server.out = out
+	uri := "file:///run.spq"
+	h.openDocument(t, uri, "values 1, 2 | put x := this * 2")
+	out.Reset()
+
+	args, _ := json.Marshal(RunQueryArgs{URI: uri, Stats: true})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRunQuery,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+
+	resultBytes, _ := json.Marshal(response.Result)
+	var result QueryResultParams
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	if result.Error != "" || !result.Done || len(result.Values) != 2 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 || msgs[0].Method != "superdb/stageStats" {
+		t.Fatalf("Expected one superdb/stageStats notification, got %+v", msgs)
+	}
+	var params StageStatsParams
+	if err := json.Unmarshal(msgs[0].Params, &params); err != nil {
+		t.Fatalf("Unmarshal stage stats: %v", err)
+	}
+	if params.URI != uri || params.Version != 1 || len(params.Stages) != 2 {
+		t.Errorf("Unexpected stage stats: %+v", params)
+	}
+}
+
+func TestRunQueryCommandReportsQueryErrors(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///bad.spq"
+	h.openDocument(t, uri, "from /no/such/file.json")
+
+	args, _ := json.Marshal(RunQueryArgs{URI: uri})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRunQuery,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Expected the query error in the result, got %+v", response.Error)
+	}
+	result := response.Result.(map[string]interface{})
+	if result["error"] == nil || result["error"] == "" {
+		t.Errorf("Expected a query error, got %+v", result)
+	}
+}
+
+func TestLongCommandsStopWhenCancelled(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///cancel.spq"
+	h.openDocument(t, uri, "values 1, 2, 3\n| where this > 1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	arg := func(v interface{}) []json.RawMessage {
+		data, _ := json.Marshal(v)
+		return []json.RawMessage{data}
+	}
+	lines := []Range{
+		{End: Position{Line: 0, Character: 14}},
+		{End: Position{Line: 1, Character: 16}},
+	}
+	tests := map[string]HandlerResult{
+		CommandRunQuery:      h.server.runQueryCommand(ctx, arg(RunQueryArgs{URI: uri})),
+		CommandDiffResults:   h.server.diffResultsCommand(ctx, arg(DiffResultsArgs{URI: uri, Mode: DiffModeSelections, Ranges: lines})),
+		CommandExploreShapes: h.server.exploreShapesCommand(ctx, arg(ExploreShapesArgs{URI: uri})),
+	}
+	for command, result := range tests {
+		data, _ := json.Marshal(result.Result)
+		var payload struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &payload)
+		if !strings.Contains(payload.Error, context.Canceled.Error()) {
+			t.Errorf("%s: expected the run cancelled, got %s", command, data)
+		}
+	}
+}
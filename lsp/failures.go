@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Internal failures. A handler that panics, or fails with an internal
+// error rather than one the protocol expects, is the server's fault, not
+// the user's. Besides the log on stderr, which most editors tuck away, the
+// failure goes to the client as window/logMessage, for the editor's output
+// panel, and the first failure of each method as window/showMessage, so
+// the user notices without being told again on every keystroke. The
+// internalErrors setting chooses how loud this is.
+
+// Settings for internalErrors
+const (
+	internalErrorsShow = "show" // log and show a message, the default
+	internalErrorsLog  = "log"  // log to the client's output only
+	internalErrorsOff  = "off"  // log to stderr only
+)
+
+// reportInternalError tells the client that handling method failed
+func (s *Server) reportInternalError(method, message string) {
+	level := s.settings().InternalErrors
+	if level == internalErrorsOff {
+		return
+	}
+	message = fmt.Sprintf("SuperDB language server: %s failed: %s", method, message)
+	s.logMessage(MessageTypeError, message)
+	if level != internalErrorsShow {
+		return
+	}
+	s.failuresMu.Lock()
+	shown := s.failures[method]
+	s.failures[method] = true
+	s.failuresMu.Unlock()
+	if !shown {
+		s.showMessage(MessageTypeError, message)
+	}
+}
+
+// logMessage writes message to the client's log
+func (s *Server) logMessage(typ int, message string) {
+	msg, err := notification("window/logMessage", LogMessageParams{Type: typ, Message: message})
+	if err == nil {
+		err = s.send(msg)
+	}
+	if err != nil {
+		log.Printf("Error logging message: %v", err)
+	}
+}
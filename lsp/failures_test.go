@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportInternalError(t *testing.T) {
+	s := NewServer()
+	out := &bytes.Buffer{}
+	s.out = out
+	methods := func() []string {
+		var got []string
+		for _, msg := range drainMessages(t, out) {
+			got = append(got, msg.Method)
+		}
+		return got
+	}
+
+	s.reportInternalError("textDocument/hover", "boom")
+	msgs := drainMessages(t, out)
+	if len(msgs) != 2 || msgs[0].Method != "window/logMessage" || msgs[1].Method != "window/showMessage" {
+		t.Fatalf("Expected the failure logged and shown, got %+v", msgs)
+	}
+	var shown ShowMessageParams
+	json.Unmarshal(msgs[1].Params, &shown)
+	if shown.Type != MessageTypeError || !strings.Contains(shown.Message, "textDocument/hover failed: boom") {
+		t.Errorf("Unexpected message: %+v", shown)
+	}
+
+	// The same method fails again, quietly
+	s.reportInternalError("textDocument/hover", "boom")
+	if got := methods(); len(got) != 1 || got[0] != "window/logMessage" {
+		t.Errorf("Expected a repeat only logged, got %v", got)
+	}
+
+	s.config.InternalErrors = internalErrorsLog
+	s.reportInternalError("textDocument/completion", "boom")
+	if got := methods(); len(got) != 1 || got[0] != "window/logMessage" {
+		t.Errorf("Expected only a log message, got %v", got)
+	}
+	s.config.InternalErrors = internalErrorsOff
+	s.reportInternalError("textDocument/formatting", "boom")
+	if got := methods(); len(got) != 0 {
+		t.Errorf("Expected nothing sent, got %v", got)
+	}
+}
+
+func TestPanicIsShown(t *testing.T) {
+	h := NewTestHelper()
+	out := &bytes.Buffer{}
+	h.server.out = out
+	h.server.crashPath = filepath.Join(t.TempDir(), "last-crash.json")
+	commands["superdb.testPanic"] = func(*Server, context.Context, []json.RawMessage) HandlerResult {
+		panic("index out of range")
+	}
+	defer delete(commands, "superdb.testPanic")
+	h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{Command: "superdb.testPanic"})
+
+	var shown ShowMessageParams
+	for _, msg := range drainMessages(t, out) {
+		if msg.Method == "window/showMessage" {
+			json.Unmarshal(msg.Params, &shown)
+		}
+	}
+	if !strings.Contains(shown.Message, "panic: index out of range") || !strings.Contains(shown.Message, CommandShowLastCrash) {
+		t.Errorf("Expected the panic shown with a pointer to the report, got %q", shown.Message)
+	}
+}
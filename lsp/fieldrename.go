@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Renaming a field everywhere. When a field of the data is renamed, every
+// query that uses it has to follow. superdb.renameFieldEverywhere finds
+// each use of a field across the workspace's queries, whether written as
+// a name, a dotted path, a subscript like this["host"], or a by-clause
+// key, and returns one WorkspaceEdit covering them all. A dry run returns
+// the report of what would change without the edit, for review first.
+//
+// Uses are found syntactically: a query that builds the name at run time,
+// or reads the field through a spread, isn't changed.
+
+// renameFieldContext is how much of a line a report shows around a use
+const renameFieldContext = 120
+
+// fieldUses returns the ranges of the uses of the field at path in text,
+// reporting false if text doesn't parse
+func fieldUses(text, path string) ([]Range, bool) {
+	table := buildSymbolTable(text)
+	if table == nil {
+		return nil, false
+	}
+	var uses []Range
+	for _, f := range table.fields {
+		if f.path == path {
+			uses = append(uses, f.rng)
+		}
+	}
+	sort.Slice(uses, func(i, j int) bool { return positionLess(uses[i].Start, uses[j].Start) })
+	return uses, true
+}
+
+// readsSource reports whether text reads source, by name or, for a file,
+// by its base name
+func readsSource(text, source string) bool {
+	for _, s := range querySources(text) {
+		if s == source || path.Base(s) == source {
+			return true
+		}
+	}
+	return false
+}
+
+// renameFieldEverywhere renames a field in every query under the
+// workspace root
+func (s *Server) renameFieldEverywhere(ctx context.Context, args []json.RawMessage) HandlerResult {
+	var params RenameFieldArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if params.Field == "" {
+		return failure(&RPCError{Code: InvalidParams, Message: "no field given"})
+	}
+	if !identifierPattern.MatchString(params.NewName) {
+		return failure(&RPCError{Code: InvalidParams, Message: fmt.Sprintf("%q is not a valid name", params.NewName)})
+	}
+	folders := s.workspaceFolders()
+	if len(folders) == 0 {
+		return failure(&RPCError{Code: RequestFailed, Message: "no workspace folder is open"})
+	}
+
+	result := RenameFieldResult{
+		Field:    params.Field,
+		NewName:  params.NewName,
+		DryRun:   params.DryRun,
+		Files:    []RenameFieldFile{},
+		Skipped:  []string{},
+		ReadOnly: []string{},
+	}
+	changes := make(map[string][]TextEdit)
+	// Nested folders would visit a file twice
+	seen := make(map[string]bool)
+	visit := func(file, text string) {
+		uri := pathToURI(file)
+		if seen[uri] {
+			return
+		}
+		seen[uri] = true
+		if current, _, ok := s.document(uri); ok {
+			text = current
+		}
+		if params.Source != "" && !readsSource(text, params.Source) {
+			return
+		}
+		uses, ok := fieldUses(text, params.Field)
+		if !ok {
+			result.Skipped = append(result.Skipped, uri)
+			return
+		}
+		if len(uses) == 0 {
+			return
+		}
+		if s.isReadOnly(uri) {
+			result.ReadOnly = append(result.ReadOnly, uri)
+			return
+		}
+		entry := RenameFieldFile{URI: uri, Uses: make([]RenameFieldUse, len(uses))}
+		edits := make([]TextEdit, len(uses))
+		for i, rng := range uses {
+			line, _ := lineAt(text, rng.Start.Line)
+			entry.Uses[i] = RenameFieldUse{Range: rng, Line: truncate(strings.TrimSpace(line), renameFieldContext)}
+			edits[i] = TextEdit{Range: rng, NewText: params.NewName}
+		}
+		result.Files = append(result.Files, entry)
+		result.Uses += len(uses)
+		changes[uri] = edits
+	}
+	p := s.startProgress(ctx, "Renaming field "+params.Field, true)
+	for _, folder := range folders {
+		walkQueryFiles(folder, p, visit)
+	}
+	if p.cancelled() {
+		p.end("Cancelled")
+		return failure(&RPCError{Code: RequestCancelled, Message: "rename cancelled"})
+	}
+	p.end(fmt.Sprintf("Found %d uses", result.Uses))
+
+	if !params.DryRun {
+		result.Edit = &WorkspaceEdit{Changes: changes}
+		if len(result.ReadOnly) > 0 {
+			s.warnReadOnly(result.ReadOnly)
+		}
+	}
+	log.Printf("Field %s is used %d times in %d queries", params.Field, result.Uses, len(result.Files))
+	return success(result)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFieldUses(t *testing.T) {
+	text := "from conn | where id.orig_h == 10.0.0.1\n| count() by this[\"id\"].orig_h, host\n| put a := id['orig_h']"
+	uses, ok := fieldUses(text, "id.orig_h")
+	if !ok || len(uses) != 3 {
+		t.Fatalf("Expected 3 uses, got %+v", uses)
+	}
+	for _, rng := range uses {
+		if got := rangeText(text, rng); got != "orig_h" {
+			t.Errorf("Expected each use to cover the name, got %q", got)
+		}
+	}
+	if uses, _ := fieldUses(text, "id"); len(uses) != 3 {
+		t.Errorf("Expected id in all three paths, got %+v", uses)
+	}
+	if _, ok := fieldUses("from |", "id"); ok {
+		t.Error("Expected a query that doesn't parse to be reported")
+	}
+}
+
+func TestRenameFieldEverywhere(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"a.spq":      "from conn.json | count() by host",
+		"b/c.spq":    "from dns.json | where host == \"x\" | cut host, query",
+		"d.spq":      "from conn.json | values this[\"host\"]",
+		"none.spq":   "from conn.json | count()",
+		"broken.spq": "from |",
+	}
+	for name, text := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755)
+		os.WriteFile(filepath.Join(root, name), []byte(text), 0o644)
+	}
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+
+	run := func(args RenameFieldArgs) RenameFieldResult {
+		t.Helper()
+		raw, _ := json.Marshal(args)
+		response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+			Command:   CommandRenameField,
+			Arguments: []json.RawMessage{raw},
+		})
+		if err != nil || response.Error != nil {
+			t.Fatalf("renameFieldEverywhere failed: %v %v", err, response.Error)
+		}
+		data, _ := json.Marshal(response.Result)
+		var result RenameFieldResult
+		json.Unmarshal(data, &result)
+		return result
+	}
+
+	report := run(RenameFieldArgs{Field: "host", NewName: "hostname", DryRun: true})
+	if report.Uses != 4 || len(report.Files) != 3 || report.Edit != nil {
+		t.Errorf("Expected 4 uses in 3 files and no edit, got %+v", report)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0] != pathToURI(filepath.Join(root, "broken.spq")) {
+		t.Errorf("Expected the broken query skipped, got %v", report.Skipped)
+	}
+	if use := report.Files[0].Uses[0]; use.Line != "from conn.json | count() by host" {
+		t.Errorf("Expected the line of the use, got %+v", use)
+	}
+
+	result := run(RenameFieldArgs{Field: "host", NewName: "hostname", Source: "conn.json"})
+	if result.Edit == nil || len(result.Edit.Changes) != 2 {
+		t.Fatalf("Expected edits to the two queries of conn.json, got %+v", result.Edit)
+	}
+	edits := result.Edit.Changes[pathToURI(filepath.Join(root, "d.spq"))]
+	if len(edits) != 1 || edits[0].NewText != "hostname" || edits[0].Range.Start.Character != len("from conn.json | values this[\"") {
+		t.Errorf("Expected the subscript renamed inside its quotes, got %+v", edits)
+	}
+}
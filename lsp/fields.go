@@ -0,0 +1,442 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Field analysis: what is known about the fields of the values flowing
+// between the top-level stages of a query. Only stages whose output fields
+// follow from their text are followed, e.g. a values of a record literal,
+// put, cut, drop, rename, and summarize; the rest leave the fields
+// unknown. Spreads in a record literal contribute the fields of what they
+// spread, so {...this, extra: 1} has the fields of the stage's input plus
+// extra.
+
+// fieldSet is the known fields of a stage's values, in order. When open,
+// the values may have other fields too.
+type fieldSet struct {
+	names []string
+	open  bool
+}
+
+func (f *fieldSet) has(name string) bool {
+	for _, n := range f.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fieldSet) add(name string) {
+	if !f.has(name) {
+		f.names = append(f.names, name)
+	}
+}
+
+func (f *fieldSet) remove(name string) {
+	for i, n := range f.names {
+		if n == name {
+			f.names = append(f.names[:i:i], f.names[i+1:]...)
+			return
+		}
+	}
+}
+
+// topLevelStages returns the operators of a query's main pipeline,
+// inside any declarations
+func topLevelStages(tree interface{}) []interface{} {
+	stages, _ := tree.([]interface{})
+	if len(stages) == 1 && nodeKind(stages[0]) == "ScopeOp" {
+		scope, _ := stages[0].(map[string]interface{})
+		stages, _ = scope["body"].([]interface{})
+	}
+	return stages
+}
+
+// stageFields returns the fields of the input of each stage, followed by
+// the fields of the last stage's output. nil means nothing is known.
+func stageFields(stages []interface{}) []*fieldSet {
+	fields := make([]*fieldSet, 0, len(stages)+1)
+	var in *fieldSet
+	for _, stage := range stages {
+		fields = append(fields, in)
+		in = stageOutput(stage, in)
+	}
+	return append(fields, in)
+}
+
+// stageOutput returns the fields of what stage outputs given the fields
+// of its input
+func stageOutput(stage interface{}, in *fieldSet) *fieldSet {
+	op, _ := stage.(map[string]interface{})
+	args, _ := op["args"].([]interface{})
+	switch nodeKind(op) {
+	case "ValuesOp":
+		exprs, _ := op["exprs"].([]interface{})
+		if len(exprs) != 1 || nodeKind(exprs[0]) != "RecordExpr" {
+			return nil
+		}
+		record, _ := exprs[0].(map[string]interface{})
+		return recordFields(record, in)
+	case "PutOp":
+		if in == nil {
+			return nil
+		}
+		out := &fieldSet{names: append([]string(nil), in.names...), open: in.open}
+		for _, arg := range args {
+			arg, _ := arg.(map[string]interface{})
+			name, ok := topField(arg["lhs"])
+			if !ok {
+				return nil
+			}
+			out.add(name)
+		}
+		return out
+	case "CutOp":
+		out := &fieldSet{}
+		for _, arg := range args {
+			arg, _ := arg.(map[string]interface{})
+			target := arg["lhs"]
+			if target == nil {
+				target = arg["rhs"]
+			}
+			name, ok := topField(target)
+			if !ok {
+				return nil
+			}
+			out.add(name)
+		}
+		return out
+	case "DropOp":
+		if in == nil {
+			return nil
+		}
+		out := &fieldSet{names: append([]string(nil), in.names...), open: in.open}
+		for _, arg := range args {
+			if id, ok := idName(arg); ok {
+				out.remove(id)
+			}
+		}
+		return out
+	case "RenameOp":
+		if in == nil {
+			return nil
+		}
+		out := &fieldSet{names: append([]string(nil), in.names...), open: in.open}
+		for _, arg := range args {
+			arg, _ := arg.(map[string]interface{})
+			from, ok1 := idName(arg["rhs"])
+			to, ok2 := idName(arg["lhs"])
+			if !ok1 || !ok2 {
+				return nil
+			}
+			for i, n := range out.names {
+				if n == from {
+					out.names[i] = to
+				}
+			}
+		}
+		return out
+	case "AggregateOp":
+		// The keys, then the aggregates, each named by its assignment or
+		// else by what it groups by or the function it calls
+		out := &fieldSet{}
+		keys, _ := op["keys"].([]interface{})
+		aggs, _ := op["aggs"].([]interface{})
+		for _, assignment := range append(keys, aggs...) {
+			assignment, _ := assignment.(map[string]interface{})
+			target := assignment["lhs"]
+			if target == nil {
+				target = assignment["rhs"]
+			}
+			name, ok := topField(target)
+			if call, _ := target.(map[string]interface{}); !ok && nodeKind(call) == "AggFuncExpr" {
+				name, ok = call["name"].(string)
+			}
+			if !ok {
+				return nil
+			}
+			out.add(name)
+		}
+		return out
+	case "WhereOp", "SortOp", "HeadOp", "TailOp", "UniqOp", "PassOp":
+		return in
+	}
+	return nil
+}
+
+// recordFields returns the fields of a record literal evaluated against
+// values with fields this
+func recordFields(record map[string]interface{}, this *fieldSet) *fieldSet {
+	out := &fieldSet{}
+	elems, _ := record["elems"].([]interface{})
+	for _, elem := range elems {
+		elem, _ := elem.(map[string]interface{})
+		switch nodeKind(elem) {
+		case "FieldElem":
+			name, _ := elem["name"].(map[string]interface{})
+			value, _ := name["value"].(string)
+			out.add(value)
+		case "ExprElem":
+			if name, ok := idName(elem["expr"]); ok {
+				out.add(name)
+			} else {
+				out.open = true
+			}
+		case "SpreadElem":
+			spread := spreadFields(elem["expr"], this)
+			if spread == nil {
+				out.open = true
+				continue
+			}
+			for _, name := range spread.names {
+				out.add(name)
+			}
+			out.open = out.open || spread.open
+		}
+	}
+	return out
+}
+
+// spreadFields returns the fields that spreading expr contributes, or nil
+// when they aren't known
+func spreadFields(expr interface{}, this *fieldSet) *fieldSet {
+	node, _ := expr.(map[string]interface{})
+	switch nodeKind(node) {
+	case "IDExpr":
+		if name, _ := idName(node); name == "this" {
+			return this
+		}
+	case "RecordExpr":
+		return recordFields(node, this)
+	}
+	return nil
+}
+
+// idName returns the name of an IDExpr node
+func idName(expr interface{}) (string, bool) {
+	node, _ := expr.(map[string]interface{})
+	if nodeKind(node) != "IDExpr" {
+		return "", false
+	}
+	id, _ := node["id"].(map[string]interface{})
+	name, _ := id["name"].(string)
+	return name, name != ""
+}
+
+// topField returns the top-level field an lvalue like a or a.b assigns
+func topField(expr interface{}) (string, bool) {
+	node, _ := expr.(map[string]interface{})
+	if nodeKind(node) == "BinaryExpr" && node["op"] == "." {
+		return topField(node["lhs"])
+	}
+	return idName(node)
+}
+
+// spreadOverrideDiagnostics reports keys of a record literal that replace
+// a field a spread in the same literal contributes, or are replaced by
+// one: whichever comes later wins, which is easy to get backwards
+func (s *Server) spreadOverrideDiagnostics(text string) []Diagnostic {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	report := func(loc interface{}, code string, kv ...string) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    locRange(text, loc),
+			Severity: DiagnosticSeverityInformation,
+			Code:     code,
+			Source:   "superdb-lsp",
+			Message:  s.messages.format(code, kv...),
+		})
+	}
+	stages := topLevelStages(tree)
+	fields := stageFields(stages)
+	for i, stage := range stages {
+		walkTree(stage, func(node map[string]interface{}) {
+			if nodeKind(node) == "RecordExpr" {
+				checkSpreads(text, node, fields[i], report)
+			}
+		})
+	}
+	return diagnostics
+}
+
+// checkSpreads checks the keys of one record literal against its spreads
+func checkSpreads(text string, record map[string]interface{}, this *fieldSet, report func(loc interface{}, code string, kv ...string)) {
+	explicit := make(map[string]bool)
+	spreadBy := make(map[string]string) // field name to the spread that set it
+	elems, _ := record["elems"].([]interface{})
+	for _, elem := range elems {
+		elem, _ := elem.(map[string]interface{})
+		switch nodeKind(elem) {
+		case "FieldElem":
+			name, _ := elem["name"].(map[string]interface{})
+			value, _ := name["value"].(string)
+			if spread, ok := spreadBy[value]; ok {
+				report(name["loc"], "spread-override", "field", value, "spread", spread)
+				delete(spreadBy, value)
+			}
+			explicit[value] = true
+		case "SpreadElem":
+			spread := spreadFields(elem["expr"], this)
+			if spread == nil {
+				continue
+			}
+			source := nodeText(text, elem["loc"])
+			for _, name := range spread.names {
+				if explicit[name] {
+					report(elem["loc"], "spread-overridden", "field", name, "spread", source)
+					delete(explicit, name)
+				}
+				spreadBy[name] = source
+			}
+		}
+	}
+}
+
+// nodeText returns the text of text at an AST node's loc
+func nodeText(text string, loc interface{}) string {
+	m, ok := loc.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	first, _ := m["first"].(float64)
+	last, _ := m["last"].(float64)
+	if first < 0 || int(last) >= len(text) || first > last {
+		return ""
+	}
+	return text[int(first) : int(last)+1]
+}
+
+// recordKeyPattern matches a key at the start of an element of a record
+// literal
+var recordKeyPattern = regexp.MustCompile(`^\s*(?:([A-Za-z_$][A-Za-z0-9_$]*)|"((?:[^"\\]|\\.)*)")\s*:`)
+
+// recordKeyCompletions returns completions for offset when it is where a
+// key goes in a record literal that spreads this, offering the fields of
+// the stage's input that the literal doesn't already set. It reports false
+// when offset isn't at such a key or the input's fields aren't known.
+func recordKeyCompletions(text string, offset int) ([]CompletionItem, bool) {
+	before := text[:offset]
+	word := before[len(strings.TrimRightFunc(before, func(r rune) bool {
+		return r < 0x80 && isIdentifierChar(byte(r))
+	})):]
+	open := openBrace(before)
+	if open < 0 || (open > 0 && text[open-1] == '|') {
+		return nil, false
+	}
+	body := before[open+1 : offset-len(word)]
+	if rest := strings.TrimRight(body, " \t\r\n"); rest != "" && !strings.HasSuffix(rest, ",") {
+		return nil, false
+	}
+
+	elems := splitElems(text[open+1 : closingBrace(text, open+1)])
+	spreadsThis := false
+	keys := make(map[string]bool)
+	for _, elem := range elems {
+		if strings.TrimSpace(elem) == "...this" {
+			spreadsThis = true
+		}
+		if m := recordKeyPattern.FindStringSubmatch(elem); m != nil {
+			keys[m[1]+m[2]] = true
+		}
+	}
+	if !spreadsThis {
+		return nil, false
+	}
+
+	// The input of the stage holding the literal is the output of the
+	// pipeline before it
+	stages := splitStages(tokenize(before[:open]))
+	stage := stages[len(stages)-1]
+	tree, ok := parseTree(text[:stage.start])
+	if stage.start == 0 || !ok {
+		return nil, false
+	}
+	fields := stageFields(topLevelStages(tree))
+	in := fields[len(fields)-1]
+	if in == nil {
+		return nil, false
+	}
+	var items []CompletionItem
+	for _, name := range in.names {
+		if keys[name] || !strings.HasPrefix(strings.ToLower(name), strings.ToLower(word)) {
+			continue
+		}
+		items = append(items, CompletionItem{
+			Label:  name,
+			Kind:   CompletionItemKindField,
+			Detail: "field of this",
+		})
+	}
+	return items, true
+}
+
+// openBrace returns the offset of the innermost bracket left open in s if
+// it is a brace, or -1
+func openBrace(s string) int {
+	var open []int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'', '`':
+			i = skipQuoted(s, i)
+		case '(', '[', '{':
+			open = append(open, i)
+		case ')', ']', '}':
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	if len(open) == 0 || s[open[len(open)-1]] != '{' {
+		return -1
+	}
+	return open[len(open)-1]
+}
+
+// closingBrace returns the offset of the brace that closes one opened just
+// before start, or len(text) if it isn't closed
+func closingBrace(text string, start int) int {
+	depth := 1
+	for i := start; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'', '`':
+			i = skipQuoted(text, i)
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(text)
+}
+
+// splitElems splits the inside of a record literal at its top-level commas
+func splitElems(s string) []string {
+	var elems []string
+	start := 0
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'', '`':
+			i = skipQuoted(s, i)
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				elems = append(elems, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elems, s[start:])
+}
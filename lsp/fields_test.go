@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestStageFields(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string // fields of the last stage's output; "?" when unknown, "+" when open
+	}{
+		{"values {a:1,b:2}", "a,b"},
+		{"values {a:1,b:2} | values {...this, c:3}", "a,b,c"},
+		{"values {a:1,b:2} | values {...this, a:4}", "a,b"},
+		{"values {a:1} | values {...{b:1, c:2}, d:3}", "b,c,d"},
+		{"values {a:1} | values {...x, b:1}", "b+"},
+		{"values {a:1,b:2} | put c:=1, a.x:=2", "a,b,c"},
+		{"values {a:1,b:2,c:3} | cut c, a", "c,a"},
+		{"values {a:1,b:2,c:3} | drop b", "a,c"},
+		{"values {a:1,b:2} | rename z:=a", "z,b"},
+		{"values {a:1,b:2} | where a > 1 | sort b", "a,b"},
+		{"values {a:1} | count()", "?"},
+		{"values {a:1,b:2} | summarize n:=count(), sum(a) by b, c:=lower(b)", "b,c,n,sum"},
+		{"values {a:1,b:2} | summarize count() by id.host", "id,count"},
+		{"values {a:1,b:2} | summarize count() by lower(b)", "?"},
+		{"put c:=1", "?"},
+		{"const n = 1 values {a:n} | values {...this, b:n}", "a,b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			tree, ok := parseTree(tt.query)
+			if !ok {
+				t.Fatalf("query doesn't parse")
+			}
+			fields := stageFields(topLevelStages(tree))
+			got := "?"
+			if out := fields[len(fields)-1]; out != nil {
+				got = strings.Join(out.names, ",")
+				if out.open {
+					got += "+"
+				}
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSpreadOverrideDiagnostics(t *testing.T) {
+	s := NewServer()
+	text := "values {a:1,b:2} | values {...this, a:3} | values {b:0, ...this}"
+	diags := s.spreadOverrideDiagnostics(text)
+	if len(diags) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %d: %+v", len(diags), diags)
+	}
+	override := diags[0]
+	if override.Severity != DiagnosticSeverityInformation || override.Code != "spread-override" {
+		t.Errorf("Unexpected diagnostic %+v", override)
+	}
+	if want := "a replaces the field of the same name from ...this"; override.Message != want {
+		t.Errorf("Expected message %q, got %q", want, override.Message)
+	}
+	if start := strings.Index(text, "a:3"); override.Range.Start.Character != start {
+		t.Errorf("Expected override at %d, got %+v", start, override.Range)
+	}
+	overridden := diags[1]
+	if overridden.Code != "spread-overridden" || !strings.Contains(overridden.Message, "b set earlier") {
+		t.Errorf("Unexpected diagnostic %+v", overridden)
+	}
+
+	for _, clean := range []string{
+		"values {a:1} | values {...this, b:2}",
+		"values {...this, a:1}",
+		"values {a:1, a:2}",
+	} {
+		if diags := s.spreadOverrideDiagnostics(clean); len(diags) != 0 {
+			t.Errorf("%s: expected no diagnostics, got %+v", clean, diags)
+		}
+	}
+}
+
+func TestRecordKeyCompletions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string // ^ marks the cursor
+		want []string
+	}{
+		{"after spread", "values {a:1,b:2,c:3} | values {...this, ^", []string{"a", "b", "c"}},
+		{"skips set keys", "values {a:1,b:2,c:3} | values {...this, b:0, ^}", []string{"a", "c"}},
+		{"keys after cursor", `values {a:1,b:2,c:3} | values {...this, ^, "c":0}`, []string{"a", "b"}},
+		{"prefix", "values {apple:1,b:2,avocado:3} | values {...this, a^", []string{"apple", "avocado"}},
+		{"through put", "values {a:1} | put b:=2 | values {...this, ^", []string{"a", "b"}},
+		{"inside put", "values {a:1,b:2} | put r:={...this, ^}", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.Index(tt.text, "^")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items := getCompletions(context.Background(), text, positionAt(text, offset))
+			if got := completionLabels(items); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Outside a key, or without a spread of this, completion is as usual
+	for _, text := range []string{
+		"values {a:1} | values {...this, b:^",
+		"values {a:1} | values {b:1, ^",
+		"values {...this, ^",
+	} {
+		offset := strings.Index(text, "^")
+		text = text[:offset] + text[offset+1:]
+		if _, ok := recordKeyCompletions(text, offset); ok {
+			t.Errorf("%q: expected no field completions", text)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/brimdata/super/sio"
+)
+
+// File path completion. In the string a from reads, or after from file,
+// completion offers the directories and data files under what is typed,
+// relative to the query's directory and its workspace folder, where
+// missing-source looks for them too (see sourceDirs). Only files super
+// reads by their extension are offered, so the queries and scripts
+// beside the data don't bury it. After the format argument of a source,
+// completion offers the formats super reads.
+
+// maxPathItems caps the entries offered from a directory
+const maxPathItems = 500
+
+// filePathPattern matches a line that ends in a path being typed after
+// from, capturing the file keyword, the opening quote, and the path
+var filePathPattern = regexp.MustCompile(`(?i)\bfrom\s+(file\s+)?(["']?)([^"'\s]*)$`)
+
+// formatArgPattern matches a line that ends in the value of a source's
+// format argument, capturing the name typed
+var formatArgPattern = regexp.MustCompile(`(?i)\bfrom\s+[^|()]+\((?:[^()]*\s)?format\s+(\w*)$`)
+
+// dataExtensions are the extensions of data super reads that
+// sio.FormatFromPath doesn't tell the format of
+var dataExtensions = map[string]string{".arrows": "arrows", ".log": "zeek", ".tsv": "tsv"}
+
+// dataFormat returns the format super reads the file name as, or "" if it
+// doesn't tell from the name
+func dataFormat(name string) string {
+	if format := sio.FormatFromPath(name); format != "" {
+		return format
+	}
+	return dataExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// fileCompletions returns the directories and data files matching the
+// path typed at pos, and reports whether pos is in a path after from: in
+// quotes, after file, or holding a slash, which no pool name does.
+// Directories end in a slash, so accepting one and going on typing
+// completes inside it.
+func (s *Server) fileCompletions(uri, text string, pos Position) ([]CompletionItem, bool) {
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) {
+		return nil, false
+	}
+	m := filePathPattern.FindStringSubmatch(line[:pos.Character])
+	if m == nil || m[1] == "" && m[2] == "" && !strings.Contains(m[3], "/") {
+		return nil, false
+	}
+	file, err := uriToPath(uri)
+	if err != nil {
+		return nil, false
+	}
+	dir, name := path.Split(m[3])
+	dirs := s.sourceDirs(file)
+	if filepath.IsAbs(filepath.FromSlash(dir)) {
+		dirs = []string{""}
+	}
+	// Replace only the name typed, since clients take a dot or slash to
+	// end the word
+	edit := s.rangeToClient(text, Range{
+		Start: Position{Line: pos.Line, Character: pos.Character - len(name)},
+		End:   pos,
+	})
+
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	for _, base := range dirs {
+		entries, err := os.ReadDir(filepath.Join(base, filepath.FromSlash(dir)))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			label := entry.Name()
+			if seen[label] || !hasPrefixFold(label, name) ||
+				strings.HasPrefix(label, ".") && !strings.HasPrefix(name, ".") {
+				continue
+			}
+			item := CompletionItem{Kind: CompletionItemKindFolder, Detail: "directory"}
+			if entry.IsDir() {
+				label += "/"
+			} else {
+				format := dataFormat(label)
+				if format == "" {
+					continue
+				}
+				item.Kind, item.Detail = CompletionItemKindFile, format+" file"
+				if info, err := entry.Info(); err == nil {
+					item.Detail += ", " + formatSize(info.Size())
+				}
+			}
+			seen[entry.Name()] = true
+			item.Label = label
+			item.TextEdit = &TextEdit{Range: edit, NewText: label}
+			items = append(items, item)
+			if len(items) == maxPathItems {
+				return items, true
+			}
+		}
+	}
+	return items, true
+}
+
+// formatArgCompletions returns the formats matching the name typed at
+// col, and reports whether col is in the value of a source's format
+// argument
+func formatArgCompletions(line string, col int) ([]CompletionItem, bool) {
+	if col > len(line) || !containsFold(line[:col], "format") {
+		return nil, false
+	}
+	m := formatArgPattern.FindStringSubmatch(line[:col])
+	if m == nil {
+		return nil, false
+	}
+	return formatCompletions(m[1]), true
+}
+
+// formatCompletions returns the formats whose names start with word
+func formatCompletions(word string) []CompletionItem {
+	items := []CompletionItem{}
+	for _, f := range Builtins.Formats() {
+		if hasPrefixFold(f.Name, word) {
+			items = append(items, f.item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFileCompletions(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "logs"), 0o755)
+	os.WriteFile(filepath.Join(dir, "data.sup"), []byte(`{a:1}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "dates.csv"), []byte("a\n1\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.md"), []byte("notes"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".hidden.json"), []byte(`{}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "logs", "conn.json"), []byte(`{}`), 0o644)
+
+	tests := []struct {
+		name string
+		text string // | marks the cursor
+		want []string
+		ok   bool
+	}{
+		{"quoted", `from "|`, []string{"data.sup", "dates.csv", "logs/"}, true},
+		{"prefix", `from 'da|`, []string{"data.sup", "dates.csv"}, true},
+		{"directory", `from "logs/c|"`, []string{"conn.json"}, true},
+		{"file keyword", `from file d|`, []string{"data.sup", "dates.csv"}, true},
+		{"slash", `from ./logs/|`, []string{"conn.json"}, true},
+		{"hidden", `from ".|`, []string{".hidden.json"}, true},
+		{"sql", `SELECT * FROM "d|`, []string{"data.sup", "dates.csv"}, true},
+		{"absolute", `from "` + filepath.ToSlash(dir) + `/logs/|`, []string{"conn.json"}, true},
+		{"pool", `from da|`, nil, false},
+		{"after source", `from "data.sup" | where |`, nil, false},
+	}
+	s := NewServer()
+	uri := pathToURI(filepath.Join(dir, "q.spq"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.LastIndex(tt.text, "|")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items, ok := s.fileCompletions(uri, text, positionAt(text, offset))
+			if got := completionLabels(items); !slices.Equal(got, tt.want) || ok != tt.ok {
+				t.Errorf("Expected %q (%v), got %q (%v)", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestFileCompletionEdit(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "data.sup"), []byte(`{a:1}`), 0o644)
+
+	s := NewServer()
+	text := `from "./da`
+	items, _ := s.fileCompletions(pathToURI(filepath.Join(dir, "q.spq")), text, Position{Line: 0, Character: len(text)})
+	if len(items) != 1 {
+		t.Fatalf("Expected data.sup, got %+v", items)
+	}
+	item := items[0]
+	want := Range{Start: Position{Line: 0, Character: 8}, End: Position{Line: 0, Character: 10}}
+	if item.TextEdit == nil || item.TextEdit.Range != want || item.TextEdit.NewText != "data.sup" {
+		t.Errorf("Expected an edit of %v to data.sup, got %+v", want, item.TextEdit)
+	}
+	if item.Kind != CompletionItemKindFile || item.Detail != "sup file, 5 B" {
+		t.Errorf("Expected a sup file of 5 B, got kind %d %q", item.Kind, item.Detail)
+	}
+}
+
+func TestFormatArgCompletions(t *testing.T) {
+	tests := []struct {
+		text string // | marks the cursor
+		want []string
+	}{
+		{"from data.txt (format |", []string{"arrows", "bsup", "csup", "csv", "json", "jsup", "line", "parquet", "sup", "tsv", "zeek"}},
+		{"from 'data.txt' (format cs|)", []string{"csup", "csv"}},
+		{"from data.txt (format PA|", []string{"parquet"}},
+		{"from data.txt (format csv) | where format == |", nil},
+		{"values format |", nil},
+	}
+	for _, tt := range tests {
+		offset := strings.LastIndex(tt.text, "|")
+		text := tt.text[:offset] + tt.text[offset+1:]
+		items, ok := formatArgCompletions(text, offset)
+		if got := completionLabels(items); !slices.Equal(got, tt.want) || ok != (tt.want != nil) {
+			t.Errorf("%q: expected %q, got %q (%v)", tt.text, tt.want, got, ok)
+		}
+	}
+
+	text := "from data.txt (format s"
+	items := getCompletions(context.Background(), text, positionAt(text, len(text)))
+	if len(items) != 1 || items[0].Label != "sup" || items[0].Kind != CompletionItemKindEnumMember {
+		t.Errorf("Expected the sup format, got %+v", items)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Renaming files. When the editor is about to rename a data file, or a
+// directory holding some, it asks with workspace/willRenameFiles for edits
+// to apply along with the rename. Every open query that reads a renamed
+// file gets its from updated: a relative path stays relative to where it
+// was found, beside the query or at the root of its folder, an absolute
+// path stays absolute, and a file URL stays a URL. Queries that read a
+// lake are left alone, since their sources are pools.
+
+// bareSourcePattern matches a source path that needs no quotes
+var bareSourcePattern = regexp.MustCompile(`^[A-Za-z0-9_./:]+$`)
+
+// renamedPath returns where p goes when from is renamed to to, whether p
+// is from itself or under it, and false if p isn't affected
+func renamedPath(p, from, to string) (string, bool) {
+	if p == from {
+		return to, true
+	}
+	if rel, ok := relativeTo(from, p); ok {
+		return filepath.Join(to, filepath.FromSlash(rel)), true
+	}
+	return "", false
+}
+
+// renamedSource returns the name that the source name, read by the query
+// at file, takes when from is renamed to to
+func (s *Server) renamedSource(file, name, from, to string) (string, bool) {
+	if u, err := url.Parse(name); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return "", false
+		}
+		p, ok := renamedPath(filepath.FromSlash(u.Path), from, to)
+		if !ok {
+			return "", false
+		}
+		return pathToURI(p), true
+	}
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		p, ok := renamedPath(filepath.Clean(name), from, to)
+		return filepath.ToSlash(p), ok
+	}
+	// A relative path is read from the first place it exists
+	dirs := []string{filepath.Dir(file)}
+	if folder := s.folderOf(file); folder != "" {
+		dirs = append(dirs, folder)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		p, ok := renamedPath(filepath.Join(dir, name), from, to)
+		if !ok {
+			return "", false
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+	return "", false
+}
+
+// sourceRenameEdits returns the edits to the query at uri for the
+// renames, each from a path to the one it is renamed to
+func (s *Server) sourceRenameEdits(uri, text string, renames [][2]string) []TextEdit {
+	file, err := uriToPath(uri)
+	if err != nil {
+		return nil
+	}
+	names, ok := sourceNames(text)
+	if !ok {
+		return nil
+	}
+	kind, configured := s.configuredSourceKind(uri)
+	if !configured {
+		kind = detectSourceKind(names)
+	}
+	if kind == sourceLake {
+		return nil
+	}
+	var edits []TextEdit
+	for _, src := range names {
+		for _, rename := range renames {
+			name, ok := s.renamedSource(file, src.name, rename[0], rename[1])
+			if !ok {
+				continue
+			}
+			rng := src.rng
+			if start, ok := offsetAt(text, rng.Start); ok && start > 0 && text[start-1] != '"' && text[start-1] != '\'' &&
+				!bareSourcePattern.MatchString(name) {
+				name = strconv.Quote(name)
+			}
+			edits = append(edits, TextEdit{Range: rng, NewText: name})
+			break
+		}
+	}
+	return edits
+}
+
+// handleWillRenameFiles processes workspace/willRenameFiles requests
+func (s *Server) handleWillRenameFiles(msg RPCMessage) HandlerResult {
+	var params RenameFilesParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	var renames [][2]string
+	for _, f := range params.Files {
+		from, err := uriToPath(f.OldURI)
+		if err != nil {
+			continue
+		}
+		to, err := uriToPath(f.NewURI)
+		if err != nil {
+			continue
+		}
+		renames = append(renames, [2]string{filepath.Clean(from), filepath.Clean(to)})
+	}
+	if len(renames) == 0 {
+		return success(nil)
+	}
+
+	s.docMu.RLock()
+	uris := make([]string, 0, len(s.documents))
+	for uri := range s.documents {
+		if !s.isDataFile(uri) {
+			uris = append(uris, uri)
+		}
+	}
+	s.docMu.RUnlock()
+
+	changes := make(map[string][]TextEdit)
+	var readOnly []string
+	for _, uri := range uris {
+		text, _, ok := s.document(uri)
+		if !ok {
+			continue
+		}
+		edits := s.sourceRenameEdits(uri, text, renames)
+		if len(edits) == 0 {
+			continue
+		}
+		if s.isReadOnly(uri) {
+			readOnly = append(readOnly, uri)
+			continue
+		}
+		changes[uri] = edits
+	}
+	if len(readOnly) > 0 {
+		s.warnReadOnly(readOnly)
+	}
+	if len(changes) == 0 {
+		return success(nil)
+	}
+	log.Printf("Renaming files updates the sources of %d queries", len(changes))
+	return success(WorkspaceEdit{Changes: changes})
+}
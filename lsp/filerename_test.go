@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWillRenameFiles(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "queries"), 0o755)
+	os.MkdirAll(filepath.Join(root, "data"), 0o755)
+	os.WriteFile(filepath.Join(root, "queries", "conn.sup"), []byte("{}"), 0o644)
+	os.WriteFile(filepath.Join(root, "data", "dns.sup"), []byte("{}"), 0o644)
+
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatal(err)
+	}
+	query := pathToURI(filepath.Join(root, "queries", "q.spq"))
+	text := "from conn.sup | join (from data/dns.sup) on left.h=right.h"
+	h.openDocument(t, query, text)
+	h.openDocument(t, pathToURI(filepath.Join(root, "queries", "other.spq")), "from dns.sup | count()")
+
+	rename := func(from, to string) WorkspaceEdit {
+		t.Helper()
+		response, err := h.ProcessRequest(2, "workspace/willRenameFiles", RenameFilesParams{
+			Files: []FileRename{{OldURI: pathToURI(filepath.Join(root, from)), NewURI: pathToURI(filepath.Join(root, to))}},
+		})
+		if err != nil || response.Error != nil {
+			t.Fatalf("willRenameFiles failed: %v %+v", err, response)
+		}
+		var edit WorkspaceEdit
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &edit)
+		return edit
+	}
+
+	edit := rename("queries/conn.sup", "queries/old conn.sup")
+	want := []TextEdit{{Range: rangeOfText(t, text, "conn.sup"), NewText: `"old conn.sup"`}}
+	if len(edit.Changes) != 1 || len(edit.Changes[query]) != 1 || edit.Changes[query][0] != want[0] {
+		t.Errorf("Expected %+v, got %+v", want, edit.Changes)
+	}
+
+	// A directory's files move with it, found at the root of the folder
+	edit = rename("data", "archive/data")
+	want = []TextEdit{{Range: rangeOfText(t, text, "data/dns.sup"), NewText: "archive/data/dns.sup"}}
+	if len(edit.Changes[query]) != 1 || edit.Changes[query][0] != want[0] {
+		t.Errorf("Expected %+v, got %+v", want, edit.Changes)
+	}
+
+	if edit := rename("queries/other.sup", "queries/new.sup"); len(edit.Changes) != 0 {
+		t.Errorf("Expected no edits for a file nothing reads, got %+v", edit.Changes)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"sort"
 	"strings"
 	"unicode"
 )
 
-// formatDocument formats a SuperSQL document
+// formatDocument formats a SuperSQL document in the standard style
 func formatDocument(text string, options FormattingOptions) string {
+	return formatStyled(text, options, FormatterStyleStandard)
+}
+
+// formatStyled formats a SuperSQL document in style
+func formatStyled(text string, options FormattingOptions, style string) string {
 	// Tokenize and format
 	tokens := tokenize(text)
-	return formatTokens(tokens, options)
+	return alignMapEntries(formatTokens(tokens, options, style))
 }
 
 // Token types for formatting
@@ -34,9 +40,14 @@ type token struct {
 	value string
 }
 
+// literalOpeners maps the bracket that closes a set or map literal to the
+// token that opened it
+var literalOpeners = map[byte]string{']': "|[", '}': "|{"}
+
 // tokenize breaks the input into tokens
 func tokenize(text string) []token {
 	var tokens []token
+	var open []string // brackets not yet closed, innermost last
 	i := 0
 
 	for i < len(text) {
@@ -127,6 +138,14 @@ func tokenize(text string) []token {
 			i = start
 		}
 
+		// Set and map literals open with |[ and |{
+		if ch == '|' && i+1 < len(text) && (text[i+1] == '[' || text[i+1] == '{') {
+			tokens = append(tokens, token{tokPunctuation, text[i : i+2]})
+			open = append(open, text[i:i+2])
+			i += 2
+			continue
+		}
+
 		// Pipe operators
 		if ch == '|' {
 			if i+1 < len(text) && text[i+1] == '>' {
@@ -170,8 +189,21 @@ func tokenize(text string) []token {
 		}
 
 		if strings.ContainsRune("()[]{},:;.?", rune(ch)) {
-			tokens = append(tokens, token{tokPunctuation, string(ch)})
-			i++
+			value := string(ch)
+			switch ch {
+			case '(', '[', '{':
+				open = append(open, value)
+			case ')', ']', '}':
+				// A set or map literal closes with ]| or }|
+				if n := len(open); n > 0 {
+					if open[n-1] == literalOpeners[ch] && i+1 < len(text) && text[i+1] == '|' {
+						value += "|"
+					}
+					open = open[:n-1]
+				}
+			}
+			tokens = append(tokens, token{tokPunctuation, value})
+			i += len(value)
 			continue
 		}
 
@@ -242,7 +274,7 @@ func tokenize(text string) []token {
 }
 
 // formatTokens formats tokens into a string
-func formatTokens(tokens []token, options FormattingOptions) string {
+func formatTokens(tokens []token, options FormattingOptions, style string) string {
 	var result strings.Builder
 	indent := 0
 	indentStr := "\t"
@@ -274,17 +306,22 @@ func formatTokens(tokens []token, options FormattingOptions) string {
 			lineStart = false
 
 		case tokPipe:
-			// Put pipe on new line with proper indentation
-			if !lineStart {
-				result.WriteString("\n")
+			// Put pipe on new line with proper indentation, or in the
+			// compact style leave it where it was written
+			if !lineStart && style == FormatterStyleCompact {
+				result.WriteString(" ")
+			} else {
+				if !lineStart {
+					result.WriteString("\n")
+				}
+				result.WriteString(strings.Repeat(indentStr, indent))
 			}
-			result.WriteString(strings.Repeat(indentStr, indent))
 			result.WriteString(tok.value)
 			result.WriteString(" ")
 			lineStart = false
 
 		case tokPunctuation:
-			if lineStart && tok.value != ")" && tok.value != "]" && tok.value != "}" {
+			if lineStart && tok.value != ")" && tok.value != "]" && tok.value != "}" && tok.value != "}|" && tok.value != "]|" {
 				result.WriteString(strings.Repeat(indentStr, indent))
 			}
 
@@ -299,10 +336,10 @@ func formatTokens(tokens []token, options FormattingOptions) string {
 					indent = 0
 				}
 				result.WriteString(tok.value)
-			case "{":
+			case "{", "|{", "|[":
 				result.WriteString(tok.value)
 				indent++
-			case "}":
+			case "}", "}|", "]|":
 				indent--
 				if indent < 0 {
 					indent = 0
@@ -350,7 +387,7 @@ func formatTokens(tokens []token, options FormattingOptions) string {
 			default:
 				// Add space before if not at line start and prev wasn't space-producing
 				if !lineStart && prevTok.typ != tokWhitespace && prevTok.typ != tokNewline &&
-					prevTok.value != "(" && prevTok.value != "[" {
+					prevTok.value != "(" && prevTok.value != "[" && prevTok.value != "|[" && prevTok.value != "|{" {
 					result.WriteString(" ")
 				}
 				result.WriteString(tok.value)
@@ -407,12 +444,101 @@ func formatTokens(tokens []token, options FormattingOptions) string {
 	return formatted
 }
 
+// alignMapEntries lines up the values of a map literal written one entry
+// per line, padding after each key's colon so the values start in the
+// same column
+func alignMapEntries(text string) string {
+	type entry struct {
+		colon, value int // offsets of the colon and of the value after it
+		column       int // column of the colon
+		width        int // column of the furthest colon in the map
+	}
+	type frame struct {
+		isMap   bool
+		entries []entry
+	}
+	var (
+		stack       []*frame
+		aligned     []entry
+		offset      int
+		lineStart   int
+		firstOnLine = true
+		key, colon  = -1, -1
+	)
+	for _, tok := range tokenize(text) {
+		start := offset
+		offset += len(tok.value)
+		switch tok.typ {
+		case tokWhitespace, tokComment:
+			continue
+		case tokNewline:
+			lineStart, firstOnLine = offset, true
+			key, colon = -1, -1
+			continue
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		switch {
+		case colon >= 0:
+			top.entries = append(top.entries, entry{colon: colon, value: start, column: colon - lineStart})
+			key, colon = -1, -1
+		case key >= 0 && tok.value == ":":
+			colon = start
+		case firstOnLine && top != nil && top.isMap &&
+			(tok.typ == tokString || tok.typ == tokIdentifier || tok.typ == tokNumber):
+			key = start
+		default:
+			key = -1
+		}
+		firstOnLine = false
+
+		switch tok.value {
+		case "(", "[", "{", "|[", "|{":
+			stack = append(stack, &frame{isMap: tok.value == "|{"})
+		case ")", "]", "}", "]|", "}|":
+			if top == nil {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if len(top.entries) < 2 {
+				continue
+			}
+			width := 0
+			for _, e := range top.entries {
+				width = max(width, e.column)
+			}
+			for _, e := range top.entries {
+				e.width = width
+				aligned = append(aligned, e)
+			}
+		}
+	}
+	if len(aligned) == 0 {
+		return text
+	}
+
+	// Inner maps close first, so put the entries back in document order
+	sort.Slice(aligned, func(i, j int) bool { return aligned[i].colon < aligned[j].colon })
+	var b strings.Builder
+	last := 0
+	for _, e := range aligned {
+		b.WriteString(text[last : e.colon+1])
+		b.WriteString(strings.Repeat(" ", e.width-e.column+1))
+		last = e.value
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
 func needsSpaceBefore(prev token) bool {
 	switch prev.typ {
 	case tokWhitespace, tokNewline, tokPipe:
 		return false
 	case tokPunctuation:
-		return prev.value != "(" && prev.value != "[" && prev.value != "." && prev.value != ":"
+		return prev.value != "(" && prev.value != "[" && prev.value != "|[" && prev.value != "|{" &&
+			prev.value != "." && prev.value != ":"
 	case tokOperator:
 		return prev.value != "." && prev.value != "::" && prev.value != "->"
 	default:
@@ -425,7 +551,7 @@ func canPrecedeRegex(tok token) bool {
 	case tokWhitespace, tokNewline, tokPipe, tokOperator:
 		return true
 	case tokPunctuation:
-		return tok.value == "(" || tok.value == "[" || tok.value == "," || tok.value == ":"
+		return tok.value == "(" || tok.value == "[" || tok.value == "|[" || tok.value == "," || tok.value == ":"
 	case tokKeyword:
 		return true
 	default:
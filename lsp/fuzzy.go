@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fuzzy completion matching. A name the word typed doesn't start matches
+// when it holds the word, like dcount for count or regexp_replace for
+// replace, or for a longer word when it holds the word's letters in
+// order, like regexp_replace for rxrep. Such items rank after every item
+// that starts with the word, best match first, and carry a filterText
+// that starts where the word matched, so clients that filter by prefix
+// keep them as the word grows.
+
+// Words shorter than these match too much of the registry to be worth it
+const (
+	minSubstringWord   = 2
+	minSubsequenceWord = 3
+)
+
+// wordMatch is how a name matches the word typed
+type wordMatch struct {
+	fuzzy bool // the name doesn't start with the word
+	score int  // of a fuzzy match, lower is better
+	at    int  // where the word starts in the name, or -1 for letters in order
+}
+
+// matchWord reports whether lowerName, a lowercase name, matches the
+// lowercase word, and how. Of fuzzy matches a substring at the start of a
+// part of the name, after an underscore, is best; anywhere else is next;
+// letters in order score by how spread out they are.
+func matchWord(lowerName, word string) (wordMatch, bool) {
+	if strings.HasPrefix(lowerName, word) {
+		return wordMatch{}, true
+	}
+	if len(word) < minSubstringWord {
+		return wordMatch{}, false
+	}
+	if at := strings.Index(lowerName, word); at > 0 {
+		score := 1
+		if lowerName[at-1] == '_' {
+			score = 0
+		}
+		return wordMatch{fuzzy: true, score: score, at: at}, true
+	}
+	// Letters in order, the first at the start of the name, scored by the
+	// letters skipped between them
+	if len(word) < minSubsequenceWord || lowerName == "" || lowerName[0] != word[0] {
+		return wordMatch{}, false
+	}
+	gaps, j := 0, 1
+	for i := 1; i < len(lowerName) && j < len(word); i++ {
+		if lowerName[i] == word[j] {
+			j++
+		} else {
+			gaps++
+		}
+	}
+	if j < len(word) {
+		return wordMatch{}, false
+	}
+	return wordMatch{fuzzy: true, score: min(2+gaps, 99), at: -1}, true
+}
+
+// apply gives item the sortText and filterText of a fuzzy match
+func (m wordMatch) apply(item *CompletionItem) {
+	if !m.fuzzy {
+		return
+	}
+	item.SortText = fmt.Sprintf("%c%02d%s", '0'+rankFuzzy, m.score, item.Label)
+	if m.at > 0 {
+		item.FilterText = item.Label[m.at:] + " " + item.Label
+	}
+}
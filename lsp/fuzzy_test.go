@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchWord(t *testing.T) {
+	for _, tt := range []struct {
+		name, word string
+		ok, fuzzy  bool
+		at         int
+	}{
+		{"count", "co", true, false, 0},
+		{"dcount", "count", true, true, 1},
+		{"regexp_replace", "replace", true, true, 7},
+		{"regexp_replace", "rxrep", true, true, -1},
+		{"regexp_replace", "xr", false, false, 0}, // too short for letters in order
+		{"sum", "m", false, false, 0},             // too short to be a substring
+		{"sort", "tros", false, false, 0},
+	} {
+		m, ok := matchWord(tt.name, tt.word)
+		if ok != tt.ok || m.fuzzy != tt.fuzzy || (ok && m.at != tt.at) {
+			t.Errorf("matchWord(%q, %q) = %+v, %v; want fuzzy=%v at=%d, %v", tt.name, tt.word, m, ok, tt.fuzzy, tt.at, tt.ok)
+		}
+	}
+
+	// A part of the name beats the middle of one, which beats letters in
+	// order
+	part, _ := matchWord("regexp_replace", "replace")
+	middle, _ := matchWord("dcount", "count")
+	order, _ := matchWord("regexp_replace", "rxrep")
+	if !(part.score < middle.score && middle.score < order.score) {
+		t.Errorf("Expected scores to order part, middle, letters; got %d, %d, %d", part.score, middle.score, order.score)
+	}
+}
+
+func TestFuzzyCompletions(t *testing.T) {
+	text := "from x | summarize count"
+	items := ranked(t, text, len(text))
+	if items[0].Label != "count" {
+		t.Errorf("Expected the exact match first, got %+v", items[0])
+	}
+	var dcount *CompletionItem
+	for i := range items {
+		if items[i].Label == "dcount" {
+			dcount = &items[i]
+		}
+	}
+	if dcount == nil {
+		t.Fatalf("Expected dcount for count")
+	}
+	if dcount.FilterText != "count dcount" || dcount.SortText <= items[0].SortText {
+		t.Errorf("Expected dcount to filter on count and rank after count, got %+v", dcount)
+	}
+
+	text = "values regexp_replace(s, \"a\", \"b\") | put y := replace"
+	items = ranked(t, text, len(text))
+	if items[0].Label != "replace" {
+		t.Errorf("Expected the exact match first, got %+v", items[0])
+	}
+	found := false
+	for _, item := range items {
+		found = found || item.Label == "regexp_replace"
+	}
+	if !found {
+		t.Errorf("Expected regexp_replace for replace")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Group-by key completion. The keys after by in summarize, aggregate, an
+// aggregation with its operator left out, or SQL's group by are fields of
+// the stage's input, or expressions over them, so completion there offers
+// the input's fields (see inputFieldCompletions) and the functions
+// rather than every builtin.
+
+// byKeysPattern matches the text of a stage that ends in a by clause
+// where a key goes: right after by or after a comma in its list. The
+// list holds no parentheses left open, since the cursor is then in a
+// call's arguments.
+var byKeysPattern = regexp.MustCompile(`(?is)^(\w+).*?\b(group\s+)?by\s+(?:[^()]*(?:\([^()]*\))?[^(),]*,\s*)*$`)
+
+// inByKeys reports whether stage, the text of a stage up to the word
+// typed, ends where a key of an aggregation's by clause goes
+func inByKeys(stage string) bool {
+	m := byKeysPattern.FindStringSubmatch(stage)
+	if m == nil {
+		return false
+	}
+	if m[2] != "" {
+		return true
+	}
+	switch op := strings.ToLower(m[1]); {
+	case op == "summarize" || op == "aggregate":
+		return true
+	default:
+		// count() by, with summarize left out
+		b := Builtins.Lookup(op)
+		return b != nil && b.Kind == KindAggregate
+	}
+}
+
+// byKeyCompletions returns the fields of the input and the functions
+// matching what is typed at pos, and reports whether pos is where a key
+// of a by clause goes. After a dot it leaves the fields under the path
+// to dataFieldCompletions.
+func (s *Server) byKeyCompletions(ctx context.Context, uri, text string, pos Position) ([]CompletionItem, bool) {
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return nil, false
+	}
+	stage, start, prefix, ok := stageAt(text, offset)
+	if !ok || !inByKeys(stage) {
+		return nil, false
+	}
+
+	rc := rankContextAt(text, offset, prefix)
+	items := s.inputFieldCompletions(uri, text, pos, start, rc)
+	items = append(items, declarationCompletions(declarationsAt(text, pos), text, pos, rc, []BuiltinKind{KindFunction})...)
+	if ctx.Err() != nil {
+		return nil, true
+	}
+	return appendCompletionsByKind(items, KindFunction, rc), true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInByKeys(t *testing.T) {
+	tests := []struct {
+		stage string
+		want  bool
+	}{
+		{"summarize count() by ", true},
+		{"summarize count() by a, ", true},
+		{"summarize count() by bucket(ts, 1h), ", true},
+		{"aggregate sum(x) by ", true},
+		{"count() by ", true},
+		{"select a, count() from t group by ", true},
+		{"SELECT a FROM t GROUP BY a, ", true},
+		{"summarize count() by a ", false},
+		{"summarize count() by bucket(", false},
+		{"summarize count() ", false},
+		{"upper(x) by ", false},
+		{"sort by ", false},
+	}
+	for _, tt := range tests {
+		if got := inByKeys(tt.stage); got != tt.want {
+			t.Errorf("inByKeys(%q) = %v, want %v", tt.stage, got, tt.want)
+		}
+	}
+}
+
+func TestByKeyCompletions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "conn.sup"), []byte(`{host:"a",port:80,id:{orig_h:10.0.0.1}}`), 0o644)
+
+	tests := []struct {
+		name    string
+		text    string // | marks the cursor
+		fields  []string
+		noMatch bool
+	}{
+		{"from the stages before", "values {a:1,b:2} | put c:=3 | summarize count() by |", []string{"a", "b", "c"}, false},
+		{"after a comma", "values {a:1,b:2} | summarize count() by a, |", []string{"a", "b"}, false},
+		{"cut restricts", "from conn.sup | cut host | count() by |", []string{"host"}, false},
+		{"from data", "from conn.sup | summarize count() by |", []string{"host", "port", "id", "id.orig_h"}, false},
+		{"group by", "from conn.sup | select host, count() as n group by |", []string{"host", "port", "id", "id.orig_h"}, false},
+		{"prefix", "values {host:1,hits:2,port:3} | summarize count() by h|", []string{"host", "hits"}, false},
+		{"not a key", "from conn.sup | summarize count() by host |", nil, true},
+		{"in a call", "from conn.sup | summarize count() by bucket(|", nil, true},
+	}
+	s := NewServer()
+	uri := pathToURI(filepath.Join(dir, "q.spq"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.LastIndex(tt.text, "|")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items, ok := s.byKeyCompletions(context.Background(), uri, text, positionAt(text, offset))
+			if ok == tt.noMatch {
+				t.Fatalf("Expected by-key completion %v, got %v", !tt.noMatch, ok)
+			}
+			if !ok {
+				return
+			}
+			var fields []string
+			for _, item := range items {
+				switch {
+				case item.Kind == CompletionItemKindField:
+					fields = append(fields, item.Label)
+				case Builtins.Lookup(item.Label) == nil || Builtins.Lookup(item.Label).Kind != KindFunction:
+					t.Errorf("Expected only fields and functions, got %s", item.Label)
+				}
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Expected fields %q, got %q", tt.fields, fields)
+			}
+		})
+	}
+}
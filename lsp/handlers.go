@@ -1,42 +1,110 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 )
 
-// response creates an RPCMessage response with the given ID and result
-func response(id interface{}, result interface{}) (interface{}, error) {
-	return RPCMessage{
-		JSONRPC: "2.0",
-		ID:      id,
-		Result:  result,
-	}, nil
+// HandlerResult is the outcome of handling a message: a result or an error
+// for requests. Notification handlers may instead set Notify to a message to
+// send back, such as diagnostics for a changed document.
+type HandlerResult struct {
+	Result interface{}
+	Error  *RPCError
+	Notify interface{}
+}
+
+// success creates a HandlerResult answering a request with result
+func success(result interface{}) HandlerResult {
+	return HandlerResult{Result: result}
+}
+
+// failure creates a HandlerResult reporting err to the client. Errors that
+// are already an *RPCError keep their code; anything else is an internal
+// error.
+func failure(err error) HandlerResult {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return HandlerResult{Error: rpcErr}
+	}
+	return HandlerResult{Error: &RPCError{Code: InternalError, Message: err.Error()}}
+}
+
+// invalidParams creates a HandlerResult reporting params, or a command's
+// arguments, that didn't unmarshal, with where and why in its data
+func invalidParams(err error) HandlerResult {
+	data := InvalidParamsData{}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		data.Field = typeErr.Field
+		data.Expected = typeErr.Type.String()
+		data.Got = typeErr.Value
+		data.Offset = typeErr.Offset
+	case errors.As(err, &syntaxErr):
+		data.Offset = syntaxErr.Offset
+	}
+	return HandlerResult{Error: &RPCError{
+		Code:    InvalidParams,
+		Message: "invalid params: " + err.Error(),
+		Data:    data,
+	}}
+}
+
+// notify creates a HandlerResult that sends msg, or reports err
+func notify(msg interface{}, err error) HandlerResult {
+	if err != nil {
+		return failure(err)
+	}
+	return HandlerResult{Notify: msg}
 }
 
 // handleInitialize processes the initialize request
-func (s *Server) handleInitialize(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleInitialize(msg RPCMessage) HandlerResult {
 	var params InitializeParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
 	log.Printf("Initialize: processId=%d, rootUri=%s", params.ProcessID, params.RootURI)
+	s.watchParent(params.ProcessID, parentPollInterval)
+	if len(params.WorkspaceFolders) > 0 {
+		s.setWorkspaceFolders(folderPaths(params.WorkspaceFolders))
+	} else if params.RootURI != "" {
+		if path, err := uriToPath(params.RootURI); err != nil {
+			log.Printf("Ignoring workspace root: %v", err)
+		} else {
+			s.setWorkspaceFolders([]string{path})
+		}
+	}
+	s.watchFiles = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+	s.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
+	s.applyEdits = params.Capabilities.Workspace.ApplyEdit
+	s.encoding = chooseEncoding(params.Capabilities.General.PositionEncodings)
+	s.client = supportFrom(params.Capabilities)
+	if params.Locale != "" {
+		s.messages = newCatalog(params.Locale)
+		log.Printf("Locale: %s (messages in %s)", params.Locale, s.messages.locale)
+	}
+	if len(params.InitializationOptions) > 0 {
+		var opts InitializationOptions
+		if err := json.Unmarshal(params.InitializationOptions, &opts); err != nil {
+			log.Printf("Ignoring initialization options: %v", err)
+		} else {
+			s.setSettings(settingsFrom(opts))
+			if opts.CompletionTelemetry {
+				s.enableUsageStats(opts.CompletionTelemetryPath)
+			}
+		}
+	}
 
-	return response(msg.ID, InitializeResult{
-		Capabilities: ServerCapabilities{
-			TextDocumentSync: 1, // Full document sync
-			CompletionProvider: &CompletionOptions{
-				TriggerCharacters: []string{".", "|", "(", ":", "="},
-				ResolveProvider:   false,
-			},
-			HoverProvider: true,
-			SignatureHelpProvider: &SignatureHelpOptions{
-				TriggerCharacters:   []string{"(", ","},
-				RetriggerCharacters: []string{","},
-			},
-			DocumentFormattingProvider: true,
-		},
+	return success(InitializeResult{
+		Capabilities: s.capabilities(),
 		ServerInfo: &ServerInfo{
 			Name:    "superdb-lsp",
 			Version: Version,
@@ -44,18 +112,121 @@ func (s *Server) handleInitialize(msg RPCMessage) (interface{}, error) {
 	})
 }
 
+// capabilities returns the capabilities the server announces, for the
+// position encoding chosen at initialize
+func (s *Server) capabilities() ServerCapabilities {
+	return ServerCapabilities{
+		PositionEncoding: s.encoding,
+		TextDocumentSync: TextDocumentSyncOptions{
+			OpenClose: true,
+			Change:    2, // Incremental document sync
+			Save:      &SaveOptions{},
+		},
+		CompletionProvider: &CompletionOptions{
+			TriggerCharacters: []string{".", "|", "(", ":", "="},
+			ResolveProvider:   true,
+		},
+		HoverProvider: true,
+		SignatureHelpProvider: &SignatureHelpOptions{
+			TriggerCharacters:   []string{"(", ",", " "},
+			RetriggerCharacters: []string{","},
+		},
+		DocumentFormattingProvider: true,
+		ExecuteCommandProvider: &ExecuteCommandOptions{
+			Commands: commandNames(),
+		},
+		CodeActionProvider: &CodeActionOptions{
+			CodeActionKinds: []string{CodeActionKindQuickFix, CodeActionKindRefactorRewrite},
+			ResolveProvider: true,
+		},
+		DefinitionProvider:         true,
+		ReferencesProvider:         true,
+		RenameProvider:             &RenameOptions{PrepareProvider: true},
+		DocumentSymbolProvider:     true,
+		WorkspaceSymbolProvider:    true,
+		InlayHintProvider:          true,
+		CodeLensProvider:           &CodeLensOptions{ResolveProvider: true},
+		SelectionRangeProvider:     true,
+		LinkedEditingRangeProvider: true,
+		DocumentLinkProvider:       &DocumentLinkOptions{},
+		Workspace: &WorkspaceServerCapabilities{
+			WorkspaceFolders: &WorkspaceFoldersServerCapabilities{
+				Supported:           true,
+				ChangeNotifications: true,
+			},
+			FileOperations: &FileOperationsServerCapabilities{
+				WillRename: &FileOperationRegistrationOptions{
+					Filters: []FileOperationFilter{{Scheme: "file", Pattern: FileOperationPattern{Glob: "**"}}},
+				},
+			},
+		},
+		SemanticTokensProvider: &SemanticTokensOptions{
+			Legend: semanticLegend,
+			Full:   &SemanticTokensFullOptions{Delta: true},
+		},
+	}
+}
+
+// handleInitialized processes the initialized notification. The client is
+// ready for server notifications at this point, so this is where companion
+// extensions learn which optional features are active.
+func (s *Server) handleInitialized(msg RPCMessage) HandlerResult {
+	s.initialized = true
+	s.warmup.begin()
+	for _, folder := range s.workspaceFolders() {
+		s.indexFolder(folder)
+	}
+	if s.watchFiles {
+		s.registerWatchers()
+	}
+
+	if s.featuresSent {
+		return HandlerResult{}
+	}
+	s.featuresSent = true
+	features := s.features()
+	log.Printf("Features: %+v", features)
+	return notify(notification("superdb/features", features))
+}
+
+// features reports which optional subsystems are active
+func (s *Server) features() FeaturesParams {
+	settings := s.settings()
+	return FeaturesParams{
+		Lake:           settings.Lake != "",
+		Execution:      commands[CommandRunQuery] != nil,
+		Dialect:        "supersql",
+		DialectVersion: settings.DialectVersion,
+		FormatterStyle: settings.FormatterStyle,
+	}
+}
+
+// notification builds a server-to-client notification message
+func notification(method string, params interface{}) (interface{}, error) {
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return RPCMessage{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  paramsBytes,
+	}, nil
+}
+
 // handleShutdown processes the shutdown request
-func (s *Server) handleShutdown(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleShutdown(msg RPCMessage) HandlerResult {
 	log.Println("Shutdown requested")
 	s.shutdown = true
-	return response(msg.ID, nil)
+	s.warmup.wait()
+	return success(nil)
 }
 
 // handleDidOpen processes textDocument/didOpen notifications
-func (s *Server) handleDidOpen(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleDidOpen(msg RPCMessage) HandlerResult {
 	var params DidOpenTextDocumentParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
 	uri := params.TextDocument.URI
@@ -64,130 +235,328 @@ func (s *Server) handleDidOpen(msg RPCMessage) (interface{}, error) {
 	log.Printf("Document opened: %s (lang=%s, version=%d)",
 		uri, params.TextDocument.LanguageID, params.TextDocument.Version)
 
-	s.documents[uri] = text
-	return s.publishDiagnostics(uri, text, params.TextDocument.Version)
+	s.setDocument(uri, text, params.TextDocument.Version)
+	if s.warmup.deferOpen(uri) {
+		log.Printf("Deferring diagnostics during warm-up: %s", uri)
+		s.scheduleDiagnostics(uri)
+		return HandlerResult{}
+	}
+	ctx := s.requests.documentContext(uri, params.TextDocument.Version)
+	return notify(s.publishDiagnostics(ctx, uri, text, params.TextDocument.Version))
 }
 
 // handleDidChange processes textDocument/didChange notifications
-func (s *Server) handleDidChange(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleDidChange(msg RPCMessage) HandlerResult {
 	var params DidChangeTextDocumentParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
 	uri := params.TextDocument.URI
 
-	// With TextDocumentSync=1 (Full), we get the full document content
+	// The user is editing, so warm-up is over; fresh diagnostics are
+	// published below, making any deferred ones for this document moot
+	s.warmup.end()
+	s.warmup.claim(uri)
+
+	// With TextDocumentSync=2 (Incremental), changes carry ranges into the
+	// stored document, applied in order; a change without one is the full
+	// document content
 	if len(params.ContentChanges) > 0 {
-		text := params.ContentChanges[len(params.ContentChanges)-1].Text
-		s.documents[uri] = text
+		before, _, _ := s.document(uri)
+		text := s.applyContentChanges(before, params.ContentChanges)
+		s.setDocument(uri, text, params.TextDocument.Version)
 
 		log.Printf("Document changed: %s (version=%d)", uri, params.TextDocument.Version)
-		return s.publishDiagnostics(uri, text, params.TextDocument.Version)
+		if !s.isDataFile(uri) && isLargePaste(before, text) {
+			log.Printf("Large paste into %s; publishing parse errors first", uri)
+			s.semantic.forget(uri)
+			s.schedulePasteDiagnostics(uri, params.TextDocument.Version)
+			return notify(s.syntaxDiagnostics(uri, text, params.TextDocument.Version))
+		}
+		ctx := s.requests.documentContext(uri, params.TextDocument.Version)
+		return notify(s.publishDiagnostics(ctx, uri, text, params.TextDocument.Version))
 	}
 
-	return nil, nil
+	return HandlerResult{}
+}
+
+// applyContentChanges applies changes to text in order. Positions past the
+// end of the document are clamped to it, so a client that got ahead of the
+// server loses characters rather than the whole edit.
+func (s *Server) applyContentChanges(text string, changes []TextDocumentContentChangeEvent) string {
+	for _, change := range changes {
+		if change.Range == nil {
+			text = change.Text
+			continue
+		}
+		start, ok := offsetAt(text, s.fromClient(text, change.Range.Start))
+		if !ok {
+			start = len(text)
+		}
+		end, ok := offsetAt(text, s.fromClient(text, change.Range.End))
+		if !ok {
+			end = len(text)
+		}
+		if end < start {
+			end = start
+		}
+		text = text[:start] + change.Text + text[end:]
+	}
+	return text
 }
 
 // handleDidClose processes textDocument/didClose notifications
-func (s *Server) handleDidClose(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleDidClose(msg RPCMessage) HandlerResult {
 	var params DidCloseTextDocumentParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
 	uri := params.TextDocument.URI
-	delete(s.documents, uri)
+	_, version, open := s.document(uri)
+	s.warmup.claim(uri)
+	s.deleteDocument(uri)
+	s.gate.forget(uri)
+	s.requests.forget(uri)
+	s.semantic.forget(uri)
 
 	log.Printf("Document closed: %s", uri)
-	return nil, nil
+	if !open || s.settings().KeepClosedDiagnostics {
+		return HandlerResult{}
+	}
+	// Clients keep what was last published for a closed document, so the
+	// diagnostics are cleared. This is a plain notification, as the
+	// version gate has just forgotten uri.
+	return notify(notification("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     version,
+		Diagnostics: []Diagnostic{},
+	}))
 }
 
 // handleCompletion processes textDocument/completion requests
-func (s *Server) handleCompletion(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleCompletion(ctx context.Context, msg RPCMessage) HandlerResult {
 	var params CompletionParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
-	text, ok := s.documents[params.TextDocument.URI]
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
 	if !ok {
 		log.Printf("Document not found: %s", params.TextDocument.URI)
-		return response(msg.ID, CompletionList{Items: []CompletionItem{}})
+		return success(CompletionList{Items: []CompletionItem{}})
 	}
 
 	log.Printf("Completion request: %s at line=%d, char=%d",
 		params.TextDocument.URI, params.Position.Line, params.Position.Character)
+	params.Position = s.fromClient(text, params.Position)
+
+	items, ok := s.parameterCompletions(text, params.Position)
+	if !ok {
+		items, ok = s.fileCompletions(params.TextDocument.URI, text, params.Position)
+	}
+	if !ok {
+		items, ok = s.poolCompletions(ctx, text, params.Position)
+	}
+	if !ok {
+		items, ok = s.byKeyCompletions(ctx, params.TextDocument.URI, text, params.Position)
+	}
+	if !ok {
+		items, ok = s.fieldArgCompletions(ctx, params.TextDocument.URI, text, params.Position)
+	}
+	if !ok {
+		var fields []CompletionItem
+		fields, ok = s.dataFieldCompletions(params.TextDocument.URI, text, params.Position)
+		items = fields
+		if !ok {
+			items = append(items, getCompletions(ctx, text, params.Position)...)
+			items = s.appendWorkspaceTypes(items, params.TextDocument.URI, text, params.Position)
+		}
+	}
+	if settings := s.settings(); !settings.SQLAliases || dialectLacksBuiltins(settings.DialectVersion) {
+		items = withoutBuiltins(items, func(b *Builtin) bool {
+			return b.SQLAlias && !settings.SQLAliases || !b.inDialect(settings.DialectVersion)
+		})
+	}
+	if s.usage != nil {
+		s.usage.rank(items)
+	}
+	if s.settings().CompletionDocs == completionDocsBrief {
+		briefCompletions(items)
+	}
+	if !s.client.snippets {
+		plainCompletions(items)
+	}
+	if !s.client.labelDetails {
+		unlabeledCompletions(items)
+	}
+	s.client.taggedCompletions(items)
+	items, incomplete := firstCompletions(items)
+	return success(CompletionList{IsIncomplete: incomplete, Items: items})
+}
 
-	return response(msg.ID, CompletionList{Items: getCompletions(text, params.Position)})
+// handleCompletionResolve processes completionItem/resolve requests,
+// adding the documentation left out of the completion list
+func (s *Server) handleCompletionResolve(msg RPCMessage) HandlerResult {
+	var item CompletionItem
+	if err := json.Unmarshal(msg.Params, &item); err != nil {
+		return invalidParams(err)
+	}
+	var params struct {
+		Data CompletionItemData `json:"data"`
+	}
+	// Items the server didn't make carry data it can't read
+	json.Unmarshal(msg.Params, &params)
+	return success(resolveCompletion(item, params.Data, s.settings()))
 }
 
 // handleHover processes textDocument/hover requests
-func (s *Server) handleHover(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleHover(msg RPCMessage) HandlerResult {
 	var params HoverParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
-	text, ok := s.documents[params.TextDocument.URI]
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
 	if !ok {
 		log.Printf("Document not found: %s", params.TextDocument.URI)
-		return response(msg.ID, nil)
+		return success(nil)
 	}
 
 	log.Printf("Hover request: %s at line=%d, char=%d",
 		params.TextDocument.URI, params.Position.Line, params.Position.Character)
+	params.Position = s.fromClient(text, params.Position)
 
-	return response(msg.ID, getHover(text, params.Position))
+	hover := s.parameterHover(text, params.Position)
+	if hover == nil {
+		hover = getHover(text, params.Position, s.settings().DocStyle)
+	}
+	if hover != nil {
+		dialectHover(hover, text, params.Position, s.settings().DialectVersion)
+	}
+	if hover != nil && hover.Range != nil {
+		rng := s.rangeToClient(text, *hover.Range)
+		hover.Range = &rng
+	}
+	return success(hover)
 }
 
 // handleSignatureHelp processes textDocument/signatureHelp requests
-func (s *Server) handleSignatureHelp(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleSignatureHelp(ctx context.Context, msg RPCMessage) HandlerResult {
 	var params SignatureHelpParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
-	text, ok := s.documents[params.TextDocument.URI]
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
 	if !ok {
 		log.Printf("Document not found: %s", params.TextDocument.URI)
-		return response(msg.ID, nil)
+		return success(nil)
 	}
 
 	log.Printf("Signature help request: %s at line=%d, char=%d",
 		params.TextDocument.URI, params.Position.Line, params.Position.Character)
+	params.Position = s.fromClient(text, params.Position)
 
-	return response(msg.ID, getSignatureHelp(text, params.Position))
+	return success(getSignatureHelp(ctx, text, params.Position, s.settings().DocStyle))
+}
+
+// handleDefinition processes textDocument/definition requests, jumping
+// from a use of a const, func, op, type, or parameter to its declaration
+func (s *Server) handleDefinition(msg RPCMessage) HandlerResult {
+	var params DefinitionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+
+	sym := buildSymbolTable(text).symbolAt(s.fromClient(text, params.Position))
+	if sym == nil {
+		return success(nil)
+	}
+	return success(Location{URI: params.TextDocument.URI, Range: s.rangeToClient(text, sym.nameRange)})
+}
+
+// handleReferences processes textDocument/references requests, finding
+// the uses of a const, func, op, type, parameter, or field in the document
+func (s *Server) handleReferences(msg RPCMessage) HandlerResult {
+	var params ReferenceParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+
+	pos := s.fromClient(text, params.Position)
+	ranges := buildSymbolTable(text).referencesAt(pos, params.Context.IncludeDeclaration)
+	if len(ranges) == 0 {
+		return success(nil)
+	}
+	locations := make([]Location, len(ranges))
+	for i, rng := range ranges {
+		locations[i] = Location{URI: params.TextDocument.URI, Range: s.rangeToClient(text, rng)}
+	}
+	return success(locations)
 }
 
 // handleFormatting processes textDocument/formatting requests
-func (s *Server) handleFormatting(msg RPCMessage) (interface{}, error) {
+func (s *Server) handleFormatting(msg RPCMessage) HandlerResult {
 	var params DocumentFormattingParams
 	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return nil, err
+		return invalidParams(err)
 	}
 
-	text, ok := s.documents[params.TextDocument.URI]
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
 	if !ok {
 		log.Printf("Document not found: %s", params.TextDocument.URI)
-		return response(msg.ID, []TextEdit{})
+		return success([]TextEdit{})
+	}
+	if s.isReadOnly(params.TextDocument.URI) {
+		log.Printf("Not formatting read-only %s", params.TextDocument.URI)
+		return success([]TextEdit{})
 	}
 
+	settings := s.settings()
+	options := settings.Format.apply(params.Options)
 	log.Printf("Formatting request: %s (tabSize=%d, insertSpaces=%v)",
-		params.TextDocument.URI, params.Options.TabSize, params.Options.InsertSpaces)
+		params.TextDocument.URI, options.TabSize, options.InsertSpaces)
+
+	if len(text) > s.limits.MaxFormatSize {
+		return failure(&RPCError{
+			Code: RequestFailed,
+			Message: fmt.Sprintf("document is too large to format (%d bytes, limit %d)",
+				len(text), s.limits.MaxFormatSize),
+		})
+	}
 
 	var formatted string
-	if isDataFile(params.TextDocument.URI) {
+	if s.isDataFile(params.TextDocument.URI) {
 		// Format as SUP data file
-		formatted = formatDataDocument(text, params.Options)
+		formatted = formatDataDocument(text, options)
 	} else {
 		// Format as SuperSQL query
-		formatted = formatDocument(text, params.Options)
+		formatted = formatStyled(text, options, settings.FormatterStyle)
 	}
 
 	// If no changes, return empty array
 	if formatted == text {
-		return response(msg.ID, []TextEdit{})
+		return success([]TextEdit{})
 	}
 
 	// Return a single edit that replaces the entire document
@@ -197,15 +566,97 @@ func (s *Server) handleFormatting(msg RPCMessage) (interface{}, error) {
 		lastLineLen = len(lines[len(lines)-1])
 	}
 
-	return response(msg.ID, []TextEdit{{
+	return success([]TextEdit{{
 		Range: Range{
 			Start: Position{Line: 0, Character: 0},
-			End:   Position{Line: len(lines) - 1, Character: lastLineLen},
+			End:   s.toClient(text, Position{Line: len(lines) - 1, Character: lastLineLen}),
 		},
 		NewText: formatted,
 	}})
 }
 
+// handleFormatText processes superdb/formatText requests, formatting text
+// that isn't an open document, like a query in a notebook cell, as a
+// document would be formatted
+func (s *Server) handleFormatText(msg RPCMessage) HandlerResult {
+	var params FormatTextParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	if len(params.Text) > s.limits.MaxFormatSize {
+		return failure(&RPCError{
+			Code: RequestFailed,
+			Message: fmt.Sprintf("text is too large to format (%d bytes, limit %d)",
+				len(params.Text), s.limits.MaxFormatSize),
+		})
+	}
+
+	settings := s.settings()
+	options := settings.Format.apply(params.Options)
+	if params.Data {
+		return success(FormatTextResult{Text: formatDataDocument(params.Text, options)})
+	}
+	return success(FormatTextResult{Text: formatStyled(params.Text, options, settings.FormatterStyle)})
+}
+
+// lineAt returns the given 0-based line of text without splitting the whole
+// document, reporting false when the line doesn't exist
+func lineAt(text string, line int) (string, bool) {
+	if line < 0 {
+		return "", false
+	}
+	for ; line > 0; line-- {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			return "", false
+		}
+		text = text[i+1:]
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return text, true
+}
+
+// offsetAt converts a position to a byte offset into text, clamping the
+// character to the end of its line. It reports false when the line doesn't
+// exist.
+func offsetAt(text string, pos Position) (int, bool) {
+	if pos.Line < 0 {
+		return 0, false
+	}
+	offset := 0
+	for line := pos.Line; line > 0; line-- {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	end := len(text)
+	if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+		end = offset + i
+	}
+	switch {
+	case pos.Character < 0:
+		end = offset
+	case pos.Character < end-offset:
+		end = offset + pos.Character
+	}
+	return end, true
+}
+
+// positionAt converts a byte offset into text to a position, the inverse of
+// offsetAt. Offsets past the end map to the end of text.
+func positionAt(text string, offset int) Position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	line := strings.Count(text[:offset], "\n")
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	return Position{Line: line, Character: offset - lineStart}
+}
+
 // splitLines splits text into lines
 func splitLines(text string) []string {
 	if text == "" {
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/brimdata/super/compiler/parser"
+)
+
+// Version and health. superdb/version reports what the server is built
+// from and what it can do, and checks that it can do it: that the bundled
+// parser parses and the configured lake opens. Clients and CI compare it
+// with what they expect instead of scraping -version output.
+
+// healthCheckQuery is parsed to check the parser
+const healthCheckQuery = "values 1"
+
+// checkHealth returns the problems that keep the server from working, or
+// none
+func (s *Server) checkHealth(ctx context.Context) []string {
+	problems := []string{}
+	if _, err := parser.ParseQuery(healthCheckQuery); err != nil {
+		problems = append(problems, fmt.Sprintf("parser: %v", err))
+	}
+	if lake := s.settings().Lake; lake != "" {
+		if _, err := newEnvironment(ctx, lake); err != nil {
+			problems = append(problems, fmt.Sprintf("lake: %v", err))
+		}
+	}
+	return problems
+}
+
+// handleVersion processes superdb/version requests
+func (s *Server) handleVersion(ctx context.Context, msg RPCMessage) HandlerResult {
+	problems := s.checkHealth(ctx)
+	return success(VersionResult{
+		Version:         FullVersion(),
+		SuperCommit:     SuperCommit,
+		DialectVersions: DialectVersions,
+		Capabilities:    s.capabilities(),
+		Features:        s.features(),
+		Healthy:         len(problems) == 0,
+		Problems:        problems,
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVersionRequest(t *testing.T) {
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+
+	response, err := h.ProcessRequest(2, "superdb/version", nil)
+	if err != nil {
+		t.Fatalf("superdb/version failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result VersionResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Version != FullVersion() || result.SuperCommit != SuperCommit || len(result.DialectVersions) == 0 {
+		t.Errorf("Unexpected version: %s", resultBytes)
+	}
+	if !result.Capabilities.HoverProvider || result.Capabilities.ExecuteCommandProvider == nil {
+		t.Errorf("Expected the announced capabilities, got %+v", result.Capabilities)
+	}
+	if !result.Healthy || len(result.Problems) != 0 {
+		t.Errorf("Expected a healthy server, got %s", resultBytes)
+	}
+}
+
+func TestVersionReportsLakeProblems(t *testing.T) {
+	h := NewTestHelper()
+	missing := filepath.Join(t.TempDir(), "no-lake")
+	opts, _ := json.Marshal(map[string]string{"lake": missing})
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+
+	response, _ := h.ProcessRequest(2, "superdb/version", nil)
+	resultBytes, _ := json.Marshal(response.Result)
+	var result VersionResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Healthy || len(result.Problems) != 1 || !strings.HasPrefix(result.Problems[0], "lake:") {
+		t.Errorf("Expected a lake problem, got %s", resultBytes)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Pre-commit checks. `superdb-lsp hook install` writes a git pre-commit
+// hook that pipes the staged changes to .spq and .sup files, as a unified
+// diff, into `superdb-lsp -check -diff -staged`. Only the lines a commit
+// changes are checked, so a team can adopt the check without fixing every
+// old query first. The files are checked as staged, since a partly staged
+// file's working copy doesn't match the diff's line numbers.
+
+// hookMarker identifies a pre-commit hook this program wrote, so install
+// can replace its own hook but not someone else's
+const hookMarker = "# Installed by superdb-lsp hook install"
+
+// lineSpan is a run of lines, 0-based and inclusive
+type lineSpan struct {
+	first, last int
+}
+
+// parseUnifiedDiff returns the files a unified diff changes, in order, and
+// the lines it adds or changes in each, numbered in the new file. Deleted
+// files, and hunks that only delete, contribute no lines.
+func parseUnifiedDiff(r io.Reader) ([]string, map[string][]lineSpan, error) {
+	var paths []string
+	lines := make(map[string][]lineSpan)
+	current := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			current = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if i := strings.IndexByte(current, '\t'); i >= 0 {
+				current = current[:i]
+			}
+			if current == "/dev/null" {
+				current = ""
+				continue
+			}
+			if _, ok := lines[current]; !ok {
+				paths = append(paths, current)
+				lines[current] = nil
+			}
+		case strings.HasPrefix(line, "@@ ") && current != "":
+			span, ok, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				lines[current] = append(lines[current], span)
+			}
+		}
+	}
+	return paths, lines, scanner.Err()
+}
+
+// parseHunkHeader returns the lines of the new file a hunk header like
+// "@@ -3,2 +3,4 @@" covers, and false when the hunk adds none
+func parseHunkHeader(header string) (lineSpan, bool, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return lineSpan{}, false, fmt.Errorf("malformed hunk header: %s", header)
+	}
+	start, count, found := strings.Cut(fields[2][1:], ",")
+	first, err := strconv.Atoi(start)
+	if err != nil {
+		return lineSpan{}, false, fmt.Errorf("malformed hunk header: %s", header)
+	}
+	n := 1
+	if found {
+		if n, err = strconv.Atoi(count); err != nil {
+			return lineSpan{}, false, fmt.Errorf("malformed hunk header: %s", header)
+		}
+	}
+	if n == 0 {
+		return lineSpan{}, false, nil
+	}
+	return lineSpan{first: first - 1, last: first + n - 2}, true, nil
+}
+
+// readStaged returns the text of path as it is staged in git's index,
+// which is what a diff of the staged changes numbers its lines by. path is
+// relative to the top of the working tree, as a diff names it.
+func readStaged(path string) ([]byte, error) {
+	out, err := exec.Command("git", "show", ":"+path).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// hookScript returns the pre-commit hook that runs exe on the staged
+// changes
+func hookScript(exe string) string {
+	return `#!/bin/sh
+` + hookMarker + `
+# Checks the lines this commit changes in .spq and .sup files: parse
+# errors, lints, and formatting. Skip with git commit --no-verify.
+git diff --cached -U0 --diff-filter=ACMR -- '*.spq' '*.sup' |
+	` + shellQuote(exe) + ` -check -diff -staged -format -fail-on hint
+`
+}
+
+// shellQuote quotes s for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// installHook writes the pre-commit hook into the hooks directory of the
+// git repository at dir. A hook that this program didn't write is left
+// alone unless force is set.
+func installHook(dir, exe string, force bool) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return "", fmt.Errorf("not in a git repository: %w", err)
+	}
+	hooks := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(dir, hooks)
+	}
+	path := filepath.Join(hooks, "pre-commit")
+	existing, err := os.ReadFile(path)
+	switch {
+	case err == nil && !force && !strings.Contains(string(existing), hookMarker):
+		return "", fmt.Errorf("%s already exists; use -force to replace it", path)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return "", err
+	}
+	if err := os.MkdirAll(hooks, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(hookScript(exe)), 0o755); err != nil {
+		return "", err
+	}
+	// WriteFile keeps the mode of a hook being replaced
+	return path, os.Chmod(path, 0o755)
+}
+
+// runHook runs the hook subcommand and returns the exit status
+func runHook(args []string) int {
+	flags := flag.NewFlagSet("hook install", flag.ContinueOnError)
+	force := flags.Bool("force", false, "replace a pre-commit hook this program didn't write")
+	if len(args) == 0 || args[0] != "install" || flags.Parse(args[1:]) != nil {
+		fmt.Fprintln(os.Stderr, "usage: superdb-lsp hook install [-force]")
+		return 2
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	path, err := installHook(".", exe, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Installed %s\n", path)
+	return 0
+}
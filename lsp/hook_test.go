@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUnifiedDiff(t *testing.T) {
+	diff := `diff --git a/q.spq b/q.spq
+index 1111111..2222222 100644
+--- a/q.spq
++++ b/q.spq
+@@ -2 +2 @@ values 1
+-| head 1
++| head 2
+@@ -5,0 +6,3 @@
++| sort
++| uniq
++| count()
+@@ -9,2 +11,0 @@
+-| a
+-| b
+diff --git a/new.sup b/new.sup
+new file mode 100644
+--- /dev/null
++++ b/new.sup
+@@ -0,0 +1,2 @@
++{a:1}
++{a:2}
+diff --git a/gone.spq b/gone.spq
+deleted file mode 100644
+--- a/gone.spq
++++ /dev/null
+@@ -1 +0,0 @@
+-values 1
+`
+	paths, lines, err := parseUnifiedDiff(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"q.spq", "new.sup"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected paths %v, got %v", want, paths)
+	}
+	if want := []lineSpan{{1, 1}, {5, 7}}; !reflect.DeepEqual(lines["q.spq"], want) {
+		t.Errorf("Expected q.spq spans %v, got %v", want, lines["q.spq"])
+	}
+	if want := []lineSpan{{0, 1}}; !reflect.DeepEqual(lines["new.sup"], want) {
+		t.Errorf("Expected new.sup spans %v, got %v", want, lines["new.sup"])
+	}
+
+	if _, _, err := parseUnifiedDiff(strings.NewReader("+++ b/q.spq\n@@ bad @@\n")); err == nil {
+		t.Error("Expected a malformed hunk header to be an error")
+	}
+}
+
+func TestCheckFilesOnChangedLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "q.spq")
+	os.WriteFile(path, []byte("values 1\n| yield this\n|head  1\n"), 0o644)
+
+	var out strings.Builder
+	// Only the yield line changed: the formatting below it isn't reported
+	opts := checkOptions{format: true, failOn: DiagnosticSeverityError, lines: map[string][]lineSpan{path: {{1, 1}}}}
+	if status := checkFiles([]string{path}, opts, &out); status != 0 {
+		t.Errorf("Expected a hint alone to pass, got %d: %s", status, out.String())
+	}
+	if !strings.Contains(out.String(), "hint: yield is an older spelling of values") || strings.Contains(out.String(), "formatter") {
+		t.Errorf("Expected only the hint on the changed line, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	opts.failOn = DiagnosticSeverityHint
+	if status := checkFiles([]string{path}, opts, &out); status != 1 {
+		t.Errorf("Expected -fail-on hint to fail, got %d", status)
+	}
+}
+
+func TestFormatDiagnostic(t *testing.T) {
+	s := NewServer()
+	if _, ok := s.formatDiagnostic("q.spq", "values 1\n| head 1\n"); ok {
+		t.Error("Expected a formatted query to pass")
+	}
+	d, ok := s.formatDiagnostic("q.spq", "values 1\n|head  1\n")
+	if !ok || d.Code != "unformatted" || d.Range.Start.Line != 1 || d.Range.End.Line != 1 {
+		t.Fatalf("Expected line 2 reported, got %+v", d)
+	}
+	if d.Message != "the formatter changes line 2" {
+		t.Errorf("Unexpected message: %q", d.Message)
+	}
+}
+
+func TestInstallHook(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if err := exec.Command("git", "init", "-q", dir).Run(); err != nil {
+		t.Fatal(err)
+	}
+	path, err := installHook(dir, "/opt/superdb lsp/bin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `'/opt/superdb lsp/bin' -check -diff`) {
+		t.Errorf("Expected the hook to run the quoted executable, got:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode()&0o111 == 0 {
+		t.Error("Expected the hook to be executable")
+	}
+
+	// Its own hook is replaced; anyone else's needs -force
+	if _, err := installHook(dir, "/bin/x", false); err != nil {
+		t.Errorf("Expected reinstalling to succeed, got %v", err)
+	}
+	os.WriteFile(path, []byte("#!/bin/sh\nmake lint\n"), 0o755)
+	if _, err := installHook(dir, "/bin/x", false); err == nil {
+		t.Error("Expected a foreign hook to be left alone")
+	}
+	if _, err := installHook(dir, "/bin/x", true); err != nil {
+		t.Errorf("Expected -force to replace it, got %v", err)
+	}
+}
+
+func TestCheckStagedFile(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	if err := exec.Command("git", "init", "-q", dir).Run(); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	os.WriteFile("q.spq", []byte("values 1\n| yield this\n"), 0o644)
+	if err := exec.Command("git", "add", "q.spq").Run(); err != nil {
+		t.Fatal(err)
+	}
+	// An unstaged edit above the staged change moves it down a line
+	os.WriteFile("q.spq", []byte("-- note\nvalues 1\n| yield this\n"), 0o644)
+
+	var out strings.Builder
+	opts := checkOptions{failOn: DiagnosticSeverityHint, lines: map[string][]lineSpan{"q.spq": {{1, 1}}}, read: readStaged}
+	if status := checkFiles([]string{"q.spq"}, opts, &out); status != 1 {
+		t.Errorf("Expected the staged yield line checked, got %d: %s", status, out.String())
+	}
+
+	out.Reset()
+	if status := checkFiles([]string{"missing.spq"}, opts, &out); status != 2 {
+		t.Errorf("Expected a file that isn't staged to be unreadable, got %d: %s", status, out.String())
+	}
+}
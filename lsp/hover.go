@@ -5,22 +5,44 @@ import (
 	"strings"
 )
 
-// getHover returns hover information for the word at the given position
-func getHover(text string, pos Position) *Hover {
+// getHover returns hover information for the word at the given position,
+// rendered in style
+func getHover(text string, pos Position, style docStyle) *Hover {
+	if strings.Contains(text, "://") {
+		if offset, ok := offsetAt(text, pos); ok {
+			if hover := httpSourceHover(text, offset, style); hover != nil {
+				return hover
+			}
+		}
+	}
+
 	word := getWordAtPosition(text, pos)
 	if word == "" {
 		return nil
 	}
 
+	// A name the query declares shadows a builtin of the same name
+	if hover := symbolHover(text, pos, style); hover != nil {
+		return hover
+	}
+
 	b := Builtins.Lookup(word)
 	if b == nil {
 		return nil
 	}
 
+	if style == docPlainText {
+		return &Hover{
+			Contents: MarkupContent{
+				Kind:  MarkupKindPlainText,
+				Value: b.plainHover,
+			},
+		}
+	}
 	return &Hover{
 		Contents: MarkupContent{
 			Kind:  MarkupKindMarkdown,
-			Value: formatHoverContent(b),
+			Value: b.hover,
 		},
 	}
 }
@@ -29,12 +51,16 @@ func getHover(text string, pos Position) *Hover {
 func formatHoverContent(b *Builtin) string {
 	switch b.Kind {
 	case KindFunction, KindAggregate:
-		if b.Signature != "" {
+		if b.sig != nil {
 			doc := b.Doc
 			if doc == "" {
 				doc = b.Brief
 			}
-			return fmt.Sprintf("```spq\n%s\n```\n\n%s", b.Signature, doc)
+			content := fmt.Sprintf("```spq\n%s\n```\n\n%s", b.sig.Label(), doc)
+			if params := b.sig.markdownParams(); params != "" {
+				content += "\n\n" + params
+			}
+			return content
 		}
 		kindName := "function"
 		if b.Kind == KindAggregate {
@@ -46,7 +72,11 @@ func formatHoverContent(b *Builtin) string {
 		return fmt.Sprintf("**%s** (keyword)\n\n%s", b.Name, b.Brief)
 
 	case KindOperator:
-		return fmt.Sprintf("**%s** (operator)\n\n%s", b.Name, b.Brief)
+		doc := b.Doc
+		if doc == "" {
+			doc = b.Brief
+		}
+		return fmt.Sprintf("**%s** (operator)\n\n%s", b.Name, doc)
 
 	case KindType:
 		return fmt.Sprintf("**%s** (type)\n\n%s", b.Name, b.Brief)
@@ -56,15 +86,54 @@ func formatHoverContent(b *Builtin) string {
 	}
 }
 
-// getWordAtPosition extracts the word at the given position
-func getWordAtPosition(text string, pos Position) string {
-	lines := strings.Split(text, "\n")
-	if pos.Line >= len(lines) {
-		return ""
+// formatPlainHoverContent formats a Builtin as plain text hover content:
+// a heading line, the signature on its own line, the documentation, and
+// an explicit Parameters: section, with no markdown
+func formatPlainHoverContent(b *Builtin) string {
+	kindName := ""
+	switch b.Kind {
+	case KindKeyword:
+		kindName = "keyword"
+	case KindOperator:
+		kindName = "operator"
+	case KindFunction:
+		kindName = "function"
+	case KindAggregate:
+		kindName = "aggregate"
+	case KindType:
+		kindName = "type"
+	}
+	heading := b.Name
+	if kindName != "" {
+		heading += " (" + kindName + ")"
 	}
+	if b.sig == nil {
+		return heading + "\n\n" + b.plainDoc
+	}
+	return heading + "\n" + b.sig.Label() + "\n\n" + b.plainDoc
+}
 
-	line := lines[pos.Line]
-	if pos.Character > len(line) {
+// formatPlainDoc returns a builtin's documentation followed by its
+// Parameters: section, for plain text hover, signature help, and
+// completion
+func formatPlainDoc(b *Builtin) string {
+	doc := b.Doc
+	if doc == "" {
+		doc = b.Brief
+	}
+	if b.sig == nil {
+		return doc
+	}
+	if params := b.sig.plainParams(); params != "" {
+		doc += "\n\n" + params
+	}
+	return doc
+}
+
+// getWordAtPosition extracts the word at the given position
+func getWordAtPosition(text string, pos Position) string {
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) {
 		return ""
 	}
 
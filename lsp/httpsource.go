@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/brimdata/super/sio"
+)
+
+// HTTP sources. from reads a URL, with optional arguments in parentheses:
+//
+//	from https://example.com/api (method POST headers {Accept:["application/json"]} body "{}")
+//
+// Inside the parentheses, completion offers the argument names, HTTP
+// methods after method, and standard header names as keys of the headers
+// record. The headers are checked the way super checks them when it
+// compiles the query, which the parser alone doesn't do. Hovering over the
+// URL explains how the format of the response is chosen.
+
+// httpSourcePattern matches from and a URL, bare or quoted, and the
+// opening parenthesis of its arguments if there is one
+var httpSourcePattern = regexp.MustCompile(`(?i)\bfrom\s+(https?://[^\s()|"']+|"https?://[^"]*"|'https?://[^']*')(\s*\()?`)
+
+// httpFormatArgPattern finds the format argument among a source's
+// arguments
+var httpFormatArgPattern = regexp.MustCompile(`(?i)\bformat\s+([A-Za-z0-9_]+)`)
+
+// httpHeadersPattern finds the start of the headers record
+var httpHeadersPattern = regexp.MustCompile(`(?i)\bheaders\s*\{`)
+
+// httpArg is an argument an HTTP source accepts
+type httpArg struct {
+	name   string
+	detail string
+	doc    string
+}
+
+// httpArgs are the arguments super accepts for a URL, in the order they
+// are usually written
+var httpArgs = []httpArg{
+	{"method", "HTTP method", "The request method. Requests are GETs unless one is given."},
+	{"headers", "request headers", "A record of header names to arrays or sets of strings, e.g. `{Accept:[\"application/json\"]}`."},
+	{"body", "request body", "The request body, as a string."},
+	{"format", "response format", "The format of the response. Without it, the URL's extension picks one, or super detects it from the response."},
+}
+
+// httpMethods are the standard HTTP methods
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// httpHeaders are the standard request headers offered as headers keys
+var httpHeaders = []struct {
+	name string
+	doc  string
+}{
+	{"Accept", "Media types the response may have"},
+	{"Accept-Encoding", "Content encodings the response may use"},
+	{"Accept-Language", "Natural languages preferred for the response"},
+	{"Authorization", "Credentials, e.g. Bearer <token>"},
+	{"Cache-Control", "Caching directives for the request"},
+	{"Content-Type", "Media type of the request body"},
+	{"Cookie", "Cookies previously sent by the server"},
+	{"If-Modified-Since", "Respond only if the resource changed after this date"},
+	{"If-None-Match", "Respond only if the resource's ETag differs"},
+	{"Origin", "Origin the request comes from"},
+	{"Range", "Part of the resource to return"},
+	{"Referer", "Address of the page that linked to the resource"},
+	{"User-Agent", "Software making the request"},
+}
+
+// httpSource is a URL read by from
+type httpSource struct {
+	url       string
+	urlStart  int // offset of the URL, including any quote
+	urlEnd    int
+	argsStart int // offset just inside the opening parenthesis, or -1
+	argsEnd   int // offset of the closing parenthesis, or the end of text
+}
+
+// findHTTPSources finds the URLs read by from in text. It works on text
+// that doesn't parse, so it serves completion while the user types.
+func findHTTPSources(text string) []httpSource {
+	var sources []httpSource
+	for _, m := range httpSourcePattern.FindAllStringSubmatchIndex(text, -1) {
+		src := httpSource{
+			url:       text[m[2]:m[3]],
+			urlStart:  m[2],
+			urlEnd:    m[3],
+			argsStart: -1,
+			argsEnd:   -1,
+		}
+		if q := src.url[0]; q == '"' || q == '\'' {
+			src.url = src.url[1 : len(src.url)-1]
+		}
+		if m[4] >= 0 {
+			src.argsStart = m[5]
+			src.argsEnd = closingParen(text, src.argsStart)
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+// closingParen returns the offset of the parenthesis that closes one
+// opened just before start, skipping quoted strings, or len(text) if it
+// isn't closed
+func closingParen(text string, start int) int {
+	depth := 1
+	for i := start; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\'', '`':
+			i = skipQuoted(text, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(text)
+}
+
+// skipQuoted returns the offset of the quote that closes the string
+// starting at i, or the end of text
+func skipQuoted(text string, i int) int {
+	quote := text[i]
+	for i++; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return len(text)
+}
+
+// nesting returns how deeply s leaves brackets of any kind open, ignoring
+// those in quoted strings
+func nesting(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'', '`':
+			i = skipQuoted(s, i)
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// httpCompletions returns completions for offset when it is inside the
+// arguments of an HTTP source, reporting false when it isn't
+func httpCompletions(text string, offset int) ([]CompletionItem, bool) {
+	for _, src := range findHTTPSources(text) {
+		if src.argsStart < 0 || offset < src.argsStart || offset > src.argsEnd {
+			continue
+		}
+		return httpArgCompletions(text[src.argsStart:offset]), true
+	}
+	return nil, false
+}
+
+// httpArgCompletions returns completions after before, the arguments of
+// an HTTP source up to the cursor
+func httpArgCompletions(before string) []CompletionItem {
+	word := before[len(strings.TrimRightFunc(before, func(r rune) bool {
+		return r < 0x80 && (isIdentifierChar(byte(r)) || r == '-')
+	})):]
+	rest := strings.TrimRight(before[:len(before)-len(word)], " \t\r\n")
+	items := []CompletionItem{}
+
+	if quoted, ok := inHeadersKey(before, rest); ok {
+		for _, h := range httpHeaders {
+			if !strings.HasPrefix(strings.ToLower(h.name), strings.ToLower(word)) {
+				continue
+			}
+			item := CompletionItem{
+				Label:         h.name,
+				Kind:          CompletionItemKindField,
+				Detail:        "HTTP header",
+				Documentation: h.doc,
+			}
+			if !quoted && strings.Contains(h.name, "-") {
+				item.InsertText = `"` + h.name + `"`
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+
+	prev := rest[len(strings.TrimRightFunc(rest, func(r rune) bool {
+		return r < 0x80 && isIdentifierChar(byte(r))
+	})):]
+	switch strings.ToLower(prev) {
+	case "method":
+		for _, m := range httpMethods {
+			if strings.HasPrefix(m, strings.ToUpper(word)) {
+				items = append(items, CompletionItem{Label: m, Kind: CompletionItemKindEnumMember, Detail: "HTTP method"})
+			}
+		}
+		return items
+	case "format":
+		return formatCompletions(word)
+	case "headers", "body":
+		return items
+	}
+
+	if nesting(before) != 0 {
+		// Inside an argument's value
+		return items
+	}
+	for _, arg := range httpArgs {
+		if strings.HasPrefix(arg.name, strings.ToLower(word)) {
+			items = append(items, CompletionItem{
+				Label:         arg.name,
+				Kind:          CompletionItemKindProperty,
+				Detail:        arg.detail,
+				Documentation: arg.doc,
+			})
+		}
+	}
+	return items
+}
+
+// inHeadersKey reports whether before, the arguments up to the cursor,
+// ends where a key of the headers record goes; rest is before without the
+// word being typed. quoted reports that the key's opening quote is typed.
+func inHeadersKey(before, rest string) (quoted bool, ok bool) {
+	locs := httpHeadersPattern.FindAllStringIndex(before, -1)
+	if len(locs) == 0 {
+		return false, false
+	}
+	record := locs[len(locs)-1][1]
+	if record > len(rest) {
+		return false, false
+	}
+	quoted = strings.HasSuffix(rest, `"`)
+	rest = strings.TrimRight(strings.TrimSuffix(rest, `"`), " \t\r\n")
+	if nesting(before[record:len(rest)]) != 0 {
+		return false, false
+	}
+	if n := len(rest); n == record || rest[n-1] == ',' {
+		return quoted, true
+	}
+	return false, false
+}
+
+// httpSourceHover explains the HTTP source or argument at offset
+func httpSourceHover(text string, offset int, style docStyle) *Hover {
+	for _, src := range findHTTPSources(text) {
+		if offset >= src.urlStart && offset <= src.urlEnd {
+			args := ""
+			if src.argsStart >= 0 {
+				args = text[src.argsStart:src.argsEnd]
+			}
+			return newHover(describeHTTPSource(src.url, args, style == docMarkdown), style)
+		}
+		if src.argsStart < 0 || offset < src.argsStart || offset > src.argsEnd {
+			continue
+		}
+		start, end := offset, offset
+		for start > src.argsStart && isIdentifierChar(text[start-1]) {
+			start--
+		}
+		for end < src.argsEnd && isIdentifierChar(text[end]) {
+			end++
+		}
+		word := strings.ToLower(text[start:end])
+		for _, arg := range httpArgs {
+			if arg.name == word && nesting(text[src.argsStart:start]) == 0 {
+				doc := arg.doc
+				if style == docMarkdown {
+					return newHover(fmt.Sprintf("**%s** (HTTP source argument)\n\n%s", arg.name, doc), style)
+				}
+				return newHover(fmt.Sprintf("%s (HTTP source argument)\n\n%s", arg.name, strings.ReplaceAll(doc, "`", "")), style)
+			}
+		}
+	}
+	return nil
+}
+
+// describeHTTPSource explains how super will request url and read its
+// response, given the text of the source's arguments
+func describeHTTPSource(url, args string, markdown bool) string {
+	code := func(s string) string { return s }
+	bold := code
+	if markdown {
+		code = func(s string) string { return "`" + s + "`" }
+		bold = func(s string) string { return "**" + s + "**" }
+	}
+
+	var b strings.Builder
+	if markdown {
+		fmt.Fprintf(&b, "**HTTP source** %s\n\n", code(url))
+	} else {
+		fmt.Fprintf(&b, "HTTP source %s\n\n", url)
+	}
+	if m := httpFormatArgPattern.FindStringSubmatch(args); m != nil {
+		fmt.Fprintf(&b, "The response is read as %s, as the %s argument says.", bold(m[1]), code("format"))
+	} else if format := sio.FormatFromPath(url); format != "" {
+		ext := url[strings.LastIndexByte(url, '.'):]
+		fmt.Fprintf(&b, "The response is read as %s, from the %s extension of the URL.", bold(format), code(ext))
+	} else {
+		fmt.Fprintf(&b, "The URL has no extension super knows, so the format is detected from the start of the response. "+
+			"The response's Content-Type header is not consulted; add %s if detection guesses wrong.", code("format <name>"))
+	}
+	return b.String()
+}
+
+// newHover wraps content in a hover of the given style
+func newHover(content string, style docStyle) *Hover {
+	kind := MarkupKindMarkdown
+	if style == docPlainText {
+		kind = MarkupKindPlainText
+	}
+	return &Hover{Contents: MarkupContent{Kind: kind, Value: content}}
+}
+
+// httpHeaderTokenPattern matches a valid header name, an HTTP token
+var httpHeaderTokenPattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// httpSourceDiagnostics checks the arguments of each HTTP source in text
+// the way super does when it compiles the query: only known arguments,
+// and headers given as a record of arrays or sets of strings
+func (s *Server) httpSourceDiagnostics(text string) []Diagnostic {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	report := func(loc interface{}, code string, kv ...string) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    locRange(text, loc),
+			Severity: DiagnosticSeverityError,
+			Code:     code,
+			Source:   "superdb-lsp",
+			Message:  s.messages.format(code, kv...),
+		})
+	}
+	walkTree(tree, func(node map[string]interface{}) {
+		source, _ := node["source"].(map[string]interface{})
+		url, _ := source["value"].(string)
+		if nodeKind(source) != "Text" || !(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) {
+			return
+		}
+		args, _ := node["args"].([]interface{})
+		for _, arg := range args {
+			arg, _ := arg.(map[string]interface{})
+			key, _ := arg["key"].(string)
+			switch strings.ToLower(key) {
+			case "method", "body", "format":
+			case "headers":
+				checkHeaders(arg["value"], arg["loc"], report)
+			default:
+				report(arg["loc"], "http-argument", "argument", key)
+			}
+		}
+	})
+	return diagnostics
+}
+
+// checkHeaders checks the value of a headers argument
+func checkHeaders(value, loc interface{}, report func(loc interface{}, code string, kv ...string)) {
+	record, _ := value.(map[string]interface{})
+	if nodeKind(record) != "RecordExpr" {
+		report(loc, "http-headers")
+		return
+	}
+	elems, _ := record["elems"].([]interface{})
+	for _, elem := range elems {
+		field, _ := elem.(map[string]interface{})
+		if nodeKind(field) != "FieldElem" {
+			// A spread; its fields aren't known until the query runs
+			continue
+		}
+		nameNode, _ := field["name"].(map[string]interface{})
+		name, _ := nameNode["value"].(string)
+		if !httpHeaderTokenPattern.MatchString(name) {
+			report(nameNode["loc"], "http-header-name", "header", name)
+		}
+		list, _ := field["value"].(map[string]interface{})
+		if kind := nodeKind(list); kind != "ArrayExpr" && kind != "SetExpr" {
+			report(field["loc"], "http-header-value", "header", name)
+			continue
+		}
+		items, _ := list["elems"].([]interface{})
+		for _, item := range items {
+			item, _ := item.(map[string]interface{})
+			expr, _ := item["expr"].(map[string]interface{})
+			s, ok := stringLiteral(expr)
+			if !ok {
+				report(item["loc"], "http-header-value", "header", name)
+			} else if strings.ContainsAny(s, "\r\n") {
+				report(item["loc"], "http-header-line-break", "header", name)
+			}
+		}
+	}
+}
+
+// stringLiteral returns the value of a string literal node
+func stringLiteral(expr map[string]interface{}) (string, bool) {
+	switch nodeKind(expr) {
+	case "DoubleQuoteExpr":
+		s, _ := expr["text"].(string)
+		return s, true
+	case "Primitive":
+		if expr["type"] == "string" {
+			s, _ := expr["text"].(string)
+			return s, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func completionLabels(items []CompletionItem) []string {
+	labels := make([]string, len(items))
+	for i, item := range items {
+		labels[i] = item.Label
+	}
+	return labels
+}
+
+func TestHTTPSourceCompletions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string // | marks the cursor
+		want []string
+	}{
+		{"argument names", "from https://x.io/a (|", []string{"method", "headers", "body", "format"}},
+		{"argument prefix", "from https://x.io/a (method GET h|)", []string{"headers"}},
+		{"methods", "from https://x.io/a (method P|)", []string{"POST", "PUT", "PATCH"}},
+		{"header names", "from https://x.io/a (headers {Acc|", []string{"Accept", "Accept-Encoding", "Accept-Language"}},
+		{"second header", `from "https://x.io/a" (headers {Accept:["a/b"], "Us|`, []string{"User-Agent"}},
+		{"header value", `from https://x.io/a (headers {Accept:[|`, []string{}},
+		{"body value", `from https://x.io/a (body |`, []string{}},
+		{"formats", "from https://x.io/a (format j|)", []string{"json", "jsup"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.Index(tt.text, "|")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items := getCompletions(context.Background(), text, positionAt(text, offset))
+			if got := completionLabels(items); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Outside the arguments, completion is as usual
+	text := "from https://x.io/a (method GET) | so"
+	if got := completionLabels(getCompletions(context.Background(), text, positionAt(text, len(text)))); len(got) == 0 || got[0] == "method" {
+		t.Errorf("Expected builtin completions after the source, got %v", got)
+	}
+}
+
+func TestHTTPHeaderInsertText(t *testing.T) {
+	items := httpArgCompletions("headers {Content-T")
+	if len(items) != 1 || items[0].InsertText != `"Content-Type"` {
+		t.Errorf("Expected a quoted Content-Type, got %+v", items)
+	}
+	items = httpArgCompletions(`headers {"Content-T`)
+	if len(items) != 1 || items[0].InsertText != "" {
+		t.Errorf("Expected no extra quotes after an opening quote, got %+v", items)
+	}
+}
+
+func TestHTTPSourceHover(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"from https://x.io/data.csv", "read as **csv**, from the `.csv` extension"},
+		{"from https://x.io/api (format json)", "read as **json**, as the `format` argument says"},
+		{"from https://x.io/api?q=1", "detected from the start of the response"},
+	}
+	for _, tt := range tests {
+		hover := getHover(tt.text, Position{Line: 0, Character: 10}, docMarkdown)
+		if hover == nil || !strings.Contains(hover.Contents.Value, tt.want) {
+			t.Errorf("Expected hover for %q to contain %q, got %+v", tt.text, tt.want, hover)
+		}
+	}
+
+	hover := getHover("from https://x.io/a.json (method POST)", Position{Line: 0, Character: 28}, docPlainText)
+	if hover == nil || hover.Contents.Kind != MarkupKindPlainText || !strings.HasPrefix(hover.Contents.Value, "method (HTTP source argument)") {
+		t.Errorf("Expected plain text hover for method, got %+v", hover)
+	}
+}
+
+func TestHTTPSourceDiagnostics(t *testing.T) {
+	s := NewServer()
+	text := `from https://x.io/a (headers {Accept:["a/b"], "X Y":["z"], B:[1], C:"c"} timeout 5)`
+	var codes []string
+	for _, d := range s.httpSourceDiagnostics(text) {
+		codes = append(codes, d.Code)
+	}
+	want := "http-header-name,http-header-value,http-header-value,http-argument"
+	if got := strings.Join(codes, ","); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	for _, text := range []string{
+		`from https://x.io/a (method POST headers {Accept:["a/b"],"Content-Type":|['c']|} body "{}")`,
+		`from data.json (format json)`,
+	} {
+		if diags := s.httpSourceDiagnostics(text); len(diags) != 0 {
+			t.Errorf("Expected no diagnostics for %q, got %+v", text, diags)
+		}
+	}
+}
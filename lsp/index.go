@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Workspace index. After initialized, the .spq files under the workspace
+// root are read in the background and their top-level declarations kept,
+// so workspace/symbol can find a const, func, op, or type in a file that
+// isn't open. Open documents are searched as they are in the editor, not
+// as they were indexed.
+
+// maxIndexedFiles bounds how many files the index reads
+const maxIndexedFiles = 5000
+
+// maxIndexedEntries bounds how many files and directories the index
+// walks, so a root like a home directory doesn't keep it busy
+const maxIndexedEntries = 100000
+
+// maxIndexedFileSize skips files too large to be hand-written queries
+const maxIndexedFileSize = 1 << 20
+
+// maxWorkspaceSymbols caps the symbols one workspace/symbol request returns
+const maxWorkspaceSymbols = 200
+
+// skippedDirs are directories the index never descends into
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+}
+
+// workspaceIndex holds the declarations of the .spq files in a workspace,
+// by URI
+type workspaceIndex struct {
+	mu      sync.Mutex
+	symbols map[string][]SymbolInformation
+	wg      sync.WaitGroup
+}
+
+func newWorkspaceIndex() *workspaceIndex {
+	return &workspaceIndex{symbols: make(map[string][]SymbolInformation)}
+}
+
+// build indexes the files under root in the background, reporting
+// progress on p, which it ends
+func (x *workspaceIndex) build(root string, p *workDone) {
+	if root == "" {
+		p.end("")
+		return
+	}
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		n := walkQueryFiles(root, p, func(path, text string) {
+			x.update(pathToURI(path), text)
+		})
+		p.end("Indexed " + fileCount(n))
+		log.Printf("Indexed %d query files under %s", len(x.uris()), root)
+	}()
+}
+
+// walkQueryFiles calls visit with the path and text of each .spq file
+// under root, in lexical order, reporting progress on p and stopping if
+// it is cancelled. It returns how many files it visited.
+func walkQueryFiles(root string, p *workDone, visit func(path, text string)) int {
+	files, entries, visited := 0, 0, 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if p.cancelled() {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if entries++; entries > maxIndexedEntries {
+			return filepath.SkipAll
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || skippedDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".spq") {
+			return nil
+		}
+		if files++; files > maxIndexedFiles {
+			return filepath.SkipAll
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		visit(path, string(data))
+		visited++
+		p.report(fileCount(visited), -1)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Walking %s: %v", root, err)
+	}
+	return visited
+}
+
+// wait blocks until a build in progress finishes
+func (x *workspaceIndex) wait() {
+	x.wg.Wait()
+}
+
+// update replaces the declarations indexed for uri with those in text
+func (x *workspaceIndex) update(uri, text string) {
+	symbols := declSymbols(uri, text)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.symbols[uri] = symbols
+}
+
+// remove drops the declarations indexed for uri
+func (x *workspaceIndex) remove(uri string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.symbols, uri)
+}
+
+// forget drops the files indexed under root, except those that another
+// folder, as folderOf tells, still holds
+func (x *workspaceIndex) forget(root string, folderOf func(string) string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for uri := range x.symbols {
+		path, err := uriToPath(uri)
+		if err != nil {
+			continue
+		}
+		if _, ok := relativeTo(root, path); ok && folderOf(path) == "" {
+			delete(x.symbols, uri)
+		}
+	}
+}
+
+// uris returns the indexed URIs
+func (x *workspaceIndex) uris() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	uris := make([]string, 0, len(x.symbols))
+	for uri := range x.symbols {
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// size returns how many files are indexed
+func (x *workspaceIndex) size() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.symbols)
+}
+
+// declSymbols returns the top-level declarations of text as symbols in uri
+func declSymbols(uri, text string) []SymbolInformation {
+	var symbols []SymbolInformation
+	for _, sym := range documentSymbols(text) {
+		if sym.Kind == SymbolKindEvent {
+			continue
+		}
+		symbols = append(symbols, SymbolInformation{
+			Name:     sym.Name,
+			Kind:     sym.Kind,
+			Location: Location{URI: uri, Range: sym.SelectionRange},
+		})
+	}
+	return symbols
+}
+
+// workspaceSymbols returns the declarations whose names contain query,
+// ignoring case, from the open documents and the index
+func (s *Server) workspaceSymbols(query string) []SymbolInformation {
+	query = strings.ToLower(query)
+	var all []SymbolInformation
+	open := make(map[string]bool)
+	s.docMu.RLock()
+	for uri, text := range s.documents {
+		open[uri] = true
+		if !s.isDataFile(uri) {
+			all = append(all, declSymbols(uri, text)...)
+		}
+	}
+	s.docMu.RUnlock()
+	s.index.mu.Lock()
+	for uri, symbols := range s.index.symbols {
+		if !open[uri] {
+			all = append(all, symbols...)
+		}
+	}
+	s.index.mu.Unlock()
+
+	matches := []SymbolInformation{}
+	for _, sym := range all {
+		if strings.Contains(strings.ToLower(sym.Name), query) {
+			matches = append(matches, sym)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Location.URI != b.Location.URI {
+			return a.Location.URI < b.Location.URI
+		}
+		return a.Location.Range.Start.Line < b.Location.Range.Start.Line
+	})
+	if len(matches) > maxWorkspaceSymbols {
+		matches = matches[:maxWorkspaceSymbols]
+	}
+	return matches
+}
+
+// appendWorkspaceTypes appends to items the types declared in the
+// workspace's other query files whose names match the word typed at pos,
+// when pos is where a type goes. Types items already has are left out,
+// so a type the document declares shows once.
+func (s *Server) appendWorkspaceTypes(items []CompletionItem, uri, text string, pos Position) []CompletionItem {
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) || getCompletionContext(line, pos.Character) != contextType {
+		return items
+	}
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return items
+	}
+	start := pos.Character
+	for start > 0 && isIdentifierChar(line[start-1]) {
+		start--
+	}
+	prefix := strings.ToLower(line[start:pos.Character])
+	rc := rankContextAt(text, offset, prefix)
+
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.Kind == CompletionItemKindClass {
+			seen[item.Label] = true
+		}
+	}
+	for _, sym := range s.workspaceSymbols(prefix) {
+		if sym.Kind != SymbolKindClass || sym.Location.URI == uri || seen[sym.Name] {
+			continue
+		}
+		m, ok := matchWord(strings.ToLower(sym.Name), prefix)
+		if !ok {
+			continue
+		}
+		seen[sym.Name] = true
+		item := CompletionItem{
+			Label:    sym.Name,
+			Kind:     CompletionItemKindClass,
+			Detail:   "type in " + path.Base(sym.Location.URI),
+			SortText: rc.rank(sym.Name, rankDeclared, KindType).sortText(sym.Name),
+		}
+		m.apply(&item)
+		items = append(items, item)
+	}
+	return items
+}
+
+// handleWorkspaceSymbol processes workspace/symbol requests
+func (s *Server) handleWorkspaceSymbol(msg RPCMessage) HandlerResult {
+	var params WorkspaceSymbolParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	return success(s.workspaceSymbols(params.Query))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWorkspaceSymbols(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, text string) {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("lib/ops.spq", "op top_hosts n: ( count() by host | sort -r count | head n )\nfn double(x): ( x * 2 )\nvalues 1")
+	write("consts.spq", "const threshold = 10\nvalues threshold")
+	write(".git/hidden.spq", "const hidden_const = 1\nvalues 1")
+	write("data.sup", "{a:1}")
+
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatal(err)
+	}
+	h.server.out = &bytes.Buffer{}
+	if _, err := h.ProcessRequest(nil, "initialized", struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	h.server.index.wait()
+
+	// An open buffer is searched as edited, not as indexed
+	opened := pathToURI(filepath.Join(root, "consts.spq"))
+	h.openDocument(t, opened, "const threshold_high = 20\nvalues threshold_high")
+
+	symbols := workspaceSymbolSearch(t, h, "THRESH")
+	if len(symbols) != 1 || symbols[0].Name != "threshold_high" || symbols[0].Location.URI != opened {
+		t.Errorf("Expected only the open buffer's const, got %+v", symbols)
+	}
+
+	symbols = workspaceSymbolSearch(t, h, "top")
+	if len(symbols) != 1 || symbols[0].Kind != SymbolKindOperator {
+		t.Fatalf("Expected the indexed op, got %+v", symbols)
+	}
+	want := Range{Start: Position{Line: 0, Character: 3}, End: Position{Line: 0, Character: 12}}
+	if symbols[0].Location.Range != want {
+		t.Errorf("Expected the op's name at %+v, got %+v", want, symbols[0].Location.Range)
+	}
+
+	if symbols := workspaceSymbolSearch(t, h, "hidden"); len(symbols) != 0 {
+		t.Errorf("Expected hidden directories to be skipped, got %+v", symbols)
+	}
+	if symbols := workspaceSymbolSearch(t, h, ""); len(symbols) != 3 {
+		t.Errorf("Expected every declaration for an empty query, got %+v", symbols)
+	}
+}
+
+func workspaceSymbolSearch(t *testing.T, h *TestHelper, query string) []SymbolInformation {
+	t.Helper()
+	response, err := h.ProcessRequest(1, "workspace/symbol", WorkspaceSymbolParams{Query: query})
+	if err != nil {
+		t.Fatalf("workspace/symbol failed: %v", err)
+	}
+	data, _ := json.Marshal(response.Result)
+	var symbols []SymbolInformation
+	if err := json.Unmarshal(data, &symbols); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	return symbols
+}
+
+func TestWorkspaceFolders(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(a, "a.spq"), []byte("const from_a = 1\nvalues from_a"), 0o644)
+	os.WriteFile(filepath.Join(b, "b.spq"), []byte("const from_b = 1\nvalues from_b"), 0o644)
+
+	h := NewTestHelper()
+	params := InitializeParams{WorkspaceFolders: []WorkspaceFolder{{URI: pathToURI(a), Name: "a"}}}
+	if _, err := h.ProcessRequest(1, "initialize", params); err != nil {
+		t.Fatal(err)
+	}
+	h.server.out = &bytes.Buffer{}
+	h.ProcessRequest(nil, "initialized", struct{}{})
+	h.server.index.wait()
+	if symbols := workspaceSymbolSearch(t, h, "from_"); len(symbols) != 1 || symbols[0].Name != "from_a" {
+		t.Fatalf("Expected the first folder indexed, got %+v", symbols)
+	}
+
+	change := func(added, removed []WorkspaceFolder) {
+		t.Helper()
+		_, err := h.ProcessNotification("workspace/didChangeWorkspaceFolders", DidChangeWorkspaceFoldersParams{
+			Event: WorkspaceFoldersChangeEvent{Added: added, Removed: removed},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.server.index.wait()
+	}
+	change([]WorkspaceFolder{{URI: pathToURI(b), Name: "b"}}, nil)
+	if symbols := workspaceSymbolSearch(t, h, "from_"); len(symbols) != 2 {
+		t.Errorf("Expected both folders indexed, got %+v", symbols)
+	}
+
+	change(nil, []WorkspaceFolder{{URI: pathToURI(a), Name: "a"}})
+	if symbols := workspaceSymbolSearch(t, h, "from_"); len(symbols) != 1 || symbols[0].Name != "from_b" {
+		t.Errorf("Expected the removed folder dropped from the index, got %+v", symbols)
+	}
+	if h.server.rootPath != b {
+		t.Errorf("Expected %s to become the root, got %s", b, h.server.rootPath)
+	}
+}
+
+func TestFolderOf(t *testing.T) {
+	s := NewServer()
+	s.setWorkspaceFolders([]string{"/work", "/work/nested", "/other"})
+	tests := []struct {
+		file, folder, rel string
+	}{
+		{"/work/q.spq", "/work", "q.spq"},
+		{"/work/nested/lib/q.spq", "/work/nested", "lib/q.spq"},
+		{"/other/q.spq", "/other", "q.spq"},
+		{"/elsewhere/q.spq", "", ""},
+	}
+	for _, tt := range tests {
+		if got := s.folderOf(tt.file); got != tt.folder {
+			t.Errorf("%s: expected folder %q, got %q", tt.file, tt.folder, got)
+		}
+		if got := s.folderRelative(tt.file); got != tt.rel {
+			t.Errorf("%s: expected %q relative to its folder, got %q", tt.file, tt.rel, got)
+		}
+	}
+}
+
+func TestDidChangeWatchedFiles(t *testing.T) {
+	root := t.TempDir()
+	h := NewTestHelper()
+	params := InitializeParams{RootURI: pathToURI(root)}
+	params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration = true
+	if _, err := h.ProcessRequest(1, "initialize", params); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	h.server.out = out
+	h.ProcessRequest(nil, "initialized", struct{}{})
+	h.server.index.wait()
+	var registered bool
+	for _, msg := range drainMessages(t, out) {
+		registered = registered || msg.Method == "client/registerCapability"
+	}
+	if !registered {
+		t.Fatal("Expected the file watchers registered")
+	}
+
+	// An open query reading a file that isn't there yet
+	query := pathToURI(filepath.Join(root, "q.spq"))
+	h.openDocument(t, query, "from conn.json | count()")
+	out.Reset()
+
+	lib := filepath.Join(root, "lib.spq")
+	os.WriteFile(lib, []byte("const watched_const = 1\nvalues watched_const"), 0o644)
+	os.WriteFile(filepath.Join(root, "conn.json"), []byte("{}"), 0o644)
+	changed := func(path string, typ int) {
+		t.Helper()
+		_, err := h.ProcessNotification("workspace/didChangeWatchedFiles", DidChangeWatchedFilesParams{
+			Changes: []FileEvent{{URI: pathToURI(path), Type: typ}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	changed(lib, FileChangeTypeCreated)
+	if symbols := workspaceSymbolSearch(t, h, "watched_"); len(symbols) != 1 {
+		t.Errorf("Expected the new query indexed, got %+v", symbols)
+	}
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 || msgs[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected the open query's diagnostics republished, got %+v", msgs)
+	}
+	var published PublishDiagnosticsParams
+	json.Unmarshal(msgs[0].Params, &published)
+	if len(published.Diagnostics) != 0 {
+		t.Errorf("Expected the missing source cleared, got %+v", published.Diagnostics)
+	}
+
+	os.Remove(lib)
+	changed(lib, FileChangeTypeDeleted)
+	if symbols := workspaceSymbolSearch(t, h, "watched_"); len(symbols) != 0 {
+		t.Errorf("Expected the deleted query dropped from the index, got %+v", symbols)
+	}
+}
+
+func TestWorkspaceTypeCompletions(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "types.spq"), []byte("type port = uint16\ntype conn = {port:port}\nvalues 1"), 0o644)
+	os.WriteFile(filepath.Join(root, "other.spq"), []byte("const porter = 1\nvalues 1"), 0o644)
+
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatal(err)
+	}
+	h.server.out = &bytes.Buffer{}
+	h.ProcessRequest(nil, "initialized", struct{}{})
+	h.server.index.wait()
+
+	uri := pathToURI(filepath.Join(root, "q.spq"))
+	text := "type flow = {src:conn, dst:port}\ntype pair = {a:flow, b:po\nvalues 1"
+	h.openDocument(t, uri, text)
+	complete := func(line, col int) []CompletionItem {
+		response, _ := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		})
+		var list CompletionList
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &list)
+		return list.Items
+	}
+
+	items := complete(1, len("type pair = {a:flow, b:po"))
+	if got := completionLabels(items); !slices.Equal(got, []string{"port"}) || items[0].Detail != "type in types.spq" {
+		t.Errorf("Expected the indexed port type, got %+v", items)
+	}
+	labels := completionLabels(complete(1, len("type pair = {a:")))
+	for _, want := range []string{"flow", "conn", "port", "string"} {
+		if !slices.Contains(labels, want) {
+			t.Errorf("Expected %s among the types, got %v", want, labels)
+		}
+	}
+	if slices.Contains(labels, "porter") {
+		t.Errorf("Expected no consts among the types, got %v", labels)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+)
+
+// Inlay hints. Two kinds: the type of each expression a put assigns or a
+// values produces, where it can be told without the input, and the names
+// of a builtin's parameters before the arguments of a call to it:
+//
+//	put n := len(s): int64
+//	replace(s, old: "a", new: "b")
+//
+// Types come from literals, casts, operators, and the return types in the
+// signature registry; anything that depends on a field's type gets no
+// hint. Arguments that are names already say what they are, and a
+// function of one parameter needs no reminder, so neither gets a name.
+
+// comparisonOps are the binary operators whose result is a bool
+var comparisonOps = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"and": true, "or": true, "in": true, "like": true, "~": true, "!~": true,
+}
+
+// inferType returns the type of the expression node when it doesn't
+// depend on the input, or ""
+func inferType(node map[string]interface{}) string {
+	switch nodeKind(node) {
+	case "Primitive":
+		typ, _ := node["type"].(string)
+		return typ
+	case "DoubleQuoteExpr", "FStringExpr":
+		return "string"
+	case "TypeValue":
+		return "type"
+	case "UnaryExpr":
+		if node["op"] == "!" {
+			return "bool"
+		}
+		operand, _ := node["operand"].(map[string]interface{})
+		return inferType(operand)
+	case "BinaryExpr":
+		op, _ := node["op"].(string)
+		lhs, _ := node["lhs"].(map[string]interface{})
+		rhs, _ := node["rhs"].(map[string]interface{})
+		switch {
+		case op == "::":
+			return typeValueName(rhs)
+		case comparisonOps[op]:
+			return "bool"
+		case op == "+" || op == "-" || op == "*" || op == "/" || op == "%":
+			if l := inferType(lhs); l != "" && l == inferType(rhs) {
+				return l
+			}
+		}
+	case "CallExpr":
+		fn, _ := node["func"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		b := Builtins.Lookup(name)
+		if b == nil {
+			return ""
+		}
+		if b.Kind == KindType {
+			return b.Name
+		}
+		args, _ := node["args"].([]interface{})
+		if b.Name == "cast" && len(args) == 2 {
+			typ, _ := args[1].(map[string]interface{})
+			return typeValueName(typ)
+		}
+		if b.sig != nil && len(b.sig.Returns) == 1 {
+			if t := Builtins.Lookup(b.sig.Returns[0]); t != nil && t.Kind == KindType {
+				return t.Name
+			}
+		}
+	}
+	return ""
+}
+
+// typeValueName returns the name of the primitive type a type value
+// names, or ""
+func typeValueName(node map[string]interface{}) string {
+	value, _ := node["value"].(map[string]interface{})
+	if nodeKind(node) != "TypeValue" || nodeKind(value) != "TypePrimitive" {
+		return ""
+	}
+	name, _ := value["name"].(string)
+	return name
+}
+
+// isLiteral reports whether node is a literal, whose type is plain to see
+func isLiteral(node map[string]interface{}) bool {
+	switch nodeKind(node) {
+	case "Primitive", "DoubleQuoteExpr", "FStringExpr", "TypeValue":
+		return true
+	case "BinaryExpr":
+		return node["op"] == "::"
+	}
+	return false
+}
+
+// inlayHints returns the hints for text within rng
+func inlayHints(text string, rng Range) []InlayHint {
+	hints := []InlayHint{}
+	tree, ok := parseTree(text)
+	if !ok {
+		return hints
+	}
+	add := func(hint InlayHint) {
+		if !positionLess(hint.Position, rng.Start) && !positionLess(rng.End, hint.Position) {
+			hints = append(hints, hint)
+		}
+	}
+	typeHint := func(expr interface{}) {
+		node, _ := expr.(map[string]interface{})
+		if node == nil || isLiteral(node) {
+			return
+		}
+		if typ := inferType(node); typ != "" {
+			add(InlayHint{
+				Position: locRange(text, node["loc"]).End,
+				Label:    ": " + typ,
+				Kind:     InlayHintKindType,
+			})
+		}
+	}
+
+	walkTree(tree, func(node map[string]interface{}) {
+		switch nodeKind(node) {
+		case "PutOp":
+			args, _ := node["args"].([]interface{})
+			for _, arg := range args {
+				arg, _ := arg.(map[string]interface{})
+				typeHint(arg["rhs"])
+			}
+		case "ValuesOp":
+			exprs, _ := node["exprs"].([]interface{})
+			for _, expr := range exprs {
+				typeHint(expr)
+			}
+		case "CallExpr":
+			fn, _ := node["func"].(map[string]interface{})
+			name, _ := fn["name"].(string)
+			b := Builtins.Lookup(name)
+			if b == nil || b.sig == nil || len(b.sig.Params) < 2 {
+				return
+			}
+			args, _ := node["args"].([]interface{})
+			for i, arg := range args {
+				arg, _ := arg.(map[string]interface{})
+				if i >= len(b.sig.Params) && !b.sig.Params[len(b.sig.Params)-1].Variadic {
+					break
+				}
+				if nodeKind(arg) == "IDExpr" || arg["loc"] == nil {
+					continue
+				}
+				param := b.sig.Params[min(i, len(b.sig.Params)-1)]
+				add(InlayHint{
+					Position:     locRange(text, arg["loc"]).Start,
+					Label:        param.Name + ":",
+					Kind:         InlayHintKindParameter,
+					PaddingRight: true,
+				})
+			}
+		}
+	})
+	sort.SliceStable(hints, func(i, j int) bool {
+		return positionLess(hints[i].Position, hints[j].Position)
+	})
+	return hints
+}
+
+// handleInlayHint processes textDocument/inlayHint requests
+func (s *Server) handleInlayHint(msg RPCMessage) HandlerResult {
+	var params InlayHintParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success([]InlayHint{})
+	}
+	hints := inlayHints(text, s.rangeFromClient(text, params.Range))
+	for i := range hints {
+		hints[i].Position = s.toClient(text, hints[i].Position)
+	}
+	return success(hints)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlayHints(t *testing.T) {
+	text := "values 1, a == 1, upper(s)\n| put n := len(s), m := x, k := int64(x) + 1, r := replace(s, \"a\", \"b\")"
+	whole := Range{End: positionAt(text, len(text))}
+	// Each hint after the four bytes before it
+	var got []string
+	for _, h := range inlayHints(text, whole) {
+		offset, _ := offsetAt(text, h.Position)
+		got = append(got, text[max(0, offset-4):offset]+"^"+h.Label)
+	}
+	want := []string{
+		"== 1^: bool",
+		"r(s)^: string",
+		"n(s)^: int64",
+		" + 1^: int64",
+		"(s, ^old:",
+		"a\", ^new:",
+		"\"b\")^: string",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Hints:\nexpected %q\ngot      %q", want, got)
+	}
+
+	// Only hints in the requested range
+	line0 := Range{End: Position{Line: 0, Character: 99}}
+	if hints := inlayHints(text, line0); len(hints) != 2 {
+		t.Errorf("Expected the two hints on the first line, got %+v", hints)
+	}
+}
+
+func TestInferType(t *testing.T) {
+	tests := map[string]string{
+		"x::string":           "string",
+		"cast(x, <ip>)":       "ip",
+		"!a":                  "bool",
+		"-1.5":                "float64",
+		"1 + 2.0":             "",
+		"a + 1":               "",
+		"strftime(\"%Y\", t)": "string",
+		"abs(x)":              "",
+		"f\"{a}\"":            "string",
+		"a in [1,2]":          "bool",
+	}
+	for expr, want := range tests {
+		tree, ok := parseTree("values " + expr)
+		if !ok {
+			t.Fatalf("%s doesn't parse", expr)
+		}
+		op, _ := topLevelStages(tree)[0].(map[string]interface{})
+		exprs, _ := op["exprs"].([]interface{})
+		node, _ := exprs[0].(map[string]interface{})
+		if got := inferType(node); got != want {
+			t.Errorf("%s: expected %q, got %q", expr, want, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Guard rails for queries that write to a lake. Such queries get a warning
+// on each writing operator when a lake is configured, run from the editor
+// only when the client opts in with allowLakeWrites, and even then only
+// after the user confirms each run. Commands that run a query more than
+// once, like diffs and sample checks, never run them.
+
+// lakeWriteOps maps the AST kinds of operators that change a lake to their
+// names. Deleting data is a super db command, not query syntax, so load is
+// the only one at this parser commit.
+var lakeWriteOps = map[string]string{
+	"LoadOp": "load",
+}
+
+// Titles of the confirmation prompt's buttons
+const (
+	lakeWriteRun    = "Run"
+	lakeWriteCancel = "Cancel"
+)
+
+// lakeWrite is an operator in a query that changes a lake
+type lakeWrite struct {
+	operator string
+	rng      Range
+}
+
+// findLakeWrites returns the operators in text that write to a lake,
+// wherever they are nested. A query that doesn't parse has none, as it
+// can't run.
+func findLakeWrites(text string) []lakeWrite {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var writes []lakeWrite
+	walkTree(tree, func(node map[string]interface{}) {
+		if op, ok := lakeWriteOps[nodeKind(node)]; ok {
+			writes = append(writes, lakeWrite{operator: op, rng: locRange(text, node["loc"])})
+		}
+	})
+	return writes
+}
+
+// locRange converts an AST node's loc, whose last offset is inclusive, to
+// a range in text
+func locRange(text string, loc interface{}) Range {
+	m, _ := loc.(map[string]interface{})
+	first, _ := m["first"].(float64)
+	last, _ := m["last"].(float64)
+	return Range{Start: positionAt(text, int(first)), End: positionAt(text, int(last)+1)}
+}
+
+// lakeWriteDiagnostics warns about each operator in text that writes to
+// the configured lake
+func (s *Server) lakeWriteDiagnostics(text string) []Diagnostic {
+	lake := s.settings().Lake
+	if lake == "" {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	for _, w := range findLakeWrites(text) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    w.rng,
+			Severity: DiagnosticSeverityWarning,
+			Code:     "lake-write",
+			Source:   "superdb-lsp",
+			Message:  s.messages.format("lake-write", "operator", w.operator, "lake", lake),
+		})
+	}
+	return diagnostics
+}
+
+// confirmLakeWrite handles superdb.runQuery for a query that writes to the
+// lake. Unless the client opted in it refuses; otherwise it asks the user
+// to confirm and returns a pending result right away. The query's values
+// follow in a superdb/queryResult notification once the user answers. The
+// answer arrives on the main loop, so a confirmed write runs in the
+// background rather than holding up every other message.
+func (s *Server) confirmLakeWrite(params RunQueryArgs, text string, writes []lakeWrite) HandlerResult {
+	ops := make([]string, 0, len(writes))
+	for _, w := range writes {
+		ops = append(ops, w.operator)
+	}
+	settings := s.settings()
+	if !settings.AllowLakeWrites {
+		return failure(&RPCError{
+			Code: RequestFailed,
+			Message: fmt.Sprintf("query writes to the lake (%s); set allowLakeWrites in initializationOptions to run it",
+				strings.Join(ops, ", ")),
+		})
+	}
+
+	uri, max := params.URI, params.MaxValues
+	prompt := ShowMessageRequestParams{
+		Type:    MessageTypeWarning,
+		Message: fmt.Sprintf("This query writes to the lake at %s (%s). Run it?", settings.Lake, strings.Join(ops, ", ")),
+		Actions: []MessageActionItem{{Title: lakeWriteRun}, {Title: lakeWriteCancel}},
+	}
+	err := s.request("window/showMessageRequest", prompt, func(msg RPCMessage) {
+		var choice MessageActionItem
+		if data, err := json.Marshal(msg.Result); err == nil {
+			json.Unmarshal(data, &choice)
+		}
+		if msg.Error != nil || choice.Title != lakeWriteRun {
+			log.Printf("Lake write cancelled: %s", uri)
+			s.sendQueryResult(QueryResultParams{URI: uri, Values: []json.RawMessage{}, Done: true, Error: "cancelled"})
+			return
+		}
+		s.lakeWrites.Add(1)
+		go func() {
+			defer s.lakeWrites.Done()
+			log.Printf("Running lake write: %s", uri)
+			result, _ := s.queryResult(s.ctx, uri, text, max)
+			s.sendQueryResult(result)
+		}()
+	})
+	if err != nil {
+		return failure(err)
+	}
+	return success(QueryResultParams{URI: uri, Values: []json.RawMessage{}, Done: false})
+}
+
+// sendQueryResult sends result in a superdb/queryResult notification
+func (s *Server) sendQueryResult(result QueryResultParams) {
+	note, err := notification("superdb/queryResult", result)
+	if err == nil {
+		err = s.send(note)
+	}
+	if err != nil {
+		log.Printf("Error sending query result for %s: %v", result.URI, err)
+	}
+}
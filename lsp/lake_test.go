@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindLakeWrites(t *testing.T) {
+	text := "values {x:1}\n| load logs@main"
+	writes := findLakeWrites(text)
+	want := Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 1, Character: 16}}
+	if len(writes) != 1 || writes[0].operator != "load" || writes[0].rng != want {
+		t.Errorf("Expected load at %+v, got %+v", want, writes)
+	}
+
+	for _, text := range []string{"values 1 | sort this", "from logs | load", ""} {
+		if writes := findLakeWrites(text); len(writes) != 0 {
+			t.Errorf("Expected no lake writes in %q, got %+v", text, writes)
+		}
+	}
+}
+
+func TestLakeWriteDiagnostics(t *testing.T) {
+	s := NewServer()
+	text := "values 1 | load logs"
+	if diags := s.lakeWriteDiagnostics(text); len(diags) != 0 {
+		t.Errorf("Expected no warnings without a lake, got %+v", diags)
+	}
+
+	s.config.Lake = "/data/lake"
+	diags := s.lakeWriteDiagnostics(text)
+	if len(diags) != 1 {
+		t.Fatalf("Expected one warning, got %+v", diags)
+	}
+	d := diags[0]
+	if d.Severity != DiagnosticSeverityWarning || d.Code != "lake-write" || !strings.Contains(d.Message, "/data/lake") {
+		t.Errorf("Unexpected warning: %+v", d)
+	}
+}
+
+func TestRunQueryRefusesLakeWritesWithoutOptIn(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///write.spq"
+	h.openDocument(t, uri, "values 1 | load logs")
+
+	args, _ := json.Marshal(RunQueryArgs{URI: uri})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRunQuery,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != RequestFailed || !strings.Contains(response.Error.Message, "allowLakeWrites") {
+		t.Errorf("Expected the run to be refused, got %+v", response)
+	}
+}
+
+func TestRunQueryConfirmsLakeWrites(t *testing.T) {
+	for _, tt := range []struct {
+		choice    string
+		wantError string
+	}{
+		{lakeWriteCancel, "cancelled"},
+		{lakeWriteRun, "opening lake"}, // the lake doesn't exist, so the run fails
+	} {
+		t.Run(tt.choice, func(t *testing.T) {
+			h := NewTestHelper()
+			out := &bytes.Buffer{}
+			h.server.out = out
+			lake := filepath.Join(t.TempDir(), "lake")
+			opts, _ := json.Marshal(InitializationOptions{Lake: lake, AllowLakeWrites: true})
+			if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+				t.Fatalf("initialize failed: %v", err)
+			}
+			uri := "file:///write.spq"
+			h.openDocument(t, uri, "values 1 | load logs")
+			out.Reset()
+
+			args, _ := json.Marshal(RunQueryArgs{URI: uri})
+			response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+				Command:   CommandRunQuery,
+				Arguments: []json.RawMessage{args},
+			})
+			if err != nil {
+				t.Fatalf("executeCommand failed: %v", err)
+			}
+			if response.Error != nil || response.Result.(map[string]interface{})["done"] != false {
+				t.Fatalf("Expected a pending result, got %+v", response)
+			}
+
+			msgs := drainMessages(t, out)
+			if len(msgs) != 1 || msgs[0].Method != "window/showMessageRequest" || msgs[0].ID == nil {
+				t.Fatalf("Expected a confirmation request, got %+v", msgs)
+			}
+			var prompt ShowMessageRequestParams
+			json.Unmarshal(msgs[0].Params, &prompt)
+			if prompt.Type != MessageTypeWarning || len(prompt.Actions) != 2 {
+				t.Errorf("Unexpected prompt: %+v", prompt)
+			}
+
+			reply, _ := json.Marshal(RPCMessage{JSONRPC: "2.0", ID: msgs[0].ID, Result: MessageActionItem{Title: tt.choice}})
+			if response, err := h.server.handleMessage(reply); err != nil || response != nil {
+				t.Fatalf("Expected the reply to be consumed, got %+v, %v", response, err)
+			}
+			h.server.lakeWrites.Wait()
+			msgs = drainMessages(t, out)
+			if len(msgs) != 1 || msgs[0].Method != "superdb/queryResult" {
+				t.Fatalf("Expected a superdb/queryResult notification, got %+v", msgs)
+			}
+			var result QueryResultParams
+			json.Unmarshal(msgs[0].Params, &result)
+			if !result.Done || !strings.Contains(result.Error, tt.wantError) {
+				t.Errorf("Expected a finished result with error %q, got %+v", tt.wantError, result)
+			}
+		})
+	}
+}
+
+func TestResponseToUnknownRequestIsIgnored(t *testing.T) {
+	s := NewServer()
+	reply, _ := json.Marshal(RPCMessage{JSONRPC: "2.0", ID: "superdb-99", Result: nil})
+	if response, err := s.handleMessage(reply); err != nil || response != nil {
+		t.Errorf("Expected no response, got %+v, %v", response, err)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+// Limits guard the server against pathological clients and files, so a
+// bad message or document can't make it allocate gigabytes or spin forever.
+// Go's regexp package runs in time linear in its input, so error-message
+// patterns only need their input capped, not a timeout.
+type Limits struct {
+	MaxMessageSize int64 // largest Content-Length accepted, in bytes
+	MaxFormatSize  int   // largest document the formatter will process, in bytes
+}
+
+const (
+	defaultMaxMessageSize = 64 << 20
+	defaultMaxFormatSize  = 4 << 20
+
+	// maxErrorMessageLen caps how much of an error message the position
+	// patterns scan
+	maxErrorMessageLen = 4096
+)
+
+// DefaultLimits returns the limits used unless overridden on the command line
+func DefaultLimits() Limits {
+	return Limits{
+		MaxMessageSize: defaultMaxMessageSize,
+		MaxFormatSize:  defaultMaxFormatSize,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Linked editing. In a record literal, a field name is often both a key
+// and read in the values beside it, as in {foo: x, bar: foo}. With the
+// cursor on such a name, each of its occurrences in the innermost record
+// literal is linked, so typing over one edits them all. Keys of records
+// nested inside it are their own scope and aren't linked; reads anywhere
+// within it are. A dotted path like foo.x links by its first name, the
+// field it reads from.
+
+// linkedWordPattern is what a linked name may be edited into
+const linkedWordPattern = `[A-Za-z_][A-Za-z0-9_]*`
+
+// linkedRanges returns the occurrences of the field name at pos in the
+// innermost record literal holding it, or nil if there are fewer than two
+func linkedRanges(text string, pos Position) []Range {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var records []Range
+	walkTree(tree, func(node map[string]interface{}) {
+		if nodeKind(node) == "RecordExpr" {
+			records = append(records, locRange(text, node["loc"]))
+		}
+	})
+	// innermost returns the smallest record holding p
+	innermost := func(p Position) (Range, bool) {
+		var best Range
+		found := false
+		for _, rng := range records {
+			if rangeContains(rng, p) && (!found || rangeContains(best, rng.Start) && rangeContains(best, rng.End)) {
+				best, found = rng, true
+			}
+		}
+		return best, found
+	}
+
+	table := symbolTableOf(text, tree)
+	var at *fieldRef
+	for i, f := range table.fields {
+		if rangeContains(f.rng, pos) {
+			at = &table.fields[i]
+			break
+		}
+	}
+	if at == nil || strings.Contains(at.path, ".") {
+		return nil
+	}
+	record, ok := innermost(pos)
+	if !ok {
+		return nil
+	}
+
+	var ranges []Range
+	for _, f := range table.fields {
+		if f.path != at.path || !rangeContains(record, f.rng.Start) {
+			continue
+		}
+		if f.alias {
+			if key, _ := innermost(f.rng.Start); key != record {
+				continue
+			}
+		}
+		ranges = append(ranges, f.rng)
+	}
+	if len(ranges) < 2 {
+		return nil
+	}
+	return ranges
+}
+
+// handleLinkedEditingRange processes textDocument/linkedEditingRange
+// requests
+func (s *Server) handleLinkedEditingRange(msg RPCMessage) HandlerResult {
+	var params LinkedEditingRangeParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success(nil)
+	}
+	ranges := linkedRanges(text, s.fromClient(text, params.Position))
+	if ranges == nil {
+		return success(nil)
+	}
+	for i, rng := range ranges {
+		ranges[i] = s.rangeToClient(text, rng)
+	}
+	return success(LinkedEditingRanges{Ranges: ranges, WordPattern: linkedWordPattern})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLinkedRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		at   string // the cursor is on the first occurrence
+		want []string
+	}{
+		{"key and read", "values {foo: 1, bar: foo}", "foo", []string{"foo", "foo"}},
+		{"from the read", "values {foo: 1, bar: foo + foo}", "foo + ", []string{"foo", "foo", "foo"}},
+		{"nested key is its own scope", "values {foo: 1, bar: {foo: foo}}", "foo: 1", []string{"foo", "foo"}},
+		{"alone", "values {foo: 1, bar: 2}", "foo", nil},
+		{"outside a record", "values foo + foo", "foo", nil},
+		{"dotted path", "values {foo: 1, bar: foo.x}", "foo", []string{"foo", "foo"}},
+		{"inside a dotted path", "values {x: 1, bar: foo.x}", ".x", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := linkedRanges(tt.text, posOf(t, tt.text, tt.at, 1, 1))
+			if len(ranges) != len(tt.want) {
+				t.Fatalf("Expected %d ranges, got %+v", len(tt.want), ranges)
+			}
+			for i, rng := range ranges {
+				if got := rangeText(tt.text, rng); got != tt.want[i] {
+					t.Errorf("Range %d: expected %q, got %q", i, tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkedEditingRangeRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///q.spq"
+	text := "values {foo: 1, bar: foo}"
+	h.openDocument(t, uri, text)
+
+	resp, err := h.ProcessRequest(1, "textDocument/linkedEditingRange", LinkedEditingRangeParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "foo", 2, 1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var linked LinkedEditingRanges
+	data, _ := json.Marshal(resp.Result)
+	if err := json.Unmarshal(data, &linked); err != nil || len(linked.Ranges) != 2 || linked.WordPattern == "" {
+		t.Errorf("Expected both occurrences of foo linked, got %s", data)
+	}
+}
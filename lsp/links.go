@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"path/filepath"
+)
+
+// Document links. The sources a query reads become links: a file path
+// like from data/conn.sup opens the file, resolved against the query's
+// directory when it is relative, and an http or https URL opens in the
+// browser. A bare name like from logs is a pool, not a file, and isn't
+// linked; a name with a slash or an extension is taken for a file.
+
+// documentLinks returns the links for the sources text reads. dir is the
+// directory relative file paths resolve against, or "" if there is none.
+func documentLinks(text, dir string) []DocumentLink {
+	names, ok := sourceNames(text)
+	if !ok {
+		return nil
+	}
+	links := []DocumentLink{}
+	for _, src := range names {
+		if target, tooltip, ok := sourceTarget(src.name, dir); ok {
+			links = append(links, DocumentLink{Range: src.rng, Target: target, Tooltip: tooltip})
+		}
+	}
+	return links
+}
+
+// sourceTarget returns what the source name links to, and a tooltip, or
+// false when it is a pool or a URL that no editor opens
+func sourceTarget(name, dir string) (string, string, bool) {
+	if u, err := url.Parse(name); err == nil && len(u.Scheme) > 1 {
+		switch u.Scheme {
+		case "http", "https":
+			return name, "Open " + name, true
+		case "file":
+			return name, "Open data file", true
+		}
+		return "", "", false
+	}
+	if !looksLikeFile(name) {
+		return "", "", false
+	}
+	file := filepath.FromSlash(name)
+	if !filepath.IsAbs(file) {
+		if dir == "" {
+			return "", "", false
+		}
+		file = filepath.Join(dir, file)
+	}
+	return pathToURI(file), "Open data file", true
+}
+
+// handleDocumentLink processes textDocument/documentLink requests
+func (s *Server) handleDocumentLink(msg RPCMessage) HandlerResult {
+	var params DocumentLinkParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	uri := params.TextDocument.URI
+	s.promote(uri)
+	text, _, ok := s.document(uri)
+	if !ok {
+		log.Printf("Document not found: %s", uri)
+		return success(nil)
+	}
+	if s.isDataFile(uri) {
+		return success([]DocumentLink{})
+	}
+	dir := s.rootPath
+	if file, err := uriToPath(uri); err == nil {
+		dir = filepath.Dir(file)
+	}
+	links := documentLinks(text, dir)
+	for i := range links {
+		links[i].Range = s.rangeToClient(text, links[i].Range)
+	}
+	return success(links)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDocumentLinks(t *testing.T) {
+	tests := []struct {
+		query, name, target string
+	}{
+		{"from data/conn.sup | head 1", "data/conn.sup", "file:///work/queries/data/conn.sup"},
+		{`from "a b.json"`, "a b.json", "file:///work/queries/a%20b.json"},
+		{"from /abs/x.sup", "/abs/x.sup", "file:///abs/x.sup"},
+		{"from https://example.com/a.json", "https://example.com/a.json", "https://example.com/a.json"},
+		{"select * from 'out/t.csv'", "out/t.csv", "file:///work/queries/out/t.csv"},
+		{"from s3://bucket/a.json", "", ""},
+		{"from logs | count()", "", ""},
+	}
+	for _, tt := range tests {
+		links := documentLinks(tt.query, "/work/queries")
+		if tt.name == "" {
+			if len(links) != 0 {
+				t.Errorf("%s: expected no links, got %+v", tt.query, links)
+			}
+			continue
+		}
+		want := Range{Start: posOf(t, tt.query, tt.name, 1, 0), End: posOf(t, tt.query, tt.name, 1, len(tt.name))}
+		if len(links) != 1 || links[0].Target != tt.target || links[0].Range != want {
+			t.Errorf("%s: expected %s at %+v, got %+v", tt.query, tt.target, want, links)
+		}
+	}
+
+	if links := documentLinks("from data.sup", ""); len(links) != 0 {
+		t.Errorf("Expected no relative links without a directory, got %+v", links)
+	}
+}
+
+func TestHandleDocumentLink(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///work/q.spq"
+	h.openDocument(t, uri, "from conn.sup | count()")
+
+	response, err := h.ProcessRequest(1, "textDocument/documentLink", DocumentLinkParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil || response.Error != nil {
+		t.Fatalf("documentLink failed: %v %v", err, response.Error)
+	}
+	var links []DocumentLink
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &links)
+	if len(links) != 1 || links[0].Target != "file:///work/conn.sup" {
+		t.Errorf("Expected a link to conn.sup beside the query, got %+v", links)
+	}
+}
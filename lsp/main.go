@@ -2,29 +2,95 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"strconv"
-	"strings"
+	"sync"
 )
 
 // LSP Server for SuperSQL (SPQ) language
 // Provides diagnostics and completion support using brimdata/super/compiler
 
 func main() {
+	limits := DefaultLimits()
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.BoolVar(showVersion, "v", false, "print the version and exit (shorthand)")
+	flag.Int64Var(&limits.MaxMessageSize, "max-message-size", limits.MaxMessageSize,
+		"largest accepted message, in bytes")
+	flag.IntVar(&limits.MaxFormatSize, "max-format-size", limits.MaxFormatSize,
+		"largest document the formatter will process, in bytes")
+	printReference := flag.Bool("reference", false,
+		"print the markdown language reference and exit")
+	printSchema := flag.Bool("protocol-schema", false,
+		"print the JSON Schema for the superdb/* protocol and exit")
+	check := flag.Bool("check", false,
+		"print diagnostics for the files named as arguments and exit, with status 1 on errors")
+	checkDiff := flag.Bool("diff", false,
+		"with -check, read a unified diff from stdin and check only the lines it changes in the files it names")
+	checkStaged := flag.Bool("staged", false,
+		"with -diff, check the files as staged in git's index rather than in the working tree")
+	checkFormat := flag.Bool("format", false,
+		"with -check, also report files the formatter would change")
+	failOn := flag.String("fail-on", "error",
+		"with -check, the least severe diagnostic that fails: error, warning, info, or hint")
+	flag.Parse()
+
+	if flag.Arg(0) == "hook" {
+		os.Exit(runHook(flag.Args()[1:]))
+	}
+
 	// Handle --version flag
-	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "-v") {
+	if *showVersion {
 		fmt.Printf("superdb-lsp %s\n", FullVersion())
 		os.Exit(0)
 	}
+	if *printReference {
+		fmt.Print(renderReference(Builtins))
+		os.Exit(0)
+	}
+	if *printSchema {
+		schema, err := protocolSchemaJSON()
+		if err != nil {
+			log.Fatalf("Schema error: %v", err)
+		}
+		os.Stdout.Write(schema)
+		os.Exit(0)
+	}
+
+	if *check {
+		opts := checkOptions{format: *checkFormat, failOn: severityNamed(*failOn)}
+		if opts.failOn == 0 {
+			fmt.Fprintf(os.Stderr, "unknown severity %q for -fail-on\n", *failOn)
+			os.Exit(2)
+		}
+		paths := flag.Args()
+		if *checkDiff {
+			var err error
+			if paths, opts.lines, err = parseUnifiedDiff(os.Stdin); err != nil {
+				fmt.Fprintf(os.Stderr, "reading diff: %v\n", err)
+				os.Exit(2)
+			}
+			if *checkStaged {
+				opts.read = readStaged
+			}
+		} else if len(paths) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: superdb-lsp -check file... | superdb-lsp -check -diff < diff")
+			os.Exit(2)
+		}
+		os.Exit(checkFiles(paths, opts, os.Stdout))
+	}
 
 	log.SetOutput(os.Stderr)
 	log.Println("SuperSQL LSP server starting...")
 
 	server := NewServer()
+	server.limits = limits
 	if err := server.Run(os.Stdin, os.Stdout); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
@@ -33,114 +99,341 @@ func main() {
 // Server represents the LSP server
 type Server struct {
 	documents  map[string]string // URI -> content
+	versions   map[string]int    // URI -> document version
+	docMu      sync.RWMutex      // guards documents and versions
 	shutdown   bool
 	initialized bool
+	featuresSent bool
+
+	writer *messageWriter // single writer for the client connection, set by Run
+	gate   *versionGate   // orders diagnostics by document version
+	out    io.Writer      // direct output when no writer is running (tests)
+	outMu  sync.Mutex
+
+	warmup     *warmupCoordinator
+	lakeWrites sync.WaitGroup // confirmed lake writes still running
+	limits     Limits
+
+	rootPath  string          // first workspace folder, if the client sent one
+	folders   []string        // workspace folders, by path
+	foldersMu sync.RWMutex    // guards rootPath and folders
+	index     *workspaceIndex // declarations in the workspace's query files
+	semantic  *semanticCache  // semantic tokens last sent for each document
+	parses    *parseTimes     // how long recent parses took
+
+	dataFields *dataFieldCache // fields of the data files queries go with
+	pools      *poolCache      // pools of the configured lake
+
+	watchFiles       bool   // the client watches .spq and .sup files for the server
+	workDoneProgress bool   // the client shows progress the server reports
+	applyEdits       bool   // the client applies edits the server sends
+	encoding         string // position encoding agreed at initialize
+	client           clientSupport
+
+	config    Settings     // see settings()
+	configMu  sync.RWMutex // guards config
+	usage     *usageStats  // accepted completions, when telemetry is on
+	messages  *catalog     // diagnostic messages in the client's locale
+	crashPath string       // where the last crash report is kept
+
+	orphaned func(pid int) // called once the editor that started the server exits
+
+	requests *requestRegistry // contexts of queued and running requests
+
+	ctx  context.Context // cancelled when Run returns, stopping background work
+	stop context.CancelFunc
+
+	pendingMu sync.Mutex
+	pending   map[string]func(RPCMessage) // outstanding server-to-client requests by ID
+	nextID    int
+
+	failuresMu sync.Mutex
+	failures   map[string]bool // methods whose failure the user was shown
 }
 
 // NewServer creates a new LSP server instance
 func NewServer() *Server {
-	return &Server{
+	s := &Server{
 		documents: make(map[string]string),
+		versions:  make(map[string]int),
+		gate:      newVersionGate(),
+		warmup:    newWarmupCoordinator(),
+		limits:    DefaultLimits(),
+		pending:   make(map[string]func(RPCMessage)),
+		failures:  make(map[string]bool),
+		requests:  newRequestRegistry(),
+		messages:  englishCatalog,
+		config:    defaultSettings(),
+		crashPath: defaultCrashPath(),
+		index:     newWorkspaceIndex(),
+		semantic:  newSemanticCache(),
+		parses:    newParseTimes(),
 	}
+	s.dataFields = newDataFieldCache()
+	s.pools = &poolCache{}
+	s.ctx, s.stop = context.WithCancel(context.Background())
+	s.gate.superseded = s.superseded
+	s.orphaned = s.exitOrphaned
+	return s
 }
 
-// Run starts the server's main loop
-func (s *Server) Run(in io.Reader, out io.Writer) error {
-	reader := bufio.NewReader(in)
+// superseded reports whether a version of uri later than version is
+// stored or has been read
+func (s *Server) superseded(uri string, version int) bool {
+	if _, current, ok := s.document(uri); ok && current > version {
+		return true
+	}
+	return s.requests.newer(uri, version)
+}
 
-	for {
-		msg, err := readMessage(reader)
-		if err != nil {
-			if err == io.EOF {
-				return nil
-			}
-			return fmt.Errorf("reading message: %w", err)
-		}
+// document returns the stored text and version for uri
+func (s *Server) document(uri string) (string, int, bool) {
+	s.docMu.RLock()
+	defer s.docMu.RUnlock()
+	text, ok := s.documents[uri]
+	return text, s.versions[uri], ok
+}
+
+// setDocument stores the text and version for uri
+func (s *Server) setDocument(uri, text string, version int) {
+	s.docMu.Lock()
+	defer s.docMu.Unlock()
+	s.documents[uri] = text
+	s.versions[uri] = version
+}
+
+// deleteDocument forgets uri
+func (s *Server) deleteDocument(uri string) {
+	s.docMu.Lock()
+	defer s.docMu.Unlock()
+	delete(s.documents, uri)
+	delete(s.versions, uri)
+}
+
+// send writes a message to the client. It is safe to call from background
+// goroutines; messages are dropped when no connection is attached.
+func (s *Server) send(msg interface{}) error {
+	if s.writer != nil {
+		return s.writer.send(msg)
+	}
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	if s.out == nil {
+		return nil
+	}
+	if d, ok := msg.(diagnosticsMessage); ok && !s.gate.allow(d.uri, d.version) {
+		return nil
+	}
+	return writeMessage(s.out, msg)
+}
+
+// inboxSize is how many messages Run reads ahead of the one being handled
+const inboxSize = 64
+
+// inbound is a message read from the client, or a reply the read loop
+// composed itself for a message it had to discard
+type inbound struct {
+	raw   json.RawMessage
+	reply interface{}
+}
+
+// Run starts the server's main loop. Messages are handled in order on one
+// goroutine while another reads ahead, so a $/cancelRequest takes effect
+// without waiting behind the request it cancels.
+func (s *Server) Run(in io.Reader, out io.Writer) error {
+	s.writer = newMessageWriter(out, s.gate)
+	defer func() {
+		// Stop the index and let background workers finish before the
+		// writer goes away
+		s.stop()
+		s.index.wait()
+		s.warmup.wait()
+		s.lakeWrites.Wait()
+		s.writer.close()
+	}()
 
-		response, err := s.handleMessage(msg)
+	inbox := make(chan inbound, inboxSize)
+	stop := make(chan struct{})
+	handled := make(chan error, 1)
+	go func() {
+		err := s.handleInbox(inbox)
 		if err != nil {
-			log.Printf("Error handling message: %v", err)
-			continue
+			close(stop)
 		}
+		handled <- err
+	}()
 
-		if response != nil {
-			if err := writeMessage(out, response); err != nil {
-				return fmt.Errorf("writing response: %w", err)
-			}
-		}
+	readErr := s.readMessages(bufio.NewReader(in), inbox, stop)
+	close(inbox)
+	if err := <-handled; err != nil {
+		return err
 	}
+	return readErr
 }
 
-// readMessage reads a JSON-RPC message from the LSP protocol
-func readMessage(reader *bufio.Reader) (json.RawMessage, error) {
-	// Read headers
-	var contentLength int
+// readMessages reads messages into inbox until the input ends or stop is
+// closed. Each message is observed as it is read, so cancellations and
+// superseded documents are noticed ahead of handling.
+func (s *Server) readMessages(reader *bufio.Reader, inbox chan<- inbound, stop <-chan struct{}) error {
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, err
+		msg, err := readMessageLimit(reader, s.limits.MaxMessageSize)
+		var next inbound
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == nil:
+			s.requests.observe(msg)
+			next.raw = msg
+		default:
+			var tooLarge *messageTooLargeError
+			if errors.As(err, &tooLarge) {
+				log.Printf("Discarded message: %v", err)
+				if tooLarge.id == nil {
+					continue
+				}
+				next.reply = RPCMessage{
+					JSONRPC: "2.0",
+					ID:      tooLarge.id,
+					Error: &RPCError{
+						Code:    InvalidRequest,
+						Message: err.Error(),
+						Data:    map[string]int64{"size": tooLarge.size, "limit": tooLarge.limit},
+					},
+				}
+				break
+			}
+			var frameErr *framingError
+			if errors.As(err, &frameErr) {
+				// Skip the bad frame; bytes read past it belong to the
+				// next one, so put them back in front of the stream
+				log.Printf("Skipping malformed message: %v", err)
+				if len(frameErr.leftover) > 0 {
+					reader = bufio.NewReader(io.MultiReader(bytes.NewReader(frameErr.leftover), reader))
+				}
+				if frameErr.id == nil {
+					continue
+				}
+				// A request is still owed an answer, or the client waits
+				// for it forever
+				next.reply = RPCMessage{
+					JSONRPC: "2.0",
+					ID:      frameErr.id,
+					Error:   &RPCError{Code: InvalidRequest, Message: err.Error()},
+				}
+				break
+			}
+			return fmt.Errorf("reading message: %w", err)
 		}
-		line = strings.TrimSpace(line)
 
-		if line == "" {
-			break
+		select {
+		case inbox <- next:
+		case <-stop:
+			return nil
 		}
+	}
+}
 
-		if strings.HasPrefix(line, "Content-Length:") {
-			value := strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:"))
-			contentLength, err = strconv.Atoi(value)
+// handleInbox handles messages from inbox in order and sends the
+// responses, stopping at the first that can't be written
+func (s *Server) handleInbox(inbox <-chan inbound) error {
+	for next := range inbox {
+		response := next.reply
+		if next.raw != nil {
+			var err error
+			response, err = s.handleMessage(next.raw)
 			if err != nil {
-				return nil, fmt.Errorf("parsing content length: %w", err)
+				log.Printf("Error handling message: %v", err)
+				continue
 			}
 		}
+
+		if response != nil {
+			if err := s.send(response); err != nil {
+				return fmt.Errorf("writing response: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// handleMessage decodes an incoming JSON-RPC message, dispatches it, and
+// returns the message to send back, if any. Requests with an ID always get
+// a response, carrying either the handler's result or its error.
+func (s *Server) handleMessage(rawMsg json.RawMessage) (interface{}, error) {
+	var msg RPCMessage
+	if err := json.Unmarshal(rawMsg, &msg); err != nil {
+		// The ID can't be trusted, so per JSON-RPC the response's is null
+		return RPCMessage{
+			JSONRPC: "2.0",
+			Error:   &RPCError{Code: ParseError, Message: err.Error()},
+		}, nil
 	}
 
-	if contentLength == 0 {
-		return nil, fmt.Errorf("no content length header")
+	if msg.Method == "" && msg.ID != nil {
+		// A response to one of our own requests
+		s.handleResponse(msg)
+		return nil, nil
 	}
 
-	// Read content
-	content := make([]byte, contentLength)
-	_, err := io.ReadFull(reader, content)
-	if err != nil {
-		return nil, fmt.Errorf("reading content: %w", err)
+	log.Printf("Received: method=%s, id=%v", msg.Method, msg.ID)
+
+	ctx := context.Background()
+	if msg.ID != nil {
+		var done func()
+		ctx, done = s.requests.begin(msg.ID)
+		defer done()
+		if ctx.Err() != nil {
+			log.Printf("Cancelled before handling: %s (id=%v)", msg.Method, msg.ID)
+			return cancelledResponse(msg.ID), nil
+		}
 	}
 
-	return content, nil
-}
+	result := s.safeDispatch(ctx, msg)
 
-// writeMessage writes a JSON-RPC message to the output
-func writeMessage(out io.Writer, msg interface{}) error {
-	content, err := json.Marshal(msg)
-	if err != nil {
-		return err
+	if msg.ID == nil {
+		// Notifications never get a response
+		if result.Error != nil {
+			log.Printf("Error handling %s: %v", msg.Method, result.Error)
+			if result.Error.Code == InternalError {
+				s.reportInternalError(msg.Method, result.Error.Message)
+			}
+		}
+		return result.Notify, nil
 	}
 
-	header := fmt.Sprintf("Content-Length: %d\r\n\r\n", len(content))
-	if _, err := out.Write([]byte(header)); err != nil {
-		return err
+	if ctx.Err() != nil {
+		// Whatever the handler got done is stale to the client now
+		log.Printf("Cancelled: %s (id=%v)", msg.Method, msg.ID)
+		return cancelledResponse(msg.ID), nil
 	}
-	if _, err := out.Write(content); err != nil {
-		return err
+	result = s.guardStale(msg, result)
+	if result.Error != nil {
+		log.Printf("Error handling %s (id=%v): %v", msg.Method, msg.ID, result.Error)
+		if result.Error.Code == InternalError {
+			s.reportInternalError(msg.Method, result.Error.Message)
+		}
+		return RPCMessage{JSONRPC: "2.0", ID: msg.ID, Error: result.Error}, nil
 	}
-	return nil
+	return RPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: result.Result}, nil
 }
 
-// handleMessage dispatches incoming JSON-RPC messages
-func (s *Server) handleMessage(rawMsg json.RawMessage) (interface{}, error) {
-	var msg RPCMessage
-	if err := json.Unmarshal(rawMsg, &msg); err != nil {
-		return nil, err
+// cancelledResponse answers a request the client cancelled
+func cancelledResponse(id interface{}) RPCMessage {
+	return RPCMessage{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &RPCError{Code: RequestCancelled, Message: "request cancelled"},
 	}
+}
 
-	log.Printf("Received: method=%s, id=%v", msg.Method, msg.ID)
-
+// dispatch routes a message to its handler. ctx is cancelled when the
+// client cancels the request.
+func (s *Server) dispatch(ctx context.Context, msg RPCMessage) HandlerResult {
 	switch msg.Method {
 	case "initialize":
 		return s.handleInitialize(msg)
 	case "initialized":
-		s.initialized = true
-		return nil, nil
+		return s.handleInitialized(msg)
 	case "shutdown":
 		return s.handleShutdown(msg)
 	case "exit":
@@ -154,17 +447,82 @@ func (s *Server) handleMessage(rawMsg json.RawMessage) (interface{}, error) {
 		return s.handleDidChange(msg)
 	case "textDocument/didClose":
 		return s.handleDidClose(msg)
+	case "textDocument/didSave":
+		return s.handleDidSave(msg)
+	case "$/cancelRequest":
+		return s.handleCancelRequest(msg)
 	case "textDocument/completion":
-		return s.handleCompletion(msg)
+		return s.handleCompletion(ctx, msg)
+	case "completionItem/resolve":
+		return s.handleCompletionResolve(msg)
 	case "textDocument/hover":
 		return s.handleHover(msg)
 	case "textDocument/signatureHelp":
-		return s.handleSignatureHelp(msg)
+		return s.handleSignatureHelp(ctx, msg)
 	case "textDocument/formatting":
 		return s.handleFormatting(msg)
+	case "textDocument/codeAction":
+		return s.handleCodeAction(msg)
+	case "codeAction/resolve":
+		return s.handleCodeActionResolve(ctx, msg)
+	case "textDocument/definition":
+		return s.handleDefinition(msg)
+	case "textDocument/references":
+		return s.handleReferences(msg)
+	case "textDocument/prepareRename":
+		return s.handlePrepareRename(msg)
+	case "textDocument/rename":
+		return s.handleRename(msg)
+	case "textDocument/documentSymbol":
+		return s.handleDocumentSymbol(msg)
+	case "textDocument/semanticTokens/full":
+		return s.handleSemanticTokensFull(msg)
+	case "textDocument/semanticTokens/full/delta":
+		return s.handleSemanticTokensDelta(msg)
+	case "textDocument/inlayHint":
+		return s.handleInlayHint(msg)
+	case "textDocument/codeLens":
+		return s.handleCodeLens(msg)
+	case "codeLens/resolve":
+		return s.handleCodeLensResolve(msg)
+	case "textDocument/selectionRange":
+		return s.handleSelectionRange(msg)
+	case "textDocument/linkedEditingRange":
+		return s.handleLinkedEditingRange(msg)
+	case "textDocument/documentLink":
+		return s.handleDocumentLink(msg)
+	case "workspace/didChangeConfiguration":
+		return s.handleDidChangeConfiguration(msg)
+	case "workspace/didChangeWorkspaceFolders":
+		return s.handleDidChangeWorkspaceFolders(msg)
+	case "window/workDoneProgress/cancel":
+		return s.handleWorkDoneProgressCancel(msg)
+	case "workspace/willRenameFiles":
+		return s.handleWillRenameFiles(msg)
+	case "workspace/didChangeWatchedFiles":
+		return s.handleDidChangeWatchedFiles(msg)
+	case "workspace/symbol":
+		return s.handleWorkspaceSymbol(msg)
+	case "workspace/executeCommand":
+		return s.handleExecuteCommand(ctx, msg)
+	case "superdb/ast":
+		return s.handleAst(msg)
+	case "superdb/plan":
+		return s.handlePlan(ctx, msg)
+	case "superdb/version":
+		return s.handleVersion(ctx, msg)
+	case "superdb/stats":
+		return s.handleStats(msg)
+	case "superdb/formatText":
+		return s.handleFormatText(msg)
 	default:
-		log.Printf("Unhandled method: %s", msg.Method)
+		if msg.ID != nil {
+			// A request the client would wait on forever
+			log.Printf("Unknown method: %s (id=%v)", msg.Method, msg.ID)
+			return failure(&RPCError{Code: MethodNotFound, Message: "method not found: " + msg.Method})
+		}
+		log.Printf("Unhandled notification: %s", msg.Method)
 	}
 
-	return nil, nil
+	return HandlerResult{}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// Message catalog for diagnostics the server writes itself. Messages are
+// keyed by diagnostic code and may name placeholders like {lake}, so rules
+// pass values and never build text. Each locale is a JSON file under
+// locales/, named by its BCP 47 tag; English is the reference every other
+// locale is checked against and the fallback for anything a translation
+// leaves out. Parse errors come from the brimdata/super parser and stay in
+// English.
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// defaultLocale is the locale of the reference catalog
+const defaultLocale = "en"
+
+// catalog holds the messages for one locale
+type catalog struct {
+	locale   string
+	messages map[string]string
+}
+
+// englishCatalog is the reference catalog, loaded once
+var englishCatalog = mustLoadLocale(defaultLocale)
+
+// newCatalog returns the catalog for a client locale like "pt-BR", trying
+// the full tag, then its language, then English. Messages missing from a
+// translation fall back to English.
+func newCatalog(locale string) *catalog {
+	tag := strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	candidates := []string{tag}
+	if lang, _, ok := strings.Cut(tag, "-"); ok {
+		candidates = append(candidates, lang)
+	}
+	for _, name := range candidates {
+		if name == "" || name == defaultLocale {
+			continue
+		}
+		messages, err := loadLocale(name)
+		if err != nil {
+			continue
+		}
+		for code, msg := range englishCatalog.messages {
+			if _, ok := messages[code]; !ok {
+				messages[code] = msg
+			}
+		}
+		return &catalog{locale: name, messages: messages}
+	}
+	return englishCatalog
+}
+
+// loadLocale reads the messages of one locale file
+func loadLocale(name string) (map[string]string, error) {
+	data, err := localeFiles.ReadFile(path.Join("locales", name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// mustLoadLocale loads a locale that is known to be embedded
+func mustLoadLocale(name string) *catalog {
+	messages, err := loadLocale(name)
+	if err != nil {
+		panic("loading locale " + name + ": " + err.Error())
+	}
+	return &catalog{locale: name, messages: messages}
+}
+
+// format returns the message for code with each {name} placeholder
+// replaced, given args as name, value pairs. An unknown code returns the
+// code itself so a missing entry shows up rather than vanishing.
+func (c *catalog) format(code string, args ...string) string {
+	msg, ok := c.messages[code]
+	if !ok {
+		return code
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, "{"+args[i]+"}", args[i+1])
+	}
+	return strings.NewReplacer(pairs...).Replace(msg)
+}
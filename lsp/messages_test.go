@@ -0,0 +1,71 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCatalogFormat(t *testing.T) {
+	got := englishCatalog.format("lake-write", "operator", "load", "lake", "/data/lake")
+	if want := "load writes to the lake at /data/lake; running this query changes its data"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := englishCatalog.format("no-such-code"); got != "no-such-code" {
+		t.Errorf("Expected an unknown code to format as itself, got %q", got)
+	}
+}
+
+func TestCatalogFallsBackToEnglish(t *testing.T) {
+	for _, locale := range []string{"", "en", "en-US", "xx-YY", "xx"} {
+		if c := newCatalog(locale); c.locale != defaultLocale {
+			t.Errorf("Expected %q to fall back to English, got %s", locale, c.locale)
+		}
+	}
+}
+
+// TestLocalesMatchEnglish checks every translation against the reference:
+// no codes English doesn't have, and the same placeholders in each message
+func TestLocalesMatchEnglish(t *testing.T) {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		t.Fatalf("reading locales: %v", err)
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		messages, err := loadLocale(name)
+		if err != nil {
+			t.Errorf("%s: %v", entry.Name(), err)
+			continue
+		}
+		for code, msg := range messages {
+			english, ok := englishCatalog.messages[code]
+			if !ok {
+				t.Errorf("%s: unknown code %s", entry.Name(), code)
+				continue
+			}
+			if got, want := placeholders(msg), placeholders(english); got != want {
+				t.Errorf("%s: %s has placeholders %s, English has %s", entry.Name(), code, got, want)
+			}
+		}
+	}
+}
+
+func TestLakeWriteDiagnosticUsesCatalog(t *testing.T) {
+	s := NewServer()
+	s.config.Lake = "/data/lake"
+	s.messages = &catalog{locale: "test", messages: map[string]string{"lake-write": "{operator} → {lake}"}}
+	diags := s.lakeWriteDiagnostics("values 1 | load logs")
+	if len(diags) != 1 || diags[0].Message != "load → /data/lake" {
+		t.Errorf("Expected the catalog's message, got %+v", diags)
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\{[a-zA-Z]+\}`)
+
+func placeholders(msg string) string {
+	names := placeholderPattern.FindAllString(msg, -1)
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
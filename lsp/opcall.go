@@ -0,0 +1,307 @@
+package main
+
+import (
+	"strings"
+)
+
+// Op calls. A query's own op is called like a builtin operator, with its
+// arguments after its name rather than in parentheses:
+//
+//	op top_by field, n: ( count() by field | sort -r count | head n )
+//	from conn | top_by host, 10
+//
+// so signature help finds the call from the clause the cursor is in, not
+// from an open paren, and counts the commas since the op's name. Inside an
+// op's body, its parameters complete as names.
+
+// opCallAt returns the op called by the clause that offset is in the
+// arguments of, and the index of the argument at offset. ops holds the
+// names of the ops the query declares.
+func opCallAt(text string, offset int, ops map[string]*symbol) (*symbol, int) {
+	tokens := tokenize(text[:offset])
+	depth, arg := 0, 0
+	for i := len(tokens) - 1; i >= 0; i-- {
+		tok := tokens[i]
+		switch tok.typ {
+		case tokPipe:
+			if depth == 0 {
+				return nil, 0
+			}
+		case tokPunctuation:
+			switch tok.value {
+			case ")", "]", "}", "]|", "}|":
+				depth++
+			case "(", "[", "{", "|[", "|{":
+				if depth == 0 {
+					return nil, 0
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					arg++
+				}
+			}
+		case tokIdentifier:
+			// The name itself, still being typed, isn't a call yet, and
+			// after op it is the declaration
+			sym := ops[tok.value]
+			if depth == 0 && sym != nil && i < len(tokens)-1 && previousSignificant(tokens, i) != "op" {
+				return sym, arg
+			}
+		}
+	}
+	return nil, 0
+}
+
+// previousSignificant returns the value of the last token before tokens[i]
+// that isn't whitespace or a comment
+func previousSignificant(tokens []token, i int) string {
+	for i--; i >= 0; i-- {
+		switch tokens[i].typ {
+		case tokWhitespace, tokNewline, tokComment:
+			continue
+		}
+		return tokens[i].value
+	}
+	return ""
+}
+
+// clauseSpan returns the offsets of the clause around offset: the rest of
+// its line within the innermost brackets and pipes that hold it
+func clauseSpan(text string, offset int) (start, end int) {
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	end = len(text)
+	if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+		end = offset + i
+	}
+	starts := []int{lineStart} // where each open bracket's clause starts
+	depth, pos := 0, lineStart
+	for _, tok := range tokenize(text[lineStart:end]) {
+		next := pos + len(tok.value)
+		opens := tok.typ == tokPunctuation && strings.ContainsAny(tok.value[:1], "([{") || tok.value == "|[" || tok.value == "|{"
+		closes := tok.typ == tokPunctuation && strings.ContainsAny(tok.value[:1], ")]}")
+		switch {
+		case next <= offset:
+			switch {
+			case opens:
+				starts = append(starts, next)
+			case closes && len(starts) > 1:
+				starts = starts[:len(starts)-1]
+			case tok.typ == tokPipe:
+				starts[len(starts)-1] = next
+			}
+		case pos >= offset:
+			switch {
+			case opens:
+				depth++
+			case (closes || tok.typ == tokPipe) && depth == 0:
+				return starts[len(starts)-1], pos
+			case closes:
+				depth--
+			}
+		}
+		pos = next
+	}
+	return starts[len(starts)-1], end
+}
+
+// declaredAt returns the symbol table of text, which is being edited at
+// offset. While the clause there is half written the query doesn't parse,
+// so the declarations come from the query with that clause passed over.
+func declaredAt(text string, offset int) *symbolTable {
+	if table := buildSymbolTable(text); table != nil {
+		return table
+	}
+	start, end := clauseSpan(text, offset)
+	filler := strings.Repeat(" ", end-start)
+	if end-start >= len("pass") {
+		filler = "pass" + filler[len("pass"):]
+	}
+	if table := buildSymbolTable(text[:start] + filler + text[end:]); table != nil {
+		return table
+	}
+	// A declaration half written inside brackets, like a record type,
+	// leaves them open; pass over its whole line
+	lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+	if start == lineStart {
+		return nil
+	}
+	return buildSymbolTable(text[:lineStart] + strings.Repeat(" ", end-lineStart) + text[end:])
+}
+
+// declaredOps returns the ops in table by name
+func declaredOps(table *symbolTable) map[string]*symbol {
+	ops := make(map[string]*symbol)
+	if table == nil {
+		return ops
+	}
+	for _, sym := range table.symbols {
+		if sym.kind == symbolOp {
+			ops[sym.name] = sym
+		}
+	}
+	return ops
+}
+
+// opSignatureHelp returns signature help for a call of a declared op at
+// pos, or nil if pos isn't in one
+func opSignatureHelp(text string, pos Position) *SignatureHelp {
+	if !strings.Contains(text, "op ") {
+		return nil
+	}
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return nil
+	}
+	sym, arg := opCallAt(text, offset, declaredOps(declaredAt(text, offset)))
+	if sym == nil || len(sym.params) == 0 {
+		return nil
+	}
+
+	// Parameter labels are offsets into the label, as for builtins
+	label := sym.signature()
+	params := make([]ParameterInformation, len(sym.params))
+	at := len("op " + sym.name + " ")
+	for i, name := range sym.params {
+		params[i] = ParameterInformation{Label: [2]int{at, at + len(name)}}
+		at += len(name) + len(", ")
+	}
+	if arg >= len(params) {
+		arg = len(params) - 1
+	}
+	info := SignatureInformation{Label: label, Parameters: params}
+	if sym.doc != "" {
+		info.Documentation = &MarkupContent{Kind: MarkupKindPlainText, Value: sym.doc}
+	}
+	return &SignatureHelp{
+		Signatures:      []SignatureInformation{info},
+		ActiveSignature: 0,
+		ActiveParameter: arg,
+	}
+}
+
+// declarationsAt returns the symbol table of text for completion at pos,
+// or nil when text declares nothing
+func declarationsAt(text string, pos Position) *symbolTable {
+	if !mayDeclare(text) {
+		return nil
+	}
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return nil
+	}
+	return declaredAt(text, offset)
+}
+
+// paramCompletions returns the parameters of the ops and funcs in table
+// whose declarations hold pos that start with the word typed
+func paramCompletions(table *symbolTable, pos Position, rc rankContext) []CompletionItem {
+	if table == nil {
+		return nil
+	}
+	var items []CompletionItem
+	for _, sym := range table.symbols {
+		if (sym.kind != symbolOp && sym.kind != symbolFunc) || !rangeContains(sym.rng, pos) {
+			continue
+		}
+		for _, name := range sym.params {
+			m, ok := matchWord(strings.ToLower(name), rc.prefix)
+			if !ok {
+				continue
+			}
+			item := CompletionItem{
+				Label:    name,
+				Kind:     CompletionItemKindVariable,
+				Detail:   "(param) of " + sym.signature(),
+				SortText: rc.rank(name, rankParam, noKind).sortText(name),
+			}
+			m.apply(&item)
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// declarationCompletions returns the consts, funcs, ops, and types in
+// table that start with the word typed and that the completion context
+// takes, one of kinds: types where a type goes, funcs and consts where a
+// value does, ops where an operator does. A declaration inside an op or
+// func is only offered within it.
+func declarationCompletions(table *symbolTable, text string, pos Position, rc rankContext, kinds []BuiltinKind) []CompletionItem {
+	if table == nil {
+		return nil
+	}
+	takes := make(map[symbolKind]bool)
+	for _, kind := range kinds {
+		switch kind {
+		case KindType:
+			takes[symbolType] = true
+		case KindFunction:
+			takes[symbolFunc] = true
+			takes[symbolConst] = true
+		case KindOperator:
+			takes[symbolOp] = true
+		}
+	}
+	var items []CompletionItem
+	for _, sym := range table.symbols {
+		m, ok := matchWord(strings.ToLower(sym.name), rc.prefix)
+		if !ok || !takes[sym.kind] || !visibleAt(table, sym, pos) {
+			continue
+		}
+		item := CompletionItem{Label: sym.name, Detail: declarationText(text, sym)}
+		kind := noKind
+		switch sym.kind {
+		case symbolConst:
+			item.Kind = CompletionItemKindConstant
+		case symbolFunc:
+			item.Kind = CompletionItemKindFunction
+			item.InsertText, item.InsertTextFormat = callSnippet(sym.name, sym.params), InsertTextFormatSnippet
+			item.LabelDetails = &LabelDetails{Detail: "(" + strings.Join(sym.params, ", ") + ")"}
+			kind = KindFunction
+		case symbolOp:
+			item.Kind = CompletionItemKindFunction
+			kind = KindOperator
+		case symbolType:
+			item.Kind = CompletionItemKindClass
+			kind = KindType
+		}
+		item.SortText = rc.rank(sym.name, rankDeclared, kind).sortText(sym.name)
+		m.apply(&item)
+		if sym.doc != "" {
+			item.Documentation = MarkupContent{Kind: MarkupKindPlainText, Value: sym.doc}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// visibleAt reports whether sym can be named at pos: it isn't declared in
+// the body of an op or func, or pos is in that body too
+func visibleAt(table *symbolTable, sym *symbol, pos Position) bool {
+	for _, outer := range table.symbols {
+		if outer != sym && (outer.kind == symbolOp || outer.kind == symbolFunc) &&
+			rangeContains(outer.rng, sym.nameRange.Start) && !rangeContains(outer.rng, pos) {
+			return false
+		}
+	}
+	return true
+}
+
+// declarationText returns the first line of sym's declaration as written,
+// like const LIMIT = 10
+func declarationText(text string, sym *symbol) string {
+	start, ok := offsetAt(text, sym.rng.Start)
+	if !ok {
+		return sym.signature()
+	}
+	end, ok := offsetAt(text, sym.rng.End)
+	if !ok || end < start {
+		end = len(text)
+	}
+	decl := text[start:end]
+	if nl := strings.IndexByte(decl, '\n'); nl >= 0 {
+		decl = decl[:nl]
+	}
+	return strings.TrimSpace(decl)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOpSignatureHelp(t *testing.T) {
+	decl := "-- Top values of a field\nop top_by field, n: ( count() by field | sort -r count | head n )\n"
+	tests := []struct {
+		text   string
+		active int // -1 for no help
+	}{
+		{decl + "from conn | top_by ", 0},
+		{decl + "from conn | top_by host, ", 1},
+		{decl + "from conn | top_by host, 10", 1},
+		{decl + "from conn | top_by host, 10, 3", 1},
+		{decl + "top_by abs(x), ", 1},
+		{decl + "from conn | top_by", -1},
+		{decl + "from conn | top_by host | sort ", -1},
+		{"op top_by field, ", -1},
+	}
+	for _, tt := range tests {
+		pos := positionAt(tt.text, len(tt.text))
+		help := getSignatureHelp(context.Background(), tt.text, pos, docMarkdown)
+		if tt.active < 0 {
+			if help != nil {
+				t.Errorf("%q: expected no help, got %+v", tt.text, help)
+			}
+			continue
+		}
+		if help == nil {
+			t.Errorf("%q: expected help", tt.text)
+			continue
+		}
+		sig := help.Signatures[0]
+		if sig.Label != "op top_by field, n" || help.ActiveParameter != tt.active {
+			t.Errorf("%q: expected parameter %d of op top_by field, n, got %d of %q", tt.text, tt.active, help.ActiveParameter, sig.Label)
+		}
+		if p := sig.Parameters[1].Label; sig.Label[p[0]:p[1]] != "n" {
+			t.Errorf("Expected the second parameter label to be n, got %q", sig.Label[p[0]:p[1]])
+		}
+		if sig.Documentation == nil || sig.Documentation.Value != "Top values of a field" {
+			t.Errorf("Expected the doc comment as documentation, got %+v", sig.Documentation)
+		}
+	}
+
+	// A builtin call in an op's arguments gets the builtin's help
+	text := decl + "top_by abs("
+	help := getSignatureHelp(context.Background(), text, positionAt(text, len(text)), docMarkdown)
+	if help == nil || !strings.HasPrefix(help.Signatures[0].Label, "abs(") {
+		t.Errorf("Expected help for abs, got %+v", help)
+	}
+}
+
+func TestClauseSpan(t *testing.T) {
+	tests := []struct {
+		text, cursor, want string
+	}{
+		{"op f a: ( put x := | sort a )", "put x := ", "put x := "},
+		{"values 1 | f x, \nvalues 2", "f x, ", "f x, "},
+		{"values [1, (2", "(2", "2"},
+	}
+	for _, tt := range tests {
+		offset := strings.Index(tt.text, tt.cursor) + len(tt.cursor)
+		start, end := clauseSpan(tt.text, offset)
+		if got := strings.TrimSpace(tt.text[start:end]); got != strings.TrimSpace(tt.want) {
+			t.Errorf("%q: expected clause %q, got %q", tt.text, tt.want, got)
+		}
+	}
+}
+
+func TestParamCompletions(t *testing.T) {
+	text := "op top_by field, n: ( count() by f | sort -r count | head  )\nvalues 1"
+	pos := posOf(t, text, "by f", 1, len("by f"))
+	items := getCompletions(context.Background(), text, pos)
+	if len(items) == 0 || items[0].Label != "field" || items[0].Detail != "(param) of op top_by field, n" {
+		t.Errorf("Expected field first, got %+v", items[:min(len(items), 3)])
+	}
+
+	// While the body doesn't parse
+	text = "op top_by field, n: ( head  )\nvalues 1"
+	pos = posOf(t, text, "head ", 1, len("head "))
+	items = getCompletions(context.Background(), text, pos)
+	var labels []string
+	for _, item := range items {
+		if item.Kind == CompletionItemKindVariable {
+			labels = append(labels, item.Label)
+		}
+	}
+	if strings.Join(labels, ",") != "field,n" {
+		t.Errorf("Expected both parameters, got %v", labels)
+	}
+
+	// Outside the op
+	text = "op top_by field, n: ( pass )\nvalues f"
+	items = getCompletions(context.Background(), text, positionAt(text, len(text)))
+	for _, item := range items {
+		if item.Label == "field" {
+			t.Errorf("Expected no parameters outside the op")
+		}
+	}
+}
+
+func TestDeclarationCompletions(t *testing.T) {
+	text := "const LIMIT = 10\n" +
+		"fn twice(x): (\n  x * 2\n)\n" +
+		"type port = uint16\n" +
+		"op top_by field: (\n  const local = 1\n  sort -r field\n)\n" +
+		"values "
+	byLabel := func(items []CompletionItem) map[string]CompletionItem {
+		found := make(map[string]CompletionItem)
+		for _, item := range items {
+			found[item.Label] = item
+		}
+		return found
+	}
+
+	items := byLabel(getCompletions(context.Background(), text, positionAt(text, len(text))))
+	for label, want := range map[string]struct {
+		kind   int
+		detail string
+	}{
+		"LIMIT":  {CompletionItemKindConstant, "const LIMIT = 10"},
+		"twice":  {CompletionItemKindFunction, "fn twice(x): ("},
+		"top_by": {CompletionItemKindFunction, "op top_by field: ("},
+		"port":   {CompletionItemKindClass, "type port = uint16"},
+	} {
+		item, ok := items[label]
+		if !ok || item.Kind != want.kind || item.Detail != want.detail {
+			t.Errorf("Expected %s with detail %q, got %+v", label, want.detail, item)
+		}
+	}
+	if _, ok := items["local"]; ok {
+		t.Errorf("Expected a const declared in an op to stay inside it")
+	}
+	if twice := items["twice"]; twice.LabelDetails == nil || twice.LabelDetails.Detail != "(x)" {
+		t.Errorf("Expected twice's parameters beside its label, got %+v", twice.LabelDetails)
+	}
+
+	// Only types where a type goes, and the prefix narrows them
+	text = "type port = uint16\nconst p = 1\nvalues x::po"
+	items = byLabel(getCompletions(context.Background(), text, positionAt(text, len(text))))
+	if _, ok := items["port"]; !ok {
+		t.Errorf("Expected the declared type, got %v", items)
+	}
+	if _, ok := items["p"]; ok {
+		t.Errorf("Expected no consts where a type goes")
+	}
+
+	// Inside the op, its own declarations are offered
+	text = "op top_by field: (\n  const local = 1\n  values lo\n)\nvalues 1"
+	items = byLabel(getCompletions(context.Background(), text, posOf(t, text, "values lo", 1, len("values lo"))))
+	if _, ok := items["local"]; !ok {
+		t.Errorf("Expected the op's const inside it")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Document outline: the declarations of a query, with the parameters of
+// funcs and ops and the stages of op bodies beneath them, followed by the
+// top-level stages of its pipeline.
+
+// maxStageDetail caps the stage text shown beside an outline entry
+const maxStageDetail = 60
+
+// documentSymbols returns the outline of text, or nil if it doesn't parse
+func documentSymbols(text string) []DocumentSymbol {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	symbols := []DocumentSymbol{}
+	nodes, _ := tree.([]interface{})
+	if len(nodes) == 1 && nodeKind(nodes[0]) == "ScopeOp" {
+		scope, _ := nodes[0].(map[string]interface{})
+		decls, _ := scope["decls"].([]interface{})
+		for _, decl := range decls {
+			decl, _ := decl.(map[string]interface{})
+			if sym, ok := declSymbol(text, decl); ok {
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	return append(symbols, stageSymbols(text, topLevelStages(tree))...)
+}
+
+// declSymbol returns the outline entry for a declaration
+func declSymbol(text string, decl map[string]interface{}) (DocumentSymbol, bool) {
+	name, _ := decl["name"].(map[string]interface{})
+	sym := DocumentSymbol{
+		Name:           declName(decl),
+		Range:          locRange(text, decl["loc"]),
+		SelectionRange: locRange(text, name["loc"]),
+	}
+	var params interface{}
+	switch nodeKind(decl) {
+	case "ConstDecl":
+		sym.Kind = SymbolKindConstant
+		sym.Detail = "const"
+	case "TypeDecl":
+		sym.Kind = SymbolKindClass
+		sym.Detail = "type"
+	case "FuncDecl":
+		sym.Kind = SymbolKindFunction
+		lambda, _ := decl["lambda"].(map[string]interface{})
+		params = lambda["params"]
+	case "OpDecl":
+		sym.Kind = SymbolKindOperator
+		params = decl["params"]
+	default:
+		return sym, false
+	}
+	list, _ := params.([]interface{})
+	var names []string
+	for _, param := range list {
+		param, _ := param.(map[string]interface{})
+		n, _ := param["name"].(string)
+		names = append(names, n)
+		rng := locRange(text, param["loc"])
+		sym.Children = append(sym.Children, DocumentSymbol{
+			Name:           n,
+			Kind:           SymbolKindVariable,
+			Range:          rng,
+			SelectionRange: rng,
+		})
+	}
+	switch nodeKind(decl) {
+	case "FuncDecl":
+		sym.Detail = "fn(" + strings.Join(names, ", ") + ")"
+	case "OpDecl":
+		sym.Detail = strings.TrimSpace("op " + strings.Join(names, ", "))
+		body, _ := decl["body"].([]interface{})
+		sym.Children = append(sym.Children, stageSymbols(text, body)...)
+	}
+	return sym, true
+}
+
+// stageSymbols returns outline entries for pipeline stages, each named by
+// its operator, or by its text when it starts with none
+func stageSymbols(text string, stages []interface{}) []DocumentSymbol {
+	var symbols []DocumentSymbol
+	for _, stage := range stages {
+		op, _ := stage.(map[string]interface{})
+		rng := locRange(text, op["loc"])
+		stageText := strings.Join(strings.Fields(nodeText(text, op["loc"])), " ")
+		sym := DocumentSymbol{
+			Kind:           SymbolKindEvent,
+			Range:          rng,
+			SelectionRange: rng,
+			Detail:         truncate(stageText, maxStageDetail),
+		}
+		word := stageText
+		if i := strings.IndexFunc(word, func(r rune) bool { return r >= 0x80 || !isIdentifierChar(byte(r)) }); i >= 0 {
+			word = word[:i]
+		}
+		b := Builtins.Lookup(word)
+		switch {
+		case nodeKind(op) == "CallOp":
+			sym.Name = declName(op)
+		case word != "" && b != nil && (b.Kind == KindOperator || b.Kind == KindKeyword):
+			sym.Name = strings.ToLower(word)
+		default:
+			sym.Name, sym.Detail = sym.Detail, ""
+		}
+		if sym.Name == "" {
+			continue
+		}
+		if start, ok := offsetAt(text, rng.Start); ok && sym.Detail != "" {
+			sym.SelectionRange = Range{Start: rng.Start, End: positionAt(text, start+len(word))}
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
+
+// handleDocumentSymbol processes textDocument/documentSymbol requests
+func (s *Server) handleDocumentSymbol(msg RPCMessage) HandlerResult {
+	var params DocumentSymbolParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success([]DocumentSymbol{})
+	}
+	return success(s.symbolsToClient(text, documentSymbols(text)))
+}
+
+// symbolsToClient converts the ranges of symbols of text and their
+// children to the client's encoding
+func (s *Server) symbolsToClient(text string, symbols []DocumentSymbol) []DocumentSymbol {
+	for i := range symbols {
+		symbols[i].Range = s.rangeToClient(text, symbols[i].Range)
+		symbols[i].SelectionRange = s.rangeToClient(text, symbols[i].SelectionRange)
+		symbols[i].Children = s.symbolsToClient(text, symbols[i].Children)
+	}
+	return symbols
+}
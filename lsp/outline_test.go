@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDocumentSymbols(t *testing.T) {
+	text := `const A = 1
+type port = uint16
+fn f(x): (x + 1)
+op o a: (
+  where a > 1
+  | head 1
+)
+from t
+| o 2
+| x > 1
+| sort   -r   ts`
+	symbols := documentSymbols(text)
+	want := []struct {
+		name   string
+		kind   int
+		detail string
+	}{
+		{"A", SymbolKindConstant, "const"},
+		{"port", SymbolKindClass, "type"},
+		{"f", SymbolKindFunction, "fn(x)"},
+		{"o", SymbolKindOperator, "op a"},
+		{"from", SymbolKindEvent, "from t"},
+		{"o", SymbolKindEvent, "o 2"},
+		{"x > 1", SymbolKindEvent, ""},
+		{"sort", SymbolKindEvent, "sort -r ts"},
+	}
+	if len(symbols) != len(want) {
+		t.Fatalf("Expected %d symbols, got %+v", len(want), symbols)
+	}
+	for i, w := range want {
+		got := symbols[i]
+		if got.Name != w.name || got.Kind != w.kind || got.Detail != w.detail {
+			t.Errorf("Symbol %d: expected %s (%d) %q, got %s (%d) %q", i, w.name, w.kind, w.detail, got.Name, got.Kind, got.Detail)
+		}
+	}
+
+	// The op has its parameter and its body's stages beneath it
+	op := symbols[3]
+	if len(op.Children) != 3 || op.Children[0].Name != "a" || op.Children[1].Name != "where" || op.Children[2].Name != "head" {
+		t.Errorf("Unexpected children of o: %+v", op.Children)
+	}
+	if op.SelectionRange.Start != posOf(t, text, "o a", 1, 0) {
+		t.Errorf("Expected o's selection range at its name, got %+v", op.SelectionRange)
+	}
+	sort := symbols[7]
+	if sort.SelectionRange.Start != posOf(t, text, "sort", 1, 0) || sort.SelectionRange.End != posOf(t, text, "sort", 1, 4) {
+		t.Errorf("Expected sort's selection range on the operator, got %+v", sort.SelectionRange)
+	}
+	if sort.Range.End != posOf(t, text, "ts", 1, 2) {
+		t.Errorf("Expected sort's range to end with the stage, got %+v", sort.Range)
+	}
+
+	if documentSymbols("from t |") != nil {
+		t.Error("Expected no outline for a query that doesn't parse")
+	}
+}
+
+func TestDocumentSymbolRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///outline.spq"
+	h.openDocument(t, uri, symbolsQuery)
+
+	response, err := h.ProcessRequest(2, "textDocument/documentSymbol", DocumentSymbolParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil {
+		t.Fatalf("documentSymbol failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var symbols []DocumentSymbol
+	json.Unmarshal(resultBytes, &symbols)
+	if len(symbols) != 7 || symbols[0].Name != "THRESH" || symbols[4].Name != "from" {
+		t.Errorf("Unexpected outline %s", resultBytes)
+	}
+}
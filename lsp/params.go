@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Query parameters. A query reads a parameter with env("NAME"), usually
+// bound once at the top as const NAME = env("NAME"). The parameters a
+// workspace uses can be listed in initializationOptions, each with a
+// default and a description: env(" completes their names, hover on a name
+// shows its default, and a name that is neither configured nor set in the
+// server's environment is flagged. superdb.runQuery replaces each env()
+// call with its value before running, so a query runs with the configured
+// default when the environment doesn't set one.
+
+// parameterUse is an env("NAME") call in a query
+type parameterUse struct {
+	name        string
+	nameRange   Range // NAME, inside the quotes
+	first, last int   // offsets of the whole call, inclusive
+}
+
+// parameterUses returns the env() calls in text whose argument is a
+// string literal
+func parameterUses(text string) []parameterUse {
+	if !strings.Contains(text, "env(") {
+		return nil
+	}
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	var uses []parameterUse
+	walkTree(tree, func(node map[string]interface{}) {
+		if nodeKind(node) != "CallExpr" {
+			return
+		}
+		fn, _ := node["func"].(map[string]interface{})
+		args, _ := node["args"].([]interface{})
+		if fn["name"] != "env" || len(args) != 1 {
+			return
+		}
+		name, loc, ok := stringIndex(args[0])
+		if !ok {
+			return
+		}
+		call, _ := node["loc"].(map[string]interface{})
+		first, _ := call["first"].(float64)
+		last, _ := call["last"].(float64)
+		uses = append(uses, parameterUse{
+			name:      name,
+			nameRange: locRange(text, loc),
+			first:     int(first),
+			last:      int(last),
+		})
+	})
+	return uses
+}
+
+// parameterValue returns the value of parameter name as a literal: from
+// overrides first, then the server's environment, then its configured
+// default
+func (s *Server) parameterValue(name string, overrides map[string]json.RawMessage) (string, bool) {
+	if value, ok := overrides[name]; ok {
+		return compactJSON(value), true
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		quoted, _ := json.Marshal(value)
+		return string(quoted), true
+	}
+	if p, ok := s.settings().Parameters[name]; ok && len(p.Default) > 0 {
+		return compactJSON(p.Default), true
+	}
+	return "", false
+}
+
+// compactJSON returns value on one line. A JSON value is also a SuperSQL
+// literal.
+func compactJSON(value json.RawMessage) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, value); err != nil {
+		return string(value)
+	}
+	return b.String()
+}
+
+// substituteParameters replaces each env() call in text with the value of
+// its parameter, or returns an error naming a parameter without one
+func (s *Server) substituteParameters(text string, overrides map[string]json.RawMessage) (string, error) {
+	uses := parameterUses(text)
+	for i := len(uses) - 1; i >= 0; i-- {
+		use := uses[i]
+		value, ok := s.parameterValue(use.name, overrides)
+		if !ok {
+			return "", fmt.Errorf("parameter %s has no value", use.name)
+		}
+		text = text[:use.first] + value + text[use.last+1:]
+	}
+	return text, nil
+}
+
+// parameterDiagnostics flags env() calls whose parameter is neither
+// configured nor set in the server's environment
+func (s *Server) parameterDiagnostics(text string) []Diagnostic {
+	parameters := s.settings().Parameters
+	var diagnostics []Diagnostic
+	for _, use := range parameterUses(text) {
+		if _, ok := parameters[use.name]; ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(use.name); ok {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    use.nameRange,
+			Severity: DiagnosticSeverityWarning,
+			Code:     "undefined-parameter",
+			Source:   "superdb-lsp",
+			Message:  s.messages.format("undefined-parameter", "name", use.name),
+		})
+	}
+	return diagnostics
+}
+
+// parameterNamePattern matches the text before the cursor when it is in
+// the string argument of env()
+var parameterNamePattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.])env\(\s*["']([A-Za-z0-9_]*)$`)
+
+// parameterCompletions returns the configured parameters whose names
+// start with what is typed, when pos is in the string argument of env()
+func (s *Server) parameterCompletions(text string, pos Position) ([]CompletionItem, bool) {
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) {
+		return nil, false
+	}
+	m := parameterNamePattern.FindStringSubmatch(line[:pos.Character])
+	if m == nil {
+		return nil, false
+	}
+	parameters := s.settings().Parameters
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		if strings.HasPrefix(name, m[1]) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := []CompletionItem{}
+	for _, name := range names {
+		p := parameters[name]
+		item := CompletionItem{
+			Label:         name,
+			Kind:          CompletionItemKindConstant,
+			Detail:        "query parameter",
+			Documentation: p.Description,
+		}
+		if len(p.Default) > 0 {
+			item.Detail += ", default " + compactJSON(p.Default)
+		}
+		items = append(items, item)
+	}
+	return items, true
+}
+
+// parameterHover describes the parameter whose name pos is on
+func (s *Server) parameterHover(text string, pos Position) *Hover {
+	settings := s.settings()
+	for _, use := range parameterUses(text) {
+		if !rangeContains(use.nameRange, pos) {
+			continue
+		}
+		code := func(s string) string { return s }
+		if settings.DocStyle == docMarkdown {
+			code = func(s string) string { return "`" + s + "`" }
+		}
+		p, configured := settings.Parameters[use.name]
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s (query parameter)", code(use.name))
+		switch {
+		case configured && len(p.Default) > 0:
+			fmt.Fprintf(&b, "\n\nDefault: %s", code(compactJSON(p.Default)))
+		case configured:
+			b.WriteString("\n\nNo default")
+		default:
+			b.WriteString("\n\nNot configured")
+		}
+		if value, ok := os.LookupEnv(use.name); ok {
+			fmt.Fprintf(&b, "; the environment sets it to %s", code(value))
+		}
+		if p.Description != "" {
+			b.WriteString("\n\n" + p.Description)
+		}
+		rng := use.nameRange
+		hover := newHover(b.String(), settings.DocStyle)
+		hover.Range = &rng
+		return hover
+	}
+	return nil
+}
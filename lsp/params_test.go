@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// parameterServer returns a server configured with the parameters in opts
+func parameterServer(t *testing.T, opts string) *TestHelper {
+	t.Helper()
+	h := NewTestHelper()
+	params := InitializeParams{InitializationOptions: json.RawMessage(opts)}
+	if _, err := h.ProcessRequest(1, "initialize", params); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+const parameterOptions = `{"parameters": {
+	"LIMIT": {"default": 2, "description": "How many values to keep"},
+	"LABEL": {"default": "all"},
+	"HOST": {}
+}}`
+
+func TestParameterDiagnostics(t *testing.T) {
+	t.Setenv("SUPERDB_LSP_TEST_PARAM", "x")
+	h := parameterServer(t, parameterOptions)
+	text := `const n = env("LIMIT")
+const h = env("HOST")
+const e = env("SUPERDB_LSP_TEST_PARAM")
+values env("MISSING")`
+	diagnostics := h.server.parameterDiagnostics(text)
+	if len(diagnostics) != 1 {
+		t.Fatalf("Expected one diagnostic, got %+v", diagnostics)
+	}
+	d := diagnostics[0]
+	want := Range{Start: posOf(t, text, "MISSING", 1, 0), End: posOf(t, text, "MISSING", 1, len("MISSING"))}
+	if d.Code != "undefined-parameter" || d.Range != want {
+		t.Errorf("Unexpected diagnostic: %+v", d)
+	}
+	if !strings.Contains(d.Message, "MISSING") {
+		t.Errorf("Expected the name in the message, got %q", d.Message)
+	}
+}
+
+func TestParameterCompletions(t *testing.T) {
+	h := parameterServer(t, parameterOptions)
+	text := `const n = env("L`
+	items, ok := h.server.parameterCompletions(text, posOf(t, text, "L", 1, 1))
+	if !ok {
+		t.Fatal("Expected parameter completions in env()")
+	}
+	if len(items) != 2 || items[0].Label != "LABEL" || items[1].Label != "LIMIT" {
+		t.Fatalf("Expected LABEL and LIMIT, got %+v", items)
+	}
+	if items[1].Detail != "query parameter, default 2" || items[1].Documentation != "How many values to keep" {
+		t.Errorf("Unexpected item: %+v", items[1])
+	}
+
+	for _, text := range []string{`values "L`, `values myenv("L`, `values env(L`} {
+		if _, ok := h.server.parameterCompletions(text, positionAt(text, len(text))); ok {
+			t.Errorf("%s: expected no parameter completions", text)
+		}
+	}
+}
+
+func TestParameterHover(t *testing.T) {
+	h := parameterServer(t, parameterOptions)
+	text := `const n = env("LIMIT") values n`
+	hover := h.server.parameterHover(text, posOf(t, text, "LIMIT", 1, 2))
+	if hover == nil {
+		t.Fatal("Expected hover on the parameter name")
+	}
+	if !strings.Contains(hover.Contents.Value, "Default: `2`") || !strings.Contains(hover.Contents.Value, "How many values to keep") {
+		t.Errorf("Unexpected hover: %q", hover.Contents.Value)
+	}
+	if h.server.parameterHover(text, posOf(t, text, "values", 1, 0)) != nil {
+		t.Error("Expected no parameter hover off the name")
+	}
+}
+
+func TestSubstituteParameters(t *testing.T) {
+	t.Setenv("LABEL", "from env")
+	h := parameterServer(t, parameterOptions)
+	text := `const n = env("LIMIT") const l = env("LABEL") values n, l`
+	got, err := h.server.substituteParameters(text, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `const n = 2 const l = "from env" values n, l`; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	got, _ = h.server.substituteParameters(text, map[string]json.RawMessage{"LIMIT": json.RawMessage(`[1, 2]`)})
+	if want := `const n = [1,2] const l = "from env" values n, l`; got != want {
+		t.Errorf("Expected the override used, got %q", got)
+	}
+
+	if _, err := h.server.substituteParameters(`values env("HOST")`, nil); err == nil || !strings.Contains(err.Error(), "HOST") {
+		t.Errorf("Expected an error for a parameter without a value, got %v", err)
+	}
+}
+
+func TestRunQuerySubstitutesParameters(t *testing.T) {
+	h := parameterServer(t, parameterOptions)
+	uri := "file:///params.spq"
+	h.openDocument(t, uri, `const n = env("LIMIT") values 1, 2, 3 | head n`)
+
+	args, _ := json.Marshal(RunQueryArgs{URI: uri})
+	response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRunQuery,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil || response.Error != nil {
+		t.Fatalf("runQuery failed: %v %v", err, response.Error)
+	}
+	var result QueryResultParams
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &result)
+	if result.Error != "" || len(result.Values) != 2 {
+		t.Errorf("Expected the default limit of 2, got %+v", result)
+	}
+}
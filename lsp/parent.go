@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Parent process monitoring. The processId in initialize is the editor
+// that started the server. If the editor crashes it never sends exit, and
+// the server would otherwise wait on its input forever, so the server
+// polls for that process and exits once it is gone.
+
+// parentPollInterval is how often the parent process is checked
+const parentPollInterval = 5 * time.Second
+
+// watchParent polls for process pid and calls s.orphaned once it has
+// exited. A pid of 0 means the client didn't say. Watching stops when the
+// server does.
+func (s *Server) watchParent(pid int, interval time.Duration) {
+	if pid <= 0 || s.orphaned == nil {
+		return
+	}
+	orphaned := s.orphaned
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !processAlive(pid) && s.ctx.Err() == nil {
+				orphaned(pid)
+				return
+			}
+		}
+	}()
+}
+
+// exitOrphaned ends the server after its parent process has gone. As with
+// exit before shutdown, the status is 1. Messages already queued are
+// written first; once Run has closed the writer there are none.
+func (s *Server) exitOrphaned(pid int) {
+	log.Printf("Parent process %d has exited; exiting", pid)
+	if s.writer != nil {
+		s.writer.flush()
+	}
+	os.Exit(1)
+}
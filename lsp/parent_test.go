@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWatchParent(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Fatal("Expected this process to be alive")
+	}
+
+	// A child that has run and been waited for stands in for an editor
+	// that crashed
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	if err := child.Run(); err != nil {
+		t.Fatalf("Running child: %v", err)
+	}
+	pid := child.Process.Pid
+	if processAlive(pid) {
+		t.Fatalf("Expected process %d to be gone", pid)
+	}
+
+	s := NewServer()
+	gone := make(chan int, 1)
+	s.orphaned = func(pid int) { gone <- pid }
+	s.watchParent(os.Getpid(), time.Millisecond)
+	s.watchParent(pid, time.Millisecond)
+	select {
+	case got := <-gone:
+		if got != pid {
+			t.Errorf("Expected process %d reported gone, got %d", pid, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the exited parent to be noticed")
+	}
+	select {
+	case got := <-gone:
+		t.Errorf("Expected a live parent to be left alone, got %d reported", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Once the server has stopped, a parent exiting is no longer its concern
+	s.stop()
+	s.watchParent(pid, time.Millisecond)
+	select {
+	case got := <-gone:
+		t.Errorf("Expected a stopped server not to watch, got %d reported", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
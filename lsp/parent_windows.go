@@ -0,0 +1,34 @@
+package main
+
+import "syscall"
+
+const (
+	// stillActive is the exit code of a process that hasn't exited
+	stillActive = 259
+
+	// processQueryLimitedInformation is the access right to read a
+	// process's exit code, which unlike PROCESS_QUERY_INFORMATION is
+	// granted on an elevated process or another user's
+	processQueryLimitedInformation = 0x1000
+
+	// errorInvalidParameter is what OpenProcess fails with when no
+	// process has the pid
+	errorInvalidParameter syscall.Errno = 87
+)
+
+// processAlive reports whether process pid is still running. A handle to
+// an exited process can outlive it, so the exit code is checked too. Only
+// a pid that names no process counts as exited: a parent the server
+// can't open, like one denying it access, is taken to be running.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return err != errorInvalidParameter
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
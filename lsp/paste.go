@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Large pastes. When a change replaces nearly all of a sizable document,
+// as pasting a big query or fixture over the old one does, nothing from
+// the previous version is worth reusing and the user is likely to keep
+// going. The change is published in two passes: right away the parse
+// errors alone, which take one parse, and after a pause the full
+// diagnostics with every lint, unless a newer version has arrived by then.
+// The semantic tokens kept for the old text are dropped rather than
+// diffed against.
+
+const (
+	// largePasteMinSize is the smallest document a change can be a large
+	// paste into, in bytes
+	largePasteMinSize = 4096
+
+	// largePasteFraction is how much of the new text a change must have
+	// replaced to count as a paste over the document
+	largePasteFraction = 0.9
+
+	// largePasteDelay is how long the full pass waits for the document to
+	// settle after a large paste
+	largePasteDelay = 150 * time.Millisecond
+)
+
+// isLargePaste reports whether going from before to after replaced nearly
+// all of a sizable document. Clients that send the whole document on every
+// change make the size of the change alone no guide, so it is measured as
+// what lies between the texts' common prefix and suffix.
+func isLargePaste(before, after string) bool {
+	if len(after) < largePasteMinSize {
+		return false
+	}
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	return float64(len(after)-prefix-suffix) >= largePasteFraction*float64(len(after))
+}
+
+// syntaxDiagnostics returns the diagnostics notification for the parse
+// errors of a query alone, the first pass after a large paste
+func (s *Server) syntaxDiagnostics(uri, text string, version int) (interface{}, error) {
+	return s.diagnosticsNotification(uri, text, version, parseAndGetDiagnostics(text))
+}
+
+// schedulePasteDiagnostics publishes the full diagnostics for version of
+// uri once it has gone largePasteDelay without being superseded
+func (s *Server) schedulePasteDiagnostics(uri string, version int) {
+	ctx := s.requests.documentContext(uri, version)
+	w := s.warmup
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		timer := time.NewTimer(largePasteDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			log.Printf("Skipping full diagnostics for superseded version %d of %s", version, uri)
+			return
+		case <-timer.C:
+		}
+
+		text, current, ok := s.document(uri)
+		if !ok || current != version {
+			return
+		}
+		msg, err := s.publishDiagnostics(ctx, uri, text, version)
+		if err != nil {
+			log.Printf("Error computing diagnostics for %s: %v", uri, err)
+			return
+		}
+		if msg == nil {
+			return
+		}
+		if err := s.send(msg); err != nil {
+			log.Printf("Error sending diagnostics for %s: %v", uri, err)
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestIsLargePaste(t *testing.T) {
+	big := strings.Repeat("values 1 | head 1\n", 300)
+	other := strings.Repeat("from logs | count()\n", 300)
+	tests := []struct {
+		name          string
+		before, after string
+		want          bool
+	}{
+		{"paste into empty", "", big, true},
+		{"paste over", big, other, true},
+		{"small paste", "", "values 1", false},
+		{"keystroke in a big document", big, big[:100] + "x" + big[100:], false},
+		{"append to a big document", big, big + other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLargePaste(tt.before, tt.after); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLargePastePublishesParseErrorsFirst(t *testing.T) {
+	h := NewTestHelper()
+	background := &bytes.Buffer{}
+	h.server.out = background
+	uri := "file:///q.spq"
+	h.openDocument(t, uri, "values 1")
+
+	// Parses, but spreads a record over a key set before it: only the
+	// full pass reports that
+	text := strings.Repeat("-- padding\n", 400) + "values {a:1, ...{a:2}}"
+	resp, err := h.ProcessNotification("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("Expected diagnostics right away, got %v, %v", resp, err)
+	}
+	var first PublishDiagnosticsParams
+	json.Unmarshal(resp.Params, &first)
+	if first.Version != 2 || len(first.Diagnostics) != 0 {
+		t.Errorf("Expected only parse errors first, got %+v", first)
+	}
+
+	h.server.warmup.wait()
+	msgs := drainMessages(t, background)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected the full pass to follow, got %d messages", len(msgs))
+	}
+	var full PublishDiagnosticsParams
+	json.Unmarshal(msgs[0].Params, &full)
+	if full.Version != 2 || len(full.Diagnostics) != 1 || full.Diagnostics[0].Code != "spread-overridden" {
+		t.Errorf("Expected the spread lint in the full pass, got %+v", full)
+	}
+}
+
+func TestLargePasteSupersededSkipsFullPass(t *testing.T) {
+	h := NewTestHelper()
+	background := &bytes.Buffer{}
+	h.server.out = background
+	uri := "file:///q.spq"
+	h.openDocument(t, uri, "values 1")
+
+	text := strings.Repeat("-- padding\n", 400) + "values 1"
+	for version := 2; version <= 3; version++ {
+		if _, err := h.ProcessNotification("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument: VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+				Version:                version,
+			},
+			ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		text = "values 2"
+	}
+
+	h.server.warmup.wait()
+	if msgs := drainMessages(t, background); len(msgs) != 0 {
+		t.Errorf("Expected no full pass for a superseded paste, got %d messages", len(msgs))
+	}
+}
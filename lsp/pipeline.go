@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Pipeline layout commands. Unlike the formatter these only move stages
+// between lines: splitting puts each top-level stage on its own line and
+// joining packs stages back onto as few lines as the width allows. Text
+// inside a stage is left alone when splitting and only has its whitespace
+// collapsed when joining.
+
+// defaultPipelineWidth is the line width joinPipeline packs stages into
+// when the client doesn't give one
+const defaultPipelineWidth = 80
+
+// pipelineStage is one top-level stage of a pipeline
+type pipelineStage struct {
+	pipe   string // the pipe that introduced the stage; "" for the first
+	tokens []token
+	start  int // offset of the pipe, or 0 for the first stage
+	end    int // offset just past the stage's last token
+}
+
+// splitStages breaks tokens into stages at pipes outside any brackets, so
+// subqueries and branches stay inside the stage that contains them
+func splitStages(tokens []token) []pipelineStage {
+	stages := []pipelineStage{{}}
+	depth, offset := 0, 0
+	for _, tok := range tokens {
+		start := offset
+		offset += len(tok.value)
+		if tok.typ == tokPunctuation {
+			switch tok.value {
+			case "(", "[", "{", "|[", "|{":
+				depth++
+			case ")", "]", "}", "]|", "}|":
+				depth--
+			}
+		}
+		if tok.typ == tokPipe && depth == 0 {
+			stages = append(stages, pipelineStage{pipe: tok.value, start: start, end: offset})
+			continue
+		}
+		last := &stages[len(stages)-1]
+		last.tokens = append(last.tokens, tok)
+		last.end = offset
+	}
+	return stages
+}
+
+// operator returns the stage's leading word, e.g. "sort"
+func (st pipelineStage) operator() string {
+	for _, tok := range st.tokens {
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+			continue
+		case tokIdentifier, tokKeyword:
+			return tok.value
+		}
+		return ""
+	}
+	return ""
+}
+
+// text returns the stage as written, without surrounding whitespace
+func (st pipelineStage) text() string {
+	var b strings.Builder
+	for _, tok := range st.tokens {
+		b.WriteString(tok.value)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// compact returns the stage on one line with whitespace runs collapsed. A
+// stage with a line comment can't be put on one line without commenting out
+// what follows, so it comes back as written with lineComment set.
+func (st pipelineStage) compact() (text string, lineComment bool) {
+	var b strings.Builder
+	space := false
+	for _, tok := range st.tokens {
+		switch {
+		case tok.typ == tokComment && strings.HasPrefix(tok.value, "--"):
+			return st.text(), true
+		case tok.typ == tokWhitespace || tok.typ == tokNewline:
+			space = b.Len() > 0
+		default:
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteString(tok.value)
+		}
+	}
+	return b.String(), false
+}
+
+// splitPipelineText puts each top-level stage of text on its own line,
+// indented like the first
+func splitPipelineText(text string) string {
+	lead, core, trail := splitSurroundingSpace(text)
+	if core == "" {
+		return text
+	}
+	indent := lead[strings.LastIndexByte(lead, '\n')+1:]
+
+	var b strings.Builder
+	b.WriteString(lead)
+	for i, st := range splitStages(tokenize(core)) {
+		if i > 0 {
+			b.WriteString("\n")
+			b.WriteString(indent)
+			b.WriteString(st.pipe)
+			b.WriteString(" ")
+		}
+		b.WriteString(st.text())
+	}
+	b.WriteString(trail)
+	return b.String()
+}
+
+// joinPipelineText packs the stages of text onto as few lines as fit in
+// width, which is a single line when the whole pipeline fits
+func joinPipelineText(text string, width int) string {
+	lead, core, trail := splitSurroundingSpace(text)
+	if core == "" {
+		return text
+	}
+	indent := lead[strings.LastIndexByte(lead, '\n')+1:]
+	if width <= 0 {
+		width = defaultPipelineWidth
+	}
+
+	var b strings.Builder
+	b.WriteString(lead)
+	lineLen := utf8.RuneCountInString(indent)
+	mustBreak := false
+	for i, st := range splitStages(tokenize(core)) {
+		piece, lineComment := st.compact()
+		if i > 0 {
+			piece = st.pipe + " " + piece
+			n := utf8.RuneCountInString(piece)
+			if mustBreak || lineLen+1+n > width {
+				b.WriteString("\n")
+				b.WriteString(indent)
+				lineLen = utf8.RuneCountInString(indent)
+			} else {
+				b.WriteString(" ")
+				lineLen++
+			}
+		}
+		b.WriteString(piece)
+		if nl := strings.LastIndexByte(piece, '\n'); nl >= 0 {
+			lineLen = utf8.RuneCountInString(piece[nl+1:])
+		} else {
+			lineLen += utf8.RuneCountInString(piece)
+		}
+		mustBreak = lineComment
+	}
+	b.WriteString(trail)
+	return b.String()
+}
+
+// splitSurroundingSpace separates leading and trailing whitespace from text
+func splitSurroundingSpace(text string) (lead, core, trail string) {
+	core = strings.TrimSpace(text)
+	if core == "" {
+		return text, "", ""
+	}
+	start := strings.Index(text, core)
+	return text[:start], core, text[start+len(core):]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSplitPipelineText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"one line",
+			"from test | where x > 1 | count()",
+			"from test\n| where x > 1\n| count()",
+		},
+		{
+			"already split",
+			"from test\n| count()\n",
+			"from test\n| count()\n",
+		},
+		{
+			"nested pipes stay in their stage",
+			"from test | fork ( from a | count() ) ( pass ) | sort x",
+			"from test\n| fork ( from a | count() ) ( pass )\n| sort x",
+		},
+		{
+			"pipes in strings and comments",
+			`from test | where s == "a | b" /* c | d */ | head 1`,
+			"from test\n| where s == \"a | b\" /* c | d */\n| head 1",
+		},
+		{
+			"string concatenation is not a pipe",
+			"from test | put s := a || b | head 1",
+			"from test\n| put s := a || b\n| head 1",
+		},
+		{
+			"keeps indentation",
+			"  from test | count()\n",
+			"  from test\n  | count()\n",
+		},
+		{
+			"sql pipe",
+			"select * from t |> where x > 1",
+			"select * from t\n|> where x > 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPipelineText(tt.input); got != tt.expected {
+				t.Errorf("Expected:\n%q\nGot:\n%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestJoinPipelineText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		width    int
+		expected string
+	}{
+		{
+			"fits on one line",
+			"from test\n| where x > 1\n| count()\n",
+			0,
+			"from test | where x > 1 | count()\n",
+		},
+		{
+			"collapses whitespace inside stages",
+			"from test\n| put y := {\n    a: 1,\n    b: 2\n  }",
+			0,
+			"from test | put y := { a: 1, b: 2 }",
+		},
+		{
+			"packs to width",
+			"from test\n| where x > 1\n| sort y\n| head 10",
+			24,
+			"from test | where x > 1\n| sort y | head 10",
+		},
+		{
+			"keeps strings intact",
+			"from test\n| where s == \"a  b\"",
+			0,
+			"from test | where s == \"a  b\"",
+		},
+		{
+			"line comment forces a break",
+			"from test -- source\n| count()",
+			0,
+			"from test -- source\n| count()",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinPipelineText(tt.input, tt.width); got != tt.expected {
+				t.Errorf("Expected:\n%q\nGot:\n%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSplitJoinRoundTrip(t *testing.T) {
+	query := "from test | where x > 1 | sort y | head 10"
+	if got := joinPipelineText(splitPipelineText(query), 0); got != query {
+		t.Errorf("Expected round trip to return %q, got %q", query, got)
+	}
+}
+
+func TestPipelineCommands(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///test.spq"
+	h.openDocument(t, uri, "const n = 1\nfrom test | where x > n | count()\n")
+
+	args, _ := json.Marshal(PipelineCommandArgs{
+		URI:   uri,
+		Range: &Range{Start: Position{Line: 1, Character: 4}, End: Position{Line: 1, Character: 4}},
+	})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandSplitPipeline,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+
+	resultBytes, _ := json.Marshal(response.Result)
+	var edits []TextEdit
+	if err := json.Unmarshal(resultBytes, &edits); err != nil {
+		t.Fatalf("Unmarshal edits: %v", err)
+	}
+	if len(edits) != 1 {
+		t.Fatalf("Expected 1 edit, got %d", len(edits))
+	}
+	expected := TextEdit{
+		Range:   Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 1, Character: 33}},
+		NewText: "from test\n| where x > n\n| count()",
+	}
+	if edits[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, edits[0])
+	}
+}
+
+func TestExecuteUnknownCommand(t *testing.T) {
+	h := NewTestHelper()
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command: "superdb.noSuchCommand",
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != InvalidParams {
+		t.Errorf("Expected InvalidParams error, got %+v", response.Error)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/brimdata/super/compiler"
+	"github.com/brimdata/super/compiler/parser"
+	"github.com/brimdata/super/compiler/sfmt"
+	"github.com/brimdata/super/compiler/srcfiles"
+)
+
+// Query plans. superdb/plan runs a document through the whole compile
+// pipeline short of running it, parsing, semantic analysis, and
+// optimization, and returns the DAG the runtime would be built from, for
+// "explain" views and for diffing the plans of two versions of a query.
+
+// compilePlan compiles query into its optimized DAG, returned as JSON and
+// as query-style text. Sources are resolved against the lake when one is
+// configured, as they are when the query runs.
+func compilePlan(ctx context.Context, lake, query string) (PlanResult, error) {
+	ast, err := parser.ParseQuery(query)
+	if err != nil {
+		return PlanResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, executionTimeout)
+	defer cancel()
+	env, err := newEnvironment(ctx, lake)
+	if err != nil {
+		return PlanResult{}, err
+	}
+	main, err := compiler.Analyze(ctx, ast, env, false)
+	if err != nil {
+		return PlanResult{}, err
+	}
+	if err := compiler.Optimize(ctx, main, env, 0); err != nil {
+		return PlanResult{}, err
+	}
+	data, err := json.Marshal(main)
+	if err != nil {
+		return PlanResult{}, err
+	}
+	return PlanResult{DAG: data, Text: sfmt.DAG(main)}, nil
+}
+
+// handlePlan processes superdb/plan requests. A query that doesn't
+// compile gets a result with the diagnostic for its first error; a
+// failure to reach the lake fails the request.
+func (s *Server) handlePlan(ctx context.Context, msg RPCMessage) HandlerResult {
+	var params PlanParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.TextDocument.URI)})
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("not a query: %s", params.TextDocument.URI)})
+	}
+
+	result, err := compilePlan(ctx, s.settings().Lake, text)
+	if err == nil {
+		return success(result)
+	}
+	if ctx.Err() != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	var diag Diagnostic
+	var list srcfiles.ErrorList
+	if _, perr := parser.ParseQuery(text); perr != nil {
+		diag = errorToDiagnostic(text, perr)
+	} else if errors.As(err, &list) && len(list) > 0 && list[0].Pos >= 0 && list[0].Pos <= len(text) {
+		diag = Diagnostic{
+			Range:    analysisRange(text, list[0]),
+			Severity: DiagnosticSeverityError,
+			Code:     "analysis",
+			Source:   "superdb-lsp",
+			Message:  list[0].Msg,
+		}
+	} else {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	diag.Range = s.rangeToClient(text, diag.Range)
+	return success(PlanResult{Error: &diag})
+}
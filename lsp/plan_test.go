@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPlanRequest(t *testing.T) {
+	h := NewTestHelper()
+	tests := []struct {
+		name  string
+		text  string
+		want  string // in the plan text
+		error string // in the error message
+		line  int
+	}{
+		{"compiles", "values {a:1}\n| where a > 0\n| count()", "where", "", 0},
+		{"syntax", "values 1\n| where (", "", "error", 1},
+		{"analysis", "values 1\n| values upper(1, 2, 3)", "", "too many arguments", 1},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri := "file:///" + tt.name + ".spq"
+			h.openDocument(t, uri, tt.text)
+			response, err := h.ProcessRequest(i+2, "superdb/plan", PlanParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+			if err != nil {
+				t.Fatalf("superdb/plan failed: %v", err)
+			}
+			if response.Error != nil {
+				t.Fatalf("Unexpected error: %+v", response.Error)
+			}
+			resultBytes, _ := json.Marshal(response.Result)
+			var result PlanResult
+			json.Unmarshal(resultBytes, &result)
+			if tt.error == "" {
+				if result.Error != nil || !strings.Contains(result.Text, tt.want) || len(result.DAG) == 0 {
+					t.Errorf("Expected a plan with %q, got %s", tt.want, resultBytes)
+				}
+				return
+			}
+			if result.Error == nil || !strings.Contains(result.Error.Message, tt.error) || result.Error.Range.Start.Line != tt.line {
+				t.Errorf("Expected an error about %q on line %d, got %s", tt.error, tt.line+1, resultBytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brimdata/super/api/client"
+	"github.com/brimdata/super/db/api"
+	"github.com/brimdata/super/db/journal"
+	"github.com/brimdata/super/db/pools"
+	"github.com/brimdata/super/pkg/nano"
+	"github.com/brimdata/super/runtime/exec"
+	"github.com/brimdata/super/sup"
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+// Pool completion. With a lake configured, a path or the URL of a lake
+// service, the names of its pools complete after from, and the branches
+// and recent commits of a pool after its @. The lists are fetched the
+// first time they're needed and kept until the lake setting changes or
+// superdb.refreshPools asks for them again, so completion doesn't wait
+// on the lake at every keystroke.
+
+// poolFetchTimeout bounds how long completion waits on the lake
+const poolFetchTimeout = 5 * time.Second
+
+// maxPoolCommits bounds the recent commits offered after a pool's @
+const maxPoolCommits = 20
+
+// lakePool is a pool in the lake
+type lakePool struct {
+	name string
+	key  string // its sort key, like ts:desc
+	size int64  // bytes in its main branch
+}
+
+// poolRevision is a branch of a pool, or one of its commits
+type poolRevision struct {
+	name   string // the branch name or commit ID
+	commit bool
+	author string
+	date   time.Time
+	msg    string
+}
+
+// revisionList is what a fetch of a pool's revisions found
+type revisionList struct {
+	revisions []poolRevision
+	err       error
+}
+
+// poolCache holds the pools of the lake last asked about. A fetch runs
+// without the lock held, so a slow lake holds up only those waiting on
+// what it fetches, and each list is fetched once however many wait on it.
+type poolCache struct {
+	mu        sync.Mutex
+	lake      string
+	pools     []lakePool
+	err       error                   // why the last fetch failed, kept so it isn't retried on every keystroke
+	revisions map[string]revisionList // by lake and pool name
+	fetching  map[string]*poolFetch   // fetches in flight, by lake, or lake and pool name
+}
+
+// poolFetch is a fetch in flight. Its results are set before done closes.
+type poolFetch struct {
+	done      chan struct{}
+	pools     []lakePool
+	revisions []poolRevision
+	err       error
+}
+
+// start returns the fetch in flight for key, starting one that runs fetch
+// if there isn't one. fetch stores what it found, with c.mu held, unless
+// current reports that forget dropped the fetch meanwhile. The fetch
+// outlives the request that started it, since others may be waiting on
+// it, and is bounded by poolFetchTimeout instead. c.mu must be held.
+func (c *poolCache) start(ctx context.Context, key string, fetch func(ctx context.Context, f *poolFetch, current func() bool)) *poolFetch {
+	if f := c.fetching[key]; f != nil {
+		return f
+	}
+	f := &poolFetch{done: make(chan struct{})}
+	if c.fetching == nil {
+		c.fetching = make(map[string]*poolFetch)
+	}
+	c.fetching[key] = f
+	go func() {
+		defer close(f.done)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), poolFetchTimeout)
+		defer cancel()
+		fetch(ctx, f, func() bool { return c.fetching[key] == f })
+		c.mu.Lock()
+		if c.fetching[key] == f {
+			delete(c.fetching, key)
+		}
+		c.mu.Unlock()
+	}()
+	return f
+}
+
+// wait returns once f is done or ctx is, whichever comes first
+func (f *poolFetch) wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// list returns the pools of lake, fetching them unless they were fetched
+// for it already
+func (c *poolCache) list(ctx context.Context, lake string) ([]lakePool, error) {
+	c.mu.Lock()
+	if c.lake == lake && (c.pools != nil || c.err != nil) {
+		defer c.mu.Unlock()
+		return c.pools, c.err
+	}
+	f := c.start(ctx, lake, func(ctx context.Context, f *poolFetch, current func() bool) {
+		f.pools, f.err = fetchPools(ctx, lake)
+		if f.err != nil {
+			log.Printf("Listing pools in %s: %v", lake, f.err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if current() {
+			c.lake, c.pools, c.err = lake, f.pools, f.err
+		}
+	})
+	c.mu.Unlock()
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.pools, nil
+}
+
+// listRevisions returns the branches and recent commits of pool in lake,
+// fetching them unless they were fetched for it already
+func (c *poolCache) listRevisions(ctx context.Context, lake, pool string) ([]poolRevision, error) {
+	c.mu.Lock()
+	key := lake + "\x00" + pool
+	if list, ok := c.revisions[key]; ok {
+		defer c.mu.Unlock()
+		return list.revisions, list.err
+	}
+	f := c.start(ctx, key, func(ctx context.Context, f *poolFetch, current func() bool) {
+		f.revisions, f.err = fetchRevisions(ctx, lake, pool)
+		if f.err != nil {
+			log.Printf("Listing revisions of %s in %s: %v", pool, lake, f.err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !current() {
+			return
+		}
+		if c.revisions == nil {
+			c.revisions = make(map[string]revisionList)
+		}
+		c.revisions[key] = revisionList{f.revisions, f.err}
+	})
+	c.mu.Unlock()
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.revisions, nil
+}
+
+// forget drops the pools and revisions fetched, so the next list fetches
+// them again. What a fetch still in flight finds isn't kept.
+func (c *poolCache) forget() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools, c.err, c.revisions, c.fetching = nil, nil, nil, nil
+}
+
+// size returns how many pools are cached, and for how many pools
+// branches and commits are
+func (c *poolCache) size() (pools, revisions int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pools), len(c.revisions)
+}
+
+// openLake connects to the lake at lake, returning the connection too
+// when it's a lake service
+func openLake(ctx context.Context, lake string) (api.Interface, *client.Connection, error) {
+	if api.IsRemote(lake) {
+		conn := client.NewConnectionTo(lake)
+		return api.NewRemoteDB(conn), conn, nil
+	}
+	db, err := api.OpenLocalDB(ctx, zap.NewNop(), lake)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening lake %s: %w", lake, err)
+	}
+	return db, nil, nil
+}
+
+// fetchPools lists the pools in the lake at lake with their sizes, by
+// name
+func fetchPools(ctx context.Context, lake string) ([]lakePool, error) {
+	db, conn, err := openLake(ctx, lake)
+	if err != nil {
+		return nil, err
+	}
+	configs, err := api.GetPools(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]lakePool, 0, len(configs))
+	for _, config := range configs {
+		pool := lakePool{name: config.Name}
+		if len(config.SortKeys) > 0 {
+			pool.key = config.SortKeys.Primary().String()
+		}
+		var stats exec.PoolStats
+		if conn != nil {
+			stats, err = conn.PoolStats(ctx, config.ID)
+		} else {
+			stats, err = localPoolStats(ctx, db, config)
+		}
+		if err != nil {
+			log.Printf("Sizing pool %s: %v", config.Name, err)
+		}
+		pool.size = stats.Size
+		result = append(result, pool)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result, nil
+}
+
+// localPoolStats measures the main branch of a pool in a local lake, the
+// way the lake service does
+func localPoolStats(ctx context.Context, db api.Interface, config *pools.Config) (exec.PoolStats, error) {
+	pool, err := db.Root().OpenPool(ctx, config.ID)
+	if err != nil {
+		return exec.PoolStats{}, err
+	}
+	branch, err := pool.OpenBranchByName(ctx, "main")
+	if err != nil {
+		return exec.PoolStats{}, err
+	}
+	snap, err := pool.Snapshot(ctx, branch.Commit)
+	if errors.Is(err, journal.ErrEmpty) {
+		return exec.PoolStats{}, nil
+	}
+	if err != nil {
+		return exec.PoolStats{}, err
+	}
+	return exec.GetPoolStats(ctx, pool, snap)
+}
+
+// fetchRevisions lists the branches of pool in the lake at lake, by name,
+// then the commits of its main branch, newest first
+func fetchRevisions(ctx context.Context, lake, pool string) ([]poolRevision, error) {
+	db, _, err := openLake(ctx, lake)
+	if err != nil {
+		return nil, err
+	}
+	var branches []struct {
+		Name string `super:"name"`
+	}
+	query := fmt.Sprintf("from :branches | pool.name == %s | values {name:branch.name} | sort name", sup.QuotedString(pool))
+	if err := queryValues(ctx, db, query, &branches); err != nil {
+		return nil, err
+	}
+	var commits []struct {
+		ID      ksuid.KSUID `super:"id"`
+		Author  string      `super:"author"`
+		Date    nano.Ts     `super:"date"`
+		Message string      `super:"message"`
+	}
+	query = fmt.Sprintf("from %s@main:log | has(author) | head %d | cut id, author, date, message", sup.QuotedName(pool), maxPoolCommits)
+	if err := queryValues(ctx, db, query, &commits); err != nil {
+		// A pool without a main branch still has its branches to offer
+		log.Printf("Listing commits of %s: %v", pool, err)
+	}
+	revisions := make([]poolRevision, 0, len(branches)+len(commits))
+	for _, b := range branches {
+		revisions = append(revisions, poolRevision{name: b.Name})
+	}
+	for _, c := range commits {
+		revisions = append(revisions, poolRevision{
+			name:   c.ID.String(),
+			commit: true,
+			author: c.Author,
+			date:   c.Date.Time(),
+			msg:    c.Message,
+		})
+	}
+	return revisions, nil
+}
+
+// queryValues runs query against db and unmarshals the values it yields
+// into the slice out points at
+func queryValues[T any](ctx context.Context, db api.Interface, query string, out *[]T) error {
+	q, err := db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer q.Pull(true)
+	for {
+		batch, err := q.Pull(false)
+		if err != nil || batch == nil {
+			return err
+		}
+		for _, val := range batch.Values() {
+			var v T
+			if err := sup.UnmarshalBSUP(val, &v); err != nil {
+				batch.Unref()
+				return err
+			}
+			*out = append(*out, v)
+		}
+		batch.Unref()
+	}
+}
+
+// poolNamePattern matches a line that ends in a pool name being typed
+// after from, capturing the name, and the branch or commit being typed
+// after its @ if there is one
+var poolNamePattern = regexp.MustCompile(`\bfrom\s+([\w-]*)(?:@([\w-]*))?$`)
+
+// poolCompletions returns the pools whose names start with what is typed
+// after from at pos, or the branches and commits after a pool's @, and
+// reports whether pos is after from in a document whose lake has them to
+// offer
+func (s *Server) poolCompletions(ctx context.Context, text string, pos Position) ([]CompletionItem, bool) {
+	lake := s.settings().Lake
+	if lake == "" {
+		return nil, false
+	}
+	line, ok := lineAt(text, pos.Line)
+	if !ok || pos.Character > len(line) {
+		return nil, false
+	}
+	m := poolNamePattern.FindStringSubmatch(line[:pos.Character])
+	if m == nil {
+		return nil, false
+	}
+	if offset, ok := offsetAt(text, pos); !ok || inStringOrComment(text, offset) {
+		return nil, false
+	}
+	if strings.Contains(m[0], "@") {
+		return s.revisionCompletions(ctx, lake, m[1], m[2])
+	}
+	lakePools, err := s.pools.list(ctx, lake)
+	if err != nil || len(lakePools) == 0 {
+		return nil, false
+	}
+	items := []CompletionItem{}
+	for _, pool := range lakePools {
+		if !hasPrefixFold(pool.name, m[1]) {
+			continue
+		}
+		detail := "pool, " + formatSize(pool.size)
+		if pool.key != "" {
+			detail = "pool by " + pool.key + ", " + formatSize(pool.size)
+		}
+		items = append(items, CompletionItem{
+			Label:  pool.name,
+			Kind:   CompletionItemKindModule,
+			Detail: detail,
+		})
+	}
+	return items, true
+}
+
+// revisionCompletions returns the branches and recent commits of pool
+// that start with typed, and reports whether pool has any
+func (s *Server) revisionCompletions(ctx context.Context, lake, pool, typed string) ([]CompletionItem, bool) {
+	revisions, err := s.pools.listRevisions(ctx, lake, pool)
+	if err != nil || len(revisions) == 0 {
+		return nil, false
+	}
+	items := []CompletionItem{}
+	for i, rev := range revisions {
+		if !hasPrefixFold(rev.name, typed) {
+			continue
+		}
+		// Branches by name, then commits newest first
+		item := CompletionItem{
+			Label:    rev.name,
+			Kind:     CompletionItemKindReference,
+			Detail:   "branch of " + pool,
+			SortText: fmt.Sprintf("%04d", i),
+		}
+		if rev.commit {
+			item.Kind = CompletionItemKindValue
+			item.Detail = fmt.Sprintf("commit by %s, %s", rev.author, rev.date.UTC().Format("2006-01-02 15:04"))
+			item.Documentation = rev.msg
+		}
+		items = append(items, item)
+	}
+	return items, true
+}
+
+// hasPrefixFold reports whether s starts with prefix, ignoring ASCII case
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && containsFold(s[:len(prefix)], prefix)
+}
+
+// formatSize renders a byte count the way people read one, like 1.5 MB
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// refreshPools fetches the lake's pools again, for when they've changed
+// since completion last listed them, and returns how many there are. The
+// branches and commits of each are fetched again when next asked for.
+func (s *Server) refreshPools(ctx context.Context, _ []json.RawMessage) HandlerResult {
+	lake := s.settings().Lake
+	if lake == "" {
+		return failure(&RPCError{Code: RequestFailed, Message: "no lake is configured"})
+	}
+	s.pools.forget()
+	lakePools, err := s.pools.list(ctx, lake)
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	return success(RefreshPoolsResult{Pools: len(lakePools)})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brimdata/super"
+	superapi "github.com/brimdata/super/api"
+	"github.com/brimdata/super/db/api"
+	"github.com/brimdata/super/order"
+	"github.com/brimdata/super/sio/supio"
+	"github.com/segmentio/ksuid"
+	"go.uber.org/zap"
+)
+
+func TestPoolCompletions(t *testing.T) {
+	ctx := context.Background()
+	lake := filepath.Join(t.TempDir(), "lake")
+	db, err := api.CreateLocalDB(ctx, zap.NewNop(), lake)
+	if err != nil {
+		t.Fatalf("creating lake: %v", err)
+	}
+	keys, _ := order.ParseSortKeys("ts:desc")
+	for _, name := range []string{"logs", "metrics"} {
+		if _, err := db.CreatePool(ctx, name, keys, 0, 0); err != nil {
+			t.Fatalf("creating pool %s: %v", name, err)
+		}
+	}
+	if _, err := runQuery(ctx, lake, "values {ts:2024-01-01T00:00:00Z,x:1} | load logs", 0); err != nil {
+		t.Fatalf("loading logs: %v", err)
+	}
+
+	h := NewTestHelper()
+	opts, _ := json.Marshal(InitializationOptions{Lake: lake})
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	complete := func(text string) []CompletionItem {
+		uri := "file:///pools.spq"
+		h.openDocument(t, uri, text)
+		response, err := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		if err != nil {
+			t.Fatalf("completion failed: %v", err)
+		}
+		var list CompletionList
+		resultBytes, _ := json.Marshal(response.Result)
+		json.Unmarshal(resultBytes, &list)
+		return list.Items
+	}
+
+	items := complete("from ")
+	if got := completionLabels(items); strings.Join(got, ",") != "logs,metrics" {
+		t.Fatalf("Expected the lake's pools, got %v", got)
+	}
+	if !strings.HasPrefix(items[0].Detail, "pool by ts:desc, ") || items[0].Detail == "pool by ts:desc, 0 B" {
+		t.Errorf("Expected the key and size of logs, got %q", items[0].Detail)
+	}
+	if got := completionLabels(complete("from me")); strings.Join(got, ",") != "metrics" {
+		t.Errorf("Expected pools matching the prefix, got %v", got)
+	}
+	if got := completionLabels(complete("values 1 | so")); len(got) == 0 || strings.Contains(strings.Join(got, ","), "logs") {
+		t.Errorf("Expected no pools away from from, got %v", got)
+	}
+
+	// After a pool's @, its branches, then its commits newest first
+	logsID, _ := db.PoolID(ctx, "logs")
+	if err := db.CreateBranch(ctx, logsID, "dev", ksuid.Nil); err != nil {
+		t.Fatalf("creating branch dev: %v", err)
+	}
+	sctx := super.NewContext()
+	r := supio.NewReader(sctx, strings.NewReader("{ts:2024-01-02T00:00:00Z,x:2}"))
+	if _, err := db.Load(ctx, sctx, logsID, "main", r, superapi.CommitMessage{Author: "alice", Body: "second"}); err != nil {
+		t.Fatalf("loading logs: %v", err)
+	}
+	items = complete("from logs@")
+	if got := completionLabels(items); len(got) != 4 || strings.Join(got[:2], ",") != "dev,main" {
+		t.Fatalf("Expected the branches then two commits, got %v", got)
+	}
+	if c := items[2]; c.Kind != CompletionItemKindValue || !strings.HasPrefix(c.Detail, "commit by alice, ") || c.Documentation != "second" {
+		t.Errorf("Expected the newest commit first, by alice, got %+v", c)
+	}
+	if got := completionLabels(complete("from logs@ma")); strings.Join(got, ",") != "main" {
+		t.Errorf("Expected branches matching the prefix, got %v", got)
+	}
+
+	// A pool made since the list was fetched appears after a refresh
+	if _, err := db.CreatePool(ctx, "traces", keys, 0, 0); err != nil {
+		t.Fatalf("creating pool traces: %v", err)
+	}
+	if got := completionLabels(complete("from t")); len(got) != 0 {
+		t.Errorf("Expected the cached list, got %v", got)
+	}
+	response, err := h.ProcessRequest(3, "workspace/executeCommand", ExecuteCommandParams{Command: CommandRefreshPools})
+	if err != nil || response.Error != nil {
+		t.Fatalf("refreshPools failed: %v %+v", err, response.Error)
+	}
+	var result RefreshPoolsResult
+	resultBytes, _ := json.Marshal(response.Result)
+	json.Unmarshal(resultBytes, &result)
+	if result.Pools != 3 {
+		t.Errorf("Expected 3 pools after the refresh, got %+v", result)
+	}
+	if got := completionLabels(complete("from t")); strings.Join(got, ",") != "traces" {
+		t.Errorf("Expected the new pool, got %v", got)
+	}
+}
+
+func TestPoolFetchInFlight(t *testing.T) {
+	c := &poolCache{}
+	release := make(chan struct{})
+	fetches := 0
+	slow := func(ctx context.Context, f *poolFetch, current func() bool) {
+		fetches++
+		<-release
+		f.pools = []lakePool{{name: "logs"}}
+	}
+
+	c.mu.Lock()
+	first := c.start(context.Background(), "lake", slow)
+	second := c.start(context.Background(), "lake", slow)
+	c.mu.Unlock()
+	if first != second {
+		t.Error("Expected callers to share the fetch in flight")
+	}
+
+	// The lock is free while the lake is slow, and a caller that gives up
+	// doesn't wait for it
+	c.forget()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := first.wait(ctx); err != context.Canceled {
+		t.Errorf("Expected the cancelled wait to return, got %v", err)
+	}
+
+	close(release)
+	if err := first.wait(context.Background()); err != nil || len(first.pools) != 1 || fetches != 1 {
+		t.Errorf("Expected one fetch of one pool, got %d of %v (%v)", fetches, first.pools, err)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 << 20: "5.0 MB"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Work-done progress. When the client declares window.workDoneProgress,
+// long operations show a progress bar: indexing a workspace folder, the
+// commands that walk every query in the workspace, and validating a large
+// data file. The server creates a token with window/workDoneProgress/create
+// and follows it with $/progress begin, report, and end notifications. It
+// doesn't hold the reports back until the client replies, since the client
+// handles the create request before anything sent after it; if the client
+// refuses the token, reporting stops. A cancellable operation stops early
+// on window/workDoneProgress/cancel, which, like $/cancelRequest, takes
+// effect as soon as it is read.
+
+// progressInterval is the least time between two reports of one operation
+const progressInterval = 100 * time.Millisecond
+
+// largeDataFile is the size from which validating a data file reports
+// progress
+const largeDataFile = 1 << 20
+
+// workDone reports the progress of one operation. Its context is cancelled
+// when the client cancels the operation.
+type workDone struct {
+	s       *Server
+	token   string // "" when the client doesn't show progress
+	ctx     context.Context
+	refused atomic.Bool // the client refused the token
+
+	mu   sync.Mutex
+	last time.Time // when the last report was sent
+}
+
+// startProgress begins reporting the progress of an operation titled
+// title. Its context is derived from ctx.
+func (s *Server) startProgress(ctx context.Context, title string, cancellable bool) *workDone {
+	p := &workDone{s: s, ctx: ctx}
+	if !s.workDoneProgress {
+		return p
+	}
+	p.token, p.ctx = s.requests.addProgress(ctx)
+	err := s.request("window/workDoneProgress/create", WorkDoneProgressCreateParams{Token: p.token}, func(msg RPCMessage) {
+		if msg.Error != nil {
+			log.Printf("Client refused progress %s: %s", p.token, msg.Error.Message)
+			p.refused.Store(true)
+		}
+	})
+	if err != nil {
+		log.Printf("Error creating progress: %v", err)
+		s.requests.endProgress(p.token)
+		p.token = ""
+		return p
+	}
+	p.send(WorkDoneProgressBegin{Kind: "begin", Title: title, Cancellable: cancellable})
+	return p
+}
+
+// noProgress returns a workDone for an operation that doesn't show its
+// progress, cancelled with ctx
+func noProgress(ctx context.Context) *workDone {
+	return &workDone{ctx: ctx}
+}
+
+// report sends message and, unless it is negative, percentage, at most
+// once per progressInterval
+func (p *workDone) report(message string, percentage int) {
+	if p.token == "" {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.last = now
+	p.mu.Unlock()
+	value := WorkDoneProgressReport{Kind: "report", Message: message}
+	if percentage >= 0 {
+		value.Percentage = &percentage
+	}
+	p.send(value)
+}
+
+// end finishes the operation with a final message
+func (p *workDone) end(message string) {
+	if p.token == "" {
+		return
+	}
+	p.send(WorkDoneProgressEnd{Kind: "end", Message: message})
+	p.s.requests.endProgress(p.token)
+}
+
+// cancelled reports whether the client cancelled the operation
+func (p *workDone) cancelled() bool {
+	return p.ctx.Err() != nil
+}
+
+// send sends a $/progress notification with value, unless the client
+// refused the token
+func (p *workDone) send(value interface{}) {
+	if p.refused.Load() {
+		return
+	}
+	msg, err := notification("$/progress", ProgressParams{Token: p.token, Value: value})
+	if err == nil {
+		err = p.s.send(msg)
+	}
+	if err != nil {
+		log.Printf("Error sending progress %s: %v", p.token, err)
+	}
+}
+
+// percent returns n as a percentage of total
+func percent(n, total int) int {
+	if total == 0 {
+		return 100
+	}
+	return 100 * n / total
+}
+
+// fileCount describes n files for a progress message
+func fileCount(n int) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", n)
+}
+
+// handleWorkDoneProgressCancel processes window/workDoneProgress/cancel
+// notifications. Under Run the cancellation has already taken effect when
+// the message was read.
+func (s *Server) handleWorkDoneProgressCancel(msg RPCMessage) HandlerResult {
+	var params WorkDoneProgressCancelParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	s.requests.cancelProgress(params.Token)
+	return HandlerResult{}
+}
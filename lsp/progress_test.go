@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexingProgress(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.spq"), []byte("const a = 1\nvalues a"), 0o644)
+	os.WriteFile(filepath.Join(root, "b.spq"), []byte("const b = 1\nvalues b"), 0o644)
+
+	h := NewTestHelper()
+	params := InitializeParams{RootURI: pathToURI(root)}
+	params.Capabilities.Window.WorkDoneProgress = true
+	if _, err := h.ProcessRequest(1, "initialize", params); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	h.server.out = out
+	h.ProcessRequest(nil, "initialized", struct{}{})
+	h.server.index.wait()
+
+	var token string
+	var kinds []string
+	for _, msg := range drainMessages(t, out) {
+		switch msg.Method {
+		case "window/workDoneProgress/create":
+			var create WorkDoneProgressCreateParams
+			json.Unmarshal(msg.Params, &create)
+			token = create.Token
+		case "$/progress":
+			var progress struct {
+				Token string `json:"token"`
+				Value struct {
+					Kind    string `json:"kind"`
+					Title   string `json:"title"`
+					Message string `json:"message"`
+				} `json:"value"`
+			}
+			json.Unmarshal(msg.Params, &progress)
+			if token == "" || progress.Token != token {
+				t.Fatalf("Expected progress on a created token, got %s", msg.Params)
+			}
+			kinds = append(kinds, progress.Value.Kind)
+			if progress.Value.Kind == "end" && progress.Value.Message != "Indexed 2 files" {
+				t.Errorf("Unexpected end message %q", progress.Value.Message)
+			}
+		}
+	}
+	if len(kinds) < 2 || kinds[0] != "begin" || kinds[len(kinds)-1] != "end" {
+		t.Errorf("Expected progress to begin and end, got %v", kinds)
+	}
+}
+
+func TestRunStopsIndexingAtEOF(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 300; i++ {
+		os.WriteFile(filepath.Join(root, fmt.Sprintf("q%03d.spq", i)), []byte("values 1"), 0o644)
+	}
+	params := InitializeParams{RootURI: pathToURI(root)}
+	params.Capabilities.Window.WorkDoneProgress = true
+	initialize, _ := json.Marshal(RPCMessage{JSONRPC: "2.0", ID: 1, Method: "initialize", Params: mustMarshal(params)})
+	initialized := `{"jsonrpc":"2.0","method":"initialized","params":{}}`
+
+	// The input ends while the index is still reporting progress
+	out := &bytes.Buffer{}
+	input := frame(string(initialize)) + frame(initialized)
+	if err := NewServer().Run(strings.NewReader(input), out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if msgs := drainMessages(t, out); len(msgs) == 0 || msgs[0].ID != float64(1) {
+		t.Errorf("Expected the initialize response first, got %+v", msgs)
+	}
+}
+
+func TestProgressCancel(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.spq"), []byte("values 1"), 0o644)
+	h := NewTestHelper()
+	h.server.workDoneProgress = true
+	out := &bytes.Buffer{}
+	h.server.out = out
+
+	p := h.server.startProgress(context.Background(), "Walking", true)
+	var create WorkDoneProgressCreateParams
+	json.Unmarshal(drainMessages(t, out)[0].Params, &create)
+
+	// Run reads the cancellation ahead of handling
+	raw, _ := json.Marshal(RPCMessage{
+		JSONRPC: "2.0",
+		Method:  "window/workDoneProgress/cancel",
+		Params:  json.RawMessage(`{"token":"` + create.Token + `"}`),
+	})
+	h.server.requests.observe(raw)
+	if !p.cancelled() {
+		t.Fatal("Expected the operation cancelled")
+	}
+	if n := walkQueryFiles(root, p, func(string, string) {}); n != 0 {
+		t.Errorf("Expected a cancelled walk to visit nothing, visited %d", n)
+	}
+	p.end("Cancelled")
+
+	// Without the client capability nothing is sent
+	h.server.workDoneProgress = false
+	out.Reset()
+	p = h.server.startProgress(context.Background(), "Walking", true)
+	p.end("")
+	if msgs := drainMessages(t, out); len(msgs) != 0 {
+		t.Errorf("Expected no progress messages, got %+v", msgs)
+	}
+}
@@ -12,6 +12,19 @@ type RPCMessage struct {
 	Error   *RPCError       `json:"error,omitempty"`
 }
 
+// MarshalJSON encodes the message, making sure a successful response carries
+// a result member even when the result is null, as JSON-RPC requires
+func (m RPCMessage) MarshalJSON() ([]byte, error) {
+	type plain RPCMessage
+	if m.ID == nil || m.Method != "" || m.Error != nil {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Result interface{} `json:"result"`
+	}{plain(m), m.Result})
+}
+
 // RPCError represents a JSON-RPC error
 type RPCError struct {
 	Code    int         `json:"code"`
@@ -19,6 +32,19 @@ type RPCError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// Error implements the error interface so handlers can return RPCErrors
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// InvalidParamsData is the data of an InvalidParams error
+type InvalidParamsData struct {
+	Field    string `json:"field,omitempty"`    // path of the field that was wrong, e.g. "position.line"
+	Expected string `json:"expected,omitempty"` // the Go type it should have been
+	Got      string `json:"got,omitempty"`      // the JSON it was, e.g. "string"
+	Offset   int64  `json:"offset"`             // byte offset in the params
+}
+
 // Error codes
 const (
 	ParseError     = -32700
@@ -26,6 +52,11 @@ const (
 	MethodNotFound = -32601
 	InvalidParams  = -32602
 	InternalError  = -32603
+
+	// LSP-specific codes
+	RequestCancelled = -32800
+	ContentModified  = -32801
+	RequestFailed    = -32803
 )
 
 // InitializeParams represents the initialize request parameters
@@ -33,17 +64,161 @@ type InitializeParams struct {
 	ProcessID             int                `json:"processId"`
 	RootURI               string             `json:"rootUri"`
 	Capabilities          ClientCapabilities `json:"capabilities"`
-	InitializationOptions interface{}        `json:"initializationOptions,omitempty"`
+	InitializationOptions json.RawMessage    `json:"initializationOptions,omitempty"`
+	Locale                string             `json:"locale,omitempty"`
+	WorkspaceFolders      []WorkspaceFolder  `json:"workspaceFolders,omitempty"`
+}
+
+// InitializationOptions are the server-specific settings a client may send
+// in initialize
+type InitializationOptions struct {
+	// VerifyRefactors runs refactored queries against a sample of their
+	// source data before offering them
+	VerifyRefactors bool `json:"verifyRefactors,omitempty"`
+	// Lake is the path or URI of a SuperDB lake for queries to run against
+	Lake string `json:"lake,omitempty"`
+	// AllowLakeWrites lets superdb.runQuery run queries that change the
+	// lake, after the user confirms each run
+	AllowLakeWrites bool `json:"allowLakeWrites,omitempty"`
+	// CompletionTelemetry records which completion items are accepted, in
+	// a local file, to rank frequently used items first
+	CompletionTelemetry bool `json:"completionTelemetry,omitempty"`
+	// CompletionTelemetryPath overrides where accepted completions are
+	// recorded
+	CompletionTelemetryPath string `json:"completionTelemetryPath,omitempty"`
+	// PlainText renders hover, signature, and completion documentation as
+	// structured plain text instead of markdown, for screen readers and
+	// clients that render markdown poorly
+	PlainText bool `json:"plainText,omitempty"`
+	// OperatorAliases is "off" to stop hinting at operators written in an
+	// older spelling, like yield for values; the default is "canonical"
+	OperatorAliases string `json:"operatorAliases,omitempty"`
+	// ReadOnlyPaths are directories, relative to the workspace root, whose
+	// files the server proposes no edits to
+	ReadOnlyPaths []string `json:"readOnlyPaths,omitempty"`
+	// Parameters are the query parameters read with env("NAME"), by name
+	Parameters map[string]Parameter `json:"parameters,omitempty"`
+	// SourceKinds sets what the queries in a directory read, "stdin",
+	// "file", or "lake", by path pattern like readOnlyPaths; "." is the
+	// whole workspace
+	SourceKinds map[string]string `json:"sourceKinds,omitempty"`
+	// Format overrides the formatting options the editor sends, so a
+	// workspace formats alike in every editor
+	Format FormatSettings `json:"format,omitempty"`
+	// Severities sets the severity of diagnostics by code, as "error",
+	// "warning", "information", "hint", or "off"
+	Severities map[string]string `json:"severities,omitempty"`
+	// CompletionDocs is "brief" to send completion items without their
+	// documentation; the default is "full"
+	CompletionDocs string `json:"completionDocs,omitempty"`
+	// InternalErrors is how the server's own failures reach the user:
+	// "show" in a message and the output panel, the default, "log" in
+	// the output panel only, or "off"
+	InternalErrors string `json:"internalErrors,omitempty"`
+	// Save is what happens when a document is saved
+	Save SaveSettings `json:"save,omitempty"`
+	// DataFiles are paths, in the form of readOnlyPaths, whose files are
+	// data like .sup files, whatever their extension
+	DataFiles []string `json:"dataFiles,omitempty"`
+	// KeepClosedDiagnostics leaves the diagnostics of a closed document in
+	// place instead of clearing them
+	KeepClosedDiagnostics bool `json:"keepClosedDiagnostics,omitempty"`
+	// DialectVersion is the version of the super language the workspace's
+	// queries target, reported in superdb/features. An older version
+	// leaves out of completion what it lacks and warns where it's used.
+	// The default is the brimdata/super commit of the bundled parser
+	DialectVersion string `json:"dialectVersion,omitempty"`
+	// FormatterStyle is "compact" to leave pipeline stages on the lines
+	// they're written on; the default, "standard", puts each on its own
+	FormatterStyle string `json:"formatterStyle,omitempty"`
+	// SampleFiles names the data file whose fields complete in the queries
+	// under each path pattern, like sourceKinds
+	SampleFiles map[string]string `json:"sampleFiles,omitempty"`
+	// Completions are the settings for what completion offers
+	Completions CompletionSettings `json:"completions,omitempty"`
+}
+
+// SaveSettings are the settings for saving a document
+type SaveSettings struct {
+	// Validate runs semantic analysis on a saved query
+	Validate bool `json:"validate,omitempty"`
+}
+
+// CompletionSettings are the settings for what completion offers
+type CompletionSettings struct {
+	// SQLAliases is false to leave the SQL spellings of types, like
+	// varchar and bigint, out of completion; the default is true
+	SQLAliases *bool `json:"sqlAliases,omitempty"`
+}
+
+// FormatSettings are formatting options that, when set, override the ones
+// in a formatting request
+type FormatSettings struct {
+	TabSize                *int  `json:"tabSize,omitempty"`
+	InsertSpaces           *bool `json:"insertSpaces,omitempty"`
+	TrimTrailingWhitespace *bool `json:"trimTrailingWhitespace,omitempty"`
+	InsertFinalNewline     *bool `json:"insertFinalNewline,omitempty"`
+	TrimFinalNewlines      *bool `json:"trimFinalNewlines,omitempty"`
+}
+
+// DidChangeConfigurationParams for workspace/didChangeConfiguration
+type DidChangeConfigurationParams struct {
+	Settings json.RawMessage `json:"settings"`
+}
+
+// Parameter is a query parameter configured in initializationOptions
+type Parameter struct {
+	// Default is the value, as JSON, used when the environment doesn't
+	// set the parameter
+	Default     json.RawMessage `json:"default,omitempty"`
+	Description string          `json:"description,omitempty"`
 }
 
 // ClientCapabilities represents client capabilities
 type ClientCapabilities struct {
 	TextDocument TextDocumentClientCapabilities `json:"textDocument,omitempty"`
+	Workspace    WorkspaceClientCapabilities    `json:"workspace,omitempty"`
+	Window       WindowClientCapabilities       `json:"window,omitempty"`
+	General      GeneralClientCapabilities      `json:"general,omitempty"`
+}
+
+// GeneralClientCapabilities represents general client capabilities
+type GeneralClientCapabilities struct {
+	PositionEncodings []string `json:"positionEncodings,omitempty"` // in order of preference
 }
 
 // TextDocumentClientCapabilities represents text document capabilities
 type TextDocumentClientCapabilities struct {
-	Completion CompletionClientCapabilities `json:"completion,omitempty"`
+	Completion         CompletionClientCapabilities         `json:"completion,omitempty"`
+	Hover              HoverClientCapabilities              `json:"hover,omitempty"`
+	CodeAction         CodeActionClientCapabilities         `json:"codeAction,omitempty"`
+	PublishDiagnostics PublishDiagnosticsClientCapabilities `json:"publishDiagnostics,omitempty"`
+}
+
+// HoverClientCapabilities represents hover capabilities
+type HoverClientCapabilities struct {
+	ContentFormat []string `json:"contentFormat,omitempty"` // markup kinds, in order of preference
+}
+
+// CodeActionClientCapabilities represents code action capabilities. A
+// client without literal support takes commands instead.
+type CodeActionClientCapabilities struct {
+	CodeActionLiteralSupport *CodeActionLiteralSupport `json:"codeActionLiteralSupport,omitempty"`
+}
+
+// CodeActionLiteralSupport lists the kinds of code action literal the
+// client takes
+type CodeActionLiteralSupport struct {
+	CodeActionKind struct {
+		ValueSet []string `json:"valueSet"`
+	} `json:"codeActionKind"`
+}
+
+// PublishDiagnosticsClientCapabilities represents diagnostic capabilities
+type PublishDiagnosticsClientCapabilities struct {
+	TagSupport struct {
+		ValueSet []int `json:"valueSet"`
+	} `json:"tagSupport,omitempty"`
 }
 
 // CompletionClientCapabilities represents completion capabilities
@@ -53,17 +228,83 @@ type CompletionClientCapabilities struct {
 
 // CompletionItemClientCapabilities represents completion item capabilities
 type CompletionItemClientCapabilities struct {
-	SnippetSupport bool `json:"snippetSupport,omitempty"`
+	SnippetSupport      bool     `json:"snippetSupport,omitempty"`
+	DocumentationFormat []string `json:"documentationFormat,omitempty"` // markup kinds, in order of preference
+	DeprecatedSupport   bool     `json:"deprecatedSupport,omitempty"`
+	LabelDetailsSupport bool     `json:"labelDetailsSupport,omitempty"`
+	TagSupport          struct {
+		ValueSet []int `json:"valueSet"`
+	} `json:"tagSupport,omitempty"`
+}
+
+// WorkspaceClientCapabilities represents workspace capabilities
+type WorkspaceClientCapabilities struct {
+	ApplyEdit             bool                            `json:"applyEdit,omitempty"`
+	DidChangeWatchedFiles DynamicRegistrationCapabilities `json:"didChangeWatchedFiles,omitempty"`
+}
+
+// DynamicRegistrationCapabilities says whether the client lets a feature
+// be registered after initialize
+type DynamicRegistrationCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+// WindowClientCapabilities represents window capabilities
+type WindowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
+
+// TextDocumentSyncOptions are the document notifications the server wants
+type TextDocumentSyncOptions struct {
+	OpenClose bool         `json:"openClose"`
+	Change    int          `json:"change"`
+	Save      *SaveOptions `json:"save,omitempty"`
+}
+
+// SaveOptions ask for textDocument/didSave
+type SaveOptions struct {
+	IncludeText bool `json:"includeText,omitempty"`
 }
 
 // ServerCapabilities represents the server's capabilities
 type ServerCapabilities struct {
-	TextDocumentSync          int                   `json:"textDocumentSync"`
+	PositionEncoding          string                `json:"positionEncoding,omitempty"`
+	TextDocumentSync          TextDocumentSyncOptions `json:"textDocumentSync"`
 	CompletionProvider        *CompletionOptions    `json:"completionProvider,omitempty"`
 	DiagnosticProvider        *DiagnosticOptions    `json:"diagnosticProvider,omitempty"`
 	HoverProvider             bool                  `json:"hoverProvider,omitempty"`
 	SignatureHelpProvider     *SignatureHelpOptions `json:"signatureHelpProvider,omitempty"`
 	DocumentFormattingProvider bool                 `json:"documentFormattingProvider,omitempty"`
+	ExecuteCommandProvider    *ExecuteCommandOptions `json:"executeCommandProvider,omitempty"`
+	CodeActionProvider        *CodeActionOptions     `json:"codeActionProvider,omitempty"`
+	DefinitionProvider        bool                   `json:"definitionProvider,omitempty"`
+	ReferencesProvider        bool                   `json:"referencesProvider,omitempty"`
+	RenameProvider            *RenameOptions         `json:"renameProvider,omitempty"`
+	DocumentSymbolProvider    bool                   `json:"documentSymbolProvider,omitempty"`
+	WorkspaceSymbolProvider   bool                   `json:"workspaceSymbolProvider,omitempty"`
+	SemanticTokensProvider    *SemanticTokensOptions `json:"semanticTokensProvider,omitempty"`
+	InlayHintProvider         bool                   `json:"inlayHintProvider,omitempty"`
+	CodeLensProvider          *CodeLensOptions       `json:"codeLensProvider,omitempty"`
+	SelectionRangeProvider    bool                   `json:"selectionRangeProvider,omitempty"`
+	LinkedEditingRangeProvider bool                  `json:"linkedEditingRangeProvider,omitempty"`
+	DocumentLinkProvider      *DocumentLinkOptions   `json:"documentLinkProvider,omitempty"`
+	Workspace                 *WorkspaceServerCapabilities `json:"workspace,omitempty"`
+}
+
+// RenameOptions says whether the server answers textDocument/prepareRename
+type RenameOptions struct {
+	PrepareProvider bool `json:"prepareProvider,omitempty"`
+}
+
+// CodeActionOptions lists the kinds of code actions the server offers
+type CodeActionOptions struct {
+	CodeActionKinds []string `json:"codeActionKinds,omitempty"`
+	ResolveProvider bool     `json:"resolveProvider,omitempty"`
+}
+
+// ExecuteCommandOptions lists the commands the server can execute
+type ExecuteCommandOptions struct {
+	Commands []string `json:"commands"`
 }
 
 // CompletionOptions represents completion provider options
@@ -90,6 +331,22 @@ type ServerInfo struct {
 	Version string `json:"version,omitempty"`
 }
 
+// FeaturesParams for the superdb/features notification, sent once after
+// initialized so companion extensions can adapt their UI
+type FeaturesParams struct {
+	Lake           bool   `json:"lake"`           // lake integration is configured
+	Execution      bool   `json:"execution"`      // queries can be run from the editor
+	Dialect        string `json:"dialect"`        // language the server targets
+	DialectVersion string `json:"dialectVersion"` // language version the queries target
+	FormatterStyle string `json:"formatterStyle"`
+}
+
+// Formatter styles
+const (
+	FormatterStyleStandard = "standard" // one pipeline stage per line
+	FormatterStyleCompact  = "compact"  // stages stay on the lines they're written on
+)
+
 // TextDocumentIdentifier identifies a text document
 type TextDocumentIdentifier struct {
 	URI string `json:"uri"`
@@ -120,9 +377,11 @@ type DidChangeTextDocumentParams struct {
 	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
 }
 
-// TextDocumentContentChangeEvent represents a change event
+// TextDocumentContentChangeEvent represents a change event. Without a
+// range, Text replaces the whole document.
 type TextDocumentContentChangeEvent struct {
-	Text string `json:"text"`
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
 }
 
 // DidCloseTextDocumentParams for textDocument/didClose
@@ -130,6 +389,11 @@ type DidCloseTextDocumentParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 }
 
+// CancelParams for $/cancelRequest
+type CancelParams struct {
+	ID interface{} `json:"id"`
+}
+
 // Position represents a position in a text document
 type Position struct {
 	Line      int `json:"line"`
@@ -155,8 +419,26 @@ type Diagnostic struct {
 	Code     string `json:"code,omitempty"`
 	Source   string `json:"source,omitempty"`
 	Message  string `json:"message"`
+	Tags     []int  `json:"tags,omitempty"`
 }
 
+// Completion item tags
+const (
+	CompletionItemTagDeprecated = 1
+)
+
+// Insert text formats
+const (
+	InsertTextFormatPlainText = 1
+	InsertTextFormatSnippet   = 2
+)
+
+// Diagnostic tags
+const (
+	DiagnosticTagUnnecessary = 1
+	DiagnosticTagDeprecated  = 2
+)
+
 // Diagnostic severity levels
 const (
 	DiagnosticSeverityError       = 1
@@ -194,11 +476,42 @@ const (
 
 // CompletionItem represents a completion item
 type CompletionItem struct {
-	Label         string `json:"label"`
-	Kind          int    `json:"kind,omitempty"`
-	Detail        string `json:"detail,omitempty"`
-	Documentation string `json:"documentation,omitempty"`
-	InsertText    string `json:"insertText,omitempty"`
+	Label            string        `json:"label"`
+	LabelDetails     *LabelDetails `json:"labelDetails,omitempty"` // shown beside the label
+	Kind             int           `json:"kind,omitempty"`
+	Detail           string        `json:"detail,omitempty"`
+	Documentation    interface{}   `json:"documentation,omitempty"` // a string or MarkupContent
+	InsertText       string        `json:"insertText,omitempty"`
+	InsertTextFormat int           `json:"insertTextFormat,omitempty"` // how InsertText reads; plain text when unset
+	TextEdit         *TextEdit     `json:"textEdit,omitempty"`         // replaces InsertText and the word typed
+	SortText         string        `json:"sortText,omitempty"`
+	FilterText       string        `json:"filterText,omitempty"`
+	Tags             []int         `json:"tags,omitempty"`
+	Deprecated       bool          `json:"deprecated,omitempty"` // for clients that predate tags
+	Command          *Command      `json:"command,omitempty"`    // run after the item is inserted
+	Data             interface{}   `json:"data,omitempty"`       // kept by the client for completionItem/resolve
+}
+
+// LabelDetails are what a completion item shows beside its label: a
+// function's parameters and result right after its name, and a
+// description after that
+type LabelDetails struct {
+	Detail      string `json:"detail,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// CompletionItemData is the data of a completion item whose documentation
+// is filled in by completionItem/resolve
+type CompletionItemData struct {
+	Builtin string `json:"builtin,omitempty"`
+}
+
+// Command is a command the client runs on the server's behalf, such as
+// after a completion item is accepted
+type Command struct {
+	Title     string        `json:"title"`
+	Command   string        `json:"command"`
+	Arguments []interface{} `json:"arguments,omitempty"`
 }
 
 // Completion item kinds
@@ -230,6 +543,63 @@ const (
 	CompletionItemKindTypeParameter = 25
 )
 
+// Symbol kinds
+const (
+	SymbolKindFile          = 1
+	SymbolKindModule        = 2
+	SymbolKindNamespace     = 3
+	SymbolKindPackage       = 4
+	SymbolKindClass         = 5
+	SymbolKindMethod        = 6
+	SymbolKindProperty      = 7
+	SymbolKindField         = 8
+	SymbolKindConstructor   = 9
+	SymbolKindEnum          = 10
+	SymbolKindInterface     = 11
+	SymbolKindFunction      = 12
+	SymbolKindVariable      = 13
+	SymbolKindConstant      = 14
+	SymbolKindString        = 15
+	SymbolKindNumber        = 16
+	SymbolKindBoolean       = 17
+	SymbolKindArray         = 18
+	SymbolKindObject        = 19
+	SymbolKindKey           = 20
+	SymbolKindNull          = 21
+	SymbolKindEnumMember    = 22
+	SymbolKindStruct        = 23
+	SymbolKindEvent         = 24
+	SymbolKindOperator      = 25
+	SymbolKindTypeParameter = 26
+)
+
+// DocumentSymbolParams for textDocument/documentSymbol
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentSymbol is an entry in a document's outline
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// WorkspaceSymbolParams for workspace/symbol
+type WorkspaceSymbolParams struct {
+	Query string `json:"query"`
+}
+
+// SymbolInformation is a declaration found by workspace/symbol
+type SymbolInformation struct {
+	Name     string   `json:"name"`
+	Kind     int      `json:"kind"`
+	Location Location `json:"location"`
+}
+
 // CompletionList represents a list of completion items
 type CompletionList struct {
 	IsIncomplete bool             `json:"isIncomplete"`
@@ -242,6 +612,43 @@ type HoverParams struct {
 	Position     Position               `json:"position"`
 }
 
+// DefinitionParams for textDocument/definition
+type DefinitionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// ReferenceParams for textDocument/references
+type ReferenceParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      ReferenceContext       `json:"context"`
+}
+
+// ReferenceContext says whether to include the declaration itself
+type ReferenceContext struct {
+	IncludeDeclaration bool `json:"includeDeclaration"`
+}
+
+// PrepareRenameParams for textDocument/prepareRename
+type PrepareRenameParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// PrepareRenameResult is the name a rename would change and where it is
+type PrepareRenameResult struct {
+	Range       Range  `json:"range"`
+	Placeholder string `json:"placeholder"`
+}
+
+// RenameParams for textDocument/rename
+type RenameParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	NewName      string                 `json:"newName"`
+}
+
 // Hover represents a hover response
 type Hover struct {
 	Contents MarkupContent `json:"contents"`
@@ -321,3 +728,700 @@ type TextEdit struct {
 	Range   Range  `json:"range"`
 	NewText string `json:"newText"`
 }
+
+// ShowMessageRequestParams for window/showMessageRequest
+type ShowMessageRequestParams struct {
+	Type    int                 `json:"type"`
+	Message string              `json:"message"`
+	Actions []MessageActionItem `json:"actions,omitempty"`
+}
+
+// ShowMessageParams for window/showMessage
+type ShowMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// LogMessageParams for window/logMessage
+type LogMessageParams struct {
+	Type    int    `json:"type"`
+	Message string `json:"message"`
+}
+
+// MessageActionItem is a button in a window/showMessageRequest
+type MessageActionItem struct {
+	Title string `json:"title"`
+}
+
+// Message types for window/showMessage and window/showMessageRequest
+const (
+	MessageTypeError   = 1
+	MessageTypeWarning = 2
+	MessageTypeInfo    = 3
+	MessageTypeLog     = 4
+)
+
+// CodeActionParams for textDocument/codeAction
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+// CodeActionContext carries the diagnostics at the range and the kinds of
+// action the client asked for
+type CodeActionContext struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Only        []string     `json:"only,omitempty"`
+}
+
+// Code action kinds
+const (
+	CodeActionKindQuickFix        = "quickfix"
+	CodeActionKindRefactor        = "refactor"
+	CodeActionKindRefactorRewrite = "refactor.rewrite"
+)
+
+// CodeAction is a change the client can apply to a document
+type CodeAction struct {
+	Title       string         `json:"title"`
+	Kind        string         `json:"kind,omitempty"`
+	IsPreferred bool           `json:"isPreferred,omitempty"`
+	Edit        *WorkspaceEdit `json:"edit,omitempty"`
+	Data        interface{}    `json:"data,omitempty"`
+}
+
+// WorkspaceEdit holds text edits keyed by document URI
+type WorkspaceEdit struct {
+	Changes map[string][]TextEdit `json:"changes"`
+}
+
+// ApplyWorkspaceEditParams for workspace/applyEdit, sent to the client
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// ApplyWorkspaceEditResult is the client's answer to workspace/applyEdit
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// ExecuteCommandParams for workspace/executeCommand
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// PipelineCommandArgs is the argument to superdb.splitPipeline and
+// superdb.joinPipeline. Without a range the whole document is rearranged;
+// a range is widened to whole lines.
+type PipelineCommandArgs struct {
+	URI   string `json:"uri"`
+	Range *Range `json:"range,omitempty"`
+	Width int    `json:"width,omitempty"` // joinPipeline line width, default 80
+}
+
+// RecordCompletionArgs is the argument to superdb.recordCompletion, which
+// completion items carry as their command when telemetry is on
+type RecordCompletionArgs struct {
+	Label string `json:"label"`
+}
+
+// ExportUsageStatsArgs is the optional argument to superdb.exportUsageStats
+type ExportUsageStatsArgs struct {
+	Path string `json:"path,omitempty"` // relative to the workspace root
+}
+
+// UsageStatsResult is what superdb.exportUsageStats returns: how often each
+// completion item was accepted, and where the stats were written if a path
+// was given
+type UsageStatsResult struct {
+	Accepted map[string]int `json:"accepted"`
+	URI      string         `json:"uri,omitempty"`
+}
+
+// CrashReport describes the last crash, as superdb.showLastCrash returns
+// it. The excerpt is only the line the request pointed at, with literals
+// and comments scrubbed.
+type CrashReport struct {
+	Time          string `json:"time"` // RFC 3339
+	ServerVersion string `json:"serverVersion"`
+	Method        string `json:"method"`
+	Panic         string `json:"panic"`
+	Stack         string `json:"stack"`
+	URI           string `json:"uri,omitempty"`
+	Line          int    `json:"line,omitempty"` // 0-based line of the excerpt
+	Excerpt       string `json:"excerpt,omitempty"`
+}
+
+// ShowLastCrashResult is what superdb.showLastCrash returns: the report,
+// if there is one, and a markdown rendering ready to paste into an issue
+type ShowLastCrashResult struct {
+	Crash  *CrashReport `json:"crash,omitempty"`
+	Report string       `json:"report,omitempty"`
+}
+
+// GenerateReferenceArgs is the optional argument to
+// superdb.generateReference
+type GenerateReferenceArgs struct {
+	Path string `json:"path,omitempty"` // relative to the workspace root
+}
+
+// GenerateReferenceResult reports where superdb.generateReference wrote
+type GenerateReferenceResult struct {
+	URI string `json:"uri"`
+}
+
+// Custom superdb/* protocol. These types are the public contract for
+// third-party clients; schema.go generates a JSON Schema from them, checked
+// in as schema/superdb-protocol.schema.json. Add fields rather than
+// changing or removing them.
+
+// AstParams for the superdb/ast request
+type AstParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// AstResult is a document's parsed AST, or the error that stopped parsing
+type AstResult struct {
+	AST   json.RawMessage `json:"ast,omitempty"` // brimdata/super AST as JSON
+	Error *Diagnostic     `json:"error,omitempty"`
+}
+
+// PlanParams for the superdb/plan request
+type PlanParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// PlanResult is a document's compiled and optimized DAG, or the error that
+// stopped compiling
+type PlanResult struct {
+	DAG   json.RawMessage `json:"dag,omitempty"`  // brimdata/super DAG as JSON
+	Text  string          `json:"text,omitempty"` // the DAG as query-style text
+	Error *Diagnostic     `json:"error,omitempty"`
+}
+
+// VersionResult for the superdb/version request
+type VersionResult struct {
+	Version         string             `json:"version"`         // the server's version, with the super commit as build metadata
+	SuperCommit     string             `json:"superCommit"`     // brimdata/super commit of the bundled parser
+	DialectVersions []string           `json:"dialectVersions"` // language versions dialectVersion can target
+	Capabilities    ServerCapabilities `json:"capabilities"`
+	Features        FeaturesParams     `json:"features"`
+	Healthy         bool               `json:"healthy"`  // no problems were found
+	Problems        []string           `json:"problems"` // what keeps the server from working
+}
+
+// StatsResult for the superdb/stats request
+type StatsResult struct {
+	Documents int         `json:"documents"` // open documents
+	Caches    CacheStats  `json:"caches"`
+	Parses    ParseStats  `json:"parses"`
+	Memory    MemoryStats `json:"memory"`
+}
+
+// CacheStats are the sizes of the server's caches
+type CacheStats struct {
+	SemanticTokens int `json:"semanticTokens"` // documents with semantic tokens kept for deltas
+	IndexedFiles   int `json:"indexedFiles"`   // workspace files in the symbol index
+	DataFiles      int `json:"dataFiles"`      // data files whose fields complete in queries
+	Pools          int `json:"pools"`          // pools of the lake listed for completion
+	PoolRevisions  int `json:"poolRevisions"`  // pools whose branches and commits are listed for completion
+}
+
+// ParseStats are how long the parses run on each change took
+type ParseStats struct {
+	Count int64   `json:"count"` // parses since the server started
+	P50Ms float64 `json:"p50Ms"` // median of the recent ones
+	P95Ms float64 `json:"p95Ms"` // 95th percentile of the recent ones
+}
+
+// MemoryStats are the memory the server process holds
+type MemoryStats struct {
+	HeapBytes  uint64 `json:"heapBytes"` // allocated heap objects
+	SysBytes   uint64 `json:"sysBytes"`  // memory obtained from the OS
+	Goroutines int    `json:"goroutines"`
+}
+
+// FormatTextParams for the superdb/formatText request
+type FormatTextParams struct {
+	Text    string            `json:"text"`
+	Options FormattingOptions `json:"options"`
+	Data    bool              `json:"data,omitempty"` // format text as SUP data instead of a query
+}
+
+// FormatTextResult is the formatted text
+type FormatTextResult struct {
+	Text string `json:"text"`
+}
+
+// QueryResultParams for the superdb/queryResult notification, sent as
+// results of a query run from the editor arrive
+type QueryResultParams struct {
+	URI       string            `json:"uri"`
+	Values    []json.RawMessage `json:"values"`            // values in this batch, as JSON
+	Done      bool              `json:"done"`              // no more batches follow
+	Error     string            `json:"error,omitempty"`   // set when the query failed
+	ElapsedMs int64             `json:"elapsedMs,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"` // more values were produced than returned
+}
+
+// StageStatsParams for the superdb/stageStats notification, sent after a
+// query runs with stats enabled
+type StageStatsParams struct {
+	URI     string       `json:"uri"`
+	Version int          `json:"version"` // document version the query ran against
+	Stages  []StageStats `json:"stages"`
+}
+
+// StageStats is what one top-level pipeline stage did during a run
+type StageStats struct {
+	Operator  string  `json:"operator"`  // leading word of the stage, e.g. "sort"
+	Range     Range   `json:"range"`     // the stage, including its pipe
+	Records   int64   `json:"records"`   // values the stage emitted
+	ElapsedMs float64 `json:"elapsedMs"` // time the stage added to the run
+}
+
+// RunQueryArgs is the argument to superdb.runQuery
+type RunQueryArgs struct {
+	URI       string `json:"uri"`
+	Stats     bool   `json:"stats,omitempty"`     // also publish superdb/stageStats
+	MaxValues int    `json:"maxValues,omitempty"` // default 1000
+
+	// Through runs only the text before this position, e.g. up to the end
+	// of a pipeline stage
+	Through *Position `json:"through,omitempty"`
+
+	// Parameters are values, as JSON, for this run's env() calls; they
+	// take precedence over the environment and configured defaults
+	Parameters map[string]json.RawMessage `json:"parameters,omitempty"`
+}
+
+// DiffResultsArgs is the argument to superdb.diffResults
+type DiffResultsArgs struct {
+	URI    string  `json:"uri"`
+	Mode   string  `json:"mode,omitempty"`   // "saved" (default) or "selections"
+	Ranges []Range `json:"ranges,omitempty"` // the two queries, in selections mode
+	Key    string  `json:"key,omitempty"`    // field identifying a record across runs
+	Limit  int     `json:"limit,omitempty"`  // values listed per kind, default 50
+}
+
+// DiffResultsResult summarizes how two runs' values differ. The lists are
+// capped at the request's limit; the counts are not.
+type DiffResultsResult struct {
+	Added        []json.RawMessage `json:"added"`
+	Removed      []json.RawMessage `json:"removed"`
+	Changed      []ValueChange     `json:"changed"`
+	AddedCount   int               `json:"addedCount"`
+	RemovedCount int               `json:"removedCount"`
+	ChangedCount int               `json:"changedCount"`
+	Unchanged    int               `json:"unchanged"`
+	Truncated    bool              `json:"truncated,omitempty"` // a run had more values than were compared
+	Error        string            `json:"error,omitempty"`     // set when either query failed
+}
+
+// ValueChange is a record whose value differs between two runs
+type ValueChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// ExploreShapesArgs is the argument to superdb.exploreShapes
+type ExploreShapesArgs struct {
+	URI    string `json:"uri,omitempty"`
+	Source string `json:"source,omitempty"` // query to explore; default the document's first stage
+	Limit  int    `json:"limit,omitempty"`  // shapes listed, default 50
+}
+
+// ExploreShapesResult counts a source's values by type, most frequent
+// first. The list is capped at the request's limit; the counts are not.
+type ExploreShapesResult struct {
+	Source   string       `json:"source"`
+	Total    int64        `json:"total"`    // values the source produced
+	Distinct int          `json:"distinct"` // shapes among them
+	Shapes   []ShapeCount `json:"shapes"`
+	Fused    string       `json:"fused,omitempty"` // the type fuse gives them all, when there is more than one
+	Error    string       `json:"error,omitempty"`
+}
+
+// ShapeCount is how many values of a source have one type
+type ShapeCount struct {
+	Shape  string `json:"shape"` // a type value, e.g. <{a:int64}>
+	Count  int64  `json:"count"`
+	Sample string `json:"sample"` // one of the values, as SUP
+}
+
+// SummarizeQueryArgs is the argument to superdb.summarizeQuery
+type SummarizeQueryArgs struct {
+	URI   string `json:"uri"`
+	Range *Range `json:"range,omitempty"` // summarize only this part of the document
+}
+
+// SummarizeQueryResult is the result of superdb.summarizeQuery
+type SummarizeQueryResult struct {
+	Summary string `json:"summary"`
+}
+
+// ExportCatalogArgs is the optional argument to superdb.exportCatalog
+type ExportCatalogArgs struct {
+	Format string `json:"format,omitempty"` // "markdown" (default) or "json"
+	Path   string `json:"path,omitempty"`   // relative to the workspace root
+}
+
+// ExportCatalogResult says where superdb.exportCatalog wrote the catalog
+type ExportCatalogResult struct {
+	URI     string `json:"uri"`
+	Queries int    `json:"queries"`
+}
+
+// CatalogEntry describes one query file in an exported catalog
+type CatalogEntry struct {
+	URI          string        `json:"uri"`
+	Path         string        `json:"path"`            // relative to the workspace root
+	Title        string        `json:"title,omitempty"` // first line of the leading comment
+	Summary      string        `json:"summary,omitempty"`
+	Sources      []string      `json:"sources"` // what the query reads with from
+	Deprecated   bool          `json:"deprecated,omitempty"`
+	Deprecation  string        `json:"deprecation,omitempty"` // text after @deprecated
+	Declarations []CatalogDecl `json:"declarations,omitempty"`
+	Error        string        `json:"error,omitempty"` // set when the query doesn't parse
+}
+
+// CatalogDecl is a const, fn, op, or type a cataloged query declares
+type CatalogDecl struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`     // e.g. "fn double(x)"
+	Doc       string `json:"doc,omitempty"` // the doc comment above it
+}
+
+// SemanticTokensLegend names the token types and modifiers that semantic
+// token data refers to by index
+type SemanticTokensLegend struct {
+	TokenTypes     []string `json:"tokenTypes"`
+	TokenModifiers []string `json:"tokenModifiers"`
+}
+
+// SemanticTokensOptions advertises semantic tokens in the initialize result
+type SemanticTokensOptions struct {
+	Legend SemanticTokensLegend       `json:"legend"`
+	Full   *SemanticTokensFullOptions `json:"full,omitempty"`
+}
+
+// SemanticTokensFullOptions says whether full requests have deltas
+type SemanticTokensFullOptions struct {
+	Delta bool `json:"delta,omitempty"`
+}
+
+// SemanticTokensParams for textDocument/semanticTokens/full
+type SemanticTokensParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// SemanticTokens is the encoded token data for a document
+type SemanticTokens struct {
+	ResultID string   `json:"resultId,omitempty"`
+	Data     []uint32 `json:"data"`
+}
+
+// SemanticTokensDeltaParams for textDocument/semanticTokens/full/delta
+type SemanticTokensDeltaParams struct {
+	TextDocument     TextDocumentIdentifier `json:"textDocument"`
+	PreviousResultID string                 `json:"previousResultId"`
+}
+
+// SemanticTokensDelta is the change from a previous result's data
+type SemanticTokensDelta struct {
+	ResultID string               `json:"resultId,omitempty"`
+	Edits    []SemanticTokensEdit `json:"edits"`
+}
+
+// SemanticTokensEdit replaces deleteCount integers of the previous data,
+// starting at start, with data
+type SemanticTokensEdit struct {
+	Start       int      `json:"start"`
+	DeleteCount int      `json:"deleteCount"`
+	Data        []uint32 `json:"data,omitempty"`
+}
+
+// InlayHintParams for textDocument/inlayHint
+type InlayHintParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+}
+
+// InlayHint kinds
+const (
+	InlayHintKindType      = 1
+	InlayHintKindParameter = 2
+)
+
+// InlayHint is a label shown inline at a position
+type InlayHint struct {
+	Position     Position `json:"position"`
+	Label        string   `json:"label"`
+	Kind         int      `json:"kind,omitempty"`
+	PaddingLeft  bool     `json:"paddingLeft,omitempty"`
+	PaddingRight bool     `json:"paddingRight,omitempty"`
+}
+
+// CodeLensOptions advertises code lenses in the initialize result
+type CodeLensOptions struct {
+	ResolveProvider bool `json:"resolveProvider,omitempty"`
+}
+
+// CodeLensParams for textDocument/codeLens
+type CodeLensParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// CodeLens is a command shown above a range of text. Data is kept by the
+// client and sent back with codeLens/resolve.
+type CodeLens struct {
+	Range   Range       `json:"range"`
+	Command *Command    `json:"command,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// RenameFieldArgs is the argument to superdb.renameFieldEverywhere
+type RenameFieldArgs struct {
+	Field   string `json:"field"`            // path of the field, e.g. "id.orig_h"
+	NewName string `json:"newName"`          // new name of its last element
+	Source  string `json:"source,omitempty"` // only queries that read this source
+	DryRun  bool   `json:"dryRun,omitempty"` // report the uses without an edit
+}
+
+// RenameFieldResult is the result of superdb.renameFieldEverywhere
+type RenameFieldResult struct {
+	Field    string            `json:"field"`
+	NewName  string            `json:"newName"`
+	DryRun   bool              `json:"dryRun,omitempty"`
+	Uses     int               `json:"uses"`
+	Files    []RenameFieldFile `json:"files"`
+	Skipped  []string          `json:"skipped"`  // queries that don't parse
+	ReadOnly []string          `json:"readOnly"` // read-only queries with uses, left unchanged
+	Edit     *WorkspaceEdit    `json:"edit,omitempty"`
+}
+
+// RenameFieldFile lists the uses of a field in one query
+type RenameFieldFile struct {
+	URI  string           `json:"uri"`
+	Uses []RenameFieldUse `json:"uses"`
+}
+
+// RenameFieldUse is one use of a field, with its line for review
+type RenameFieldUse struct {
+	Range Range  `json:"range"`
+	Line  string `json:"line"`
+}
+
+// ApplyEditArgs is the argument to superdb.applyEdit
+type ApplyEditArgs struct {
+	Label string        `json:"label"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// FixDeprecatedResult is the result of superdb.fixDeprecatedSyntax
+type FixDeprecatedResult struct {
+	Uses     int            `json:"uses"`
+	Files    []string       `json:"files"`              // queries changed
+	ReadOnly []string       `json:"readOnly"`           // read-only queries with uses, left unchanged
+	Applying bool           `json:"applying,omitempty"` // the client was asked to apply the edit
+	Edit     *WorkspaceEdit `json:"edit,omitempty"`     // for a client that can't be asked
+}
+
+// RefreshPoolsResult is the result of superdb.refreshPools
+type RefreshPoolsResult struct {
+	Pools int `json:"pools"` // pools in the lake now
+}
+
+// SelectionRangeParams for textDocument/selectionRange
+type SelectionRangeParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Positions    []Position             `json:"positions"`
+}
+
+// SelectionRange is a range to select at a position, with the larger
+// range that contains it
+type SelectionRange struct {
+	Range  Range           `json:"range"`
+	Parent *SelectionRange `json:"parent,omitempty"`
+}
+
+// LinkedEditingRangeParams for textDocument/linkedEditingRange
+type LinkedEditingRangeParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// LinkedEditingRanges are ranges that are edited together, and what they
+// may be edited into
+type LinkedEditingRanges struct {
+	Ranges      []Range `json:"ranges"`
+	WordPattern string  `json:"wordPattern,omitempty"`
+}
+
+// DocumentLinkOptions advertises document links in the initialize result
+type DocumentLinkOptions struct {
+	ResolveProvider bool `json:"resolveProvider,omitempty"`
+}
+
+// DocumentLinkParams for textDocument/documentLink
+type DocumentLinkParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentLink is a range of a document that links to target
+type DocumentLink struct {
+	Range   Range  `json:"range"`
+	Target  string `json:"target,omitempty"`
+	Tooltip string `json:"tooltip,omitempty"`
+}
+
+// WorkspaceFolder is a root folder open in the editor
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+// WorkspaceServerCapabilities advertises workspace features in the
+// initialize result
+type WorkspaceServerCapabilities struct {
+	WorkspaceFolders *WorkspaceFoldersServerCapabilities `json:"workspaceFolders,omitempty"`
+	FileOperations   *FileOperationsServerCapabilities   `json:"fileOperations,omitempty"`
+}
+
+// FileOperationsServerCapabilities are the file operations the server
+// wants to hear about
+type FileOperationsServerCapabilities struct {
+	WillRename *FileOperationRegistrationOptions `json:"willRename,omitempty"`
+}
+
+// FileOperationRegistrationOptions say which files an operation is about
+type FileOperationRegistrationOptions struct {
+	Filters []FileOperationFilter `json:"filters"`
+}
+
+// FileOperationFilter matches files by scheme and glob
+type FileOperationFilter struct {
+	Scheme  string               `json:"scheme,omitempty"`
+	Pattern FileOperationPattern `json:"pattern"`
+}
+
+// FileOperationPattern is a glob over file paths
+type FileOperationPattern struct {
+	Glob string `json:"glob"`
+}
+
+// WorkspaceFoldersServerCapabilities says the server handles more than
+// one workspace folder and wants to hear when they change
+type WorkspaceFoldersServerCapabilities struct {
+	Supported           bool `json:"supported,omitempty"`
+	ChangeNotifications bool `json:"changeNotifications,omitempty"`
+}
+
+// DidChangeWorkspaceFoldersParams for workspace/didChangeWorkspaceFolders
+type DidChangeWorkspaceFoldersParams struct {
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
+// WorkspaceFoldersChangeEvent lists the folders added and removed
+type WorkspaceFoldersChangeEvent struct {
+	Added   []WorkspaceFolder `json:"added"`
+	Removed []WorkspaceFolder `json:"removed"`
+}
+
+// RegistrationParams for client/registerCapability
+type RegistrationParams struct {
+	Registrations []Registration `json:"registrations"`
+}
+
+// Registration registers the server for method, with options
+type Registration struct {
+	ID              string      `json:"id"`
+	Method          string      `json:"method"`
+	RegisterOptions interface{} `json:"registerOptions,omitempty"`
+}
+
+// DidChangeWatchedFilesRegistrationOptions are the files to watch
+type DidChangeWatchedFilesRegistrationOptions struct {
+	Watchers []FileSystemWatcher `json:"watchers"`
+}
+
+// FileSystemWatcher watches the files matching a glob
+type FileSystemWatcher struct {
+	GlobPattern string `json:"globPattern"`
+}
+
+// File change types in workspace/didChangeWatchedFiles
+const (
+	FileChangeTypeCreated = 1
+	FileChangeTypeChanged = 2
+	FileChangeTypeDeleted = 3
+)
+
+// DidChangeWatchedFilesParams for workspace/didChangeWatchedFiles
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// FileEvent is a change to a watched file
+type FileEvent struct {
+	URI  string `json:"uri"`
+	Type int    `json:"type"`
+}
+
+// WorkDoneProgressCreateParams for window/workDoneProgress/create
+type WorkDoneProgressCreateParams struct {
+	Token string `json:"token"`
+}
+
+// WorkDoneProgressCancelParams for window/workDoneProgress/cancel
+type WorkDoneProgressCancelParams struct {
+	Token interface{} `json:"token"`
+}
+
+// ProgressParams for $/progress
+type ProgressParams struct {
+	Token string      `json:"token"`
+	Value interface{} `json:"value"`
+}
+
+// WorkDoneProgressBegin starts reporting progress
+type WorkDoneProgressBegin struct {
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Cancellable bool   `json:"cancellable,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Percentage  *int   `json:"percentage,omitempty"`
+}
+
+// WorkDoneProgressReport reports progress
+type WorkDoneProgressReport struct {
+	Kind       string `json:"kind"`
+	Message    string `json:"message,omitempty"`
+	Percentage *int   `json:"percentage,omitempty"`
+}
+
+// WorkDoneProgressEnd finishes reporting progress
+type WorkDoneProgressEnd struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message,omitempty"`
+}
+
+// DidSaveTextDocumentParams for textDocument/didSave
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// RenameFilesParams for workspace/willRenameFiles
+type RenameFilesParams struct {
+	Files []FileRename `json:"files"`
+}
+
+// FileRename is one file or directory being renamed
+type FileRename struct {
+	OldURI string `json:"oldUri"`
+	NewURI string `json:"newUri"`
+}
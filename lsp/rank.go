@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Completion ranking. Each item's sortText is a rank digit followed by its
+// label, so clients list items by rank and then by name: the word typed
+// exactly first, then the parameters of the enclosing op or fn, then what
+// the context most likely wants, like operators at the start of a stage
+// or aggregates in summarize, then what the query declares or its data
+// holds, then the rest of the builtins, then older spellings, and last
+// the names that hold the word without starting with it (see fuzzy.go).
+
+// completionRank orders completion items, lowest first
+type completionRank byte
+
+const (
+	rankExact     completionRank = iota // the label is the word typed
+	rankParam                           // a parameter of the enclosing op or fn
+	rankPreferred                       // the kind the context most likely wants
+	rankDeclared                        // declared by the query, or a field of its data
+	rankOther                           // any other builtin
+	rankOlder                           // an older spelling of a builtin, or one deprecated
+	rankFuzzy                           // matches the word without starting with it
+	rankCount
+)
+
+// noKind is the kind of an item no context prefers, like a const
+const noKind BuiltinKind = -1
+
+// sortText returns the sortText of an item labelled label at rank r
+func (r completionRank) sortText(label string) string {
+	return string(rune('0'+r)) + label
+}
+
+// rankContext is what completion at a position most likely wants
+type rankContext struct {
+	prefix    string      // the lowercase word typed
+	preferred BuiltinKind // the kind ranked ahead of the rest, or noKind
+}
+
+// rankContextAt returns the rank context of completion at offset in text,
+// where prefix, lowercased, is the word typed before offset. It scans back
+// to the pipe that starts the stage rather than tokenizing, since it runs
+// on every keystroke in documents of any size.
+func rankContextAt(text string, offset int, prefix string) rankContext {
+	rc := rankContext{prefix: prefix, preferred: noKind}
+	if offset < len(prefix) || offset > len(text) {
+		return rc
+	}
+	before := strings.TrimRight(text[:offset-len(prefix)], " \t\r\n")
+	start := stageStart(before)
+	stage := strings.TrimLeft(before[start:], " \t\r\n")
+	end := 0
+	for end < len(stage) && isIdentifierChar(stage[end]) {
+		end++
+	}
+	switch op := stage[:end]; {
+	case stage == "" && (start == 0 || before[start-1] == '|'):
+		rc.preferred = KindOperator
+	case strings.EqualFold(op, "summarize") || strings.EqualFold(op, "aggregate"):
+		rc.preferred = KindAggregate
+	}
+	return rc
+}
+
+// stageStart returns the offset in text just past the pipe or open bracket
+// that starts the stage text ends in, or 0 when it is the first. Brackets
+// closed before the end are passed over, so a stage holding a subquery
+// or a call is seen whole; || is an operator, not a pipe.
+func stageStart(text string) int {
+	depth := 0
+	for i := len(text) - 1; i >= 0; i-- {
+		switch text[i] {
+		case ')', ']', '}':
+			depth++
+		case '(', '[', '{':
+			if depth == 0 {
+				return i + 1
+			}
+			depth--
+		case '|':
+			if depth > 0 {
+				continue
+			}
+			if i > 0 && text[i-1] == '|' {
+				i--
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '|' {
+				continue
+			}
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// rank returns the rank of an item labelled label of kind, given the rank
+// it has when the context has no say. The context only lifts declarations
+// and builtins in their canonical spelling.
+func (rc rankContext) rank(label string, base completionRank, kind BuiltinKind) completionRank {
+	switch {
+	case rc.prefix != "" && strings.EqualFold(label, rc.prefix):
+		return rankExact
+	case kind != noKind && kind == rc.preferred && (base == rankDeclared || base == rankOther):
+		return rankPreferred
+	}
+	return base
+}
+
+// builtinSortTexts returns the sortText of b's item at each rank, built
+// once with the registry so ranking doesn't allocate per item
+func builtinSortTexts(b *Builtin) [rankCount]string {
+	var texts [rankCount]string
+	for r := range texts {
+		texts[r] = completionRank(r).sortText(b.Name)
+	}
+	return texts
+}
+
+// builtinRank returns the rank of b's item when the context has no say
+func builtinRank(b *Builtin) completionRank {
+	if b.replacement() != "" {
+		return rankOlder
+	}
+	return rankOther
+}
+
+// maxCompletionItems caps the items sent for one completion request. A
+// word of a letter or two matches most of the registry, and a slow
+// transport takes longer to send it all than the client takes to ask
+// again, so the best of it goes marked incomplete and the client asks
+// again as the word grows.
+const maxCompletionItems = 100
+
+// firstCompletions returns the first maxCompletionItems of items in the
+// order the client lists them, by sortText or else label, and reports
+// whether any were left out
+func firstCompletions(items []CompletionItem) ([]CompletionItem, bool) {
+	if len(items) <= maxCompletionItems {
+		return items, false
+	}
+	key := func(item *CompletionItem) string {
+		if item.SortText != "" {
+			return item.SortText
+		}
+		return item.Label
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return key(&items[i]) < key(&items[j])
+	})
+	return items[:maxCompletionItems], true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// ranked returns the completions at offset in text, in the order a client
+// lists them
+func ranked(t *testing.T, text string, offset int) []CompletionItem {
+	t.Helper()
+	items := getCompletions(context.Background(), text, positionAt(text, offset))
+	if len(items) == 0 {
+		t.Fatalf("Expected completions for %q", text)
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].SortText < items[j].SortText })
+	return items
+}
+
+func TestCompletionRanking(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		want BuiltinKind
+	}{
+		{"operators after a pipe", "from x | ", KindOperator},
+		{"operators at the start", "", KindOperator},
+		{"aggregates in summarize", "from x | summarize ", KindAggregate},
+		{"aggregates in an aggregate call", "from x | aggregate c", KindAggregate},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			items := ranked(t, tt.text, len(tt.text))
+			b := Builtins.Lookup(items[0].Label)
+			if b == nil || b.Kind != tt.want {
+				t.Errorf("Expected a builtin of kind %v first, got %+v", tt.want, items[0])
+			}
+		})
+	}
+
+	// The word typed exactly comes first, ahead of the operators
+	if items := ranked(t, "from x | where x == abs", len("from x | where x == abs")); items[0].Label != "abs" {
+		t.Errorf("Expected abs first, got %+v", items[0])
+	}
+	if items := ranked(t, "from x | head", len("from x | head")); items[0].Label != "head" {
+		t.Errorf("Expected head first, got %+v", items[0])
+	}
+
+	// Parameters, then what the query declares, then builtins
+	text := "op top_by field: ( values f )\nfn fmt2(x): (x)\nvalues f"
+	items := ranked(t, text, len("op top_by field: ( values f"))
+	if items[0].Label != "field" {
+		t.Errorf("Expected the parameter first, got %+v", items[0])
+	}
+	items = ranked(t, text, len(text))
+	if items[0].Label != "fmt2" {
+		t.Errorf("Expected the declared fn first, got %+v", items[0])
+	}
+}
+
+func TestCompletionRankOlderSpellingsLast(t *testing.T) {
+	items := ranked(t, "from x | ", len("from x | "))
+	seen := false
+	for _, item := range items {
+		b := Builtins.Lookup(item.Label)
+		if b != nil && b.replacement() != "" {
+			seen = true
+		} else if seen {
+			t.Fatalf("Expected older spellings and deprecated names last, got %s after one", item.Label)
+		}
+	}
+	if !seen {
+		t.Errorf("Expected older spellings among the operators")
+	}
+}
+
+func TestCompletionPaging(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///paging.spq"
+	complete := func(text string) CompletionList {
+		t.Helper()
+		h.openDocument(t, uri, text)
+		response, err := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		if err != nil {
+			t.Fatalf("completion failed: %v", err)
+		}
+		var list CompletionList
+		resultBytes, _ := json.Marshal(response.Result)
+		json.Unmarshal(resultBytes, &list)
+		return list
+	}
+
+	// A short word matches more than is sent; the best of it goes
+	text := "values 1 | where x == "
+	list := complete(text)
+	if !list.IsIncomplete || len(list.Items) != maxCompletionItems {
+		t.Fatalf("Expected %d items marked incomplete, got %d (incomplete %v)", maxCompletionItems, len(list.Items), list.IsIncomplete)
+	}
+	all := ranked(t, text, len(text))
+	for i, item := range list.Items {
+		if item.Label != all[i].Label {
+			t.Fatalf("Expected the first items by rank, got %s at %d where %s ranks", item.Label, i, all[i].Label)
+		}
+	}
+
+	// A longer one matches few enough to send whole
+	list = complete("values 1 | where x == regexp_r")
+	if list.IsIncomplete || len(list.Items) == 0 {
+		t.Errorf("Expected a complete list, got %d items (incomplete %v)", len(list.Items), list.IsIncomplete)
+	}
+}
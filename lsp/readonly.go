@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Read-only files. Directories listed in readOnlyPaths, such as generated/
+// or vendored queries, keep hover, diagnostics, and everything else that
+// only reads, but the server proposes no edits to their files: no code
+// actions, formatting, pipeline layout, or rename. A workspace-wide edit
+// that would touch them, like superdb.renameFieldEverywhere, leaves them
+// out and warns with window/showMessage.
+//
+// A pattern is a slash-separated path relative to the workspace root, or
+// an absolute one, whose segments may hold globs: "generated",
+// "vendor/*/queries". It covers the file or directory it names and all
+// below it. dataFiles patterns take the same form.
+
+// readOnlyError is the error for an edit refused because uri is read-only
+func readOnlyError(uri string) *RPCError {
+	return &RPCError{Code: RequestFailed, Message: fmt.Sprintf("%s is read-only", uri)}
+}
+
+// isReadOnly reports whether uri is in a read-only directory
+func (s *Server) isReadOnly(uri string) bool {
+	return s.coveredByPatterns(uri, s.settings().ReadOnlyPaths)
+}
+
+// isDataFile reports whether uri is a data file, by its extension or a
+// dataFiles pattern
+func (s *Server) isDataFile(uri string) bool {
+	return hasDataExtension(uri) || s.coveredByPatterns(uri, s.settings().DataFiles)
+}
+
+// coveredByPatterns reports whether uri is covered by one of patterns
+func (s *Server) coveredByPatterns(uri string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	file, err := uriToPath(uri)
+	if err != nil {
+		return false
+	}
+	rel := s.folderRelative(file)
+	for _, pattern := range patterns {
+		pattern = path.Clean(filepath.ToSlash(pattern))
+		if path.IsAbs(pattern) {
+			if coveredBy(filepath.ToSlash(file), pattern) {
+				return true
+			}
+		} else if rel != "" && coveredBy(rel, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveredBy reports whether the slash-separated file is what pattern names
+// or is below it, matching segment by segment
+func coveredBy(file, pattern string) bool {
+	files := strings.Split(strings.TrimPrefix(file, "/"), "/")
+	patterns := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	if len(files) < len(patterns) {
+		return false
+	}
+	for i, p := range patterns {
+		if ok, err := path.Match(p, files[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// warnReadOnly tells the user that an edit left the read-only files at
+// uris unchanged
+func (s *Server) warnReadOnly(uris []string) {
+	message := fmt.Sprintf("Left %d read-only file(s) unchanged: %s", len(uris), strings.Join(uris, ", "))
+	log.Print(message)
+	s.showMessage(MessageTypeWarning, message)
+}
+
+// showMessage shows message to the user
+func (s *Server) showMessage(typ int, message string) {
+	msg, err := notification("window/showMessage", ShowMessageParams{Type: typ, Message: message})
+	if err == nil {
+		err = s.send(msg)
+	}
+	if err != nil {
+		log.Printf("Error showing message: %v", err)
+	}
+}
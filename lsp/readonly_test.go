@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	s := NewServer()
+	s.rootPath = "/work"
+	s.config.ReadOnlyPaths = []string{"generated", "vendor/*/queries", "/shared/"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/work/generated/q.spq", true},
+		{"/work/generated/deep/q.spq", true},
+		{"/work/generated.spq", false},
+		{"/work/src/generated/q.spq", false},
+		{"/work/vendor/acme/queries/q.spq", true},
+		{"/work/vendor/acme/q.spq", false},
+		{"/shared/q.spq", true},
+		{"/work/q.spq", false},
+	}
+	for _, tt := range tests {
+		if got := s.isReadOnly(pathToURI(tt.path)); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+	if s.isReadOnly("untitled:Untitled-1") {
+		t.Error("Expected a document without a path to be editable")
+	}
+}
+
+func TestIsDataFile(t *testing.T) {
+	s := NewServer()
+	s.rootPath = "/work"
+	s.config.DataFiles = []string{"fixtures", "samples/*.json"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/work/q.sup", true},
+		{"/work/Q.SUP", true},
+		{"/work/fixtures/conn.json", true},
+		{"/work/samples/dns.json", true},
+		{"/work/samples/dns.spq", false},
+		{"/work/q.json", false},
+	}
+	for _, tt := range tests {
+		if got := s.isDataFile(pathToURI(tt.path)); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestReadOnlySuppressesEdits(t *testing.T) {
+	root := t.TempDir()
+	h := NewTestHelper()
+	opts := json.RawMessage(`{"readOnlyPaths": ["generated"]}`)
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root), InitializationOptions: opts}); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(root, "generated", "q.spq"))
+	text := "const n = 1\nvalues n|yield n\n|sort this"
+	h.openDocument(t, uri, text)
+
+	at := posOf(t, text, "n", 2, 0)
+	formatting, _ := h.ProcessRequest(2, "textDocument/formatting", DocumentFormattingParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Options:      FormattingOptions{TabSize: 2, InsertSpaces: true},
+	})
+	if data, _ := json.Marshal(formatting.Result); string(data) != "[]" {
+		t.Errorf("Expected no formatting edits, got %s", data)
+	}
+	actions, _ := h.ProcessRequest(3, "textDocument/codeAction", CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        Range{Start: posOf(t, text, "yield", 1, 0), End: posOf(t, text, "yield", 1, 0)},
+	})
+	if data, _ := json.Marshal(actions.Result); string(data) != "[]" {
+		t.Errorf("Expected no code actions, got %s", data)
+	}
+	rename, _ := h.ProcessRequest(4, "textDocument/rename", RenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     at,
+		NewName:      "m",
+	})
+	if rename.Error == nil || !strings.Contains(rename.Error.Message, "read-only") {
+		t.Errorf("Expected rename to be refused, got %+v", rename)
+	}
+
+	// Reading still works
+	hover, _ := h.ProcessRequest(5, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "sort", 1, 0),
+	})
+	if hover.Result == nil {
+		t.Error("Expected hover in a read-only file")
+	}
+}
+
+func TestRenameFieldEverywhereSkipsReadOnly(t *testing.T) {
+	root := t.TempDir()
+	for name, text := range map[string]string{
+		"a.spq":           "from conn.json | count() by host",
+		"generated/b.spq": "from conn.json | cut host",
+	} {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755)
+		os.WriteFile(filepath.Join(root, name), []byte(text), 0o644)
+	}
+	h := NewTestHelper()
+	background := &bytes.Buffer{}
+	h.server.out = background
+	opts := json.RawMessage(`{"readOnlyPaths": ["generated"]}`)
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root), InitializationOptions: opts}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := json.Marshal(RenameFieldArgs{Field: "host", NewName: "hostname"})
+	response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandRenameField,
+		Arguments: []json.RawMessage{raw},
+	})
+	if err != nil || response.Error != nil {
+		t.Fatalf("renameFieldEverywhere failed: %v %v", err, response.Error)
+	}
+	var result RenameFieldResult
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &result)
+
+	readOnly := pathToURI(filepath.Join(root, "generated", "b.spq"))
+	if len(result.ReadOnly) != 1 || result.ReadOnly[0] != readOnly {
+		t.Errorf("Expected the generated query reported as read-only, got %v", result.ReadOnly)
+	}
+	if _, ok := result.Edit.Changes[readOnly]; ok || len(result.Edit.Changes) != 1 {
+		t.Errorf("Expected only a.spq in the edit, got %+v", result.Edit.Changes)
+	}
+
+	msgs := drainMessages(t, background)
+	if len(msgs) != 1 || msgs[0].Method != "window/showMessage" {
+		t.Fatalf("Expected a warning, got %+v", msgs)
+	}
+	var warning ShowMessageParams
+	json.Unmarshal(msgs[0].Params, &warning)
+	if warning.Type != MessageTypeWarning || !strings.Contains(warning.Message, "b.spq") {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultReferencePath is where superdb.generateReference writes, relative
+// to the workspace root, when no path is given
+const defaultReferencePath = "docs/superdb-reference.md"
+
+// referenceSection is one kind's section of the generated reference
+type referenceSection struct {
+	kind  BuiltinKind
+	title string
+	table bool // a name/description table rather than a subsection per entry
+}
+
+var referenceSections = []referenceSection{
+	{KindKeyword, "Keywords", true},
+	{KindOperator, "Operators", false},
+	{KindFunction, "Functions", false},
+	{KindAggregate, "Aggregates", false},
+	{KindType, "Types", true},
+}
+
+// renderReference renders the registry as a markdown language reference so
+// docs can be regenerated instead of kept in sync by hand
+func renderReference(r *Registry) string {
+	var b strings.Builder
+	b.WriteString("# SuperSQL Reference\n\n")
+	b.WriteString("<!-- Generated from the superdb-lsp builtin registry by the\n")
+	b.WriteString("     superdb.generateReference command. Do not edit by hand. -->\n")
+
+	for _, section := range referenceSections {
+		fmt.Fprintf(&b, "\n## %s\n", section.title)
+		if section.table {
+			b.WriteString("\n| Name | Description |\n|------|-------------|\n")
+			for _, builtin := range r.ByKind(section.kind) {
+				fmt.Fprintf(&b, "| `%s` | %s |\n", builtin.Name, builtin.Brief)
+			}
+			continue
+		}
+		for _, builtin := range r.ByKind(section.kind) {
+			writeReferenceEntry(&b, builtin)
+		}
+	}
+	return b.String()
+}
+
+// writeReferenceEntry writes the subsection for one operator, function, or
+// aggregate
+func writeReferenceEntry(b *strings.Builder, builtin *Builtin) {
+	fmt.Fprintf(b, "\n### `%s`\n\n", builtin.Name)
+	if builtin.sig != nil {
+		fmt.Fprintf(b, "```spq\n%s\n```\n\n", builtin.sig.Label())
+	}
+	doc := builtin.Doc
+	if doc == "" {
+		doc = builtin.Brief
+	}
+	b.WriteString(doc)
+	b.WriteString("\n")
+	if builtin.sig != nil {
+		if params := builtin.sig.markdownParams(); params != "" {
+			b.WriteString("\n")
+			b.WriteString(params)
+			b.WriteString("\n")
+		}
+	}
+	if examples := markdownExamples(builtin); examples != "" {
+		b.WriteString("\n" + examples + "\n")
+	}
+}
+
+// generateReference writes the rendered reference into the workspace and
+// returns where it went
+func (s *Server) generateReference(_ context.Context, args []json.RawMessage) HandlerResult {
+	var params GenerateReferenceArgs
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &params); err != nil {
+			return invalidParams(err)
+		}
+	}
+	if params.Path == "" {
+		params.Path = defaultReferencePath
+	}
+
+	path, err := s.workspacePath(params.Path)
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return failure(err)
+	}
+	if err := os.WriteFile(path, []byte(renderReference(Builtins)), 0o644); err != nil {
+		return failure(err)
+	}
+
+	log.Printf("Wrote reference to %s", path)
+	return success(GenerateReferenceResult{URI: pathToURI(path)})
+}
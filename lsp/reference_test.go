@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brimdata/super/compiler/parser"
+)
+
+func TestRenderReference(t *testing.T) {
+	ref := renderReference(Builtins)
+
+	for _, section := range referenceSections {
+		if !strings.Contains(ref, "\n## "+section.title+"\n") {
+			t.Errorf("Expected a %s section", section.title)
+		}
+	}
+	for _, b := range append(Builtins.Functions(), Builtins.Aggregates()...) {
+		if b.sig != nil && !strings.Contains(ref, b.sig.Label()) {
+			t.Errorf("Expected reference to contain the signature of %s", b.Name)
+		}
+	}
+	if !strings.Contains(ref, "| `int64` | 64-bit signed integer |") {
+		t.Error("Expected types table to list int64")
+	}
+}
+
+func TestRegistryExamplesPresent(t *testing.T) {
+	// Completion documentation shows them, so none go without
+	for _, kind := range []BuiltinKind{KindOperator, KindFunction, KindAggregate} {
+		for _, b := range Builtins.ByKind(kind) {
+			if len(b.Examples) == 0 {
+				t.Errorf("Expected an example for %s", b.Name)
+			}
+		}
+	}
+}
+
+func TestRegistryExamplesParse(t *testing.T) {
+	for _, kind := range []BuiltinKind{KindOperator, KindFunction, KindAggregate} {
+		for _, b := range Builtins.ByKind(kind) {
+			for _, example := range b.Examples {
+				if _, err := parser.ParseQuery(example); err != nil {
+					t.Errorf("Example for %s does not parse: %q: %v", b.Name, example, err)
+				}
+			}
+		}
+	}
+}
+
+func TestSnippetsParse(t *testing.T) {
+	// Filled in with what their placeholders show, snippets are queries
+	for _, sn := range Builtins.Snippets() {
+		query := plainInsertText(sn.Body)
+		if !sn.Stage {
+			query = "values " + query
+		}
+		_, err := parser.ParseQuery(query)
+		if err != nil && sn.Stage {
+			// A declaration needs a query after it
+			_, err = parser.ParseQuery(query + "\nvalues 1")
+		}
+		if err != nil {
+			t.Errorf("Snippet %s does not parse: %q: %v", sn.Name, query, err)
+		}
+	}
+}
+
+func TestGenerateReferenceCommand(t *testing.T) {
+	root := t.TempDir()
+	h := NewTestHelper()
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{RootURI: pathToURI(root)}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+
+	args, _ := json.Marshal(GenerateReferenceArgs{Path: "docs/ref.md"})
+	response, err := h.ProcessRequest(2, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandGenerateReference,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+
+	path := filepath.Join(root, "docs", "ref.md")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected reference at %s: %v", path, err)
+	}
+	if string(data) != renderReference(Builtins) {
+		t.Error("Written reference doesn't match the rendered registry")
+	}
+	result := response.Result.(map[string]interface{})
+	if result["uri"] != pathToURI(path) {
+		t.Errorf("Expected uri %s, got %v", pathToURI(path), result["uri"])
+	}
+}
+
+func TestGenerateReferenceOutsideWorkspace(t *testing.T) {
+	h := NewTestHelper()
+	h.server.rootPath = t.TempDir()
+
+	args, _ := json.Marshal(GenerateReferenceArgs{Path: "../escape.md"})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandGenerateReference,
+		Arguments: []json.RawMessage{args},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != RequestFailed {
+		t.Errorf("Expected RequestFailed error, got %+v", response.Error)
+	}
+}
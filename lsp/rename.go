@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Renaming. Consts, funcs, ops, types, and parameters are renamed at their
+// declaration and every use that resolves to it. A field can be renamed
+// only if the query names it somewhere, as on the left of := or as a key of
+// a record literal; a field that only comes from the input would stop
+// matching its data. Builtins are never renamed, and nothing is renamed to
+// a keyword, a builtin, or a name already in use alongside it.
+
+// identifierPattern matches a name that can be written without quoting
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// renameTarget is what a rename at a position changes
+type renameTarget struct {
+	name   string
+	rng    Range   // the occurrence at the position
+	ranges []Range // every occurrence, the declaration first
+	sym    *symbol // the declaration renamed, if it isn't a field
+	path   string  // the path of the field renamed, if it is one
+	table  *symbolTable
+}
+
+// findRenameTarget returns what a rename at pos in text would change. It
+// returns nil when there is nothing there to rename, and an error when
+// there is a name there that can't be renamed.
+func findRenameTarget(text string, pos Position) (*renameTarget, error) {
+	table := buildSymbolTable(text)
+	if table == nil {
+		return nil, nil
+	}
+	target := &renameTarget{table: table}
+	if sym := table.symbolAt(pos); sym != nil {
+		target.name = sym.name
+		target.sym = sym
+		target.ranges = table.referencesAt(pos, true)
+	} else if path := table.fieldAt(pos); path != "" {
+		if !table.isAlias(path) {
+			return nil, &RPCError{Code: RequestFailed, Message: fmt.Sprintf("field %s comes from the input; only fields the query names can be renamed", path)}
+		}
+		target.name = path[strings.LastIndex(path, ".")+1:]
+		target.path = path
+		target.ranges = table.referencesAt(pos, true)
+	} else {
+		if word := getWordAtPosition(text, pos); word != "" && (Builtins.Lookup(word) != nil || isKeyword(word)) {
+			return nil, &RPCError{Code: InvalidParams, Message: fmt.Sprintf("%s is a builtin and can't be renamed", word)}
+		}
+		return nil, nil
+	}
+	for _, rng := range target.ranges {
+		if rangeContains(rng, pos) {
+			target.rng = rng
+			break
+		}
+	}
+	return target, nil
+}
+
+// check returns an error if the target can't be renamed to name: name is
+// a keyword or builtin, or already names another declaration in the same
+// namespace or another field of the same record
+func (target *renameTarget) check(name string) error {
+	if name == target.name {
+		return nil
+	}
+	if isKeyword(name) {
+		return &RPCError{Code: InvalidParams, Message: fmt.Sprintf("%s is a keyword", name)}
+	}
+	if Builtins.Lookup(name) != nil {
+		return &RPCError{Code: InvalidParams, Message: fmt.Sprintf("%s is a builtin", name)}
+	}
+	if target.sym != nil {
+		for _, sym := range target.table.symbols {
+			if sym.name == name && sym.kind.space() == target.sym.kind.space() {
+				return &RPCError{Code: InvalidParams, Message: fmt.Sprintf("%s is already declared", name)}
+			}
+		}
+		return nil
+	}
+	sibling := target.path[:len(target.path)-len(target.name)] + name
+	for _, f := range target.table.fields {
+		if f.path == sibling {
+			return &RPCError{Code: InvalidParams, Message: fmt.Sprintf("field %s already exists", sibling)}
+		}
+	}
+	return nil
+}
+
+// isAlias reports whether the query names the field at path anywhere
+func (t *symbolTable) isAlias(path string) bool {
+	for _, f := range t.fields {
+		if f.path == path && f.alias {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePrepareRename processes textDocument/prepareRename requests,
+// returning the range of the name at the position and the name itself
+func (s *Server) handlePrepareRename(msg RPCMessage) HandlerResult {
+	var params PrepareRenameParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isReadOnly(params.TextDocument.URI) {
+		return failure(readOnlyError(params.TextDocument.URI))
+	}
+
+	target, err := findRenameTarget(text, s.fromClient(text, params.Position))
+	if err != nil {
+		return failure(err)
+	}
+	if target == nil {
+		return success(nil)
+	}
+	return success(PrepareRenameResult{Range: s.rangeToClient(text, target.rng), Placeholder: target.name})
+}
+
+// handleRename processes textDocument/rename requests, returning the edits
+// that rename every occurrence of the name at the position
+func (s *Server) handleRename(msg RPCMessage) HandlerResult {
+	var params RenameParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	if !identifierPattern.MatchString(params.NewName) {
+		return failure(&RPCError{Code: InvalidParams, Message: fmt.Sprintf("%q is not a valid name", params.NewName)})
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isReadOnly(params.TextDocument.URI) {
+		return failure(readOnlyError(params.TextDocument.URI))
+	}
+
+	target, err := findRenameTarget(text, s.fromClient(text, params.Position))
+	if err != nil {
+		return failure(err)
+	}
+	if target == nil {
+		return success(nil)
+	}
+	if err := target.check(params.NewName); err != nil {
+		return failure(err)
+	}
+	edits := make([]TextEdit, len(target.ranges))
+	for i, rng := range target.ranges {
+		edits[i] = TextEdit{Range: s.rangeToClient(text, rng), NewText: params.NewName}
+	}
+	return success(WorkspaceEdit{Changes: map[string][]TextEdit{params.TextDocument.URI: edits}})
+}
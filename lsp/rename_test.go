@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFindRenameTarget(t *testing.T) {
+	text := `const LIMIT = 10
+fn twice(x): (x * 2)
+from data.json
+| put total := twice(price)
+| where total > LIMIT and price > 0
+| sort total`
+	tests := []struct {
+		name  string
+		at    Position
+		want  string // the placeholder and how many occurrences
+		n     int
+		error string
+	}{
+		{"const", posOf(t, text, "LIMIT", 2, 0), "LIMIT", 2, ""},
+		{"func", posOf(t, text, "twice", 1, 1), "twice", 2, ""},
+		{"param", posOf(t, text, "x * 2", 1, 0), "x", 2, ""},
+		{"alias", posOf(t, text, "total", 3, 0), "total", 3, ""},
+		{"input field", posOf(t, text, "price", 1, 0), "", 0, "comes from the input"},
+		{"builtin", posOf(t, text, "sort", 1, 0), "", 0, "builtin"},
+		{"nothing", posOf(t, text, "10", 1, 0), "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := findRenameTarget(text, tt.at)
+			if tt.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.error) {
+					t.Errorf("Expected an error about %q, got %v", tt.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tt.want == "" {
+				if target != nil {
+					t.Errorf("Expected nothing to rename, got %+v", target)
+				}
+				return
+			}
+			if target == nil || target.name != tt.want || len(target.ranges) != tt.n {
+				t.Fatalf("Expected %s with %d occurrences, got %+v", tt.want, tt.n, target)
+			}
+			if !rangeContains(target.rng, tt.at) {
+				t.Errorf("Expected the occurrence at the position, got %+v", target.rng)
+			}
+		})
+	}
+}
+
+func TestRenameRequests(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///rename.spq"
+	text := "values {a:1} | put b := a + 1 | cut b"
+	h.openDocument(t, uri, text)
+
+	response, err := h.ProcessRequest(2, "textDocument/prepareRename", PrepareRenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "b", 2, 0),
+	})
+	if err != nil {
+		t.Fatalf("prepareRename failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var prepared PrepareRenameResult
+	json.Unmarshal(resultBytes, &prepared)
+	if prepared.Placeholder != "b" || prepared.Range.Start != posOf(t, text, "b", 2, 0) {
+		t.Errorf("Unexpected prepareRename result %s", resultBytes)
+	}
+
+	response, err = h.ProcessRequest(3, "textDocument/rename", RenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "a + 1", 1, 0),
+		NewName:      "count_a",
+	})
+	if err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	resultBytes, _ = json.Marshal(response.Result)
+	var edit WorkspaceEdit
+	json.Unmarshal(resultBytes, &edit)
+	if got := applyEdits(text, edit.Changes[uri]); got != "values {count_a:1} | put b := count_a + 1 | cut b" {
+		t.Errorf("Unexpected result of rename: %q", got)
+	}
+
+	response, _ = h.ProcessRequest(4, "textDocument/rename", RenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "a + 1", 1, 0),
+		NewName:      "not valid",
+	})
+	if response.Error == nil || response.Error.Code != InvalidParams {
+		t.Errorf("Expected InvalidParams for a bad name, got %+v", response)
+	}
+
+	response, _ = h.ProcessRequest(5, "textDocument/prepareRename", PrepareRenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "cut", 1, 0),
+	})
+	if response.Error == nil || response.Error.Code != InvalidParams {
+		t.Errorf("Expected a builtin to be refused, got %+v", response)
+	}
+}
+
+func TestRenameRefusesNamesInUse(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///rename.spq"
+	text := `const LIMIT = 10
+const FLOOR = 0
+values {a:1, b:2} | put c := a + LIMIT + FLOOR`
+	h.openDocument(t, uri, text)
+
+	tests := []struct {
+		name    string
+		at      Position
+		newName string
+	}{
+		{"keyword", posOf(t, text, "LIMIT", 1, 0), "from"},
+		{"builtin", posOf(t, text, "LIMIT", 1, 0), "upper"},
+		{"declared", posOf(t, text, "LIMIT", 1, 0), "FLOOR"},
+		{"field", posOf(t, text, "c :=", 1, 0), "b"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := h.ProcessRequest(i+2, "textDocument/rename", RenameParams{
+				TextDocument: TextDocumentIdentifier{URI: uri},
+				Position:     tt.at,
+				NewName:      tt.newName,
+			})
+			if err != nil {
+				t.Fatalf("rename failed: %v", err)
+			}
+			if response.Error == nil || response.Error.Code != InvalidParams {
+				t.Errorf("Expected InvalidParams renaming to %s, got %+v", tt.newName, response)
+			}
+		})
+	}
+
+	response, err := h.ProcessRequest(10, "textDocument/prepareRename", PrepareRenameParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, text, "values", 1, 0),
+	})
+	if err != nil {
+		t.Fatalf("prepareRename failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != InvalidParams {
+		t.Errorf("Expected a keyword to be refused, got %+v", response)
+	}
+}
+
+// applyEdits applies non-overlapping edits to text
+func applyEdits(text string, edits []TextEdit) string {
+	sorted := append([]TextEdit(nil), edits...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return positionLess(sorted[j].Range.Start, sorted[i].Range.Start)
+	})
+	for _, e := range sorted {
+		start, _ := offsetAt(text, e.Range.Start)
+		end, _ := offsetAt(text, e.Range.End)
+		text = text[:start] + e.NewText + text[end:]
+	}
+	return text
+}
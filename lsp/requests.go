@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Cancellation. Run reads messages ahead of the handlers, so a
+// $/cancelRequest is seen as soon as it arrives, whether the request it
+// names is running or still queued behind another. Each request gets a
+// context that the cancellation cancels; handlers that do real work check
+// it and stop early, and the request is answered with RequestCancelled.
+// Diagnostics work the same way, except that what cancels them is a newer
+// version of the document.
+//
+// A request about a document is answered from the version the server has
+// when it is handled, but a newer one may already have been read and be
+// waiting behind it. By the time the answer arrives the client's text has
+// moved on, so an answer from stale text is marked: completions as
+// incomplete, so the client asks again, and anything else, edits above
+// all, as ContentModified rather than applied to text it wasn't made for.
+
+// requestRegistry holds the contexts of requests that are queued or
+// running, and of the latest version of each open document
+type requestRegistry struct {
+	mu       sync.Mutex
+	requests map[string]*requestEntry // by idKey
+	docs     map[string]*documentEntry
+	progress map[string]*requestEntry // work-done progress, by idKey of the token
+	tokens   int                      // progress tokens created
+}
+
+type requestEntry struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type documentEntry struct {
+	version int
+	requestEntry
+}
+
+func newRequestRegistry() *requestRegistry {
+	return &requestRegistry{
+		requests: make(map[string]*requestEntry),
+		docs:     make(map[string]*documentEntry),
+		progress: make(map[string]*requestEntry),
+	}
+}
+
+// idKey turns a request ID into a map key that keeps 1 and "1" apart
+func idKey(id interface{}) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+// add registers a request as it is read, before it is handled
+func (r *requestRegistry) add(id interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := idKey(id)
+	if _, ok := r.requests[key]; !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.requests[key] = &requestEntry{ctx: ctx, cancel: cancel}
+	}
+}
+
+// begin returns the context for handling request id, registering it if
+// add wasn't called, and a function to call once it has been answered
+func (r *requestRegistry) begin(id interface{}) (context.Context, func()) {
+	r.add(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := idKey(id)
+	entry := r.requests[key]
+	return entry.ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		entry.cancel()
+		delete(r.requests, key)
+	}
+}
+
+// cancel cancels request id, reporting false if it isn't queued or running
+func (r *requestRegistry) cancel(id interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.requests[idKey(id)]
+	if ok {
+		entry.cancel()
+	}
+	return ok
+}
+
+// supersede records version as the latest of uri, cancelling the
+// diagnostics of any earlier version
+func (r *requestRegistry) supersede(uri string, version int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.docs[uri]; ok {
+		if version <= entry.version {
+			return
+		}
+		entry.cancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.docs[uri] = &documentEntry{version: version, requestEntry: requestEntry{ctx: ctx, cancel: cancel}}
+}
+
+// documentContext returns the context for computing diagnostics for
+// version of uri, which is already cancelled when a newer version has
+// been read
+func (r *requestRegistry) documentContext(uri string, version int) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.docs[uri]
+	switch {
+	case !ok || version > entry.version:
+		return context.Background()
+	case version < entry.version:
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	return entry.ctx
+}
+
+// newer reports whether a version of uri later than version has been read
+func (r *requestRegistry) newer(uri string, version int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.docs[uri]
+	return ok && entry.version > version
+}
+
+// forget drops uri when its document is closed
+func (r *requestRegistry) forget(uri string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.docs[uri]; ok {
+		entry.cancel()
+		delete(r.docs, uri)
+	}
+}
+
+// addProgress creates a progress token and the context of its operation,
+// derived from ctx
+func (r *requestRegistry) addProgress(ctx context.Context) (string, context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens++
+	token := fmt.Sprintf("superdb-progress-%d", r.tokens)
+	ctx, cancel := context.WithCancel(ctx)
+	r.progress[idKey(token)] = &requestEntry{ctx: ctx, cancel: cancel}
+	return token, ctx
+}
+
+// cancelProgress cancels the operation reporting progress on token
+func (r *requestRegistry) cancelProgress(token interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.progress[idKey(token)]; ok {
+		entry.cancel()
+	}
+}
+
+// endProgress drops token once its operation is done
+func (r *requestRegistry) endProgress(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.progress[idKey(token)]; ok {
+		entry.cancel()
+		delete(r.progress, idKey(token))
+	}
+}
+
+// observe looks at a message as Run reads it, ahead of its handling:
+// requests are registered, cancellations of requests and of operations
+// reporting progress take effect at once, and a new version of a document
+// supersedes the diagnostics of the old one
+func (r *requestRegistry) observe(raw json.RawMessage) {
+	var msg struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+		Params struct {
+			ID           interface{}                     `json:"id"`
+			Token        interface{}                     `json:"token"`
+			TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+		} `json:"params"`
+	}
+	if json.Unmarshal(raw, &msg) != nil || msg.Method == "" {
+		return
+	}
+	switch {
+	case msg.Method == "$/cancelRequest":
+		r.cancel(msg.Params.ID)
+	case msg.Method == "window/workDoneProgress/cancel":
+		r.cancelProgress(msg.Params.Token)
+	case msg.Method == "textDocument/didChange":
+		r.supersede(msg.Params.TextDocument.URI, msg.Params.TextDocument.Version)
+	case msg.ID != nil:
+		r.add(msg.ID)
+	}
+}
+
+// handleCancelRequest processes $/cancelRequest notifications. Under Run
+// the cancellation has already taken effect when the message was read.
+func (s *Server) handleCancelRequest(msg RPCMessage) HandlerResult {
+	var params CancelParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	s.requests.cancel(params.ID)
+	return HandlerResult{}
+}
+
+// guardStale marks the result of request msg if the version of its
+// document it was computed against is no longer the latest
+func (s *Server) guardStale(msg RPCMessage, result HandlerResult) HandlerResult {
+	if result.Error != nil {
+		return result
+	}
+	var params struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+	}
+	if json.Unmarshal(msg.Params, &params) != nil || params.TextDocument.URI == "" {
+		return result
+	}
+	uri := params.TextDocument.URI
+	_, version, ok := s.document(uri)
+	if !ok || !s.requests.newer(uri, version) {
+		return result
+	}
+	if list, ok := result.Result.(CompletionList); ok {
+		list.IsIncomplete = true
+		return success(list)
+	}
+	log.Printf("Stale: %s (id=%v) was handled against version %d of %s", msg.Method, msg.ID, version, uri)
+	return failure(&RPCError{Code: ContentModified, Message: "the document changed while the request was handled"})
+}
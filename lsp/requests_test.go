@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// observeAndHandle feeds messages to the server the way Run does: each is
+// observed as it is read, then all are handled in order
+func observeAndHandle(t *testing.T, s *Server, messages ...string) []interface{} {
+	t.Helper()
+	for _, msg := range messages {
+		s.requests.observe(json.RawMessage(msg))
+	}
+	var responses []interface{}
+	for _, msg := range messages {
+		response, err := s.handleMessage(json.RawMessage(msg))
+		if err != nil {
+			t.Fatalf("handleMessage failed: %v", err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func TestCancelQueuedRequest(t *testing.T) {
+	s := NewServer()
+	s.setDocument("file:///c.spq", "so", 1)
+	completion := `{"jsonrpc":"2.0","id":"c1","method":"textDocument/completion","params":{"textDocument":{"uri":"file:///c.spq"},"position":{"line":0,"character":2}}}`
+	hover := `{"jsonrpc":"2.0","id":7,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///c.spq"},"position":{"line":0,"character":0}}}`
+	cancel := `{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":"c1"}}`
+
+	responses := observeAndHandle(t, s, completion, hover, cancel)
+	msg, ok := responses[0].(RPCMessage)
+	if !ok || msg.Error == nil || msg.Error.Code != RequestCancelled || msg.ID != "c1" {
+		t.Errorf("Expected RequestCancelled for c1, got %+v", responses[0])
+	}
+	if msg, ok := responses[1].(RPCMessage); !ok || msg.Error != nil {
+		t.Errorf("Expected hover to be answered normally, got %+v", responses[1])
+	}
+	if responses[2] != nil {
+		t.Errorf("Expected no response to $/cancelRequest, got %+v", responses[2])
+	}
+	if len(s.requests.requests) != 0 {
+		t.Errorf("Expected finished requests to be dropped, got %d", len(s.requests.requests))
+	}
+}
+
+func TestCancelUnknownRequest(t *testing.T) {
+	s := NewServer()
+	if s.requests.cancel(42) {
+		t.Error("Expected cancelling an unknown request to report false")
+	}
+	// 1 and "1" are different requests
+	s.requests.add(1)
+	if s.requests.cancel("1") {
+		t.Error(`Expected "1" not to cancel request 1`)
+	}
+}
+
+func TestCancelledContextStopsWork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if items := getCompletions(ctx, "so", Position{Line: 0, Character: 2}); items != nil {
+		t.Errorf("Expected no completions once cancelled, got %d", len(items))
+	}
+	if help := getSignatureHelp(ctx, "values abs(", Position{Line: 0, Character: 11}, docMarkdown); help != nil {
+		t.Errorf("Expected no signature help once cancelled, got %+v", help)
+	}
+	msg, err := NewServer().publishDiagnostics(ctx, "file:///x.spq", "values (", 1)
+	if err != nil || msg != nil {
+		t.Errorf("Expected no diagnostics once cancelled, got %+v %v", msg, err)
+	}
+}
+
+func TestSupersededDiagnostics(t *testing.T) {
+	s := NewServer()
+	s.setDocument("file:///d.spq", "values 1", 1)
+	change := func(version int, text string) string {
+		data, _ := json.Marshal(RPCMessage{
+			JSONRPC: "2.0",
+			Method:  "textDocument/didChange",
+			Params: mustMarshal(DidChangeTextDocumentParams{
+				TextDocument: VersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: TextDocumentIdentifier{URI: "file:///d.spq"},
+					Version:                version,
+				},
+				ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+			}),
+		})
+		return string(data)
+	}
+
+	responses := observeAndHandle(t, s, change(2, "values ("), change(3, "values 2"))
+	if responses[0] != nil {
+		t.Errorf("Expected no diagnostics for superseded version 2, got %+v", responses[0])
+	}
+	if d, ok := responses[1].(diagnosticsMessage); !ok || d.version != 3 {
+		t.Errorf("Expected diagnostics for version 3, got %+v", responses[1])
+	}
+	if text, version, _ := s.document("file:///d.spq"); text != "values 2" || version != 3 {
+		t.Errorf("Expected version 3 stored, got %d: %q", version, text)
+	}
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestStaleResponses(t *testing.T) {
+	s := NewServer()
+	s.setDocument("file:///s.spq", "values 1\n| so", 1)
+	change := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///s.spq","version":2},"contentChanges":[{"text":"values 2\n| so"}]}}`
+	completion := `{"jsonrpc":"2.0","id":1,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///s.spq"},"position":{"line":1,"character":4}}}`
+	formatting := `{"jsonrpc":"2.0","id":2,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///s.spq"},"options":{"tabSize":2,"insertSpaces":true}}}`
+
+	// Both requests are handled against version 1 after version 2 was read
+	responses := observeAndHandle(t, s, completion, formatting, change)
+	msg, _ := responses[0].(RPCMessage)
+	if list, ok := msg.Result.(CompletionList); !ok || !list.IsIncomplete || len(list.Items) == 0 {
+		t.Errorf("Expected incomplete completions, got %+v", responses[0])
+	}
+	msg, _ = responses[1].(RPCMessage)
+	if msg.Error == nil || msg.Error.Code != ContentModified {
+		t.Errorf("Expected ContentModified for formatting, got %+v", responses[1])
+	}
+
+	// Once the change is applied, answers are current again
+	responses = observeAndHandle(t, s, completion)
+	msg, _ = responses[0].(RPCMessage)
+	if list, ok := msg.Result.(CompletionList); !ok || list.IsIncomplete {
+		t.Errorf("Expected complete completions, got %+v", responses[0])
+	}
+}
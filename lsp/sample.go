@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/brimdata/super"
+	"github.com/brimdata/super/sup"
+)
+
+// Sample-based checks for refactors. The first stage of a query is its
+// source; a sample of what it produces is inlined as a values stage in
+// both the original and the refactored query, and the two are run and
+// their output compared. Matching output on a sample is evidence, not
+// proof, that the refactor is safe.
+
+// sampleSize is how many source values a refactor is checked against
+const sampleSize = 100
+
+// sampleVerdict is the outcome of checking a refactor on sample data
+type sampleVerdict int
+
+const (
+	sampleUnchecked sampleVerdict = iota // no sample could be taken or run
+	sampleMatches                        // both versions return the same values
+	sampleDiffers                        // the versions return different values
+)
+
+// verifyOnSample runs original and rewritten against a sample of the
+// original's source data and compares their output, in order. The rewrite
+// must keep the source stage as written, or there is nothing to compare.
+// Queries that write to a lake are never run.
+func verifyOnSample(ctx context.Context, lake, original, rewritten string) sampleVerdict {
+	if len(findLakeWrites(original)) > 0 || len(findLakeWrites(rewritten)) > 0 {
+		return sampleUnchecked
+	}
+	source, ok := sourceStage(original)
+	if !ok {
+		return sampleUnchecked
+	}
+	if other, ok := sourceStage(rewritten); !ok || other != source {
+		return sampleUnchecked
+	}
+	values, err := sampleSource(ctx, lake, source)
+	if err != nil || len(values) == 0 {
+		return sampleUnchecked
+	}
+
+	inline := "values " + strings.Join(values, ", ")
+	before, beforeErr := runQuery(ctx, lake, inline+original[len(source):], diffMaxValues)
+	after, afterErr := runQuery(ctx, lake, inline+rewritten[len(source):], diffMaxValues)
+	switch {
+	case beforeErr != nil && afterErr != nil:
+		return sampleUnchecked
+	case beforeErr != nil || afterErr != nil:
+		return sampleDiffers
+	case before.count != after.count || len(before.values) != len(after.values):
+		return sampleDiffers
+	}
+	for i := range before.values {
+		if string(before.values[i]) != string(after.values[i]) {
+			return sampleDiffers
+		}
+	}
+	return sampleMatches
+}
+
+// sourceStage returns the text of query up to the end of its first stage
+func sourceStage(query string) (string, bool) {
+	stages := splitStages(tokenize(query))
+	if len(stages) < 2 || stages[0].operator() == "" {
+		return "", false
+	}
+	return query[:stages[0].end], true
+}
+
+// sampleSource runs source and returns up to sampleSize of its values as
+// SUP literals
+func sampleSource(ctx context.Context, lake, source string) ([]string, error) {
+	var values []string
+	_, err := pullQuery(ctx, lake, source+"\n| head "+strconv.Itoa(sampleSize), func(val super.Value) error {
+		values = append(values, sup.FormatValue(val))
+		return nil
+	})
+	return values, err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/brimdata/super/compiler"
+	"github.com/brimdata/super/compiler/parser"
+	"github.com/brimdata/super/compiler/srcfiles"
+)
+
+// Validate on save. The diagnostics sent on each change come from parsing
+// and the server's own lints. Saving can also run the compiler's semantic
+// analysis, which finds what parsing can't, like an unknown operator, a
+// function given too many arguments, or a field a sort can't have, but
+// costs too much to run on every keystroke. It runs when save.validate is
+// set; its diagnostics last until the next change. A save also brings
+// the workspace index up to date with the saved text.
+
+// analysisDiagnostics returns the errors semantic analysis finds in a
+// query that parses. Sources are left to the missing-source lint, which
+// knows which directory a relative path is read from.
+func (s *Server) analysisDiagnostics(ctx context.Context, text string) []Diagnostic {
+	ast, err := parser.ParseQuery(text)
+	if err != nil {
+		return nil
+	}
+	env, err := newEnvironment(ctx, s.settings().Lake)
+	if err != nil {
+		log.Printf("Skipping analysis: %v", err)
+		return nil
+	}
+	_, err = compiler.Analyze(ctx, ast, env, false)
+	var list srcfiles.ErrorList
+	if !errors.As(err, &list) {
+		if err != nil {
+			log.Printf("Analysis failed: %v", err)
+		}
+		return nil
+	}
+	names, _ := sourceNames(text)
+	var diagnostics []Diagnostic
+	for _, e := range list {
+		if e.Pos < 0 || e.Pos > len(text) {
+			continue
+		}
+		rng := analysisRange(text, e)
+		if overlapsSource(rng, names) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    rng,
+			Severity: DiagnosticSeverityError,
+			Code:     "analysis",
+			Source:   "superdb-lsp",
+			Message:  e.Msg,
+		})
+	}
+	return diagnostics
+}
+
+// analysisRange returns the range of text an analysis error covers
+func analysisRange(text string, e *srcfiles.Error) Range {
+	// End is the offset of the last character
+	return Range{Start: positionAt(text, e.Pos), End: positionAt(text, min(max(e.End+1, e.Pos), len(text)))}
+}
+
+// overlapsSource reports whether rng overlaps one of the sources names
+func overlapsSource(rng Range, names []sourceName) bool {
+	for _, src := range names {
+		if positionLess(rng.Start, src.rng.End) && positionLess(src.rng.Start, rng.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDidSave processes textDocument/didSave notifications
+func (s *Server) handleDidSave(msg RPCMessage) HandlerResult {
+	var params DidSaveTextDocumentParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	uri := params.TextDocument.URI
+	text, version, ok := s.document(uri)
+	if !ok {
+		log.Printf("Document not found: %s", uri)
+		return HandlerResult{}
+	}
+	log.Printf("Document saved: %s", uri)
+	if s.isDataFile(uri) {
+		return HandlerResult{}
+	}
+	if file, err := uriToPath(uri); err == nil && s.folderOf(file) != "" {
+		s.index.update(uri, text)
+	}
+
+	settings := s.settings()
+	if !settings.Save.Validate {
+		return HandlerResult{}
+	}
+	ctx := s.requests.documentContext(uri, version)
+	diagnostics := s.diagnose(ctx, uri, text)
+	analysis := applySeverities(s.analysisDiagnostics(ctx, text), settings.Severities)
+	diagnostics = append(diagnostics, analysis...)
+	if ctx.Err() != nil {
+		return HandlerResult{}
+	}
+	log.Printf("Publishing %d diagnostics for %s after validating", len(diagnostics), uri)
+	return notify(s.diagnosticsNotification(uri, text, version, diagnostics))
+}
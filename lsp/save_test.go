@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestAnalysisDiagnostics(t *testing.T) {
+	s := NewServer()
+	text := "values 1 | put x := len(1,2)"
+	diagnostics := s.analysisDiagnostics(context.Background(), text)
+	if len(diagnostics) != 1 || diagnostics[0].Code != "analysis" || diagnostics[0].Message != "too many arguments" {
+		t.Fatalf("Expected too many arguments, got %+v", diagnostics)
+	}
+	if diagnostics[0].Range != rangeOfText(t, text, "len(1,2)") {
+		t.Errorf("Expected the call flagged, got %+v", diagnostics[0].Range)
+	}
+
+	// A missing file is the missing-source lint's to report
+	if diagnostics := s.analysisDiagnostics(context.Background(), "from nosuch.json | count()"); len(diagnostics) != 0 {
+		t.Errorf("Expected sources left alone, got %+v", diagnostics)
+	}
+	if diagnostics := s.analysisDiagnostics(context.Background(), "values 1 |"); len(diagnostics) != 0 {
+		t.Errorf("Expected nothing for a query that doesn't parse, got %+v", diagnostics)
+	}
+}
+
+func TestDidSave(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///saved.spq"
+	h.openDocument(t, uri, "values 1 | put x := len(1,2)")
+
+	save := func() *RPCMessage {
+		t.Helper()
+		params := DidSaveTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}
+		msg, err := h.ProcessNotification("textDocument/didSave", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	if msg := save(); msg != nil {
+		t.Errorf("Expected nothing published without save.validate, got %+v", msg)
+	}
+
+	h.server.config.Save.Validate = true
+	msg := save()
+	if msg == nil || msg.Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected diagnostics published, got %+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	json.Unmarshal(msg.Params, &params)
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Code != "analysis" {
+		t.Errorf("Expected the analysis error, got %+v", params.Diagnostics)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// protocolSchemaID identifies the generated schema document
+const protocolSchemaID = "https://github.com/chrismo/superdb-syntaxes/blob/main/lsp/schema/superdb-protocol.schema.json"
+
+// customMethod describes one superdb/* request or notification
+type customMethod struct {
+	Method    string
+	Kind      string // "request" or "notification"
+	Direction string // "clientToServer" or "serverToClient"
+	Params    reflect.Type
+	Result    reflect.Type // nil for notifications
+}
+
+// customMethods is every superdb/* method in the public contract. A
+// method is listed once the server implements it.
+var customMethods = []customMethod{
+	{"superdb/features", "notification", "serverToClient", reflect.TypeOf(FeaturesParams{}), nil},
+	{"superdb/ast", "request", "clientToServer", reflect.TypeOf(AstParams{}), reflect.TypeOf(AstResult{})},
+	{"superdb/plan", "request", "clientToServer", reflect.TypeOf(PlanParams{}), reflect.TypeOf(PlanResult{})},
+	{"superdb/version", "request", "clientToServer", nil, reflect.TypeOf(VersionResult{})},
+	{"superdb/stats", "request", "clientToServer", nil, reflect.TypeOf(StatsResult{})},
+	{"superdb/formatText", "request", "clientToServer", reflect.TypeOf(FormatTextParams{}), reflect.TypeOf(FormatTextResult{})},
+	{"superdb/queryResult", "notification", "serverToClient", reflect.TypeOf(QueryResultParams{}), nil},
+	{"superdb/stageStats", "notification", "serverToClient", reflect.TypeOf(StageStatsParams{}), nil},
+}
+
+// protocolSchema generates the JSON Schema for the custom protocol from the
+// Go types. Each type is a definition under $defs, the x-methods
+// annotation maps each method to its params and result definitions, and
+// x-initializationOptions points at the options the server takes, so an
+// editor can check its configuration.
+func protocolSchema() map[string]interface{} {
+	defs := make(map[string]interface{})
+	methods := make(map[string]interface{})
+	for _, m := range customMethods {
+		method := map[string]interface{}{
+			"kind":      m.Kind,
+			"direction": m.Direction,
+		}
+		if m.Params != nil {
+			method["params"] = schemaFor(m.Params, defs)
+		}
+		if m.Result != nil {
+			method["result"] = schemaFor(m.Result, defs)
+		}
+		methods[m.Method] = method
+	}
+	options := schemaFor(reflect.TypeOf(InitializationOptions{}), defs)
+	return map[string]interface{}{
+		"$schema":                 "https://json-schema.org/draft/2020-12/schema",
+		"$id":                     protocolSchemaID,
+		"title":                   "superdb-lsp custom protocol",
+		"$defs":                   defs,
+		"x-methods":               methods,
+		"x-initializationOptions": options,
+	}
+}
+
+// protocolSchemaJSON renders protocolSchema as indented JSON
+func protocolSchemaJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(protocolSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schemaFor returns the schema for values of type t, adding a definition
+// to defs for each named struct it reaches
+func schemaFor(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if t == rawMessageType || t.Kind() == reflect.Interface {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), defs)
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // placeholder for recursive types
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	panic("schemaFor: unsupported type " + t.String())
+}
+
+// structSchema describes a struct the way encoding/json marshals it.
+// Fields without omitempty are required.
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaFor(f.Type, defs)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const protocolSchemaFile = "schema/superdb-protocol.schema.json"
+
+func TestProtocolSchemaUpToDate(t *testing.T) {
+	want, err := protocolSchemaJSON()
+	if err != nil {
+		t.Fatalf("protocolSchemaJSON failed: %v", err)
+	}
+	got, err := os.ReadFile(protocolSchemaFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", protocolSchemaFile, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s is out of date; regenerate it with:\n\tgo run . -protocol-schema > %s",
+			protocolSchemaFile, protocolSchemaFile)
+	}
+}
+
+// TestProtocolPayloadsMatchSchema checks payloads the server builds against
+// the checked-in schema, so the contract and the code can't drift apart
+func TestProtocolPayloadsMatchSchema(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	rng := Range{Start: Position{Line: 0, Character: 0}, End: Position{Line: 0, Character: 4}}
+	doc := TextDocumentIdentifier{URI: "file:///test.spq"}
+
+	payloads := []struct {
+		method string
+		part   string
+		value  interface{}
+	}{
+		{"superdb/features", "params", NewServer().features()},
+		{"superdb/ast", "params", AstParams{TextDocument: doc}},
+		{"superdb/ast", "result", AstResult{AST: json.RawMessage(`{"kind":"Seq"}`)}},
+		{"superdb/ast", "result", AstResult{Error: &Diagnostic{Range: rng, Message: "syntax error"}}},
+		{"superdb/plan", "params", PlanParams{TextDocument: doc}},
+		{"superdb/plan", "result", PlanResult{DAG: json.RawMessage(`{"funcs":null,"body":[]}`), Text: "values 1"}},
+		{"superdb/plan", "result", PlanResult{Error: &Diagnostic{Range: rng, Message: "no such pool"}}},
+		{"superdb/version", "result", VersionResult{
+			Version: FullVersion(), SuperCommit: SuperCommit, DialectVersions: DialectVersions,
+			Capabilities: NewServer().capabilities(), Features: NewServer().features(), Healthy: true, Problems: []string{},
+		}},
+		{"superdb/stats", "result", StatsResult{
+			Documents: 1, Caches: CacheStats{SemanticTokens: 1}, Parses: ParseStats{Count: 3, P50Ms: 0.2, P95Ms: 1.5},
+			Memory: MemoryStats{HeapBytes: 1 << 20, SysBytes: 1 << 24, Goroutines: 8},
+		}},
+		{"superdb/formatText", "params", FormatTextParams{Text: "values 1|count()", Options: FormattingOptions{TabSize: 2, InsertSpaces: true}}},
+		{"superdb/formatText", "result", FormatTextResult{Text: "values 1\n| count()"}},
+		{"superdb/queryResult", "params", QueryResultParams{
+			URI: doc.URI, Values: []json.RawMessage{json.RawMessage(`{"x":1}`)}, Done: true,
+		}},
+		{"superdb/stageStats", "params", StageStatsParams{
+			URI: doc.URI, Version: 1, Stages: []StageStats{{Operator: "from", Range: rng, Records: 3, ElapsedMs: 0.5}},
+		}},
+	}
+
+	for _, p := range payloads {
+		t.Run(p.method+" "+p.part, func(t *testing.T) {
+			if err := validatePayload(schema, p.method, p.part, p.value); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestProtocolSchemaRejectsInvalidPayload(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	// A stage without a range, and a done flag with the wrong type
+	noRange := map[string]interface{}{
+		"stages": []interface{}{map[string]interface{}{"operator": "from", "text": "from test"}},
+	}
+	if err := validatePayload(schema, "superdb/pipelineOutline", "result", noRange); err == nil {
+		t.Error("Expected stage without a range to fail validation")
+	}
+	wrongType := map[string]interface{}{"uri": "file:///x", "values": []interface{}{}, "done": "yes"}
+	if err := validatePayload(schema, "superdb/queryResult", "params", wrongType); err == nil {
+		t.Error("Expected string done flag to fail validation")
+	}
+}
+
+func TestProtocolSchemaCoversCustomMethods(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	methods := schema["x-methods"].(map[string]interface{})
+	for _, m := range customMethods {
+		if !strings.HasPrefix(m.Method, "superdb/") {
+			t.Errorf("Custom method %s is outside the superdb/ namespace", m.Method)
+		}
+		if _, ok := methods[m.Method]; !ok {
+			t.Errorf("Schema is missing %s", m.Method)
+		}
+	}
+}
+
+func TestProtocolSchemaCoversInitializationOptions(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	ref, _ := schema["x-initializationOptions"].(map[string]interface{})
+	if ref["$ref"] != "#/$defs/InitializationOptions" {
+		t.Fatalf("Expected x-initializationOptions to point at its definition, got %v", ref)
+	}
+	def := schema["$defs"].(map[string]interface{})["InitializationOptions"].(map[string]interface{})
+	properties := def["properties"].(map[string]interface{})
+	for _, name := range []string{"lake", "format", "operatorAliases", "dataFiles", "dialectVersion", "formatterStyle"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Schema is missing option %s", name)
+		}
+	}
+}
+
+func loadProtocolSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(protocolSchemaFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", protocolSchemaFile, err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("failed to parse %s: %v", protocolSchemaFile, err)
+	}
+	return schema
+}
+
+// validatePayload checks value against the params or result schema of method
+func validatePayload(schema map[string]interface{}, method, part string, value interface{}) error {
+	m, ok := schema["x-methods"].(map[string]interface{})[method].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("schema has no method %s", method)
+	}
+	sub, ok := m[part].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s has no %s", method, part)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	return validateSchema(schema, sub, decoded, method+"."+part)
+}
+
+// validateSchema implements the subset of JSON Schema the generator emits:
+// $ref, type, properties, required, items, and additionalProperties
+func validateSchema(root, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		def, ok := root["$defs"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unresolved $ref %s", path, ref)
+		}
+		return validateSchema(root, def, value, path)
+	}
+
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", path, value)
+		}
+		for _, name := range schema["required"].([]interface{}) {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		for name, v := range obj {
+			sub, ok := properties[name].(map[string]interface{})
+			if !ok {
+				sub = additional
+			}
+			if sub == nil {
+				continue
+			}
+			if err := validateSchema(root, sub, v, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", path, value)
+		}
+		items := schema["items"].(map[string]interface{})
+		for i, v := range arr {
+			if err := validateSchema(root, items, v, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", path, value)
+		}
+	case "integer":
+		if f, ok := value.(float64); !ok || f != float64(int64(f)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", path, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Selection ranges. Expanding the selection steps out from the word at the
+// cursor through each expression of the parse tree that holds it, then
+// the pipeline stage, then the whole query:
+//
+//	len  →  len(name)  →  len(name) > 3  →  where len(name) > 3  →  query
+//
+// A query that doesn't parse still steps from word to stage to query.
+
+// span is a range of offsets, end exclusive
+type span struct {
+	start, end int
+}
+
+func (s span) contains(t span) bool {
+	return s.start <= t.start && t.end <= s.end
+}
+
+// selectionSpans returns the spans to select at offset in text, smallest
+// first, each containing the one before
+func selectionSpans(text string, offset int) []span {
+	var spans []span
+	add := func(start, end int) {
+		if start <= offset && offset <= end && start < end {
+			spans = append(spans, span{start, end})
+		}
+	}
+
+	// The token at offset, or just before it when offset is at its end
+	pos := 0
+	for _, tok := range tokenize(text) {
+		next := pos + len(tok.value)
+		switch tok.typ {
+		case tokIdentifier, tokKeyword, tokNumber, tokString, tokRegexp:
+			if pos <= offset && offset <= next {
+				add(pos, next)
+			}
+		}
+		pos = next
+	}
+
+	if tree, ok := parseTree(text); ok {
+		walkTree(tree, func(node map[string]interface{}) {
+			loc, _ := node["loc"].(map[string]interface{})
+			first, ok1 := loc["first"].(float64)
+			last, ok2 := loc["last"].(float64)
+			if ok1 && ok2 && int(last) < len(text) {
+				add(int(first), int(last)+1)
+			}
+		})
+	}
+
+	for _, st := range splitStages(tokenize(text)) {
+		add(stageBody(text, st))
+	}
+	start := len(text) - len(strings.TrimLeft(text, " \t\r\n"))
+	add(start, start+len(strings.TrimSpace(text)))
+	add(0, len(text))
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].end-spans[i].start < spans[j].end-spans[j].start
+	})
+	chain := []span{}
+	for _, s := range spans {
+		if len(chain) == 0 || s != chain[len(chain)-1] && s.contains(chain[len(chain)-1]) {
+			chain = append(chain, s)
+		}
+	}
+	return chain
+}
+
+// selectionRange returns the selection ranges at pos, innermost first
+func selectionRange(text string, pos Position) SelectionRange {
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return SelectionRange{Range: Range{Start: pos, End: pos}}
+	}
+	var sel *SelectionRange
+	spans := selectionSpans(text, offset)
+	for i := len(spans) - 1; i >= 0; i-- {
+		sel = &SelectionRange{
+			Range:  Range{Start: positionAt(text, spans[i].start), End: positionAt(text, spans[i].end)},
+			Parent: sel,
+		}
+	}
+	if sel == nil {
+		return SelectionRange{Range: Range{Start: pos, End: pos}}
+	}
+	return *sel
+}
+
+// handleSelectionRange processes textDocument/selectionRange requests
+func (s *Server) handleSelectionRange(msg RPCMessage) HandlerResult {
+	var params SelectionRangeParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, _, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	ranges := make([]SelectionRange, len(params.Positions))
+	for i, pos := range params.Positions {
+		ranges[i] = selectionRange(text, s.fromClient(text, pos))
+		for r := &ranges[i]; r != nil; r = r.Parent {
+			r.Range = s.rangeToClient(text, r.Range)
+		}
+	}
+	return success(ranges)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSelectionSpans(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		at   string // the cursor is just inside the first occurrence
+		want []string
+	}{
+		{
+			"nested expression",
+			"from logs\n| where len(name) > 3\n| head 1",
+			"name",
+			[]string{"name", "len(name)", "len(name) > 3", "where len(name) > 3", "from logs\n| where len(name) > 3\n| head 1"},
+		},
+		{
+			"precedence",
+			"values 1 | put a := x + y * 2",
+			"y",
+			[]string{"y", "y * 2", "x + y * 2", "a := x + y * 2", "put a := x + y * 2", "values 1 | put a := x + y * 2"},
+		},
+		{
+			"doesn't parse",
+			"from logs | where len(name",
+			"name",
+			[]string{"name", "where len(name", "from logs | where len(name"},
+		},
+		{
+			"surrounding whitespace",
+			"\nvalues 1\n",
+			"1",
+			[]string{"1", "values 1", "\nvalues 1\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range selectionSpans(tt.text, strings.Index(tt.text, tt.at)+1) {
+				got = append(got, tt.text[s.start:s.end])
+			}
+			if strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectionRangeRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///q.spq"
+	text := "values 1 | head 1"
+	h.openDocument(t, uri, text)
+
+	resp, err := h.ProcessRequest(1, "textDocument/selectionRange", SelectionRangeParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Positions:    []Position{posOf(t, text, "head", 1, 1), posOf(t, text, "values", 1, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranges []SelectionRange
+	data, _ := json.Marshal(resp.Result)
+	if err := json.Unmarshal(data, &ranges); err != nil || len(ranges) != 2 {
+		t.Fatalf("Expected a selection range per position, got %s", data)
+	}
+	var got []string
+	for sel := &ranges[0]; sel != nil; sel = sel.Parent {
+		got = append(got, rangeText(text, sel.Range))
+	}
+	if want := []string{"head", "head 1", text}; strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if rangeText(text, ranges[1].Range) != "values" {
+		t.Errorf("Expected the second position to start at values, got %+v", ranges[1])
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Semantic tokens. Client grammars color words by what they look like, so
+// count reads as an aggregate even where it names a field. The server
+// knows better: a word the query declares takes the kind of its
+// declaration, a word the symbol table resolves to a field is a property,
+// and a builtin is colored by its kind only where it is used as one, as a
+// function where it is called and as an operator where it starts a stage.
+
+// The last tokens sent for each document are kept under a result ID, so a
+// delta request can be answered with just the span of data that changed,
+// which for an edit to a large query is a few tokens rather than all of
+// them.
+
+// Token types, in legend order
+const (
+	semKeyword = iota
+	semOperator
+	semFunction
+	semType
+	semVariable
+	semParameter
+	semProperty
+	semString
+	semNumber
+	semRegexp
+	semComment
+)
+
+// Token modifiers, as bits in legend order
+const (
+	semModDeclaration = 1 << iota
+	semModReadonly
+	semModDefaultLibrary
+)
+
+// semanticLegend is the legend advertised in the initialize result
+var semanticLegend = SemanticTokensLegend{
+	TokenTypes: []string{
+		"keyword", "operator", "function", "type", "variable", "parameter",
+		"property", "string", "number", "regexp", "comment",
+	},
+	TokenModifiers: []string{"declaration", "readonly", "defaultLibrary"},
+}
+
+// semanticClass is the type and modifiers of a token
+type semanticClass struct {
+	typ       int
+	modifiers int
+}
+
+// declaredClasses classifies the names the symbol table of tree resolves,
+// keyed by where each starts. Where a stage starts it has a keyword, which
+// only an operator's name takes.
+func declaredClasses(text string, tree interface{}) map[Position]semanticClass {
+	classes := make(map[Position]semanticClass)
+	if tree == nil {
+		return classes
+	}
+	walkTree(tree, func(node map[string]interface{}) {
+		if strings.HasSuffix(nodeKind(node), "Op") {
+			classes[locRange(text, node["loc"]).Start] = semanticClass{semKeyword, 0}
+		}
+	})
+	table := symbolTableOf(text, tree)
+	classOf := func(sym *symbol) semanticClass {
+		switch sym.kind {
+		case symbolConst:
+			return semanticClass{semVariable, semModReadonly}
+		case symbolFunc, symbolOp:
+			return semanticClass{semFunction, 0}
+		case symbolType:
+			return semanticClass{semType, 0}
+		}
+		return semanticClass{semParameter, 0}
+	}
+	for _, f := range table.fields {
+		classes[f.rng.Start] = semanticClass{semProperty, 0}
+	}
+	for _, ref := range table.refs {
+		classes[ref.rng.Start] = classOf(ref.target)
+	}
+	for _, sym := range table.symbols {
+		c := classOf(sym)
+		c.modifiers |= semModDeclaration
+		classes[sym.nameRange.Start] = c
+	}
+	return classes
+}
+
+// semanticTokens returns the encoded semantic tokens of text: five
+// integers per token, its line and start relative to the previous token,
+// its length, its type, and its modifiers. Tokens that span lines are
+// split at each line break. Starts and lengths are measured with columns,
+// in the client's position encoding.
+func semanticTokens(text string, columns func(string) int) []uint32 {
+	tokens := tokenize(text)
+	tree, _ := parseTree(text)
+	declared := declaredClasses(text, tree)
+	data := []uint32{}
+	var line, char, col, prevLine, prevChar int
+	emit := func(line, char, length int, c semanticClass) {
+		if length == 0 {
+			return
+		}
+		deltaChar := char
+		if line == prevLine {
+			deltaChar = char - prevChar
+		}
+		data = append(data, uint32(line-prevLine), uint32(deltaChar), uint32(length), uint32(c.typ), uint32(c.modifiers))
+		prevLine, prevChar = line, char
+	}
+
+	stageStart := true
+	for i, tok := range tokens {
+		c, ok := classifyToken(tokens, i, stageStart, declared, Position{Line: line, Character: char})
+		if ok {
+			l, ch := line, col
+			for j, part := range strings.Split(tok.value, "\n") {
+				if j > 0 {
+					l, ch = l+1, 0
+				}
+				emit(l, ch, columns(strings.TrimSuffix(part, "\r")), c)
+			}
+		}
+		if n := strings.Count(tok.value, "\n"); n > 0 {
+			line += n
+			rest := tok.value[strings.LastIndexByte(tok.value, '\n')+1:]
+			char, col = len(rest), columns(rest)
+		} else {
+			char += len(tok.value)
+			col += columns(tok.value)
+		}
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+		case tokPipe:
+			stageStart = true
+		default:
+			stageStart = tok.value == "("
+		}
+	}
+	return data
+}
+
+// classifyToken returns the class of tokens[i], which starts at pos, and
+// false if it gets none. stageStart says whether it is the first word of a
+// stage.
+func classifyToken(tokens []token, i int, stageStart bool, declared map[Position]semanticClass, pos Position) (semanticClass, bool) {
+	tok := tokens[i]
+	switch tok.typ {
+	case tokComment:
+		return semanticClass{semComment, 0}, true
+	case tokString:
+		return semanticClass{semString, 0}, true
+	case tokNumber:
+		return semanticClass{semNumber, 0}, true
+	case tokRegexp:
+		return semanticClass{semRegexp, 0}, true
+	case tokOperator, tokPipe:
+		return semanticClass{semOperator, 0}, true
+	case tokIdentifier, tokKeyword:
+	default:
+		return semanticClass{}, false
+	}
+
+	if c, ok := declared[pos]; ok {
+		if c.typ != semKeyword {
+			return c, true
+		}
+		// The parse tree says a stage starts here
+		stageStart = true
+	}
+	b := Builtins.Lookup(tok.value)
+	if b == nil {
+		if tok.typ == tokKeyword {
+			return semanticClass{semKeyword, 0}, true
+		}
+		return semanticClass{}, false
+	}
+	switch b.Kind {
+	case KindFunction, KindAggregate:
+		if nextSignificant(tokens, i) == "(" {
+			return semanticClass{semFunction, semModDefaultLibrary}, true
+		}
+	case KindOperator:
+		if stageStart {
+			return semanticClass{semKeyword, 0}, true
+		}
+	case KindType:
+		return semanticClass{semType, semModDefaultLibrary}, true
+	case KindKeyword:
+		return semanticClass{semKeyword, 0}, true
+	}
+	if tok.typ == tokKeyword {
+		return semanticClass{semKeyword, 0}, true
+	}
+	return semanticClass{}, false
+}
+
+// nextSignificant returns the value of the first token after tokens[i]
+// that isn't whitespace or a comment
+func nextSignificant(tokens []token, i int) string {
+	for _, tok := range tokens[i+1:] {
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+			continue
+		}
+		return tok.value
+	}
+	return ""
+}
+
+// semanticResult is the token data last sent for a document
+type semanticResult struct {
+	id      string
+	version int
+	data    []uint32
+}
+
+// semanticCache holds the last result sent for each open document
+type semanticCache struct {
+	mu      sync.Mutex
+	results map[string]semanticResult
+	next    int
+}
+
+func newSemanticCache() *semanticCache {
+	return &semanticCache{results: make(map[string]semanticResult)}
+}
+
+// tokens returns the data for version of uri's text, measured with
+// columns, under a new result ID, along with the result previously sent
+// for uri. The data is reused when the version hasn't changed.
+func (c *semanticCache) tokens(uri, text string, version int, columns func(string) int) (current, previous semanticResult) {
+	c.mu.Lock()
+	previous, ok := c.results[uri]
+	c.mu.Unlock()
+
+	var data []uint32
+	if ok && previous.version == version {
+		data = previous.data
+	} else {
+		data = semanticTokens(text, columns)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next++
+	current = semanticResult{id: strconv.Itoa(c.next), version: version, data: data}
+	c.results[uri] = current
+	return current, previous
+}
+
+// forget drops the result kept for uri
+func (c *semanticCache) forget(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.results, uri)
+}
+
+// size returns how many documents have semantic tokens cached
+func (c *semanticCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.results)
+}
+
+// semanticEdits returns the edit that turns before into after: the span
+// between their common prefix and common suffix, replaced. Equal data
+// needs no edits.
+func semanticEdits(before, after []uint32) []SemanticTokensEdit {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(before) && prefix == len(after) {
+		return []SemanticTokensEdit{}
+	}
+	return []SemanticTokensEdit{{
+		Start:       prefix,
+		DeleteCount: len(before) - prefix - suffix,
+		Data:        append([]uint32{}, after[prefix:len(after)-suffix]...),
+	}}
+}
+
+// handleSemanticTokensFull processes textDocument/semanticTokens/full
+// requests
+func (s *Server) handleSemanticTokensFull(msg RPCMessage) HandlerResult {
+	var params SemanticTokensParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, version, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success(SemanticTokens{Data: []uint32{}})
+	}
+	current, _ := s.semantic.tokens(params.TextDocument.URI, text, version, s.columns)
+	return success(SemanticTokens{ResultID: current.id, Data: current.data})
+}
+
+// handleSemanticTokensDelta processes textDocument/semanticTokens/full/delta
+// requests. When the client's previous result is the one last sent, only
+// the changed span of data is returned; otherwise all of it is.
+func (s *Server) handleSemanticTokensDelta(msg RPCMessage) HandlerResult {
+	var params SemanticTokensDeltaParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.TextDocument.URI)
+	text, version, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		log.Printf("Document not found: %s", params.TextDocument.URI)
+		return success(nil)
+	}
+	if s.isDataFile(params.TextDocument.URI) {
+		return success(SemanticTokens{Data: []uint32{}})
+	}
+	current, previous := s.semantic.tokens(params.TextDocument.URI, text, version, s.columns)
+	if previous.id == "" || previous.id != params.PreviousResultID {
+		return success(SemanticTokens{ResultID: current.id, Data: current.data})
+	}
+	return success(SemanticTokensDelta{
+		ResultID: current.id,
+		Edits:    semanticEdits(previous.data, current.data),
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// decodedToken is a semantic token with absolute positions and names
+type decodedToken struct {
+	line, char int
+	text       string
+	typ        string
+	modifiers  []string
+}
+
+func decodeSemanticTokens(text string, data []uint32) []decodedToken {
+	lines := strings.Split(text, "\n")
+	var tokens []decodedToken
+	line, char := 0, 0
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			char = 0
+		}
+		line += int(data[i])
+		char += int(data[i+1])
+		tok := decodedToken{
+			line: line,
+			char: char,
+			text: lines[line][char : char+int(data[i+2])],
+			typ:  semanticLegend.TokenTypes[data[i+3]],
+		}
+		for bit, name := range semanticLegend.TokenModifiers {
+			if data[i+4]&(1<<bit) != 0 {
+				tok.modifiers = append(tok.modifiers, name)
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+func TestSemanticTokens(t *testing.T) {
+	text := "const n = 3\nfn double(x): ( x * 2 )\nvalues {count:1} -- a /* note */\n| count() by count\n| sort -r count\n| put y := double(n)"
+	got := make(map[string][]string)
+	for _, tok := range decodeSemanticTokens(text, semanticTokens(text, utf16Units)) {
+		key := tok.text
+		got[key] = append(got[key], strings.TrimSpace(tok.typ+" "+strings.Join(tok.modifiers, " ")))
+	}
+
+	want := map[string][]string{
+		"const":  {"keyword"},
+		"n":      {"variable declaration readonly", "variable readonly"},
+		"double": {"function declaration", "function"},
+		"x":      {"parameter declaration", "parameter"},
+		"values": {"keyword"},
+		// An aggregate where it's called, a field everywhere else
+		"count": {"property", "function defaultLibrary", "property", "property"},
+		"sort":  {"keyword"},
+		"3":     {"number"},
+		"|":     {"operator", "operator", "operator"},
+	}
+	for text, classes := range want {
+		if strings.Join(got[text], ", ") != strings.Join(classes, ", ") {
+			t.Errorf("%s: expected %v, got %v", text, classes, got[text])
+		}
+	}
+	if c := got["-- a /* note */"]; len(c) != 1 || c[0] != "comment" {
+		t.Errorf("Expected the comment as one token, got %v", c)
+	}
+}
+
+func TestSemanticTokensMultilineComment(t *testing.T) {
+	text := "/* one\ntwo */ values 1"
+	tokens := decodeSemanticTokens(text, semanticTokens(text, utf16Units))
+	if len(tokens) < 2 || tokens[0].text != "/* one" || tokens[1].text != "two */" || tokens[1].line != 1 {
+		t.Errorf("Expected the comment split at the line break, got %+v", tokens)
+	}
+}
+
+func TestSemanticEdits(t *testing.T) {
+	before := []uint32{0, 0, 5, 0, 0, 0, 6, 1, 8, 0, 1, 0, 4, 0, 0}
+	after := []uint32{0, 0, 5, 0, 0, 0, 6, 2, 8, 0, 1, 0, 4, 0, 0}
+	edits := semanticEdits(before, after)
+	if len(edits) != 1 || edits[0].Start != 7 || edits[0].DeleteCount != 1 || len(edits[0].Data) != 1 || edits[0].Data[0] != 2 {
+		t.Errorf("Expected one integer replaced, got %+v", edits)
+	}
+	if edits := semanticEdits(before, before); len(edits) != 0 {
+		t.Errorf("Expected no edits for equal data, got %+v", edits)
+	}
+	edits = semanticEdits(before, before[:10])
+	if len(edits) != 1 || edits[0].Start != 10 || edits[0].DeleteCount != 5 || len(edits[0].Data) != 0 {
+		t.Errorf("Expected the last token deleted, got %+v", edits)
+	}
+}
+
+func TestSemanticTokensDelta(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///delta.spq"
+	text := "values 1\n| put a := 2\n| sort a"
+	h.openDocument(t, uri, text)
+
+	full := func(method string, params interface{}) map[string]interface{} {
+		response, err := h.ProcessRequest(1, method, params)
+		if err != nil || response.Error != nil {
+			t.Fatalf("%s failed: %v %v", method, err, response.Error)
+		}
+		result, _ := response.Result.(map[string]interface{})
+		return result
+	}
+	first := full("textDocument/semanticTokens/full", SemanticTokensParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	var data []uint32
+	raw, _ := json.Marshal(first["data"])
+	json.Unmarshal(raw, &data)
+
+	text = "values 1\n| put abc := 2\n| sort a"
+	h.ProcessNotification("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: text}},
+	})
+
+	params := SemanticTokensDeltaParams{
+		TextDocument:     TextDocumentIdentifier{URI: uri},
+		PreviousResultID: first["resultId"].(string),
+	}
+	result := full("textDocument/semanticTokens/full/delta", params)
+	raw, _ = json.Marshal(result["edits"])
+	var edits []SemanticTokensEdit
+	if err := json.Unmarshal(raw, &edits); err != nil || len(edits) != 1 {
+		t.Fatalf("Expected one edit, got %v", result)
+	}
+	e := edits[0]
+	if len(e.Data) >= len(data) {
+		t.Errorf("Expected the edit to be smaller than the full data, got %+v", e)
+	}
+	data = append(append(append([]uint32{}, data[:e.Start]...), e.Data...), data[e.Start+e.DeleteCount:]...)
+	if want := semanticTokens(text, utf16Units); fmt.Sprint(data) != fmt.Sprint(want) {
+		t.Errorf("Applying the delta:\nexpected %v\ngot      %v", want, data)
+	}
+
+	// A result ID the server no longer has gets the full data
+	result = full("textDocument/semanticTokens/full/delta", params)
+	if _, ok := result["data"]; !ok {
+		t.Errorf("Expected full data for a stale result ID, got %v", result)
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestHelper provides utilities for testing the LSP server
@@ -18,8 +21,11 @@ type TestHelper struct {
 
 // NewTestHelper creates a new test helper
 func NewTestHelper() *TestHelper {
+	server := NewServer()
+	// Process IDs in tests are made up; don't exit when they aren't found
+	server.orphaned = nil
 	return &TestHelper{
-		server: NewServer(),
+		server: server,
 		input:  &bytes.Buffer{},
 		output: &bytes.Buffer{},
 	}
@@ -177,8 +183,8 @@ func TestInitializeHandshake(t *testing.T) {
 		t.Error("Expected server info with name 'superdb-lsp'")
 	}
 
-	if result.Capabilities.TextDocumentSync != 1 {
-		t.Errorf("Expected TextDocumentSync 1, got %d", result.Capabilities.TextDocumentSync)
+	if result.Capabilities.TextDocumentSync.Change != 2 {
+		t.Errorf("Expected TextDocumentSync 2, got %d", result.Capabilities.TextDocumentSync.Change)
 	}
 
 	if result.Capabilities.CompletionProvider == nil {
@@ -210,6 +216,51 @@ func TestShutdown(t *testing.T) {
 	}
 }
 
+func TestUnknownMethod(t *testing.T) {
+	h := NewTestHelper()
+
+	response, err := h.ProcessRequest(1, "textDocument/noSuchThing", struct{}{})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if response == nil || response.Error == nil || response.Error.Code != MethodNotFound {
+		t.Errorf("Expected MethodNotFound, got %+v", response)
+	}
+
+	// Notifications are ignored
+	msg, err := h.ProcessNotification("$/noSuchThing", struct{}{})
+	if err != nil || msg != nil {
+		t.Errorf("Expected no response to an unknown notification, got %+v, %v", msg, err)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	h := NewTestHelper()
+	h.server.out = &bytes.Buffer{}
+
+	response, err := h.ProcessRequest(1, "textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]string{"uri": "file:///test.spq"},
+		"position":     map[string]interface{}{"line": "one", "character": 0},
+	})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if response == nil || response.Error == nil || response.Error.Code != InvalidParams {
+		t.Fatalf("Expected InvalidParams, got %+v", response)
+	}
+	var data InvalidParamsData
+	raw, _ := json.Marshal(response.Error.Data)
+	json.Unmarshal(raw, &data)
+	if data.Field != "position.line" || data.Expected != "int" || data.Got != "string" {
+		t.Errorf("Unexpected error data: %+v", data)
+	}
+
+	// Params the client got wrong aren't the server's failure to report
+	if msgs := drainMessages(t, h.server.out.(*bytes.Buffer)); len(msgs) != 0 {
+		t.Errorf("Expected nothing shown, got %+v", msgs)
+	}
+}
+
 func TestDidOpenWithValidDocument(t *testing.T) {
 	h := NewTestHelper()
 
@@ -351,6 +402,54 @@ func TestCompletion(t *testing.T) {
 	}
 }
 
+func TestCompletionResolve(t *testing.T) {
+	h := NewTestHelper()
+	h.openDocument(t, "file:///resolve.spq", "from test | cu")
+	response, _ := h.ProcessRequest(1, "textDocument/completion", CompletionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///resolve.spq"},
+		Position:     Position{Line: 0, Character: 14},
+	})
+	resultBytes, _ := json.Marshal(response.Result)
+	var list CompletionList
+	json.Unmarshal(resultBytes, &list)
+	var cut *CompletionItem
+	for i := range list.Items {
+		if list.Items[i].Documentation != nil {
+			t.Errorf("Expected %s without documentation until resolved", list.Items[i].Label)
+		}
+		if list.Items[i].Label == "cut" {
+			cut = &list.Items[i]
+		}
+	}
+	if cut == nil {
+		t.Fatal("Expected cut in completions")
+	}
+
+	response, err := h.ProcessRequest(2, "completionItem/resolve", cut)
+	if err != nil || response.Error != nil {
+		t.Fatalf("resolve failed: %v %+v", err, response)
+	}
+	var resolved struct {
+		Label         string        `json:"label"`
+		Documentation MarkupContent `json:"documentation"`
+	}
+	resultBytes, _ = json.Marshal(response.Result)
+	json.Unmarshal(resultBytes, &resolved)
+	doc := resolved.Documentation
+	if resolved.Label != "cut" || doc.Kind != MarkupKindMarkdown {
+		t.Fatalf("Unexpected resolved item: %+v", resolved)
+	}
+	if !strings.Contains(doc.Value, "**cut** (operator)") || !strings.Contains(doc.Value, "```spq\nfrom test | cut name, age\n```") {
+		t.Errorf("Expected the docs and examples, got %q", doc.Value)
+	}
+
+	// An item the server didn't make comes back as it was
+	response, _ = h.ProcessRequest(3, "completionItem/resolve", CompletionItem{Label: "other"})
+	if item := response.Result.(map[string]interface{}); item["label"] != "other" || item["documentation"] != nil {
+		t.Errorf("Expected the item unchanged, got %+v", item)
+	}
+}
+
 func TestCompletionKeywords(t *testing.T) {
 	h := NewTestHelper()
 
@@ -568,6 +667,76 @@ func TestDocumentManagement(t *testing.T) {
 	}
 }
 
+func TestDidCloseClearsDiagnostics(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///closed.spq"
+	closeDocument := func() *RPCMessage {
+		t.Helper()
+		msg, err := h.ProcessNotification("textDocument/didClose", DidCloseTextDocumentParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+		})
+		if err != nil {
+			t.Fatalf("didClose failed: %v", err)
+		}
+		return msg
+	}
+
+	h.openDocument(t, uri, "values 1 |")
+	msg := closeDocument()
+	if msg == nil || msg.Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected diagnostics published, got %+v", msg)
+	}
+	if !strings.Contains(string(msg.Params), `"diagnostics":[]`) || !strings.Contains(string(msg.Params), `"version":1`) {
+		t.Errorf("Expected version 1 cleared, got %s", msg.Params)
+	}
+
+	// Reopened, its versions start over
+	if msg := h.openDocument(t, uri, "values 1 |"); msg == nil {
+		t.Error("Expected the reopened document's diagnostics published")
+	}
+
+	h.server.config.KeepClosedDiagnostics = true
+	if msg := closeDocument(); msg != nil {
+		t.Errorf("Expected diagnostics kept, got %+v", msg)
+	}
+	if msg := closeDocument(); msg != nil {
+		t.Errorf("Expected nothing for a document that isn't open, got %+v", msg)
+	}
+}
+
+func TestIncrementalDidChange(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///incremental.spq"
+	h.openDocument(t, uri, "from test\n| count()")
+
+	rng := func(sl, sc, el, ec int) *Range {
+		return &Range{Start: Position{Line: sl, Character: sc}, End: Position{Line: el, Character: ec}}
+	}
+	changeParams := DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: TextDocumentIdentifier{URI: uri},
+			Version:                2,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{
+			{Range: rng(0, 5, 0, 9), Text: "logs"},         // replace
+			{Range: rng(1, 9, 1, 9), Text: " by host"},     // insert at end of line
+			{Range: rng(1, 0, 1, 0), Text: "| where ok\n"}, // insert a line
+			{Range: rng(0, 9, 1, 0), Text: " "},            // join lines
+			{Range: rng(5, 0, 5, 0), Text: "\n| head"},     // past the end
+		},
+	}
+	if _, err := h.ProcessNotification("textDocument/didChange", changeParams); err != nil {
+		t.Fatalf("didChange failed: %v", err)
+	}
+	want := "from logs | where ok\n| count() by host\n| head"
+	if got := h.server.documents[uri]; got != want {
+		t.Errorf("Expected %q after incremental changes, got %q", want, got)
+	}
+	if h.server.versions[uri] != 2 {
+		t.Errorf("Expected version 2, got %d", h.server.versions[uri])
+	}
+}
+
 func TestPositionExtraction(t *testing.T) {
 	tests := []struct {
 		errStr       string
@@ -620,7 +789,7 @@ func TestCompletionPrefixMatching(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.text, func(t *testing.T) {
-			items := getCompletions(tt.text, tt.position)
+			items := getCompletions(context.Background(), tt.text, tt.position)
 
 			for _, exp := range tt.expected {
 				found := false
@@ -645,27 +814,119 @@ func TestCompletionPrefixMatching(t *testing.T) {
 }
 
 func TestCompletionSQLKeywords(t *testing.T) {
-	// Test that SQL keywords are available in completions
-	sqlKeywords := []string{
-		"select", "group", "having", "order", "limit", "offset",
-		"join", "left", "right", "inner", "outer", "on",
-		"case", "when", "then", "else", "end",
-		"and", "or", "not", "in", "like", "between",
+	// Test that SQL keywords are available in completions, those of a
+	// clause where the clause is open
+	tests := []struct {
+		text     string
+		keywords []string
+	}{
+		{"select ", []string{
+			"select", "group", "order", "limit", "offset", "join",
+			"case", "when", "and", "or", "not", "in", "like", "between",
+		}},
+		{"select a from t group by a ", []string{"having"}},
+		{"select * from t ", []string{"left", "right", "inner", "outer"}},
+		{"select * from t join u ", []string{"on"}},
+		{"select case when a ", []string{"then", "else", "end"}},
 	}
 
-	items := getCompletions("", Position{Line: 0, Character: 0})
+	for _, tt := range tests {
+		items := getCompletions(context.Background(), tt.text, Position{Line: 0, Character: len(tt.text)})
+		for _, kw := range tt.keywords {
+			found := false
+			for _, item := range items {
+				if item.Label == kw {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("SQL keyword '%s' not found in completions after %q", kw, tt.text)
+			}
+		}
+	}
+}
 
-	for _, kw := range sqlKeywords {
-		found := false
-		for _, item := range items {
-			if item.Label == kw {
-				found = true
-				break
+func TestCompletionStageStart(t *testing.T) {
+	for _, text := range []string{"from test | ", "from test\n| ", ""} {
+		lines := strings.Split(text, "\n")
+		pos := Position{Line: len(lines) - 1, Character: len(lines[len(lines)-1])}
+		labels := completionLabels(getCompletions(context.Background(), text, pos))
+		for _, want := range []string{"sort", "where", "count", "select"} {
+			if !slices.Contains(labels, want) {
+				t.Errorf("%q: expected %s in completions", text, want)
 			}
 		}
-		if !found {
-			t.Errorf("SQL keyword '%s' not found in completions", kw)
+		for _, unwanted := range []string{"upper", "int64", "case", "this"} {
+			if slices.Contains(labels, unwanted) {
+				t.Errorf("%q: expected no %s at the start of a stage", text, unwanted)
+			}
+		}
+	}
+
+	// A line continuing a stage is no stage start
+	text := "from test | where a\n  ca"
+	labels := completionLabels(getCompletions(context.Background(), text, Position{Line: 1, Character: 4}))
+	if !slices.Contains(labels, "case") {
+		t.Errorf("expected case continuing a where, got %v", labels)
+	}
+}
+
+func TestCompletionInStringOrComment(t *testing.T) {
+	tests := []struct {
+		text string // | marks the cursor
+		want bool
+	}{
+		{`values "ab|`, true},
+		{`values 'it\'s |`, true},
+		{`values "ab" + |`, false},
+		{`values "a\\" + |`, false},
+		{`values x -- count |`, true},
+		{"values x -- note\n| so|", false},
+		{"/* a\n  b |", true},
+		{"/* a */ values |", false},
+		{"from \"logs/*.json\"\n| so|", false},
+		{`values f"{ab|`, false},
+		{`values f"ab|`, true},
+		{`values x - |`, false},
+	}
+	for _, tt := range tests {
+		offset := strings.LastIndex(tt.text, "|")
+		text := tt.text[:offset] + tt.text[offset+1:]
+		pos := positionAt(text, offset)
+		if got := inStringOrComment(text, offset); got != tt.want {
+			t.Errorf("inStringOrComment(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+		if items := getCompletions(context.Background(), text, pos); tt.want && len(items) > 0 {
+			t.Errorf("%q: expected no completions, got %d", tt.text, len(items))
+		}
+	}
+}
+
+func TestCompletionSnippets(t *testing.T) {
+	snippets := func(text string) []string {
+		var labels []string
+		for _, item := range getCompletions(context.Background(), text, Position{Line: 0, Character: len(text)}) {
+			if item.Kind == CompletionItemKindSnippet {
+				if item.InsertTextFormat != InsertTextFormatSnippet {
+					t.Errorf("Expected %s to be a snippet", item.Label)
+				}
+				labels = append(labels, item.Label)
+			}
 		}
+		return labels
+	}
+	if got := snippets("from test | su"); !slices.Equal(got, []string{"summarize count"}) {
+		t.Errorf("Expected summarize count at the start of a stage, got %v", got)
+	}
+	if got := snippets("from test | ca"); len(got) != 0 {
+		t.Errorf("Expected no expression snippets at the start of a stage, got %v", got)
+	}
+	if got := snippets("values 1 + ca"); !slices.Equal(got, []string{"case when"}) {
+		t.Errorf("Expected case when in an expression, got %v", got)
+	}
+	if got := snippets("values cast(x, "); len(got) != 0 {
+		t.Errorf("Expected no snippets where a type goes, got %v", got)
 	}
 }
 
@@ -678,7 +939,7 @@ func TestCompletionOperators(t *testing.T) {
 		"debug", "explode", "output", "skip", "unnest", "values",
 	}
 
-	items := getCompletions("", Position{Line: 0, Character: 0})
+	items := getCompletions(context.Background(), "", Position{Line: 0, Character: 0})
 
 	for _, op := range ops {
 		found := false
@@ -703,7 +964,7 @@ func TestCompletionFunctions(t *testing.T) {
 		"date_part", "length", "nullif", "parse_sup", "position",
 	}
 
-	items := getCompletions("test(", Position{Line: 0, Character: 5})
+	items := getCompletions(context.Background(), "test(", Position{Line: 0, Character: 5})
 
 	for _, fn := range funcs {
 		found := false
@@ -726,7 +987,7 @@ func TestCompletionAggregates(t *testing.T) {
 		"collect", "collect_map", "dcount", "union", "any", "fuse",
 	}
 
-	items := getCompletions("summarize(", Position{Line: 0, Character: 10})
+	items := getCompletions(context.Background(), "summarize(", Position{Line: 0, Character: 10})
 
 	for _, agg := range aggs {
 		found := false
@@ -752,7 +1013,7 @@ func TestCompletionAllTypes(t *testing.T) {
 		"date", "timestamp", "bigint", "smallint", "boolean", "text", "bytea",
 	}
 
-	items := getCompletions("cast(x, ", Position{Line: 0, Character: 8})
+	items := getCompletions(context.Background(), "cast(x, ", Position{Line: 0, Character: 8})
 
 	for _, typ := range allTypes {
 		found := false
@@ -778,8 +1039,18 @@ func TestCompletionContext(t *testing.T) {
 		{"general context", "from test", 9, contextGeneral},
 		{"type context after cast", "cast(x, ", 8, contextType},
 		{"type context after ::", "x::", 3, contextType},
+		{"type context in a declaration", "type port = ", 12, contextType},
+		{"type context in a record type", "type conn = {port:ui", 20, contextType},
+		{"type context in a type value", "values <{a:str", 14, contextType},
+		{"type context after a call's comma", "is(x, <str", 10, contextType},
+		{"general after a comparison", "where x < y", 11, contextGeneral},
+		{"general after a closed type value", "values <int64> | so", 19, contextStageStart},
+		{"general after type ==", "where type == ", 14, contextGeneral},
 		{"function context in parens", "foo(bar", 7, contextFunction},
 		{"general after closed parens", "foo()", 5, contextGeneral},
+		{"stage start after pipe", "from test | so", 14, contextStageStart},
+		{"stage start at line start", "  so", 4, contextStageStart},
+		{"general after or", "where a || b", 12, contextGeneral},
 	}
 
 	for _, tt := range tests {
@@ -868,7 +1139,7 @@ func TestHoverKeyword(t *testing.T) {
 	text := "from test | where x > 5"
 	pos := Position{Line: 0, Character: 13} // over "where"
 
-	hover := getHover(text, pos)
+	hover := getHover(text, pos, docMarkdown)
 	if hover == nil {
 		t.Fatal("Expected hover result, got nil")
 	}
@@ -886,7 +1157,7 @@ func TestHoverFunction(t *testing.T) {
 	text := "from test | put y := ceil(x)"
 	pos := Position{Line: 0, Character: 22} // over "ceil"
 
-	hover := getHover(text, pos)
+	hover := getHover(text, pos, docMarkdown)
 	if hover == nil {
 		t.Fatal("Expected hover result, got nil")
 	}
@@ -900,7 +1171,7 @@ func TestHoverAggregate(t *testing.T) {
 	text := "from test | summarize count() by x"
 	pos := Position{Line: 0, Character: 23} // over "count"
 
-	hover := getHover(text, pos)
+	hover := getHover(text, pos, docMarkdown)
 	if hover == nil {
 		t.Fatal("Expected hover result, got nil")
 	}
@@ -914,7 +1185,7 @@ func TestHoverType(t *testing.T) {
 	text := "cast(x, int64)"
 	pos := Position{Line: 0, Character: 9} // over "int64"
 
-	hover := getHover(text, pos)
+	hover := getHover(text, pos, docMarkdown)
 	if hover == nil {
 		t.Fatal("Expected hover result, got nil")
 	}
@@ -928,7 +1199,7 @@ func TestHoverNoResult(t *testing.T) {
 	text := "from test"
 	pos := Position{Line: 0, Character: 5} // over "test" (not a keyword)
 
-	hover := getHover(text, pos)
+	hover := getHover(text, pos, docMarkdown)
 	if hover != nil {
 		t.Errorf("Expected no hover for identifier, got: %v", hover)
 	}
@@ -938,7 +1209,7 @@ func TestSignatureHelpFunction(t *testing.T) {
 	text := "from test | put y := ceil("
 	pos := Position{Line: 0, Character: 26} // after opening paren
 
-	sigHelp := getSignatureHelp(text, pos)
+	sigHelp := getSignatureHelp(context.Background(), text, pos, docMarkdown)
 	if sigHelp == nil {
 		t.Fatal("Expected signature help, got nil")
 	}
@@ -957,7 +1228,7 @@ func TestSignatureHelpAggregate(t *testing.T) {
 	text := "from test | summarize sum("
 	pos := Position{Line: 0, Character: 26}
 
-	sigHelp := getSignatureHelp(text, pos)
+	sigHelp := getSignatureHelp(context.Background(), text, pos, docMarkdown)
 	if sigHelp == nil {
 		t.Fatal("Expected signature help, got nil")
 	}
@@ -976,7 +1247,7 @@ func TestSignatureHelpMultipleParams(t *testing.T) {
 	text := "replace(s, old, "
 	pos := Position{Line: 0, Character: 16} // after second comma
 
-	sigHelp := getSignatureHelp(text, pos)
+	sigHelp := getSignatureHelp(context.Background(), text, pos, docMarkdown)
 	if sigHelp == nil {
 		t.Fatal("Expected signature help, got nil")
 	}
@@ -990,7 +1261,7 @@ func TestSignatureHelpNoContext(t *testing.T) {
 	text := "from test | sort x"
 	pos := Position{Line: 0, Character: 18}
 
-	sigHelp := getSignatureHelp(text, pos)
+	sigHelp := getSignatureHelp(context.Background(), text, pos, docMarkdown)
 	if sigHelp != nil {
 		t.Errorf("Expected no signature help outside function call, got: %v", sigHelp)
 	}
@@ -1011,6 +1282,21 @@ func TestFormatBasic(t *testing.T) {
 	}
 }
 
+func TestFormatCompactStyle(t *testing.T) {
+	input := "from   test  |   count()\n|  sort this"
+	expected := "from test | count()\n| sort this"
+
+	options := FormattingOptions{
+		TabSize:      2,
+		InsertSpaces: true,
+	}
+
+	result := formatStyled(input, options, FormatterStyleCompact)
+	if result != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
 func TestFormatPreservesComments(t *testing.T) {
 	input := "-- comment\nfrom test"
 
@@ -1565,3 +1851,639 @@ func TestInitializeWithNewCapabilities(t *testing.T) {
 		t.Error("Expected DocumentFormattingProvider to be true")
 	}
 }
+
+// openDocument sends a didOpen for a .spq document and returns whatever the
+// server replied with directly (nil when diagnostics were deferred)
+func (h *TestHelper) openDocument(t *testing.T, uri, text string) *RPCMessage {
+	t.Helper()
+	response, err := h.ProcessNotification("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "spq", Version: 1, Text: text},
+	})
+	if err != nil {
+		t.Fatalf("didOpen failed: %v", err)
+	}
+	return response
+}
+
+// drainMessages reads every message written to out
+func drainMessages(t *testing.T, out *bytes.Buffer) []RPCMessage {
+	t.Helper()
+	var msgs []RPCMessage
+	reader := bufio.NewReader(out)
+	for {
+		raw, err := readMessage(reader)
+		if err != nil {
+			return msgs
+		}
+		var msg RPCMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			t.Fatalf("Unmarshal message: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func diagnosticsURIs(t *testing.T, msgs []RPCMessage) map[string]bool {
+	t.Helper()
+	uris := make(map[string]bool)
+	for _, msg := range msgs {
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatalf("Unmarshal params: %v", err)
+		}
+		uris[params.URI] = true
+	}
+	return uris
+}
+
+func TestWarmupDefersBatchedOpens(t *testing.T) {
+	h := NewTestHelper()
+	background := &bytes.Buffer{}
+	h.server.out = background
+
+	now := time.Unix(0, 0)
+	h.server.warmup.now = func() time.Time { return now }
+
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{ProcessID: 1}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if _, err := h.ProcessNotification("initialized", nil); err != nil {
+		t.Fatalf("initialized failed: %v", err)
+	}
+
+	// The first document is published synchronously
+	if response := h.openDocument(t, "file:///visible.spq", "from test"); response == nil {
+		t.Fatal("Expected diagnostics for the first opened document")
+	}
+
+	// The rest of the burst is deferred to background workers
+	for _, uri := range []string{"file:///a.spq", "file:///b.spq", "file:///c.spq"} {
+		now = now.Add(10 * time.Millisecond)
+		if response := h.openDocument(t, uri, "from test | count()"); response != nil {
+			t.Errorf("Expected deferred diagnostics for %s, got %v", uri, response)
+		}
+	}
+
+	h.server.warmup.wait()
+	uris := diagnosticsURIs(t, drainMessages(t, background))
+	for _, uri := range []string{"file:///a.spq", "file:///b.spq", "file:///c.spq"} {
+		if !uris[uri] {
+			t.Errorf("Expected background diagnostics for %s", uri)
+		}
+	}
+
+	// After a quiet period, opens are synchronous again
+	now = now.Add(time.Second)
+	if response := h.openDocument(t, "file:///later.spq", "from test"); response == nil {
+		t.Error("Expected synchronous diagnostics after warm-up ended")
+	}
+}
+
+func TestWarmupPromotesRequestedDocument(t *testing.T) {
+	h := NewTestHelper()
+	background := &bytes.Buffer{}
+	h.server.out = background
+
+	if _, err := h.ProcessNotification("initialized", nil); err != nil {
+		t.Fatalf("initialized failed: %v", err)
+	}
+
+	// Occupy every worker so deferred documents stay queued
+	w := h.server.warmup
+	for i := 0; i < cap(w.sem); i++ {
+		w.sem <- struct{}{}
+	}
+
+	h.openDocument(t, "file:///visible.spq", "from test")
+	if response := h.openDocument(t, "file:///other.spq", "from test |"); response != nil {
+		t.Fatal("Expected diagnostics for the second document to be deferred")
+	}
+
+	// Hovering in the deferred document publishes its diagnostics first
+	_, err := h.ProcessRequest(2, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///other.spq"},
+		Position:     Position{Line: 0, Character: 0},
+	})
+	if err != nil {
+		t.Fatalf("Hover failed: %v", err)
+	}
+	if !diagnosticsURIs(t, drainMessages(t, background))["file:///other.spq"] {
+		t.Error("Expected promoted diagnostics for file:///other.spq")
+	}
+
+	// The queued worker finds nothing left to do
+	for i := 0; i < cap(w.sem); i++ {
+		<-w.sem
+	}
+	w.wait()
+	if msgs := drainMessages(t, background); len(msgs) != 0 {
+		t.Errorf("Expected no duplicate diagnostics, got %d messages", len(msgs))
+	}
+}
+
+func TestVersionGateDropsStaleDiagnostics(t *testing.T) {
+	s := NewServer()
+	out := &bytes.Buffer{}
+	s.out = out
+
+	uri := "file:///test.spq"
+	newer, err := s.publishDiagnostics(context.Background(), uri, "from test | count()", 2)
+	if err != nil {
+		t.Fatalf("publishDiagnostics failed: %v", err)
+	}
+	older, err := s.publishDiagnostics(context.Background(), uri, "from test |", 1)
+	if err != nil {
+		t.Fatalf("publishDiagnostics failed: %v", err)
+	}
+
+	// A slow worker finishing version 1 after version 2 was published
+	if err := s.send(newer); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if err := s.send(older); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 published message, got %d", len(msgs))
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msgs[0].Params, &params); err != nil {
+		t.Fatalf("Unmarshal params: %v", err)
+	}
+	if params.Version != 2 {
+		t.Errorf("Expected version 2 to win, got %d", params.Version)
+	}
+
+	// Closing resets the history so a reopened document starts over
+	s.gate.forget(uri)
+	if err := s.send(older); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if msgs := drainMessages(t, out); len(msgs) != 1 {
+		t.Errorf("Expected diagnostics after forget, got %d messages", len(msgs))
+	}
+}
+
+func TestVersionGateDropsSupersededDiagnostics(t *testing.T) {
+	s := NewServer()
+	out := &bytes.Buffer{}
+	s.out = out
+
+	uri := "file:///test.spq"
+	older, _ := s.publishDiagnostics(context.Background(), uri, "from test |", 1)
+
+	// Version 2 is stored, but its diagnostics aren't out yet
+	s.setDocument(uri, "from test | count()", 2)
+	if err := s.send(older); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if msgs := drainMessages(t, out); len(msgs) != 0 {
+		t.Errorf("Expected version 1 dropped once version 2 is stored, got %d messages", len(msgs))
+	}
+
+	// Version 3 has only been read, ahead of handling
+	s.requests.supersede(uri, 3)
+	current, _ := s.publishDiagnostics(context.Background(), uri, "from test | count()", 2)
+	if err := s.send(current); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if msgs := drainMessages(t, out); len(msgs) != 0 {
+		t.Errorf("Expected version 2 dropped once version 3 is read, got %d messages", len(msgs))
+	}
+}
+
+func TestRunWritesWholeFramesWithBackgroundWork(t *testing.T) {
+	in := &bytes.Buffer{}
+	write := func(msg RPCMessage, params interface{}) {
+		if params != nil {
+			b, err := json.Marshal(params)
+			if err != nil {
+				t.Fatalf("Marshal params: %v", err)
+			}
+			msg.Params = b
+		}
+		msg.JSONRPC = "2.0"
+		if err := writeMessage(in, msg); err != nil {
+			t.Fatalf("writeMessage failed: %v", err)
+		}
+	}
+
+	write(RPCMessage{ID: 1, Method: "initialize"}, InitializeParams{ProcessID: 1})
+	write(RPCMessage{Method: "initialized"}, nil)
+	const docs = 20
+	for i := 0; i < docs; i++ {
+		write(RPCMessage{Method: "textDocument/didOpen"}, DidOpenTextDocumentParams{
+			TextDocument: TextDocumentItem{
+				URI:     fmt.Sprintf("file:///doc%d.spq", i),
+				Version: 1,
+				Text:    "from test | summarize count() by host",
+			},
+		})
+	}
+	write(RPCMessage{ID: 2, Method: "textDocument/hover"}, HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///doc7.spq"},
+		Position:     Position{Line: 0, Character: 24},
+	})
+	write(RPCMessage{ID: 3, Method: "shutdown"}, nil)
+
+	out := &bytes.Buffer{}
+	if err := NewServer().Run(in, out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	msgs := drainMessages(t, out)
+	uris := diagnosticsURIs(t, msgs)
+	if len(uris) != docs {
+		t.Errorf("Expected diagnostics for %d documents, got %d", docs, len(uris))
+	}
+	responses := 0
+	for _, msg := range msgs {
+		if msg.ID != nil {
+			responses++
+		}
+	}
+	if responses != 3 {
+		t.Errorf("Expected 3 responses, got %d", responses)
+	}
+}
+
+func TestHandlerErrorIsAnswered(t *testing.T) {
+	h := NewTestHelper()
+
+	// Params that can't decode into HoverParams
+	response, err := h.ProcessRequest(7, "textDocument/hover", []int{1, 2})
+	if err != nil {
+		t.Fatalf("Hover failed: %v", err)
+	}
+	if response == nil {
+		t.Fatal("Expected an error response, got nil")
+	}
+	if response.ID != float64(7) {
+		t.Errorf("Expected ID 7, got %v", response.ID)
+	}
+	if response.Error == nil {
+		t.Fatal("Expected an error in the response")
+	}
+	if response.Error.Code == 0 || response.Error.Message == "" {
+		t.Errorf("Expected a code and message, got %+v", response.Error)
+	}
+}
+
+func TestEveryRequestIsAnswered(t *testing.T) {
+	s := NewServer()
+	raw := json.RawMessage(`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///missing.spq"},"position":{"line":0,"character":0}}}`)
+
+	response, err := s.handleMessage(raw)
+	if err != nil {
+		t.Fatalf("handleMessage failed: %v", err)
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("Marshal response: %v", err)
+	}
+	// A null result must still be present for the client to match it up
+	if !strings.Contains(string(encoded), `"result":null`) {
+		t.Errorf("Expected an explicit null result, got %s", encoded)
+	}
+}
+
+func TestNotificationErrorsAreNotAnswered(t *testing.T) {
+	s := NewServer()
+	raw := json.RawMessage(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":"bogus"}`)
+
+	response, err := s.handleMessage(raw)
+	if err != nil {
+		t.Fatalf("handleMessage failed: %v", err)
+	}
+	if response != nil {
+		t.Errorf("Expected no response to a notification, got %v", response)
+	}
+}
+
+func TestMalformedJSONGetsParseError(t *testing.T) {
+	s := NewServer()
+
+	response, err := s.handleMessage(json.RawMessage(`{"jsonrpc":`))
+	if err != nil {
+		t.Fatalf("handleMessage failed: %v", err)
+	}
+	msg, ok := response.(RPCMessage)
+	if !ok || msg.Error == nil {
+		t.Fatalf("Expected an error response, got %v", response)
+	}
+	if msg.Error.Code != ParseError {
+		t.Errorf("Expected ParseError, got %d", msg.Error.Code)
+	}
+}
+
+func TestInitializedSendsFeaturesOnce(t *testing.T) {
+	h := NewTestHelper()
+
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{ProcessID: 1}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+
+	response, err := h.ProcessNotification("initialized", nil)
+	if err != nil {
+		t.Fatalf("initialized failed: %v", err)
+	}
+	if response == nil || response.Method != "superdb/features" {
+		t.Fatalf("Expected superdb/features notification, got %v", response)
+	}
+	if response.ID != nil {
+		t.Errorf("Expected a notification without ID, got %v", response.ID)
+	}
+
+	var features FeaturesParams
+	if err := json.Unmarshal(response.Params, &features); err != nil {
+		t.Fatalf("Unmarshal features: %v", err)
+	}
+	if features.Lake || !features.Execution {
+		t.Errorf("Expected lake off and execution on, got %+v", features)
+	}
+	if features.Dialect != "supersql" || features.DialectVersion != SuperCommit {
+		t.Errorf("Unexpected dialect: %+v", features)
+	}
+	if features.FormatterStyle != FormatterStyleStandard {
+		t.Errorf("Expected formatter style %q, got %q", FormatterStyleStandard, features.FormatterStyle)
+	}
+
+	response, err = h.ProcessNotification("initialized", nil)
+	if err != nil {
+		t.Fatalf("initialized failed: %v", err)
+	}
+	if response != nil {
+		t.Errorf("Expected features to be sent only once, got %v", response)
+	}
+}
+
+func TestFeaturesFollowSettings(t *testing.T) {
+	h := NewTestHelper()
+	opts := json.RawMessage(`{"dialectVersion": "v0.1.0", "lake": "/data/lake", "formatterStyle": "compact"}`)
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	features := h.server.features()
+	if features.DialectVersion != "v0.1.0" {
+		t.Errorf("Expected dialect version v0.1.0, got %q", features.DialectVersion)
+	}
+	if !features.Lake || !features.Execution {
+		t.Errorf("Expected lake and execution on, got %+v", features)
+	}
+	if features.FormatterStyle != FormatterStyleCompact {
+		t.Errorf("Expected formatter style %q, got %q", FormatterStyleCompact, features.FormatterStyle)
+	}
+}
+
+func TestFormattingRejectsOversizedDocument(t *testing.T) {
+	h := NewTestHelper()
+	h.server.limits.MaxFormatSize = 16
+
+	h.openDocument(t, "file:///big.spq", "from test | where x > 5 | count()")
+
+	response, err := h.ProcessRequest(2, "textDocument/formatting", DocumentFormattingParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///big.spq"},
+		Options:      FormattingOptions{TabSize: 2, InsertSpaces: true},
+	})
+	if err != nil {
+		t.Fatalf("Formatting failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != RequestFailed {
+		t.Fatalf("Expected RequestFailed, got %+v", response)
+	}
+	if !strings.Contains(response.Error.Message, "too large") {
+		t.Errorf("Expected a clear message, got %q", response.Error.Message)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	h := NewTestHelper()
+	options := FormattingOptions{TabSize: 2, InsertSpaces: true}
+	tests := []struct {
+		name   string
+		params FormatTextParams
+		want   string
+	}{
+		{"query", FormatTextParams{Text: "from   test  |   count()", Options: options}, "from test\n| count()"},
+		{"data", FormatTextParams{Text: "{a:1,b:2}", Options: options, Data: true}, formatDataDocument("{a:1,b:2}", options)},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := h.ProcessRequest(i+1, "superdb/formatText", tt.params)
+			if err != nil {
+				t.Fatalf("superdb/formatText failed: %v", err)
+			}
+			resultBytes, _ := json.Marshal(response.Result)
+			var result FormatTextResult
+			json.Unmarshal(resultBytes, &result)
+			if result.Text != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, result.Text)
+			}
+		})
+	}
+
+	h.server.limits.MaxFormatSize = 16
+	response, _ := h.ProcessRequest(10, "superdb/formatText", FormatTextParams{Text: "from test | where x > 5 | count()", Options: options})
+	if response.Error == nil || response.Error.Code != RequestFailed {
+		t.Errorf("Expected oversized text to fail, got %+v", response)
+	}
+}
+
+func TestSignaturesParse(t *testing.T) {
+	for _, b := range append(Builtins.Functions(), Builtins.Aggregates()...) {
+		if b.Signature != "" && b.sig == nil {
+			t.Errorf("Signature for %s does not parse: %s", b.Name, b.Signature)
+		}
+	}
+}
+
+func TestSignatureRendering(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"len", "len(value: string | bytes | array) -> int64"},
+		{"log", "log(value: number, base?: number) -> float64"},
+		{"coalesce", "coalesce(value: any, ...) -> any"},
+	}
+	for _, tt := range tests {
+		b := Builtins.Lookup(tt.name)
+		if b == nil || b.sig == nil {
+			t.Fatalf("Expected signature for %s", tt.name)
+		}
+		if got := b.sig.Label(); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestSignatureRenderedConsistently(t *testing.T) {
+	b := Builtins.Lookup("round")
+	label := b.sig.Label()
+
+	if hover := getHover("round(x)", Position{Line: 0, Character: 2}, docMarkdown); hover == nil ||
+		!strings.Contains(hover.Contents.Value, label) {
+		t.Errorf("Expected hover to contain %q, got %+v", label, hover)
+	}
+	if b.item.Detail != label {
+		t.Errorf("Expected completion detail %q, got %q", label, b.item.Detail)
+	}
+
+	sigHelp := getSignatureHelp(context.Background(), "round(x, ", Position{Line: 0, Character: 9}, docMarkdown)
+	if sigHelp == nil {
+		t.Fatal("Expected signature help, got nil")
+	}
+	sig := sigHelp.Signatures[0]
+	if sig.Label != label {
+		t.Errorf("Expected signature label %q, got %q", label, sig.Label)
+	}
+	expected := []string{"value: number", "precision?: int64"}
+	for i, p := range sig.Parameters {
+		if got := sig.Label[p.Label[0]:p.Label[1]]; got != expected[i] {
+			t.Errorf("Parameter %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+}
+
+func TestSignatureHelpVariadic(t *testing.T) {
+	sigHelp := getSignatureHelp(context.Background(), "coalesce(a, b, c, ", Position{Line: 0, Character: 18}, docMarkdown)
+	if sigHelp == nil {
+		t.Fatal("Expected signature help, got nil")
+	}
+	if sigHelp.ActiveParameter != 0 {
+		t.Errorf("Expected variadic parameter 0 to stay active, got %d", sigHelp.ActiveParameter)
+	}
+}
+
+func TestSignatureHelpAggregateModifiers(t *testing.T) {
+	b := Builtins.Lookup("count")
+	expected := "count([distinct] value?: any) [filter (condition: bool)] -> int64"
+	if got := b.sig.Label(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"plain argument", "summarize sum(", "value: number"},
+		{"after distinct", "summarize count(distinct ", "value?: any"},
+		{"inside distinct argument", "summarize count(distinct len(x), ", "value?: any"},
+		{"filter clause", "summarize sum(x) filter (", "condition: bool"},
+		{"filter condition", "summarize count(distinct x) filter (y > ", "condition: bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := Position{Line: 0, Character: len(tt.text)}
+			sigHelp := getSignatureHelp(context.Background(), tt.text, pos, docMarkdown)
+			if sigHelp == nil {
+				t.Fatal("Expected signature help, got nil")
+			}
+			sig := sigHelp.Signatures[0]
+			active := sig.Parameters[sigHelp.ActiveParameter].Label
+			if got := sig.Label[active[0]:active[1]]; got != tt.expected {
+				t.Errorf("Expected active parameter %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSignatureHelpFilterNotAggregate(t *testing.T) {
+	// A filter operator with a parenthesized expression isn't an aggregate
+	// clause, and there's no "filter" function to show
+	text := "from test | filter ("
+	if sigHelp := getSignatureHelp(context.Background(), text, Position{Line: 0, Character: len(text)}, docMarkdown); sigHelp != nil {
+		t.Errorf("Expected no signature help, got: %v", sigHelp)
+	}
+	text = "from test | upper(s) | filter ("
+	if sigHelp := getSignatureHelp(context.Background(), text, Position{Line: 0, Character: len(text)}, docMarkdown); sigHelp != nil {
+		t.Errorf("Expected no signature help after a function call, got: %v", sigHelp)
+	}
+}
+
+func TestPlainTextDocumentation(t *testing.T) {
+	hover := getHover("round(x)", Position{Line: 0, Character: 2}, docPlainText)
+	if hover == nil || hover.Contents.Kind != MarkupKindPlainText {
+		t.Fatalf("Expected plain text hover, got %+v", hover)
+	}
+	for _, markup := range []string{"```", "**", "`", "- "} {
+		if strings.Contains(hover.Contents.Value, markup) {
+			t.Errorf("Expected no markdown %q in plain hover:\n%s", markup, hover.Contents.Value)
+		}
+	}
+	for _, want := range []string{"round (function)\n", Builtins.Lookup("round").sig.Label(), "\nParameters:\n  value (number): "} {
+		if !strings.Contains(hover.Contents.Value, want) {
+			t.Errorf("Expected plain hover to contain %q, got:\n%s", want, hover.Contents.Value)
+		}
+	}
+
+	sigHelp := getSignatureHelp(context.Background(), "sum(x", Position{Line: 0, Character: 5}, docPlainText)
+	if sigHelp == nil {
+		t.Fatal("Expected signature help, got nil")
+	}
+	doc := sigHelp.Signatures[0].Documentation.Value
+	if !strings.Contains(doc, "Parameters:") || !strings.Contains(doc, "  condition (bool, optional): ") {
+		t.Errorf("Expected a Parameters: section in plain signature docs, got:\n%s", doc)
+	}
+}
+
+func TestPlainTextOption(t *testing.T) {
+	h := NewTestHelper()
+	opts, _ := json.Marshal(InitializationOptions{PlainText: true})
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	uri := "file:///plain.spq"
+	text := "values round(x) + roun"
+	h.openDocument(t, uri, text)
+
+	response, err := h.ProcessRequest(2, "textDocument/hover", HoverParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 9},
+	})
+	if err != nil {
+		t.Fatalf("hover failed: %v", err)
+	}
+	contents := response.Result.(map[string]interface{})["contents"].(map[string]interface{})
+	if contents["kind"] != MarkupKindPlainText {
+		t.Errorf("Expected plain text hover, got %+v", contents)
+	}
+
+	response, err = h.ProcessRequest(3, "textDocument/completion", CompletionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: len(text)},
+	})
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var list CompletionList
+	json.Unmarshal(resultBytes, &list)
+	var round *CompletionItem
+	for i := range list.Items {
+		if list.Items[i].Label == "round" {
+			round = &list.Items[i]
+		}
+	}
+	if round == nil {
+		t.Fatal("Expected round in completions")
+	}
+	response, err = h.ProcessRequest(4, "completionItem/resolve", round)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	var resolved struct {
+		Documentation MarkupContent `json:"documentation"`
+	}
+	resultBytes, _ = json.Marshal(response.Result)
+	json.Unmarshal(resultBytes, &resolved)
+	if doc := resolved.Documentation; doc.Kind != MarkupKindPlainText || !strings.Contains(doc.Value, "Parameters:") {
+		t.Errorf("Expected plain completion docs with parameters, got %+v", doc)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Settings. Everything a client can configure, apart from completion
+// telemetry, is kept in one Settings value. It is read from
+// initializationOptions and replaced whole by
+// workspace/didChangeConfiguration, which takes the same options, either
+// bare or under a "superdb" section. Handlers read a snapshot with
+// s.settings(), so a change takes effect on the next request, and open
+// documents get fresh diagnostics right away.
+
+// Settings for completionDocs
+const (
+	completionDocsFull  = "full"  // details and documentation, the default
+	completionDocsBrief = "brief" // details only
+)
+
+// Settings is the server's configuration
+type Settings struct {
+	VerifyRefactors bool                 // check refactors against sample data before offering them
+	Lake            string               // lake queries run against, if configured
+	AllowLakeWrites bool                 // runQuery may run queries that change the lake
+	DocStyle        docStyle             // how hover, signature, and completion docs render
+	OperatorAliases string               // whether older operator spellings get a hint
+	ReadOnlyPaths   []string             // directories whose files get no edits
+	Parameters      map[string]Parameter // query parameters read with env()
+	SourceKinds     map[string]string    // what queries read, by path pattern
+	Format          FormatSettings       // formatting options that override the editor's
+	Severities      map[string]int       // severity by diagnostic code; 0 drops it
+	CompletionDocs  string               // how much of each completion item to send
+	InternalErrors  string               // how loudly the server's own failures are reported
+	Save            SaveSettings         // what happens when a document is saved
+	DataFiles       []string             // paths of data files besides .sup ones
+	DialectVersion  string               // language version the queries target
+	FormatterStyle  string               // how the formatter lays out pipelines
+	SampleFiles     map[string]string    // data whose fields complete, by path pattern
+	SQLAliases      bool                 // SQL spellings of types complete
+
+	KeepClosedDiagnostics bool // a closed document's diagnostics stay published
+}
+
+// defaultSettings returns the settings of a client that sets none
+func defaultSettings() Settings {
+	return Settings{
+		OperatorAliases: aliasesCanonical,
+		CompletionDocs:  completionDocsFull,
+		InternalErrors:  internalErrorsShow,
+		DialectVersion:  SuperCommit,
+		FormatterStyle:  FormatterStyleStandard,
+		SQLAliases:      true,
+	}
+}
+
+// settingsFrom returns the settings opts ask for, with the default for
+// each that they leave out
+func settingsFrom(opts InitializationOptions) Settings {
+	settings := defaultSettings()
+	settings.VerifyRefactors = opts.VerifyRefactors
+	settings.Lake = opts.Lake
+	settings.AllowLakeWrites = opts.AllowLakeWrites
+	if opts.PlainText {
+		settings.DocStyle = docPlainText
+	}
+	if opts.OperatorAliases != "" {
+		settings.OperatorAliases = opts.OperatorAliases
+	}
+	settings.ReadOnlyPaths = opts.ReadOnlyPaths
+	settings.Parameters = opts.Parameters
+	settings.SourceKinds = opts.SourceKinds
+	settings.SampleFiles = opts.SampleFiles
+	settings.Format = opts.Format
+	if len(opts.Severities) > 0 {
+		settings.Severities = make(map[string]int, len(opts.Severities))
+		for code, name := range opts.Severities {
+			severity := severityNamed(name)
+			if severity == 0 && name != "off" {
+				log.Printf("Ignoring severity %q for %s", name, code)
+				continue
+			}
+			settings.Severities[code] = severity
+		}
+	}
+	switch opts.CompletionDocs {
+	case "":
+	case completionDocsFull, completionDocsBrief:
+		settings.CompletionDocs = opts.CompletionDocs
+	default:
+		log.Printf("Ignoring completionDocs %q", opts.CompletionDocs)
+	}
+	settings.Save = opts.Save
+	settings.DataFiles = opts.DataFiles
+	settings.KeepClosedDiagnostics = opts.KeepClosedDiagnostics
+	if opts.DialectVersion != "" {
+		settings.DialectVersion = opts.DialectVersion
+	}
+	if opts.Completions.SQLAliases != nil {
+		settings.SQLAliases = *opts.Completions.SQLAliases
+	}
+	switch opts.FormatterStyle {
+	case "":
+	case FormatterStyleStandard, FormatterStyleCompact:
+		settings.FormatterStyle = opts.FormatterStyle
+	default:
+		log.Printf("Ignoring formatterStyle %q", opts.FormatterStyle)
+	}
+	switch opts.InternalErrors {
+	case "":
+	case internalErrorsShow, internalErrorsLog, internalErrorsOff:
+		settings.InternalErrors = opts.InternalErrors
+	default:
+		log.Printf("Ignoring internalErrors %q", opts.InternalErrors)
+	}
+	return settings
+}
+
+// settings returns the current settings
+func (s *Server) settings() Settings {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	settings := s.config
+	if s.client.plainText {
+		settings.DocStyle = docPlainText
+	}
+	return settings
+}
+
+// setSettings replaces the current settings
+func (s *Server) setSettings(settings Settings) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.config = settings
+}
+
+// apply returns options with the fields f sets replaced
+func (f FormatSettings) apply(options FormattingOptions) FormattingOptions {
+	if f.TabSize != nil {
+		options.TabSize = *f.TabSize
+	}
+	if f.InsertSpaces != nil {
+		options.InsertSpaces = *f.InsertSpaces
+	}
+	if f.TrimTrailingWhitespace != nil {
+		options.TrimTrailingWhitespace = *f.TrimTrailingWhitespace
+	}
+	if f.InsertFinalNewline != nil {
+		options.InsertFinalNewline = *f.InsertFinalNewline
+	}
+	if f.TrimFinalNewlines != nil {
+		options.TrimFinalNewlines = *f.TrimFinalNewlines
+	}
+	return options
+}
+
+// applySeverities sets the severity of each diagnostic whose code has one
+// configured, dropping those configured off
+func applySeverities(diagnostics []Diagnostic, severities map[string]int) []Diagnostic {
+	if len(severities) == 0 {
+		return diagnostics
+	}
+	kept := diagnostics[:0]
+	for _, d := range diagnostics {
+		if severity, ok := severities[d.Code]; ok {
+			if severity == 0 {
+				continue
+			}
+			d.Severity = severity
+		}
+		kept = append(kept, d)
+	}
+	return kept
+}
+
+// briefCompletions drops the documentation of items, leaving their
+// details
+func briefCompletions(items []CompletionItem) {
+	for i := range items {
+		items[i].Documentation = ""
+	}
+}
+
+// withoutBuiltins drops from items the builtins that drop reports true
+// for, and the snippets that start with one
+func withoutBuiltins(items []CompletionItem, drop func(*Builtin) bool) []CompletionItem {
+	kept := items[:0]
+	for _, item := range items {
+		if b := completedBuiltin(item); b == nil || !drop(b) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// completedBuiltin returns the builtin item completes, or the one a
+// snippet item starts with, or nil when it's neither
+func completedBuiltin(item CompletionItem) *Builtin {
+	if item.Kind == CompletionItemKindSnippet {
+		return Builtins.Lookup(leadingWord(item.Label))
+	}
+	if b := Builtins.Lookup(item.Label); b != nil && b.item.Kind == item.Kind {
+		return b
+	}
+	return nil
+}
+
+// handleDidChangeConfiguration processes workspace/didChangeConfiguration
+// notifications, replacing the settings and republishing diagnostics for
+// the open documents under them
+func (s *Server) handleDidChangeConfiguration(msg RPCMessage) HandlerResult {
+	var params DidChangeConfigurationParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	raw := params.Settings
+	var section struct {
+		SuperDB json.RawMessage `json:"superdb"`
+	}
+	if json.Unmarshal(raw, &section) == nil && len(section.SuperDB) > 0 {
+		raw = section.SuperDB
+	}
+	var opts InitializationOptions
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			log.Printf("Ignoring configuration change: %v", err)
+			return HandlerResult{}
+		}
+	}
+	s.setSettings(settingsFrom(opts))
+	log.Printf("Configuration changed")
+	s.republishDiagnostics()
+	return HandlerResult{}
+}
+
+// republishDiagnostics publishes fresh diagnostics for every open
+// document
+func (s *Server) republishDiagnostics() {
+	s.docMu.RLock()
+	uris := make([]string, 0, len(s.documents))
+	for uri := range s.documents {
+		uris = append(uris, uri)
+	}
+	s.docMu.RUnlock()
+	for _, uri := range uris {
+		text, version, ok := s.document(uri)
+		if !ok {
+			continue
+		}
+		ctx := s.requests.documentContext(uri, version)
+		msg, err := s.publishDiagnostics(ctx, uri, text, version)
+		if err == nil && msg != nil {
+			err = s.send(msg)
+		}
+		if err != nil {
+			log.Printf("Error sending diagnostics for %s: %v", uri, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSettingsFrom(t *testing.T) {
+	var opts InitializationOptions
+	json.Unmarshal([]byte(`{
+		"lake": "/data/lake",
+		"plainText": true,
+		"severities": {"operator-alias": "warning", "missing-source": "off", "lake-write": "loud"},
+		"completionDocs": "brief",
+		"format": {"tabSize": 4},
+		"dialectVersion": "v0.1.0",
+		"completions": {"sqlAliases": false}
+	}`), &opts)
+	settings := settingsFrom(opts)
+	if settings.Lake != "/data/lake" || settings.DocStyle != docPlainText || settings.CompletionDocs != completionDocsBrief {
+		t.Errorf("Unexpected settings: %+v", settings)
+	}
+	want := map[string]int{"operator-alias": DiagnosticSeverityWarning, "missing-source": 0}
+	if len(settings.Severities) != len(want) {
+		t.Fatalf("Expected severities %v, got %v", want, settings.Severities)
+	}
+	for code, severity := range want {
+		if got, ok := settings.Severities[code]; !ok || got != severity {
+			t.Errorf("%s: expected severity %d, got %d", code, severity, got)
+		}
+	}
+	options := settings.Format.apply(FormattingOptions{TabSize: 2, InsertSpaces: true})
+	if options.TabSize != 4 || !options.InsertSpaces {
+		t.Errorf("Expected only the tab size overridden, got %+v", options)
+	}
+
+	if settings.DialectVersion != "v0.1.0" {
+		t.Errorf("Expected dialect version v0.1.0, got %q", settings.DialectVersion)
+	}
+	if settings.SQLAliases {
+		t.Error("Expected SQL type aliases turned off")
+	}
+
+	if defaults := settingsFrom(InitializationOptions{}); defaults.OperatorAliases != aliasesCanonical || defaults.CompletionDocs != completionDocsFull || defaults.DialectVersion != SuperCommit || !defaults.SQLAliases {
+		t.Errorf("Unexpected defaults: %+v", defaults)
+	}
+}
+
+func TestApplySeverities(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Code: "operator-alias", Severity: DiagnosticSeverityHint},
+		{Code: "missing-source", Severity: DiagnosticSeverityWarning},
+		{Code: "lake-write", Severity: DiagnosticSeverityWarning},
+	}
+	got := applySeverities(diagnostics, map[string]int{"operator-alias": DiagnosticSeverityError, "missing-source": 0})
+	if len(got) != 2 || got[0].Severity != DiagnosticSeverityError || got[1].Code != "lake-write" {
+		t.Errorf("Unexpected diagnostics: %+v", got)
+	}
+}
+
+func TestDidChangeConfiguration(t *testing.T) {
+	h := NewTestHelper()
+	out := &bytes.Buffer{}
+	h.server.out = out
+	uri := "file:///q.spq"
+	text := "values 1 | yield this"
+	h.openDocument(t, uri, text)
+	out.Reset()
+
+	// A section, as VS Code sends settings
+	settings := json.RawMessage(`{"superdb": {"severities": {"operator-alias": "warning"}}}`)
+	if _, err := h.ProcessNotification("workspace/didChangeConfiguration", DidChangeConfigurationParams{Settings: settings}); err != nil {
+		t.Fatal(err)
+	}
+	msgs := drainMessages(t, out)
+	if len(msgs) != 1 || msgs[0].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected the open document's diagnostics republished, got %+v", msgs)
+	}
+	var params PublishDiagnosticsParams
+	json.Unmarshal(msgs[0].Params, &params)
+	if len(params.Diagnostics) != 1 || params.Diagnostics[0].Severity != DiagnosticSeverityWarning {
+		t.Errorf("Expected the alias hint raised to a warning, got %+v", params.Diagnostics)
+	}
+
+	// Bare settings replace the section's
+	settings = json.RawMessage(`{"operatorAliases": "off", "completionDocs": "brief"}`)
+	h.ProcessNotification("workspace/didChangeConfiguration", DidChangeConfigurationParams{Settings: settings})
+	if msgs = drainMessages(t, out); len(msgs) != 1 {
+		t.Fatalf("Expected diagnostics republished, got %+v", msgs)
+	}
+	json.Unmarshal(msgs[0].Params, &params)
+	if len(params.Diagnostics) != 0 {
+		t.Errorf("Expected the alias hint turned off, got %+v", params.Diagnostics)
+	}
+
+	response, _ := h.ProcessRequest(1, "textDocument/completion", CompletionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 2},
+	})
+	var list CompletionList
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &list)
+	if len(list.Items) == 0 {
+		t.Fatal("Expected completions")
+	}
+	for _, item := range list.Items {
+		if item.Documentation != "" {
+			t.Fatalf("Expected brief completions, got documentation on %s", item.Label)
+		}
+	}
+}
+
+func TestSQLAliasesSetting(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///q.spq"
+	text := "values cast(x, <b"
+	h.openDocument(t, uri, text)
+	labels := func() []string {
+		response, _ := h.ProcessRequest(1, "textDocument/completion", CompletionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: 0, Character: len(text)},
+		})
+		var list CompletionList
+		data, _ := json.Marshal(response.Result)
+		json.Unmarshal(data, &list)
+		return completionLabels(list.Items)
+	}
+
+	if got := labels(); !slices.Contains(got, "bigint") || !slices.Contains(got, "bytes") {
+		t.Fatalf("Expected bigint and bytes, got %v", got)
+	}
+	settings := json.RawMessage(`{"completions": {"sqlAliases": false}}`)
+	h.ProcessNotification("workspace/didChangeConfiguration", DidChangeConfigurationParams{Settings: settings})
+	if got := labels(); slices.Contains(got, "bigint") || slices.Contains(got, "boolean") || !slices.Contains(got, "bytes") {
+		t.Errorf("Expected bytes without the SQL aliases, got %v", got)
+	}
+}
+
+func TestFormattingSettingsOverrideRequest(t *testing.T) {
+	h := NewTestHelper()
+	opts := json.RawMessage(`{"format": {"tabSize": 4}}`)
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{InitializationOptions: opts}); err != nil {
+		t.Fatal(err)
+	}
+	uri := "file:///f.spq"
+	h.openDocument(t, uri, "op f(): (\nvalues 1\n)\nf()")
+	response, _ := h.ProcessRequest(2, "textDocument/formatting", DocumentFormattingParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Options:      FormattingOptions{TabSize: 2, InsertSpaces: true},
+	})
+	var edits []TextEdit
+	data, _ := json.Marshal(response.Result)
+	json.Unmarshal(data, &edits)
+	if len(edits) != 1 || !strings.Contains(edits[0].NewText, "\n    values 1") {
+		t.Errorf("Expected four-space indentation, got %+v", edits)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/brimdata/super"
+	"github.com/brimdata/super/sup"
+)
+
+// Shape exploration. Data read from files and pools often mixes records of
+// several types. superdb.exploreShapes groups a query's source values by
+// type, as the shapes operator does, and counts each group, so the types
+// worth handling show up before a query is written against them. A type
+// picked from that table can then be filtered for with a code action.
+
+// defaultShapeLimit is how many shapes exploreShapes lists when the client
+// doesn't say; all of them are counted
+const defaultShapeLimit = 50
+
+// shapeCounts runs source and counts its values by type, most frequent
+// first, along with the type they all fuse to
+func shapeCounts(ctx context.Context, lake, source string) (ExploreShapesResult, error) {
+	result := ExploreShapesResult{Source: source, Shapes: []ShapeCount{}}
+	_, err := pullQuery(ctx, lake, source+"\n| aggregate count:=count(), sample:=any(this) by shape:=typeof(this)", func(val super.Value) error {
+		shape := val.Ptr().Deref("shape")
+		count := val.Ptr().Deref("count")
+		sample := val.Ptr().Deref("sample")
+		if shape == nil || count == nil || sample == nil {
+			return nil
+		}
+		var n int64
+		switch id := count.Type().ID(); {
+		case super.IsSigned(id):
+			n = count.Int()
+		case super.IsUnsigned(id):
+			n = int64(count.Uint())
+		default:
+			return nil
+		}
+		result.Shapes = append(result.Shapes, ShapeCount{
+			Shape:  sup.FormatValue(*shape),
+			Count:  n,
+			Sample: sup.FormatValue(*sample),
+		})
+		result.Total += n
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(result.Shapes, func(i, j int) bool {
+		a, b := result.Shapes[i], result.Shapes[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Shape < b.Shape
+	})
+	if len(result.Shapes) > 1 {
+		_, err = pullQuery(ctx, lake, source+"\n| fuse | head 1 | values typeof(this)", func(val super.Value) error {
+			result.Fused = sup.FormatValue(val)
+			return nil
+		})
+	}
+	return result, err
+}
+
+// exploreShapesCommand runs the source of a document's query, or the
+// source the client names, and returns how many of its values have each
+// type
+func (s *Server) exploreShapesCommand(ctx context.Context, args []json.RawMessage) HandlerResult {
+	var params ExploreShapesArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultShapeLimit
+	}
+
+	source := strings.TrimSpace(params.Source)
+	if source == "" {
+		s.promote(params.URI)
+		text, _, ok := s.document(params.URI)
+		if !ok {
+			return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.URI)})
+		}
+		if s.isDataFile(params.URI) {
+			return failure(&RPCError{Code: RequestFailed, Message: "data files have no source to explore"})
+		}
+		source = splitStages(tokenize(text))[0].text()
+	}
+	if source == "" {
+		return failure(&RPCError{Code: RequestFailed, Message: "the query has no source to explore"})
+	}
+	if len(findLakeWrites(source)) > 0 {
+		return failure(&RPCError{Code: RequestFailed, Message: "queries that write to the lake can't be explored"})
+	}
+
+	log.Printf("Exploring shapes: %s", source)
+	result, err := shapeCounts(ctx, s.settings().Lake, source)
+	if err != nil {
+		return success(ExploreShapesResult{Source: source, Shapes: []ShapeCount{}, Error: err.Error()})
+	}
+	result.Distinct = len(result.Shapes)
+	if len(result.Shapes) > params.Limit {
+		result.Shapes = result.Shapes[:params.Limit]
+	}
+	return success(result)
+}
+
+// shapeFilter returns the refactor that keeps only values of the type
+// literal selected by rng, filtering right after the query's source. It
+// returns false when the selection isn't a type literal or the filter is
+// already there.
+func shapeFilter(text string, rng Range) (refactor, bool) {
+	shape := strings.TrimSpace(rangeText(text, rng))
+	if !isTypeLiteral(shape) {
+		return refactor{}, false
+	}
+	filter := "where typeof(this)==" + shape
+	if strings.Contains(text, filter) {
+		return refactor{}, false
+	}
+
+	// The filter goes after the source's last token, ahead of any comment
+	// that trails it
+	stages := splitStages(tokenize(text))
+	at, offset := 0, 0
+	for _, tok := range stages[0].tokens {
+		offset += len(tok.value)
+		switch tok.typ {
+		case tokWhitespace, tokNewline, tokComment:
+		default:
+			at = offset
+		}
+	}
+	if at == 0 {
+		return refactor{}, false
+	}
+	insert := " | " + filter
+	if len(stages) > 1 {
+		next := stages[1]
+		insert = " " + next.pipe + " " + filter
+		if strings.Contains(text[at:next.start], "\n") {
+			insert = "\n" + next.pipe + " " + filter
+		}
+	}
+	pos := positionAt(text, at)
+	return refactor{
+		title: fmt.Sprintf("Keep only values of shape %s", shape),
+		edit:  TextEdit{Range: Range{Start: pos, End: pos}, NewText: insert},
+		text:  text[:at] + insert + text[at:],
+	}, true
+}
+
+// isTypeLiteral reports whether text is a single type value, like
+// <{a:int64}>
+func isTypeLiteral(text string) bool {
+	if !strings.HasPrefix(text, "<") {
+		return false
+	}
+	tree, ok := parseTree("values " + text)
+	if !ok {
+		return false
+	}
+	stages := topLevelStages(tree)
+	if len(stages) != 1 {
+		return false
+	}
+	op, _ := stages[0].(map[string]interface{})
+	exprs, _ := op["exprs"].([]interface{})
+	return nodeKind(op) == "ValuesOp" && len(exprs) == 1 && nodeKind(exprs[0]) == "TypeValue"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShapeCounts(t *testing.T) {
+	result, err := shapeCounts(context.Background(), "", "values {a:1}, {a:2,b:\"x\"}, {a:3}, 4")
+	if err != nil {
+		t.Fatalf("shapeCounts failed: %v", err)
+	}
+	if result.Total != 4 || len(result.Shapes) != 3 {
+		t.Fatalf("Expected 4 values in 3 shapes, got %+v", result)
+	}
+	if got := result.Shapes[0]; got.Shape != "<{a:int64}>" || got.Count != 2 {
+		t.Errorf("Expected the most frequent shape first, got %+v", got)
+	}
+	if result.Fused == "" {
+		t.Errorf("Expected a fused type for mixed shapes")
+	}
+
+	result, err = shapeCounts(context.Background(), "", "values 1, 2")
+	if err != nil || len(result.Shapes) != 1 || result.Fused != "" {
+		t.Errorf("Expected one shape and no fused type, got %+v (%v)", result, err)
+	}
+}
+
+func exploreShapes(t *testing.T, h *TestHelper, args ExploreShapesArgs) ExploreShapesResult {
+	t.Helper()
+	raw, _ := json.Marshal(args)
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandExploreShapes,
+		Arguments: []json.RawMessage{raw},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result ExploreShapesResult
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	return result
+}
+
+func TestExploreShapesCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mixed.sup")
+	if err := os.WriteFile(path, []byte("{a:1}\n{b:2}\n{a:3}\n{c:4}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTestHelper()
+	uri := "file:///shapes.spq"
+	h.openDocument(t, uri, "from '"+path+"'\n| where a > 1")
+
+	result := exploreShapes(t, h, ExploreShapesArgs{URI: uri, Limit: 2})
+	if result.Source != "from '"+path+"'" {
+		t.Errorf("Expected the first stage as the source, got %q", result.Source)
+	}
+	if result.Total != 4 || result.Distinct != 3 || len(result.Shapes) != 2 {
+		t.Errorf("Expected 3 shapes of 4 values with 2 listed, got %+v", result)
+	}
+
+	result = exploreShapes(t, h, ExploreShapesArgs{Source: "values 1, \"a\""})
+	if result.Distinct != 2 {
+		t.Errorf("Expected the given source to be explored, got %+v", result)
+	}
+
+	result = exploreShapes(t, h, ExploreShapesArgs{Source: "from '/no/such/file.sup'"})
+	if result.Error == "" {
+		t.Errorf("Expected a run error in the result, got %+v", result)
+	}
+}
+
+func TestShapeFilter(t *testing.T) {
+	selection := func(text, shape string) Range {
+		start := strings.Index(text, shape)
+		return Range{Start: positionAt(text, start), End: positionAt(text, start+len(shape))}
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{
+			"-- <{a:int64}>\nfrom 'x.sup'\n| sort a",
+			"-- <{a:int64}>\nfrom 'x.sup'\n| where typeof(this)==<{a:int64}>\n| sort a",
+		},
+		{
+			"from 'x.sup' | sort a -- <{a:int64}>",
+			"from 'x.sup' | where typeof(this)==<{a:int64}> | sort a -- <{a:int64}>",
+		},
+		{
+			"from 'x.sup' -- <{a:int64}>",
+			"from 'x.sup' | where typeof(this)==<{a:int64}> -- <{a:int64}>",
+		},
+	}
+	for _, tt := range tests {
+		r, ok := shapeFilter(tt.text, selection(tt.text, "<{a:int64}>"))
+		if !ok {
+			t.Errorf("Expected a filter for %q", tt.text)
+			continue
+		}
+		if r.text != tt.want {
+			t.Errorf("Filter:\nexpected %q\ngot      %q", tt.want, r.text)
+		}
+	}
+
+	text := "from 'x.sup' | sort a"
+	if _, ok := shapeFilter(text, selection(text, "sort a")); ok {
+		t.Errorf("Expected no filter for a selection that isn't a type")
+	}
+	text = "from 'x.sup' | where typeof(this)==<{a:int64}>"
+	if _, ok := shapeFilter(text, selection(text, "<{a:int64}>")); ok {
+		t.Errorf("Expected no filter when it is already there")
+	}
+}
@@ -1,64 +1,61 @@
 package main
 
 import (
+	"context"
 	"strings"
 )
 
 // getSignatureHelp returns signature help for the current position
-func getSignatureHelp(text string, pos Position) *SignatureHelp {
+func getSignatureHelp(ctx context.Context, text string, pos Position, style docStyle) *SignatureHelp {
 	// Find the function call context
-	funcName, paramIndex := findFunctionContext(text, pos)
-	if funcName == "" {
+	funcName, paramIndex, inFilter := findFunctionContext(text, pos)
+	if ctx.Err() != nil {
 		return nil
 	}
 
-	b := Builtins.Lookup(funcName)
-	if b == nil || (b.Kind != KindFunction && b.Kind != KindAggregate) {
-		return nil
-	}
-
-	if b.Signature == "" {
-		return nil
+	if funcName != "" {
+		b := Builtins.Lookup(funcName)
+		if b != nil && (b.Kind == KindFunction || b.Kind == KindAggregate) {
+			return buildSignatureHelp(b, paramIndex, inFilter, style)
+		}
 	}
 
-	return buildSignatureHelp(b, paramIndex)
+	// A call of the query's own op has no parentheses to find
+	return opSignatureHelp(text, pos)
 }
 
-// buildSignatureHelp creates a SignatureHelp from a Builtin
-func buildSignatureHelp(b *Builtin, activeParam int) *SignatureHelp {
-	params := make([]ParameterInformation, len(b.Parameters))
-
-	// Calculate parameter label offsets
-	labelOffset := strings.Index(b.Signature, "(") + 1
-	currentOffset := labelOffset
-
-	for i, p := range b.Parameters {
-		// Find this parameter in the label
-		paramStart := strings.Index(b.Signature[currentOffset:], p.Name)
-		if paramStart == -1 {
-			continue
-		}
-		paramStart += currentOffset
+// buildSignatureHelp creates a SignatureHelp from a Builtin. inFilter marks
+// the cursor as inside an aggregate's trailing filter clause.
+func buildSignatureHelp(b *Builtin, activeParam int, inFilter bool, style docStyle) *SignatureHelp {
+	if b.sig == nil {
+		return nil
+	}
 
-		// Find the end of this parameter (comma or closing paren)
-		paramEnd := paramStart + len(p.Name)
-		for paramEnd < len(b.Signature) && b.Signature[paramEnd] != ',' && b.Signature[paramEnd] != ')' {
-			paramEnd++
+	// Parameter labels are offsets into the rendered signature, so they line
+	// up with what hover and completion show
+	label, offsets := b.sig.render()
+	params := make([]ParameterInformation, len(offsets))
+	for i := range offsets {
+		doc := filterParamDoc
+		if i < len(b.sig.Params) {
+			doc = b.sig.Params[i].Doc
 		}
-
 		params[i] = ParameterInformation{
-			Label: [2]int{paramStart, paramEnd},
+			Label: offsets[i],
 			Documentation: &MarkupContent{
 				Kind:  MarkupKindPlainText,
-				Value: p.Doc,
+				Value: doc,
 			},
 		}
-
-		currentOffset = paramEnd + 1
 	}
 
-	if activeParam >= len(params) {
-		activeParam = len(params) - 1
+	// Arguments past the last parameter belong to it when it is variadic,
+	// and stay on it otherwise so the client still highlights something.
+	// The filter slot is only active inside the filter clause itself.
+	if inFilter && b.sig.Filter {
+		activeParam = len(b.sig.Params)
+	} else if activeParam >= len(b.sig.Params) {
+		activeParam = len(b.sig.Params) - 1
 	}
 	if activeParam < 0 {
 		activeParam = 0
@@ -68,11 +65,14 @@ func buildSignatureHelp(b *Builtin, activeParam int) *SignatureHelp {
 	if doc == "" {
 		doc = b.Brief
 	}
+	if style == docPlainText {
+		doc = b.plainDoc
+	}
 
 	return &SignatureHelp{
 		Signatures: []SignatureInformation{
 			{
-				Label: b.Signature,
+				Label: label,
 				Documentation: &MarkupContent{
 					Kind:  MarkupKindPlainText,
 					Value: doc,
@@ -85,30 +85,39 @@ func buildSignatureHelp(b *Builtin, activeParam int) *SignatureHelp {
 	}
 }
 
-// findFunctionContext finds the function name and parameter index at position
-func findFunctionContext(text string, pos Position) (string, int) {
-	lines := strings.Split(text, "\n")
-	if pos.Line >= len(lines) {
-		return "", 0
+// findFunctionContext finds the function name and parameter index at
+// position. Inside an aggregate's trailing filter (...) clause it returns the
+// aggregate with inFilter set, rather than a call to "filter".
+func findFunctionContext(text string, pos Position) (name string, paramIndex int, inFilter bool) {
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return "", 0, false
 	}
 
 	// Get text up to cursor position
-	var textToCursor strings.Builder
-	for i := 0; i <= pos.Line && i < len(lines); i++ {
-		if i == pos.Line {
-			if pos.Character <= len(lines[i]) {
-				textToCursor.WriteString(lines[i][:pos.Character])
-			} else {
-				textToCursor.WriteString(lines[i])
-			}
-		} else {
-			textToCursor.WriteString(lines[i])
-			textToCursor.WriteByte('\n')
-		}
+	content := text[:offset]
+	name, nameStart, paramIndex := findEnclosingCall(content)
+	if !strings.EqualFold(name, "filter") {
+		return name, paramIndex, false
 	}
 
-	content := textToCursor.String()
+	// The clause follows the aggregate's closing paren, so the aggregate is
+	// the call left open just before it
+	before := strings.TrimRight(content[:nameStart], " \t\r\n")
+	if !strings.HasSuffix(before, ")") {
+		return name, paramIndex, false
+	}
+	agg, _, _ := findEnclosingCall(before[:len(before)-1])
+	if b := Builtins.Lookup(agg); b != nil && b.sig != nil && b.sig.Filter {
+		return agg, 0, true
+	}
+	return name, paramIndex, false
+}
 
+// findEnclosingCall finds the innermost call left open at the end of
+// content, returning its name, where the name starts, and the index of the
+// argument being written
+func findEnclosingCall(content string) (string, int, int) {
 	// Walk backward to find matching open paren
 	parenDepth := 0
 	funcEnd := -1
@@ -131,21 +140,26 @@ func findFunctionContext(text string, pos Position) (string, int) {
 	}
 
 	if funcEnd < 0 {
-		return "", 0
+		return "", 0, 0
 	}
 
-	// Extract function name
-	funcStart := funcEnd - 1
+	// Extract function name, which may be separated from the paren by
+	// whitespace as in "filter (cond)"
+	nameEnd := funcEnd
+	for nameEnd > 0 && isWhitespace(content[nameEnd-1]) {
+		nameEnd--
+	}
+	funcStart := nameEnd - 1
 	for funcStart >= 0 && isIdentifierChar(content[funcStart]) {
 		funcStart--
 	}
 	funcStart++
 
-	if funcStart >= funcEnd {
-		return "", 0
+	if funcStart >= nameEnd {
+		return "", 0, 0
 	}
 
-	funcName := content[funcStart:funcEnd]
+	funcName := content[funcStart:nameEnd]
 
 	// Count commas to determine parameter index
 	paramIndex := 0
@@ -164,5 +178,5 @@ func findFunctionContext(text string, pos Position) (string, int) {
 		}
 	}
 
-	return funcName, paramIndex
+	return funcName, funcStart, paramIndex
 }
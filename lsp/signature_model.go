@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FuncSignature is the structured form of a function or aggregate
+// signature. Hover, completion detail, and signature help all render from
+// it so they show signatures the same way.
+type FuncSignature struct {
+	Name    string
+	Params  []SigParam
+	Returns TypeUnion
+
+	// Aggregate call modifiers, as in count(distinct x) filter (y > 0)
+	Distinct bool // accepts a leading distinct or all qualifier
+	Filter   bool // accepts a trailing filter (condition) clause
+}
+
+// filterParamDoc documents the condition of an aggregate's filter clause
+const filterParamDoc = "Only values from records where this is true are aggregated"
+
+// SigParam is one parameter of a FuncSignature
+type SigParam struct {
+	Name     string
+	Types    TypeUnion
+	Optional bool // may be omitted, written "name?: type"
+	Variadic bool // followed by any number of further arguments, written ", ..."
+	Doc      string
+}
+
+// TypeUnion lists the alternative types a value may have
+type TypeUnion []string
+
+func (u TypeUnion) String() string {
+	return strings.Join(u, " | ")
+}
+
+// parseSignature parses the registry's compact signature notation:
+//
+//	name(param: type|type, opt?: type, ...) -> type
+func parseSignature(s string) (*FuncSignature, error) {
+	lparen := strings.IndexByte(s, '(')
+	rparen := strings.LastIndexByte(s, ')')
+	if lparen <= 0 || rparen < lparen {
+		return nil, fmt.Errorf("signature %q: missing parameter list", s)
+	}
+
+	sig := &FuncSignature{Name: strings.TrimSpace(s[:lparen])}
+	if rest := strings.TrimSpace(s[rparen+1:]); rest != "" {
+		ret, ok := strings.CutPrefix(rest, "->")
+		if !ok {
+			return nil, fmt.Errorf("signature %q: expected -> before return type", s)
+		}
+		sig.Returns = parseTypeUnion(ret)
+	}
+
+	for _, field := range splitParams(s[lparen+1 : rparen]) {
+		if field == "..." {
+			if len(sig.Params) == 0 {
+				return nil, fmt.Errorf("signature %q: ... without a parameter", s)
+			}
+			sig.Params[len(sig.Params)-1].Variadic = true
+			continue
+		}
+		name, typ, _ := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		p := SigParam{Types: parseTypeUnion(typ)}
+		p.Name, p.Optional = strings.CutSuffix(name, "?")
+		if p.Name == "" {
+			return nil, fmt.Errorf("signature %q: unnamed parameter", s)
+		}
+		sig.Params = append(sig.Params, p)
+	}
+	return sig, nil
+}
+
+// splitParams splits a parameter list on commas outside brackets
+func splitParams(list string) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '[', '{', '(', '<':
+			depth++
+		case ']', '}', ')', '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				fields = append(fields, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(list[start:]); last != "" {
+		fields = append(fields, last)
+	}
+	return fields
+}
+
+func parseTypeUnion(s string) TypeUnion {
+	var u TypeUnion
+	for _, t := range strings.Split(s, "|") {
+		if t = strings.TrimSpace(t); t != "" {
+			u = append(u, t)
+		}
+	}
+	return u
+}
+
+// Label renders the signature as a single line
+func (sig *FuncSignature) Label() string {
+	label, _ := sig.render()
+	return label
+}
+
+// render returns the signature label along with the [start, end) offset of
+// each parameter within it, as signature help needs. An aggregate's filter
+// condition gets one more offset after the parameters.
+func (sig *FuncSignature) render() (string, [][2]int) {
+	var b strings.Builder
+	offsets := make([][2]int, len(sig.Params), len(sig.Params)+1)
+
+	b.WriteString(sig.Name)
+	b.WriteByte('(')
+	if sig.Distinct {
+		b.WriteString("[distinct] ")
+	}
+	for i, p := range sig.Params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		start := b.Len()
+		b.WriteString(p.Name)
+		if p.Optional {
+			b.WriteByte('?')
+		}
+		if len(p.Types) > 0 {
+			b.WriteString(": ")
+			b.WriteString(p.Types.String())
+		}
+		offsets[i] = [2]int{start, b.Len()}
+		if p.Variadic {
+			b.WriteString(", ...")
+		}
+	}
+	b.WriteByte(')')
+	if sig.Filter {
+		b.WriteString(" [filter (")
+		start := b.Len()
+		b.WriteString("condition: bool")
+		offsets = append(offsets, [2]int{start, b.Len()})
+		b.WriteString(")]")
+	}
+	if len(sig.Returns) > 0 {
+		b.WriteString(" -> ")
+		b.WriteString(sig.Returns.String())
+	}
+	return b.String(), offsets
+}
+
+// markdownParams renders a parameter list for hover, or "" when no
+// parameter is documented
+func (sig *FuncSignature) markdownParams() string {
+	var b strings.Builder
+	for _, p := range sig.Params {
+		if p.Doc == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("**Parameters**\n")
+		}
+		fmt.Fprintf(&b, "\n- `%s`", p.Name)
+		if len(p.Types) > 0 {
+			fmt.Fprintf(&b, " *%s*", p.Types)
+		}
+		if p.Optional {
+			b.WriteString(" (optional)")
+		}
+		fmt.Fprintf(&b, ": %s", p.Doc)
+	}
+	if sig.Filter {
+		if b.Len() == 0 {
+			b.WriteString("**Parameters**\n")
+		}
+		fmt.Fprintf(&b, "\n- `condition` *bool* (optional): %s", filterParamDoc)
+	}
+	return b.String()
+}
+
+// plainParams renders a parameter list as plain text under an explicit
+// "Parameters:" heading, one parameter per line, or "" when no parameter is
+// documented
+func (sig *FuncSignature) plainParams() string {
+	var b strings.Builder
+	line := func(name, types string, optional bool, doc string) {
+		if b.Len() == 0 {
+			b.WriteString("Parameters:")
+		}
+		fmt.Fprintf(&b, "\n  %s", name)
+		switch {
+		case types != "" && optional:
+			fmt.Fprintf(&b, " (%s, optional)", types)
+		case types != "":
+			fmt.Fprintf(&b, " (%s)", types)
+		case optional:
+			b.WriteString(" (optional)")
+		}
+		fmt.Fprintf(&b, ": %s", doc)
+	}
+	for _, p := range sig.Params {
+		if p.Doc == "" {
+			continue
+		}
+		types := ""
+		if len(p.Types) > 0 {
+			types = p.Types.String()
+		}
+		line(p.Name, types, p.Optional, p.Doc)
+	}
+	if sig.Filter {
+		line("condition", "bool", true, filterParamDoc)
+	}
+	return b.String()
+}
+
+// newFuncSignature builds the signature model for a builtin, attaching the
+// registry's parameter docs by name. It returns nil for builtins without a
+// signature or with one that doesn't parse, which then render as before.
+func newFuncSignature(b *Builtin) *FuncSignature {
+	if b.Signature == "" {
+		return nil
+	}
+	sig, err := parseSignature(b.Signature)
+	if err != nil {
+		return nil
+	}
+	// Every SuperSQL aggregate call accepts both modifiers
+	if b.Kind == KindAggregate {
+		sig.Distinct = true
+		sig.Filter = true
+	}
+	for i := range sig.Params {
+		for _, pd := range b.Parameters {
+			if pd.Name == sig.Params[i].Name {
+				sig.Params[i].Doc = pd.Doc
+			}
+		}
+	}
+	return sig
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Source kinds. A query reads stdin when it has no from, files when its
+// from names paths or URLs, and a lake when its from names pools. Lints
+// about sources only apply to the kind they are about: a file source that
+// doesn't exist is flagged in a query that reads files, while the same
+// name in a lake query is a pool and isn't checked. The kind is told from
+// the query, and sourceKinds in initializationOptions can fix it for a
+// directory whose queries are, say, all run against a lake.
+
+// sourceKind is what a query reads
+type sourceKind string
+
+// Source kinds, as named in sourceKinds
+const (
+	sourceStdin sourceKind = "stdin"
+	sourceFile  sourceKind = "file"
+	sourceLake  sourceKind = "lake"
+)
+
+// sourceName is a source named by from
+type sourceName struct {
+	name string
+	rng  Range // the name, without its quotes
+}
+
+// sourceNames returns the sources text reads by name, and false if it
+// doesn't parse
+func sourceNames(text string) ([]sourceName, bool) {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil, false
+	}
+	var names []sourceName
+	walkTree(tree, func(node map[string]interface{}) {
+		var item map[string]interface{}
+		switch nodeKind(node) {
+		case "FromOp":
+			item, _ = node["item"].(map[string]interface{})
+		case "SQLFromItem":
+			item, _ = node["input"].(map[string]interface{})
+		}
+		node, _ = item["source"].(map[string]interface{})
+		if nodeKind(node) != "Text" {
+			return
+		}
+		name, _ := node["value"].(string)
+		rng := locRange(text, node["loc"])
+		if start, ok := offsetAt(text, rng.Start); ok && start < len(text) && (text[start] == '"' || text[start] == '\'') {
+			rng.Start.Character++
+			rng.End.Character--
+		}
+		names = append(names, sourceName{name: name, rng: rng})
+	})
+	return names, true
+}
+
+// looksLikeFile reports whether a source name is a file or URL rather
+// than a pool: it has a scheme, a slash, or an extension
+func looksLikeFile(name string) bool {
+	if u, err := url.Parse(name); err == nil && len(u.Scheme) > 1 {
+		return true
+	}
+	return filepath.IsAbs(name) || strings.Contains(name, "/") || path.Ext(name) != ""
+}
+
+// detectSourceKind tells what a query reads from the sources it names:
+// stdin if none, files if any looks like a file, and otherwise a lake
+func detectSourceKind(names []sourceName) sourceKind {
+	if len(names) == 0 {
+		return sourceStdin
+	}
+	for _, src := range names {
+		if looksLikeFile(src.name) {
+			return sourceFile
+		}
+	}
+	return sourceLake
+}
+
+// configuredSourceKind returns the kind sourceKinds sets for uri, from
+// the most specific pattern that covers it
+func (s *Server) configuredSourceKind(uri string) (sourceKind, bool) {
+	kind, ok := s.configuredFor(uri, s.settings().SourceKinds)
+	return sourceKind(kind), ok
+}
+
+// configuredFor returns the value a setting keyed by path pattern, like
+// sourceKinds, holds for uri, from the most specific pattern that covers it
+func (s *Server) configuredFor(uri string, patterns map[string]string) (string, bool) {
+	if len(patterns) == 0 {
+		return "", false
+	}
+	file, err := uriToPath(uri)
+	if err != nil {
+		return "", false
+	}
+	rel := s.folderRelative(file)
+	var value string
+	best := -1
+	for pattern, v := range patterns {
+		pattern = path.Clean(filepath.ToSlash(pattern))
+		covered := false
+		switch {
+		case path.IsAbs(pattern):
+			covered = coveredBy(filepath.ToSlash(file), pattern)
+		case pattern == ".":
+			covered = rel != ""
+		case rel != "":
+			covered = coveredBy(rel, pattern)
+		}
+		if covered && len(pattern) > best {
+			value, best = v, len(pattern)
+		}
+	}
+	return value, best >= 0
+}
+
+// sourceDiagnostics runs the lints for the kind of source the query at
+// uri reads
+func (s *Server) sourceDiagnostics(uri, text string) []Diagnostic {
+	names, ok := sourceNames(text)
+	if !ok {
+		return nil
+	}
+	kind, configured := s.configuredSourceKind(uri)
+	if !configured {
+		kind = detectSourceKind(names)
+	}
+	if kind != sourceFile {
+		return nil
+	}
+	file, err := uriToPath(uri)
+	if err != nil {
+		return nil
+	}
+	dirs := s.sourceDirs(file)
+	var diagnostics []Diagnostic
+	for _, src := range names {
+		if u, err := url.Parse(src.name); err == nil && len(u.Scheme) > 1 {
+			// Reaching a URL is left to the run
+			continue
+		}
+		if sourceExists(filepath.FromSlash(src.name), dirs) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    src.rng,
+			Severity: DiagnosticSeverityWarning,
+			Code:     "missing-source",
+			Source:   "superdb-lsp",
+			Message:  s.messages.format("missing-source", "source", src.name),
+		})
+	}
+	return diagnostics
+}
+
+// sourceDirs returns the directories a relative source in the query at
+// file is looked for in. super reads a relative path from where it runs,
+// which is usually the query's directory or its workspace folder.
+func (s *Server) sourceDirs(file string) []string {
+	dirs := []string{filepath.Dir(file)}
+	if folder := s.folderOf(file); folder != "" {
+		dirs = append(dirs, folder)
+	}
+	return dirs
+}
+
+// sourceExists reports whether the file at path exists, trying each of
+// dirs in turn when it is relative
+func sourceExists(path string, dirs []string) bool {
+	if filepath.IsAbs(path) {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectSourceKind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  sourceKind
+	}{
+		{"values 1 | count()", sourceStdin},
+		{"where status == 200", sourceStdin},
+		{"from conn.json | count()", sourceFile},
+		{"from logs | count()", sourceLake},
+		{"from logs | join (from hosts.csv) on left.h=right.h", sourceFile},
+		{"from https://example.com/a.json", sourceFile},
+		{"select * from 'data/t.csv'", sourceFile},
+	}
+	for _, tt := range tests {
+		names, ok := sourceNames(tt.query)
+		if !ok {
+			t.Fatalf("%s: expected the query to parse", tt.query)
+		}
+		if got := detectSourceKind(names); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.query, tt.want, got)
+		}
+	}
+}
+
+func TestConfiguredSourceKind(t *testing.T) {
+	s := NewServer()
+	s.rootPath = "/work"
+	s.config.SourceKinds = map[string]string{".": "file", "lake": "lake", "lake/scratch": "stdin"}
+	tests := []struct {
+		path string
+		want sourceKind
+	}{
+		{"/work/q.spq", sourceFile},
+		{"/work/lake/q.spq", sourceLake},
+		{"/work/lake/scratch/q.spq", sourceStdin},
+	}
+	for _, tt := range tests {
+		if got, ok := s.configuredSourceKind(pathToURI(tt.path)); !ok || got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.want, got)
+		}
+	}
+	if _, ok := s.configuredSourceKind(pathToURI("/elsewhere/q.spq")); ok {
+		t.Error("Expected no kind outside the workspace")
+	}
+}
+
+func TestSourceDiagnostics(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "queries"), 0o755)
+	os.WriteFile(filepath.Join(root, "queries", "conn.json"), []byte("{}"), 0o644)
+	os.WriteFile(filepath.Join(root, "hosts.json"), []byte("{}"), 0o644)
+	s := NewServer()
+	s.rootPath = root
+	uri := pathToURI(filepath.Join(root, "queries", "q.spq"))
+
+	// Beside the query and at the root are both found
+	text := "from conn.json | join (from hosts.json) on left.h=right.h | join (from dns.json) on left.h=right.h"
+	diagnostics := s.sourceDiagnostics(uri, text)
+	if len(diagnostics) != 1 || diagnostics[0].Code != "missing-source" || diagnostics[0].Range != rangeOfText(t, text, "dns.json") {
+		t.Fatalf("Expected dns.json flagged, got %+v", diagnostics)
+	}
+
+	// A pool isn't a file, unless the workspace says its queries read
+	// files
+	if diagnostics := s.sourceDiagnostics(uri, "from logs | count()"); len(diagnostics) != 0 {
+		t.Errorf("Expected no lint for a pool, got %+v", diagnostics)
+	}
+	s.config.SourceKinds = map[string]string{"queries": "file"}
+	if diagnostics := s.sourceDiagnostics(uri, "from logs | count()"); len(diagnostics) != 1 {
+		t.Errorf("Expected logs flagged in a file workspace, got %+v", diagnostics)
+	}
+	s.config.SourceKinds = map[string]string{"queries": "lake"}
+	if diagnostics := s.sourceDiagnostics(uri, text); len(diagnostics) != 0 {
+		t.Errorf("Expected no file lints in a lake workspace, got %+v", diagnostics)
+	}
+}
+
+// rangeOfText returns the range of the first substr in text
+func rangeOfText(t *testing.T, text, substr string) Range {
+	t.Helper()
+	return Range{Start: posOf(t, text, substr, 1, 0), End: posOf(t, text, substr, 1, len(substr))}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Field completion in stage arguments. What cut, drop, and sort take, and
+// what rename renames, are fields of the stage's input, so completion
+// there offers only the fields the input has: those the stages before it
+// follow from (see stageFields), so a field put adds is offered and one
+// drop removed or cut left out isn't, or when those aren't all known, the
+// fields of the query's data. A field a cut or drop already lists isn't
+// offered again.
+
+// fieldArgsPattern matches the text of a cut, drop, rename, or sort
+// stage, capturing its operator and its arguments so far
+var fieldArgsPattern = regexp.MustCompile(`(?is)^(cut|drop|rename|sort|order\s+by)(\s.*)?$`)
+
+// sortFlagsPattern matches the flags of a sort, like -r
+var sortFlagsPattern = regexp.MustCompile(`^(?:-\w+\s*)*`)
+
+// fieldArgsAt reports whether stage, the text of a stage up to the word
+// typed, ends where a field of its input goes as an argument, and
+// returns the fields its arguments already list
+func fieldArgsAt(stage string) ([]string, bool) {
+	m := fieldArgsPattern.FindStringSubmatch(stage)
+	if m == nil {
+		return nil, false
+	}
+	op, args := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+	if strings.Count(args, "(") != strings.Count(args, ")") {
+		// In the arguments of a call
+		return nil, false
+	}
+	switch op {
+	case "rename":
+		return nil, strings.HasSuffix(args, ":=")
+	case "cut", "drop":
+		if args != "" && !strings.HasSuffix(args, ",") {
+			return nil, false
+		}
+		var listed []string
+		for _, arg := range strings.Split(args, ",") {
+			if arg = strings.TrimSpace(arg); arg != "" {
+				listed = append(listed, arg)
+			}
+		}
+		return listed, true
+	default:
+		args = sortFlagsPattern.ReplaceAllString(args, "")
+		return nil, args == "" || strings.HasSuffix(args, ",")
+	}
+}
+
+// stageAt returns the text of the stage at offset in text up to the word
+// typed there, where the stage starts, and the word, lowercased. It
+// reports false inside a string or comment and after a dot, where what is
+// typed isn't a field of the input but one nested in it.
+func stageAt(text string, offset int) (string, int, string, bool) {
+	if inStringOrComment(text, offset) {
+		return "", 0, "", false
+	}
+	word := offset
+	for word > 0 && isIdentifierChar(text[word-1]) {
+		word--
+	}
+	if word > 0 && text[word-1] == '.' {
+		return "", 0, "", false
+	}
+	start := stageStart(text[:word])
+	return strings.TrimLeft(text[start:word], " \t\r\n"), start, strings.ToLower(text[word:offset]), true
+}
+
+// fieldArgCompletions returns the fields of the input matching what is
+// typed at pos, and reports whether pos is where a cut, drop, rename, or
+// sort takes one and there are fields to offer there
+func (s *Server) fieldArgCompletions(ctx context.Context, uri, text string, pos Position) ([]CompletionItem, bool) {
+	offset, ok := offsetAt(text, pos)
+	if !ok {
+		return nil, false
+	}
+	stage, start, prefix, ok := stageAt(text, offset)
+	if !ok {
+		return nil, false
+	}
+	listed, ok := fieldArgsAt(stage)
+	if !ok {
+		return nil, false
+	}
+	var items []CompletionItem
+	for _, item := range s.inputFieldCompletions(uri, text, pos, start, rankContextAt(text, offset, prefix)) {
+		if !slices.Contains(listed, item.Label) {
+			items = append(items, item)
+		}
+	}
+	return items, len(items) > 0 && ctx.Err() == nil
+}
+
+// inputFieldCompletions returns the fields of the input of the stage that
+// starts at offset start in text matching the word typed, ranked ahead
+// of the rest: those the stages before it follow from, or when those
+// aren't all known, the fields of the query's data
+func (s *Server) inputFieldCompletions(uri, text string, pos Position, start int, rc rankContext) []CompletionItem {
+	var known *fieldSet
+	if start > 0 && text[start-1] == '|' {
+		if tree, ok := parseTree(text[:start-1]); ok {
+			fields := stageFields(topLevelStages(tree))
+			known = fields[len(fields)-1]
+		}
+	}
+	var items []CompletionItem
+	seen := make(map[string]bool)
+	if known != nil {
+		for _, name := range known.names {
+			m, ok := matchWord(strings.ToLower(name), rc.prefix)
+			if !ok {
+				continue
+			}
+			item := CompletionItem{
+				Label:    name,
+				Kind:     CompletionItemKindField,
+				Detail:   "field of the input",
+				SortText: rc.rank(name, rankDeclared, noKind).sortText(name),
+			}
+			m.apply(&item)
+			items = append(items, item)
+			seen[name] = true
+		}
+		if !known.open {
+			return items
+		}
+	}
+	data, _ := s.dataFieldCompletions(uri, text, pos)
+	for _, item := range data {
+		if !seen[item.Label] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
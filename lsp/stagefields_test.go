@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFieldArgsAt(t *testing.T) {
+	tests := []struct {
+		stage  string
+		listed []string
+		ok     bool
+	}{
+		{"cut ", nil, true},
+		{"cut a, b, ", []string{"a", "b"}, true},
+		{"drop a,", []string{"a"}, true},
+		{"rename x:=", nil, true},
+		{"rename ", nil, false},
+		{"sort ", nil, true},
+		{"sort -r ", nil, true},
+		{"sort a desc, ", nil, true},
+		{"order by ", nil, true},
+		{"cut a ", nil, false},
+		{"cut x:=lower(", nil, false},
+		{"cutter ", nil, false},
+		{"where ", nil, false},
+	}
+	for _, tt := range tests {
+		listed, ok := fieldArgsAt(tt.stage)
+		if ok != tt.ok || !slices.Equal(listed, tt.listed) {
+			t.Errorf("fieldArgsAt(%q) = %q, %v, want %q, %v", tt.stage, listed, ok, tt.listed, tt.ok)
+		}
+	}
+}
+
+func TestFieldArgCompletions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "conn.sup"), []byte(`{host:"a",port:80,proto:"tcp"}`), 0o644)
+
+	tests := []struct {
+		name string
+		text string // | marks the cursor
+		want []string
+	}{
+		{"put adds", "values {a:1,b:2} | put c:=3 | cut |", []string{"a", "b", "c"}},
+		{"drop removes", "values {a:1,b:2,c:3} | drop b | sort |", []string{"a", "c"}},
+		{"cut restricts", "from conn.sup | cut host, port | drop |", []string{"host", "port"}},
+		{"listed already", "from conn.sup | cut host, |", []string{"port", "proto"}},
+		{"rename", "values {a:1,b:2} | rename z:=|", []string{"a", "b"}},
+		{"summarize", "from conn.sup | summarize n:=count() by proto | sort -r |", []string{"proto", "n"}},
+		{"prefix", "from conn.sup | sort p|", []string{"port", "proto"}},
+		{"from data", "from conn.sup | drop |", []string{"host", "port", "proto"}},
+		{"nothing known", "from other.sup | cut |", nil},
+		{"not an argument", "from conn.sup | cut host |", nil},
+	}
+	s := NewServer()
+	uri := pathToURI(filepath.Join(dir, "q.spq"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset := strings.LastIndex(tt.text, "|")
+			text := tt.text[:offset] + tt.text[offset+1:]
+			items, ok := s.fieldArgCompletions(context.Background(), uri, text, positionAt(text, offset))
+			if got := completionLabels(items); !slices.Equal(got, tt.want) || ok != (tt.want != nil) {
+				t.Errorf("Expected %q, got %q (%v)", tt.want, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Server metrics. superdb/stats reports where the server's time and memory
+// go, for users working out why an editor is slow: how many documents are
+// open, how much is cached, how long parsing takes on each change, and
+// how much memory the process holds.
+
+// parseSamples is how many of the latest parse durations are kept
+const parseSamples = 256
+
+// parseTimes records how long recent parses took
+type parseTimes struct {
+	mu      sync.Mutex
+	samples []time.Duration // a ring of the latest parseSamples
+	next    int
+	count   int64 // parses since the server started
+}
+
+func newParseTimes() *parseTimes {
+	return &parseTimes{samples: make([]time.Duration, 0, parseSamples)}
+}
+
+// record adds the duration of a parse
+func (p *parseTimes) record(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	if len(p.samples) < parseSamples {
+		p.samples = append(p.samples, d)
+		return
+	}
+	p.samples[p.next] = d
+	p.next = (p.next + 1) % parseSamples
+}
+
+// stats returns the count of parses and the median and 95th percentile of
+// the recent ones
+func (p *parseTimes) stats() ParseStats {
+	p.mu.Lock()
+	sorted := append([]time.Duration(nil), p.samples...)
+	count := p.count
+	p.mu.Unlock()
+	if len(sorted) == 0 {
+		return ParseStats{Count: count}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(q float64) float64 {
+		d := sorted[int(q*float64(len(sorted)-1))]
+		return float64(d.Microseconds()) / 1000
+	}
+	return ParseStats{Count: count, P50Ms: percentile(0.50), P95Ms: percentile(0.95)}
+}
+
+// handleStats processes superdb/stats requests
+func (s *Server) handleStats(msg RPCMessage) HandlerResult {
+	s.docMu.RLock()
+	documents := len(s.documents)
+	s.docMu.RUnlock()
+
+	pools, revisions := s.pools.size()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return success(StatsResult{
+		Documents: documents,
+		Caches: CacheStats{
+			SemanticTokens: s.semantic.size(),
+			IndexedFiles:   s.index.size(),
+			DataFiles:      s.dataFields.size(),
+			Pools:          pools,
+			PoolRevisions:  revisions,
+		},
+		Parses: s.parses.stats(),
+		Memory: MemoryStats{
+			HeapBytes:  mem.HeapAlloc,
+			SysBytes:   mem.Sys,
+			Goroutines: runtime.NumGoroutine(),
+		},
+	})
+}
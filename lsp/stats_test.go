@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimesPercentiles(t *testing.T) {
+	p := newParseTimes()
+	if stats := p.stats(); stats.Count != 0 || stats.P50Ms != 0 {
+		t.Errorf("Expected no parses, got %+v", stats)
+	}
+	for i := 1; i <= parseSamples+100; i++ {
+		p.record(time.Duration(i) * time.Millisecond)
+	}
+	stats := p.stats()
+	if stats.Count != parseSamples+100 {
+		t.Errorf("Expected %d parses, got %d", parseSamples+100, stats.Count)
+	}
+	// Only the latest parseSamples are kept: 101ms through 356ms
+	if stats.P50Ms != 228 || stats.P95Ms != 343 {
+		t.Errorf("Unexpected percentiles: %+v", stats)
+	}
+}
+
+func TestStatsRequest(t *testing.T) {
+	h := NewTestHelper()
+	h.openDocument(t, "file:///a.spq", "values 1")
+	h.openDocument(t, "file:///b.spq", "values 2")
+	h.server.pools.pools = []lakePool{{name: "logs"}, {name: "traces"}}
+	h.server.pools.revisions = map[string]revisionList{"lake\x00logs": {}}
+
+	response, err := h.ProcessRequest(2, "superdb/stats", nil)
+	if err != nil {
+		t.Fatalf("superdb/stats failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result StatsResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Documents != 2 || result.Parses.Count != 2 {
+		t.Errorf("Expected 2 documents parsed once each, got %s", resultBytes)
+	}
+	if result.Caches.Pools != 2 || result.Caches.PoolRevisions != 1 {
+		t.Errorf("Expected 2 pools and the revisions of 1, got %s", resultBytes)
+	}
+	if result.Memory.HeapBytes == 0 || result.Memory.Goroutines == 0 {
+		t.Errorf("Expected memory usage, got %s", resultBytes)
+	}
+}
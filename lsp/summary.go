@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Query summaries. superdb.summarizeQuery describes what a query does in a
+// sentence or two of plain English, stage by stage, for review
+// descriptions and query catalogs. Expressions are quoted as written;
+// only the operators around them are put into words.
+
+// maxSummaryExpr caps how much of an expression a summary quotes
+const maxSummaryExpr = 80
+
+// summarizeQuery describes the query in text, reporting false if it
+// doesn't parse
+func summarizeQuery(text string) (string, bool) {
+	tree, ok := parseTree(text)
+	if !ok {
+		return "", false
+	}
+	var sentences []string
+	nodes, _ := tree.([]interface{})
+	if len(nodes) == 1 && nodeKind(nodes[0]) == "ScopeOp" {
+		scope, _ := nodes[0].(map[string]interface{})
+		decls, _ := scope["decls"].([]interface{})
+		var names []string
+		for _, decl := range decls {
+			decl, _ := decl.(map[string]interface{})
+			kind := strings.ToLower(strings.TrimSuffix(nodeKind(decl), "Decl"))
+			if kind == "" || declName(decl) == "" {
+				continue
+			}
+			names = append(names, fmt.Sprintf("%s %s", kind, declName(decl)))
+		}
+		if len(names) > 0 {
+			sentences = append(sentences, "Defines "+joinList(names)+".")
+		}
+	}
+	if clauses := describeStages(text, topLevelStages(tree)); len(clauses) > 0 {
+		sentences = append(sentences, capitalize(joinList(clauses))+".")
+	}
+	return strings.Join(sentences, " "), true
+}
+
+// describeStages returns a clause for each stage of a pipeline
+func describeStages(text string, stages []interface{}) []string {
+	var clauses []string
+	for i, stage := range stages {
+		op, _ := stage.(map[string]interface{})
+		var previous string
+		if i > 0 {
+			previous = nodeKind(stages[i-1])
+		}
+		if clause := describeStage(text, op, previous); clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses
+}
+
+// describeStage returns a clause for one stage. previous is the kind of
+// the stage before it, which changes how some stages read: a head after a
+// sort takes the top values rather than the first.
+func describeStage(text string, op map[string]interface{}, previous string) string {
+	quote := func(node interface{}) string {
+		m, _ := node.(map[string]interface{})
+		loc := m["loc"]
+		if id, ok := m["id"].(map[string]interface{}); ok && loc == nil {
+			// An identifier's loc is on its name
+			loc = id["loc"]
+		}
+		return truncate(strings.Join(strings.Fields(nodeText(text, loc)), " "), maxSummaryExpr)
+	}
+	quoteAll := func(list interface{}) string {
+		items, _ := list.([]interface{})
+		quoted := make([]string, 0, len(items))
+		for _, item := range items {
+			if q := quoteAssignment(item, quote); q != "" {
+				quoted = append(quoted, q)
+			}
+		}
+		return joinList(quoted)
+	}
+	count := func(fallback string) string {
+		if n, ok := op["count"].(map[string]interface{}); ok {
+			return quote(n)
+		}
+		return fallback
+	}
+
+	switch nodeKind(op) {
+	case "FromOp":
+		item, _ := op["item"].(map[string]interface{})
+		return "reads " + quote(item["source"])
+	case "ValuesOp":
+		return "produces " + quoteAll(op["exprs"])
+	case "WhereOp":
+		return "keeps values where " + quote(op["expr"])
+	case "SearchOp":
+		return "keeps values matching " + quote(op["expr"])
+	case "ExprOp":
+		expr, _ := op["expr"].(map[string]interface{})
+		if isAggregateCall(expr) {
+			return "aggregates " + quote(expr)
+		}
+		return "keeps values where " + quote(expr)
+	case "AggregateOp":
+		clause := "aggregates " + quoteAll(op["aggs"])
+		if keys, _ := op["keys"].([]interface{}); len(keys) > 0 {
+			clause += " by " + quoteAll(keys)
+		}
+		return clause
+	case "CountOp":
+		return "counts values"
+	case "SortOp":
+		exprs, _ := op["exprs"].([]interface{})
+		var keys []string
+		for _, e := range exprs {
+			e, _ := e.(map[string]interface{})
+			keys = append(keys, quote(e))
+		}
+		clause := "sorts"
+		if len(keys) > 0 {
+			clause += " by " + joinList(keys)
+		}
+		if reverse, _ := op["reverse"].(bool); reverse {
+			clause += " in reverse"
+		}
+		return clause
+	case "HeadOp":
+		if previous == "SortOp" {
+			return "returns the top " + count("1")
+		}
+		return "returns the first " + count("1")
+	case "TailOp":
+		return "returns the last " + count("1")
+	case "CutOp":
+		return "keeps only " + quoteAll(op["args"])
+	case "DropOp":
+		return "drops " + quoteAll(op["args"])
+	case "PutOp":
+		return "sets " + quoteAll(op["args"])
+	case "RenameOp":
+		args, _ := op["args"].([]interface{})
+		var renames []string
+		for _, arg := range args {
+			arg, _ := arg.(map[string]interface{})
+			renames = append(renames, quote(arg["rhs"])+" to "+quote(arg["lhs"]))
+		}
+		return "renames " + joinList(renames)
+	case "UniqOp":
+		if cflag, _ := op["cflag"].(bool); cflag {
+			return "drops adjacent duplicates, keeping a count of each"
+		}
+		return "drops adjacent duplicates"
+	case "FuseOp":
+		return "fuses values into one type"
+	case "ShapesOp":
+		return "takes one value of each shape"
+	case "UnnestOp":
+		return "unnests " + quote(op["expr"])
+	case "JoinOp":
+		cond, _ := op["cond"].(map[string]interface{})
+		if expr, ok := cond["expr"].(map[string]interface{}); ok {
+			return "joins on " + quote(expr)
+		}
+		return "joins"
+	case "ForkOp":
+		paths, _ := op["paths"].([]interface{})
+		return fmt.Sprintf("splits into %d branches", len(paths))
+	case "SwitchOp":
+		cases, _ := op["cases"].([]interface{})
+		return fmt.Sprintf("routes values through %d cases", len(cases))
+	case "AssertOp":
+		return "asserts " + quote(op["expr"])
+	case "CallOp":
+		return "applies op " + declName(op)
+	case "LoadOp":
+		return "loads into pool " + quote(op["pool"])
+	case "OutputOp":
+		return "outputs to " + declName(op)
+	case "SQLOp":
+		return "runs the SQL query " + quote(op)
+	case "PassOp":
+		return ""
+	}
+	if stage := quote(op); stage != "" {
+		return "runs " + stage
+	}
+	return ""
+}
+
+// quoteAssignment quotes an item of an operator's argument list. Items of
+// put, cut, and aggregate lists are assignments whose left side may be
+// omitted; other lists hold expressions.
+func quoteAssignment(item interface{}, quote func(interface{}) string) string {
+	m, _ := item.(map[string]interface{})
+	if nodeKind(m) != "" {
+		return quote(m)
+	}
+	rhs := quote(m["rhs"])
+	if lhs, ok := m["lhs"].(map[string]interface{}); ok {
+		return quote(lhs) + ":=" + rhs
+	}
+	return rhs
+}
+
+// isAggregateCall reports whether expr calls an aggregate function, which
+// as a stage of its own aggregates its input
+func isAggregateCall(expr map[string]interface{}) bool {
+	if nodeKind(expr) != "CallExpr" {
+		return false
+	}
+	fn, _ := expr["func"].(map[string]interface{})
+	name, _ := fn["name"].(string)
+	for _, agg := range Builtins.Aggregates() {
+		if strings.EqualFold(agg.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinList joins items as an English list: "a", "a and b", "a, b, and c"
+func joinList(items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
+
+// capitalize upper-cases the first letter of s
+func capitalize(s string) string {
+	for i, r := range s {
+		return s[:i] + string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// summarizeQueryCommand returns a plain-English summary of a document's
+// query
+func (s *Server) summarizeQueryCommand(_ context.Context, args []json.RawMessage) HandlerResult {
+	var params SummarizeQueryArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+
+	s.promote(params.URI)
+	text, _, ok := s.document(params.URI)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: fmt.Sprintf("document not open: %s", params.URI)})
+	}
+	if s.isDataFile(params.URI) {
+		return failure(&RPCError{Code: RequestFailed, Message: "data files aren't queries"})
+	}
+	if params.Range != nil {
+		text = rangeText(text, s.rangeFromClient(text, *params.Range))
+	}
+
+	summary, ok := summarizeQuery(text)
+	if !ok {
+		return failure(&RPCError{Code: RequestFailed, Message: "the query doesn't parse"})
+	}
+	return success(SummarizeQueryResult{Summary: summary})
+}
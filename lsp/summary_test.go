@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSummarizeQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			"from pool1 | where x > 1 | count() by host | sort -r count | head 10",
+			"Reads pool1, keeps values where x > 1, aggregates count() by host, sorts by count in reverse, and returns the top 10.",
+		},
+		{
+			"const n = 3\nvalues {a:1}\n| put b:=a+n\n| cut a, b\n| head",
+			"Defines const n. Produces {a:1}, sets b:=a+n, keeps only a and b, and returns the first 1.",
+		},
+		{"count()", "Aggregates count()."},
+		{"from data.json | rename n:=count | drop x", "Reads data.json, renames count to n, and drops x."},
+	}
+	for _, tt := range tests {
+		got, ok := summarizeQuery(tt.query)
+		if !ok {
+			t.Errorf("Expected %q to parse", tt.query)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Summary of %q:\nexpected %q\ngot      %q", tt.query, tt.want, got)
+		}
+	}
+
+	if _, ok := summarizeQuery("from |"); ok {
+		t.Errorf("Expected no summary of a query that doesn't parse")
+	}
+}
+
+func TestSummarizeQueryCommand(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///summary.spq"
+	h.openDocument(t, uri, "values 1, 2\n| where this > 1")
+
+	raw, _ := json.Marshal(SummarizeQueryArgs{URI: uri})
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandSummarizeQuery,
+		Arguments: []json.RawMessage{raw},
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error.Message)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var result SummarizeQueryResult
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		t.Fatalf("Unmarshal result: %v", err)
+	}
+	if want := "Produces 1 and 2 and keeps values where this > 1."; result.Summary != want {
+		t.Errorf("Expected %q, got %q", want, result.Summary)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import "sort"
+
+// Symbol table for the declarations a query makes itself: consts, funcs,
+// ops, types, and the parameters of funcs and ops. Each use of a name is
+// resolved to its declaration by scope, innermost first, the way the
+// compiler resolves it. Names that resolve to nothing are fields, kept by
+// their path, e.g. a.b, so their uses can be found too.
+
+// symbolKind is what a declaration declares
+type symbolKind int
+
+const (
+	symbolConst symbolKind = iota
+	symbolFunc
+	symbolOp
+	symbolType
+	symbolParam
+)
+
+// String names the kind the way the language does
+func (k symbolKind) String() string {
+	switch k {
+	case symbolConst:
+		return "const"
+	case symbolFunc:
+		return "fn"
+	case symbolOp:
+		return "op"
+	case symbolType:
+		return "type"
+	}
+	return "param"
+}
+
+// symbolSpace is a namespace of names. Consts and parameters are both
+// values; funcs, ops, and types each have their own.
+type symbolSpace int
+
+const (
+	spaceValue symbolSpace = iota
+	spaceFunc
+	spaceOp
+	spaceType
+)
+
+func (k symbolKind) space() symbolSpace {
+	switch k {
+	case symbolFunc:
+		return spaceFunc
+	case symbolOp:
+		return spaceOp
+	case symbolType:
+		return spaceType
+	}
+	return spaceValue
+}
+
+// symbol is a declaration
+type symbol struct {
+	name      string
+	kind      symbolKind
+	nameRange Range // the declared name
+	rng       Range // the whole declaration
+	params    []string
+	doc       string // the doc comment above the declaration
+}
+
+// symbolRef is a use of a declared name
+type symbolRef struct {
+	rng    Range
+	target *symbol
+}
+
+// fieldRef is a use of a field, named by its path from this. An alias is
+// where the query itself names the field, as on the left of := or as a
+// key of a record literal.
+type fieldRef struct {
+	rng   Range
+	path  string
+	alias bool
+}
+
+// symbolTable holds the declarations in a document and the uses of them
+type symbolTable struct {
+	symbols []*symbol
+	refs    []symbolRef
+	fields  []fieldRef
+}
+
+// scope maps names in each namespace to their declarations
+type scope struct {
+	parent *scope
+	names  map[symbolSpace]map[string]*symbol
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, names: make(map[symbolSpace]map[string]*symbol)}
+}
+
+func (sc *scope) declare(sym *symbol) {
+	space := sym.kind.space()
+	if sc.names[space] == nil {
+		sc.names[space] = make(map[string]*symbol)
+	}
+	sc.names[space][sym.name] = sym
+}
+
+func (sc *scope) lookup(space symbolSpace, name string) *symbol {
+	for ; sc != nil; sc = sc.parent {
+		if sym, ok := sc.names[space][name]; ok {
+			return sym
+		}
+	}
+	return nil
+}
+
+// buildSymbolTable parses text and resolves its declared names, returning
+// nil when text doesn't parse
+func buildSymbolTable(text string) *symbolTable {
+	tree, ok := parseTree(text)
+	if !ok {
+		return nil
+	}
+	return symbolTableOf(text, tree)
+}
+
+// symbolTableOf resolves the declared names in tree, parsed from text
+func symbolTableOf(text string, tree interface{}) *symbolTable {
+	b := &symbolBuilder{text: text, table: &symbolTable{}}
+	b.walk(tree, newScope(nil))
+	return b.table
+}
+
+type symbolBuilder struct {
+	text  string
+	table *symbolTable
+}
+
+// declare records the declaration node of kind, whose name is the
+// identifier node name
+func (b *symbolBuilder) declare(sc *scope, kind symbolKind, name, node interface{}) *symbol {
+	id, _ := name.(map[string]interface{})
+	n, _ := id["name"].(string)
+	if n == "" {
+		return nil
+	}
+	decl, _ := node.(map[string]interface{})
+	sym := &symbol{
+		name:      n,
+		kind:      kind,
+		nameRange: locRange(b.text, id["loc"]),
+		rng:       locRange(b.text, decl["loc"]),
+	}
+	sc.declare(sym)
+	b.table.symbols = append(b.table.symbols, sym)
+	return sym
+}
+
+// use records a use of name in space at loc, reporting whether it resolves
+func (b *symbolBuilder) use(sc *scope, space symbolSpace, name string, loc interface{}) bool {
+	sym := sc.lookup(space, name)
+	if sym != nil {
+		b.table.refs = append(b.table.refs, symbolRef{rng: locRange(b.text, loc), target: sym})
+	}
+	return sym != nil
+}
+
+// field records a use of the field at path
+func (b *symbolBuilder) field(path string, loc interface{}) {
+	b.table.fields = append(b.table.fields, fieldRef{rng: locRange(b.text, loc), path: path})
+}
+
+// alias marks the field recorded since mark as named by the query
+func (b *symbolBuilder) alias(mark int) {
+	if n := len(b.table.fields); n > mark {
+		b.table.fields[n-1].alias = true
+	}
+}
+
+// walkPath resolves an identifier or a dotted path like a.b.c and returns
+// the field path it names. It reports false when node isn't a field, as
+// when it starts with a const or parameter.
+func (b *symbolBuilder) walkPath(node map[string]interface{}, sc *scope) (string, bool) {
+	switch nodeKind(node) {
+	case "IDExpr":
+		id, _ := node["id"].(map[string]interface{})
+		name, _ := id["name"].(string)
+		if name == "this" {
+			return "", true
+		}
+		if b.use(sc, spaceValue, name, id["loc"]) {
+			return "", false
+		}
+		b.field(name, id["loc"])
+		return name, true
+	case "BinaryExpr":
+		if node["op"] == "." {
+			lhs, _ := node["lhs"].(map[string]interface{})
+			rhs, _ := node["rhs"].(map[string]interface{})
+			path, ok := b.walkPath(lhs, sc)
+			if !ok || nodeKind(rhs) != "IDExpr" {
+				return "", false
+			}
+			id, _ := rhs["id"].(map[string]interface{})
+			name, _ := id["name"].(string)
+			if path != "" {
+				name = path + "." + name
+			}
+			b.field(name, id["loc"])
+			return name, true
+		}
+	case "IndexExpr":
+		// A subscript by a string, as in a["b"], names the field a.b; the
+		// field's range is the string without its quotes
+		if key, loc, ok := stringIndex(node["index"]); ok {
+			expr, _ := node["expr"].(map[string]interface{})
+			path, ok := b.walkPath(expr, sc)
+			if !ok {
+				return "", false
+			}
+			if path != "" {
+				key = path + "." + key
+			}
+			b.field(key, loc)
+			return key, true
+		}
+	}
+	b.walk(node, sc)
+	return "", false
+}
+
+// stringIndex returns the string a subscript indexes by and the loc of its
+// text inside the quotes, reporting false if the index isn't a plain
+// string literal
+func stringIndex(index interface{}) (string, interface{}, bool) {
+	node, _ := index.(map[string]interface{})
+	text, _ := node["text"].(string)
+	switch nodeKind(node) {
+	case "DoubleQuoteExpr":
+	case "Primitive":
+		if node["type"] != "string" {
+			return "", nil, false
+		}
+	default:
+		return "", nil, false
+	}
+	loc, _ := node["loc"].(map[string]interface{})
+	first, _ := loc["first"].(float64)
+	last, _ := loc["last"].(float64)
+	if text == "" || int(last-first)-1 != len(text) {
+		// Empty, or written with escapes
+		return "", nil, false
+	}
+	return text, map[string]interface{}{"first": first + 1, "last": last - 1}, true
+}
+
+// walk resolves the names in node within sc
+func (b *symbolBuilder) walk(node interface{}, sc *scope) {
+	switch node := node.(type) {
+	case []interface{}:
+		for _, child := range node {
+			b.walk(child, sc)
+		}
+		return
+	case map[string]interface{}:
+		b.walkNode(node, sc)
+	}
+}
+
+func (b *symbolBuilder) walkNode(node map[string]interface{}, sc *scope) {
+	switch nodeKind(node) {
+	case "ScopeOp":
+		// Declarations are visible to each other and to the body
+		inner := newScope(sc)
+		decls, _ := node["decls"].([]interface{})
+		for _, decl := range decls {
+			decl, _ := decl.(map[string]interface{})
+			var sym *symbol
+			switch nodeKind(decl) {
+			case "ConstDecl":
+				sym = b.declare(inner, symbolConst, decl["name"], decl)
+			case "FuncDecl":
+				sym = b.declare(inner, symbolFunc, decl["name"], decl)
+			case "OpDecl":
+				sym = b.declare(inner, symbolOp, decl["name"], decl)
+			case "TypeDecl":
+				sym = b.declare(inner, symbolType, decl["name"], decl)
+			}
+			if sym != nil {
+				if start, ok := offsetAt(b.text, sym.rng.Start); ok {
+					sym.doc = declDoc(b.text, start)
+				}
+			}
+		}
+		for _, decl := range decls {
+			b.walk(decl, inner)
+		}
+		b.walk(node["body"], inner)
+		return
+	case "ConstDecl", "TypeDecl":
+		b.walk(node["expr"], sc)
+		b.walk(node["type"], sc)
+		return
+	case "FuncDecl":
+		lambda, _ := node["lambda"].(map[string]interface{})
+		b.walkParams(sc, lambda["params"], lambda["expr"], sc.lookup(spaceFunc, declName(node)))
+		return
+	case "LambdaExpr":
+		b.walkParams(sc, node["params"], node["expr"], nil)
+		return
+	case "OpDecl":
+		b.walkParams(sc, node["params"], node["body"], sc.lookup(spaceOp, declName(node)))
+		return
+	case "IDExpr":
+		b.walkPath(node, sc)
+		return
+	case "IndexExpr":
+		if _, _, ok := stringIndex(node["index"]); ok {
+			b.walkPath(node, sc)
+			return
+		}
+	case "BinaryExpr":
+		if node["op"] == "." {
+			b.walkPath(node, sc)
+			return
+		}
+	case "FieldElem":
+		name, _ := node["name"].(map[string]interface{})
+		value, _ := name["value"].(string)
+		b.field(value, name["loc"])
+		b.alias(len(b.table.fields) - 1)
+		b.walk(node["value"], sc)
+		return
+	case "SQLAsExpr":
+		b.walk(node["expr"], sc)
+		label, _ := node["label"].(map[string]interface{})
+		if name, _ := label["name"].(string); name != "" {
+			b.field(name, label["loc"])
+			b.alias(len(b.table.fields) - 1)
+		}
+		return
+	case "":
+		// An assignment, lhs := rhs, names the field on its left
+		if lhs, ok := node["lhs"].(map[string]interface{}); ok && node["rhs"] != nil {
+			b.walk(node["rhs"], sc)
+			mark := len(b.table.fields)
+			b.walk(lhs, sc)
+			b.alias(mark)
+			return
+		}
+	case "FuncNameExpr":
+		name, _ := node["name"].(string)
+		b.use(sc, spaceFunc, name, node["loc"])
+		return
+	case "TypeName":
+		name, _ := node["name"].(string)
+		b.use(sc, spaceType, name, node["loc"])
+		return
+	case "CallOp":
+		id, _ := node["name"].(map[string]interface{})
+		name, _ := id["name"].(string)
+		b.use(sc, spaceOp, name, id["loc"])
+	}
+	for _, key := range sortedKeys(node) {
+		b.walk(node[key], sc)
+	}
+}
+
+// walkParams declares params in a scope of their own and resolves body
+// within it. owner, the func or op they belong to, records their names.
+func (b *symbolBuilder) walkParams(sc *scope, params, body interface{}, owner *symbol) {
+	inner := newScope(sc)
+	list, _ := params.([]interface{})
+	for _, param := range list {
+		if sym := b.declare(inner, symbolParam, param, param); sym != nil && owner != nil {
+			owner.params = append(owner.params, sym.name)
+		}
+	}
+	b.walk(body, inner)
+}
+
+// declName returns the name a declaration node declares
+func declName(node map[string]interface{}) string {
+	id, _ := node["name"].(map[string]interface{})
+	name, _ := id["name"].(string)
+	return name
+}
+
+// symbolAt returns the declaration named at pos, whether pos is on the
+// declaration itself or on a use of it
+func (t *symbolTable) symbolAt(pos Position) *symbol {
+	if t == nil {
+		return nil
+	}
+	for _, sym := range t.symbols {
+		if rangeContains(sym.nameRange, pos) {
+			return sym
+		}
+	}
+	for _, ref := range t.refs {
+		if rangeContains(ref.rng, pos) {
+			return ref.target
+		}
+	}
+	return nil
+}
+
+// referencesAt returns the uses of the declaration or field named at pos,
+// in the order they appear, after the declaration itself if includeDecl
+func (t *symbolTable) referencesAt(pos Position, includeDecl bool) []Range {
+	if t == nil {
+		return nil
+	}
+	var decl []Range
+	var uses []Range
+	if sym := t.symbolAt(pos); sym != nil {
+		if includeDecl {
+			decl = append(decl, sym.nameRange)
+		}
+		for _, ref := range t.refs {
+			if ref.target == sym {
+				uses = append(uses, ref.rng)
+			}
+		}
+	} else if path := t.fieldAt(pos); path != "" {
+		for _, f := range t.fields {
+			if f.path == path {
+				uses = append(uses, f.rng)
+			}
+		}
+	}
+	sort.SliceStable(uses, func(i, j int) bool {
+		return positionLess(uses[i].Start, uses[j].Start)
+	})
+	return append(decl, uses...)
+}
+
+// fieldAt returns the path of the field used at pos, or ""
+func (t *symbolTable) fieldAt(pos Position) string {
+	for _, f := range t.fields {
+		if rangeContains(f.rng, pos) {
+			return f.path
+		}
+	}
+	return ""
+}
+
+// rangeContains reports whether pos is within rng, counting its end, so a
+// cursor just past a name is on it
+func rangeContains(rng Range, pos Position) bool {
+	return !positionLess(pos, rng.Start) && !positionLess(rng.End, pos)
+}
+
+// positionLess reports whether a comes before b
+func positionLess(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
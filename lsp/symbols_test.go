@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const symbolsQuery = `const THRESH = 5
+fn double(x): (x * 2)
+op myfilter f, v: ( where f > v )
+type port = uint16
+from data.json
+| myfilter a, THRESH
+| values double(a), cast(1, <port>), x`
+
+// posOf returns the position of the nth occurrence (from 1) of substr in
+// text, offset by delta characters
+func posOf(t *testing.T, text, substr string, n, delta int) Position {
+	t.Helper()
+	offset := -1
+	for i := 0; i < n; i++ {
+		next := strings.Index(text[offset+1:], substr)
+		if next < 0 {
+			t.Fatalf("%q occurs fewer than %d times", substr, n)
+		}
+		offset += next + 1
+	}
+	return positionAt(text, offset+delta)
+}
+
+func TestSymbolTableResolvesUses(t *testing.T) {
+	table := buildSymbolTable(symbolsQuery)
+	if table == nil {
+		t.Fatal("Expected the query to parse")
+	}
+	tests := []struct {
+		use  string
+		n    int
+		name string
+		kind symbolKind
+		decl string // text starting with the declared name
+	}{
+		{"THRESH", 2, "THRESH", symbolConst, "THRESH = 5"},
+		{"double", 2, "double", symbolFunc, "double(x)"},
+		{"myfilter", 2, "myfilter", symbolOp, "myfilter f"},
+		{"port", 2, "port", symbolType, "port ="},
+		{"x * 2", 1, "x", symbolParam, "x):"},
+		{"f > v", 1, "f", symbolParam, "f, v:"},
+	}
+	for _, tt := range tests {
+		sym := table.symbolAt(posOf(t, symbolsQuery, tt.use, tt.n, 0))
+		if sym == nil || sym.name != tt.name || sym.kind != tt.kind {
+			t.Errorf("Expected %s to resolve to %s %s, got %+v", tt.use, tt.kind, tt.name, sym)
+			continue
+		}
+		if sym.nameRange.Start != posOf(t, symbolsQuery, tt.decl, 1, 0) {
+			t.Errorf("Expected %s to be declared at %q, got %+v", tt.name, tt.decl, sym.nameRange)
+		}
+	}
+
+	// The x after the pipeline is a field, not double's parameter
+	if sym := table.symbolAt(posOf(t, symbolsQuery, ", x", 1, 2)); sym != nil {
+		t.Errorf("Expected the field x to resolve to nothing, got %+v", sym)
+	}
+	if got := table.symbolAt(posOf(t, symbolsQuery, "myfilter", 1, 0)); got == nil || strings.Join(got.params, ",") != "f,v" {
+		t.Errorf("Expected myfilter's params f,v, got %+v", got)
+	}
+}
+
+func TestSymbolTableShadowing(t *testing.T) {
+	text := "const a = 1\nop o a: ( values a )\nvalues a | o 2"
+	table := buildSymbolTable(text)
+	// Inside o, a is the parameter
+	if sym := table.symbolAt(posOf(t, text, "values a", 1, 7)); sym == nil || sym.kind != symbolParam {
+		t.Errorf("Expected a inside o to be the parameter, got %+v", sym)
+	}
+	// Outside, the const
+	if sym := table.symbolAt(posOf(t, text, "values a", 2, 7)); sym == nil || sym.kind != symbolConst {
+		t.Errorf("Expected a outside o to be the const, got %+v", sym)
+	}
+}
+
+func TestDefinitionRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///symbols.spq"
+	h.openDocument(t, uri, symbolsQuery)
+
+	response, err := h.ProcessRequest(2, "textDocument/definition", DefinitionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, symbolsQuery, "THRESH", 2, 3),
+	})
+	if err != nil {
+		t.Fatalf("definition failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var loc Location
+	json.Unmarshal(resultBytes, &loc)
+	want := Range{Start: Position{Line: 0, Character: 6}, End: Position{Line: 0, Character: 12}}
+	if loc.URI != uri || loc.Range != want {
+		t.Errorf("Expected %s at %+v, got %s", uri, want, resultBytes)
+	}
+
+	// A builtin has no declaration in the document
+	response, err = h.ProcessRequest(3, "textDocument/definition", DefinitionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, symbolsQuery, "cast", 1, 1),
+	})
+	if err != nil || response.Result != nil {
+		t.Errorf("Expected no definition for a builtin, got %+v %v", response, err)
+	}
+}
+
+func TestReferencesAt(t *testing.T) {
+	text := `const N = 5
+values {a:1, b:{c:N}}
+| where a > N and b.c > 0 -- a in a comment
+| put d := "a" + b.c
+| cut a, d`
+	table := buildSymbolTable(text)
+	tests := []struct {
+		name string
+		at   Position
+		decl bool
+		want []string // text at each reference, as "line:col"
+	}{
+		{"const with declaration", posOf(t, text, "N", 2, 0), true, []string{"0:6", "1:18", "2:12"}},
+		{"const without declaration", posOf(t, text, "N", 1, 0), false, []string{"1:18", "2:12"}},
+		{"field", posOf(t, text, "a > N", 1, 0), true, []string{"1:8", "2:8", "4:6"}},
+		{"nested field", posOf(t, text, "b.c", 2, 2), true, []string{"2:20", "3:19"}},
+		{"keyword", posOf(t, text, "where", 1, 0), true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, rng := range table.referencesAt(tt.at, tt.decl) {
+				got = append(got, fmt.Sprintf("%d:%d", rng.Start.Line, rng.Start.Character))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestReferencesRequest(t *testing.T) {
+	h := NewTestHelper()
+	uri := "file:///symbols.spq"
+	h.openDocument(t, uri, symbolsQuery)
+
+	response, err := h.ProcessRequest(2, "textDocument/references", ReferenceParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     posOf(t, symbolsQuery, "double", 1, 0),
+		Context:      ReferenceContext{IncludeDeclaration: true},
+	})
+	if err != nil {
+		t.Fatalf("references failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var locs []Location
+	json.Unmarshal(resultBytes, &locs)
+	if len(locs) != 2 || locs[0].URI != uri || locs[1].Range.Start != posOf(t, symbolsQuery, "double", 2, 0) {
+		t.Errorf("Expected the declaration and one use of double, got %s", resultBytes)
+	}
+}
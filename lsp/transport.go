@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The LSP base protocol frames each JSON-RPC message with HTTP-style
+// headers. Content-Length is required; Content-Type is optional and
+// defaults to application/vscode-jsonrpc; charset=utf-8.
+
+const contentLengthHeader = "content-length"
+
+// framingError reports a message whose frame was malformed. The stream has
+// been advanced past it; leftover holds any bytes that were read past the
+// bad frame and belong to the next one, and id is the request ID of its
+// body if one could be read.
+type framingError struct {
+	reason   string
+	leftover []byte
+	id       interface{}
+}
+
+func (e *framingError) Error() string {
+	return e.reason
+}
+
+// messageTooLargeError reports a message whose Content-Length exceeded the
+// limit. Its body has been discarded; id is the request ID if one could be
+// recovered from the start of the body, so the client can still be told.
+type messageTooLargeError struct {
+	size  int64
+	limit int64
+	id    interface{}
+}
+
+func (e *messageTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the %d byte limit", e.size, e.limit)
+}
+
+// requestIDPattern finds a request ID near the start of a message body
+var requestIDPattern = regexp.MustCompile(`"id"\s*:\s*("(?:[^"\\]|\\.)*"|-?\d+)`)
+
+// idScanLen is how much of an oversized body is searched for its ID
+const idScanLen = 512
+
+// readMessage reads a JSON-RPC message using the default size limit
+func readMessage(reader *bufio.Reader) (json.RawMessage, error) {
+	return readMessageLimit(reader, defaultMaxMessageSize)
+}
+
+// readMessageLimit reads a JSON-RPC message from the LSP protocol. Unknown
+// headers are ignored. Garbage where headers are expected is skipped up to
+// the next Content-Length header, so one bad write from the client doesn't
+// desynchronize the stream for good. A header block that ends without a
+// valid Content-Length is a framing error rather than a wait for a header
+// that never comes. Bodies larger than limit, and header lines longer than
+// maxHeaderLine, are discarded without being buffered.
+func readMessageLimit(reader *bufio.Reader, limit int64) (json.RawMessage, error) {
+	contentLength := -1
+	charset := ""
+	headers := 0    // header lines since the last garbage
+	badLength := "" // an unusable Content-Length value
+	for {
+		line, err := readHeaderLine(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		// The tail of a message whose Content-Length was too small runs
+		// straight into the next header on the same line
+		if i := indexHeader([]byte(line)); i > 0 {
+			log.Printf("Resynchronizing at header after %d stray bytes", i)
+			line = line[i:]
+			contentLength = -1
+			headers, badLength = 0, ""
+		}
+
+		if line == "" {
+			if contentLength >= 0 {
+				break
+			}
+			if headers == 0 {
+				// Stray blank line between messages
+				continue
+			}
+			reason := "missing Content-Length header"
+			if badLength != "" {
+				reason = fmt.Sprintf("invalid Content-Length %q", badLength)
+			}
+			return nil, skipBody(reader, reason, limit)
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			log.Printf("Skipping malformed header line: %q", truncate(line, 80))
+			contentLength = -1
+			headers, badLength = 0, ""
+			continue
+		}
+		value = strings.TrimSpace(value)
+		headers++
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case contentLengthHeader:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				contentLength = -1
+				badLength = value
+				continue
+			}
+			contentLength = n
+		case "content-type":
+			charset, err = parseContentType(value)
+			if err != nil {
+				log.Printf("Ignoring invalid Content-Type %q: %v", value, err)
+			}
+		default:
+			// The base protocol allows headers we don't know about
+			log.Printf("Ignoring unknown header: %s", name)
+		}
+	}
+
+	if int64(contentLength) > limit {
+		return nil, discardMessage(reader, int64(contentLength), limit)
+	}
+
+	// Read content
+	content := make([]byte, contentLength)
+	_, err := io.ReadFull(reader, content)
+	if err != nil {
+		return nil, fmt.Errorf("reading content: %w", err)
+	}
+
+	if !isUTF8Charset(charset) {
+		return nil, &framingError{reason: fmt.Sprintf("unsupported charset %q", charset)}
+	}
+
+	// A Content-Length that was too large swallows the start of the next
+	// message. Hand those bytes back so the caller can resynchronize.
+	if !json.Valid(content) {
+		if i := indexHeader(content); i >= 0 {
+			return nil, &framingError{
+				reason:   "Content-Length overran the message body",
+				leftover: content[i:],
+			}
+		}
+	}
+
+	return content, nil
+}
+
+// maxHeaderLine is the longest header line read. Headers are short; a
+// longer line is garbage, and buffering it whole would let a peer that
+// never sends a newline grow the server without bound.
+const maxHeaderLine = 4 << 10
+
+// readHeaderLine reads a header line without its line ending. A line
+// longer than maxHeaderLine is discarded up to its newline, a chunk at a
+// time, and is a framing error.
+func readHeaderLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxHeaderLine {
+			size := len(line) + len(chunk)
+			for err == bufio.ErrBufferFull {
+				chunk, err = reader.ReadSlice('\n')
+				size += len(chunk)
+			}
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			return "", &framingError{reason: fmt.Sprintf("header line of %d bytes exceeds the %d byte limit", size, maxHeaderLine)}
+		}
+		line = append(line, chunk...)
+		switch err {
+		case nil:
+			return strings.TrimRight(string(line), "\r\n"), nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return "", err
+		}
+	}
+}
+
+// skipBody skips the body of a frame whose length isn't known, so the
+// stream can go on to the next frame. The body can only be found if it is
+// a JSON object, which is read up to its closing brace; otherwise nothing
+// is skipped and the body is passed over later as garbage. A body longer
+// than limit is an error the stream doesn't recover from.
+func skipBody(reader *bufio.Reader, reason string, limit int64) error {
+	frameErr := &framingError{reason: reason}
+	if b, err := reader.Peek(1); err != nil || b[0] != '{' {
+		return frameErr
+	}
+
+	// Without a length there is no skipping a body that is too large, so
+	// the connection can't go on past one
+	body := io.LimitedReader{R: reader, N: limit}
+	dec := json.NewDecoder(&body)
+	var msg json.RawMessage
+	if err := dec.Decode(&msg); err != nil {
+		if body.N == 0 {
+			return fmt.Errorf("%s, and its body exceeds the %d byte limit", reason, limit)
+		}
+		frameErr.reason = fmt.Sprintf("%s, and its body is unreadable: %v", reason, err)
+		return frameErr
+	}
+	// The decoder reads ahead; what it didn't use starts the next frame
+	frameErr.leftover, _ = io.ReadAll(dec.Buffered())
+
+	var request struct {
+		ID interface{} `json:"id"`
+	}
+	if json.Unmarshal(msg, &request) == nil {
+		frameErr.id = request.ID
+	}
+	return frameErr
+}
+
+// discardMessage skips an oversized body, keeping just enough of its start
+// to find the request ID
+func discardMessage(reader *bufio.Reader, size, limit int64) error {
+	head := make([]byte, min(size, idScanLen))
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return fmt.Errorf("reading content: %w", err)
+	}
+	if _, err := io.CopyN(io.Discard, reader, size-int64(len(head))); err != nil {
+		return fmt.Errorf("reading content: %w", err)
+	}
+
+	tooLarge := &messageTooLargeError{size: size, limit: limit}
+	if m := requestIDPattern.FindSubmatch(head); m != nil {
+		var id interface{}
+		if json.Unmarshal(m[1], &id) == nil {
+			tooLarge.id = id
+		}
+	}
+	return tooLarge
+}
+
+// parseContentType returns the charset from a Content-Type header value,
+// or "" when none is given
+func parseContentType(value string) (string, error) {
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", err
+	}
+	return params["charset"], nil
+}
+
+// isUTF8Charset reports whether charset names UTF-8. "utf8" is accepted for
+// backwards compatibility, as the specification requires.
+func isUTF8Charset(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8":
+		return true
+	}
+	return false
+}
+
+// indexHeader finds the start of a Content-Length header line in b
+func indexHeader(b []byte) int {
+	lower := bytes.ToLower(b)
+	for i := 0; ; {
+		j := bytes.Index(lower[i:], []byte(contentLengthHeader+":"))
+		if j < 0 {
+			return -1
+		}
+		j += i
+		if j == 0 || b[j-1] == '\n' || b[j-1] == '}' {
+			return j
+		}
+		i = j + 1
+	}
+}
+
+// truncate shortens s for log output
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// writeMessage writes a JSON-RPC message to the output
+func writeMessage(out io.Writer, msg interface{}) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	// Write the frame in one call so a partial write can't leave a header
+	// without its body
+	frame := make([]byte, 0, len(content)+32)
+	frame = fmt.Appendf(frame, "Content-Length: %d\r\n\r\n", len(content))
+	frame = append(frame, content...)
+	_, err = out.Write(frame)
+	return err
+}
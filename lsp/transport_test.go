@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const pingBody = `{"jsonrpc":"2.0","id":1,"method":"ping"}`
+
+func frame(body string) string {
+	return "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestReadMessageHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"plain", frame(pingBody)},
+		{"content type", "Content-Length: 40\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n" + pingBody},
+		{"legacy utf8 charset", "Content-Type: application/vscode-jsonrpc; charset=utf8\r\nContent-Length: 40\r\n\r\n" + pingBody},
+		{"lowercase header names", "content-length: 40\r\n\r\n" + pingBody},
+		{"unknown header", "Content-Length: 40\r\nX-Trace-Id: abc\r\n\r\n" + pingBody},
+		{"bare newlines", "Content-Length: 40\n\n" + pingBody},
+		{"stray blank lines", "\r\n\r\n" + frame(pingBody)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := readMessage(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("readMessage failed: %v", err)
+			}
+			if string(msg) != pingBody {
+				t.Errorf("Expected %s, got %s", pingBody, msg)
+			}
+		})
+	}
+}
+
+func TestReadMessageResynchronizes(t *testing.T) {
+	next := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"garbage before header", "hello there\r\n" + frame(next)},
+		{"invalid content length", "Content-Length: abc\r\n\r\n" + pingBody + frame(next)},
+		{"missing content length", "Content-Type: application/vscode-jsonrpc\r\n\r\n" + pingBody + "\r\n" + frame(next)},
+		{"missing content length, no newline", "Content-Type: application/vscode-jsonrpc\r\n\r\n" + pingBody + frame(next)},
+		{"content length too small", "Content-Length: 20\r\n\r\n" + pingBody + frame(next)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			for {
+				msg, err := readMessage(reader)
+				var frameErr *framingError
+				if errors.As(err, &frameErr) {
+					reader = bufio.NewReader(io.MultiReader(bytes.NewReader(frameErr.leftover), reader))
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Expected to recover the next message, got %v", err)
+				}
+				// A too-small length yields a truncated body first
+				if json.Valid(msg) {
+					if string(msg) != next {
+						t.Errorf("Expected %s, got %s", next, msg)
+					}
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessageContentLengthTooLarge(t *testing.T) {
+	next := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+	input := "Content-Length: 60\r\n\r\n" + pingBody + frame(next)
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := readMessage(reader)
+	var frameErr *framingError
+	if !errors.As(err, &frameErr) {
+		t.Fatalf("Expected a framing error, got %v", err)
+	}
+
+	// The swallowed bytes are the start of the next frame; putting them
+	// back recovers it, as Run does
+	reader = bufio.NewReader(io.MultiReader(bytes.NewReader(frameErr.leftover), reader))
+	msg, err := readMessage(reader)
+	if err != nil || string(msg) != next {
+		t.Errorf("Expected %s, got %s (%v)", next, msg, err)
+	}
+}
+
+func TestReadMessageMissingContentLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		reason string
+	}{
+		{"missing", "X-Trace-Id: abc\n\n", "missing Content-Length header"},
+		{"malformed", "Content-Length: 4O\r\n\r\n", `invalid Content-Length "4O"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nothing follows the body, so reading on would hang
+			r, w := io.Pipe()
+			go w.Write([]byte(tt.header + pingBody))
+			_, err := readMessage(bufio.NewReader(r))
+			var frameErr *framingError
+			if !errors.As(err, &frameErr) {
+				t.Fatalf("Expected a framing error, got %v", err)
+			}
+			if frameErr.reason != tt.reason || frameErr.id != float64(1) {
+				t.Errorf("Expected %q for request 1, got %q for %v", tt.reason, frameErr.reason, frameErr.id)
+			}
+		})
+	}
+}
+
+func TestReadMessageUnframedBodyTooLarge(t *testing.T) {
+	big := `{"jsonrpc":"2.0","id":1,"method":"ping","params":"` + strings.Repeat("x", 500) + `"}`
+	_, err := readMessageLimit(bufio.NewReader(strings.NewReader("X-Trace-Id: abc\r\n\r\n"+big)), 200)
+	var frameErr *framingError
+	if err == nil || errors.As(err, &frameErr) {
+		t.Fatalf("Expected the oversized body to end the stream, got %v", err)
+	}
+
+	s := NewServer()
+	s.limits.MaxMessageSize = 200
+	if err := s.Run(strings.NewReader("X-Trace-Id: abc\r\n\r\n"+big), &bytes.Buffer{}); err == nil {
+		t.Error("Expected Run to fail on an oversized unframed body")
+	}
+}
+
+func TestReadMessageHeaderLineTooLong(t *testing.T) {
+	// A line that never ends is discarded as it streams in, not buffered
+	endless := io.LimitReader(infiniteReader('x'), 1<<20)
+	_, err := readMessage(bufio.NewReader(endless))
+	var frameErr *framingError
+	if !errors.As(err, &frameErr) || !strings.Contains(frameErr.reason, "exceeds the 4096 byte limit") {
+		t.Fatalf("Expected the long header line to be rejected, got %v", err)
+	}
+
+	// Once the line ends, the frame after it reads cleanly
+	reader := bufio.NewReader(strings.NewReader("X-Trace-Id: " + strings.Repeat("x", 10000) + "\r\n" + frame(pingBody)))
+	if _, err := readMessage(reader); !errors.As(err, &frameErr) {
+		t.Fatalf("Expected a framing error, got %v", err)
+	}
+	msg, err := readMessage(reader)
+	if err != nil || string(msg) != pingBody {
+		t.Errorf("Expected next message, got %s (%v)", msg, err)
+	}
+}
+
+// infiniteReader reads as an endless run of its byte
+type infiniteReader byte
+
+func (r infiniteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestReadMessageUnsupportedCharset(t *testing.T) {
+	input := "Content-Length: 40\r\nContent-Type: application/vscode-jsonrpc; charset=latin1\r\n\r\n" + pingBody + frame(pingBody)
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	_, err := readMessage(reader)
+	var frameErr *framingError
+	if !errors.As(err, &frameErr) {
+		t.Fatalf("Expected a framing error, got %v", err)
+	}
+
+	// The bad frame was consumed whole, so the next one reads cleanly
+	msg, err := readMessage(reader)
+	if err != nil || string(msg) != pingBody {
+		t.Errorf("Expected next message, got %s (%v)", msg, err)
+	}
+}
+
+func TestRunSurvivesMalformedFrames(t *testing.T) {
+	hover := `{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///x.spq"},"position":{"line":0,"character":0}}}`
+	shutdown := `{"jsonrpc":"2.0","id":3,"method":"shutdown"}`
+	input := "garbage\r\n" +
+		"Content-Length: 80\r\n\r\n" + pingBody + frame(hover) +
+		"Content-Length: 10\r\n\r\n" + pingBody + frame(shutdown)
+
+	out := &bytes.Buffer{}
+	if err := NewServer().Run(strings.NewReader(input), out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	answered := make(map[float64]bool)
+	for _, msg := range drainMessages(t, out) {
+		if id, ok := msg.ID.(float64); ok {
+			answered[id] = true
+		}
+	}
+	if !answered[2] || !answered[3] {
+		t.Errorf("Expected responses to requests 2 and 3 after bad frames, got %v", answered)
+	}
+}
+
+func TestRunAnswersUnframedRequest(t *testing.T) {
+	shutdown := `{"jsonrpc":"2.0","id":3,"method":"shutdown"}`
+	input := "Content-Type: application/vscode-jsonrpc\r\n\r\n" + pingBody + frame(shutdown)
+
+	out := &bytes.Buffer{}
+	if err := NewServer().Run(strings.NewReader(input), out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	msgs := drainMessages(t, out)
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(msgs))
+	}
+	if msgs[0].ID != float64(1) || msgs[0].Error == nil || msgs[0].Error.Code != InvalidRequest {
+		t.Errorf("Expected InvalidRequest for id 1, got %+v", msgs[0])
+	}
+	if msgs[1].ID != float64(3) || msgs[1].Error != nil {
+		t.Errorf("Expected the next request to be handled, got %+v", msgs[1])
+	}
+}
+
+func TestRunRejectsOversizedMessage(t *testing.T) {
+	big := `{"jsonrpc":"2.0","id":9,"method":"textDocument/didOpen","params":{"text":"` +
+		strings.Repeat("x", 500) + `"}}`
+	shutdown := `{"jsonrpc":"2.0","id":10,"method":"shutdown"}`
+
+	s := NewServer()
+	s.limits.MaxMessageSize = 200
+	out := &bytes.Buffer{}
+	if err := s.Run(strings.NewReader(frame(big)+frame(shutdown)), out); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	msgs := drainMessages(t, out)
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(msgs))
+	}
+	if msgs[0].ID != float64(9) || msgs[0].Error == nil || msgs[0].Error.Code != InvalidRequest {
+		t.Errorf("Expected InvalidRequest for id 9, got %+v", msgs[0])
+	}
+	if msgs[1].ID != float64(10) || msgs[1].Error != nil {
+		t.Errorf("Expected the next request to be handled, got %+v", msgs[1])
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Completion telemetry. When the client opts in, completion items carry a
+// superdb.recordCompletion command so the server learns which ones the user
+// accepts, and items accepted before are ranked first. Counts are kept in a
+// local file and never sent anywhere; superdb.exportUsageStats is the only
+// way they leave the server.
+
+// usageStatsVersion is written to the stats file so the format can change
+const usageStatsVersion = 1
+
+// usageStats counts accepted completion items by label
+type usageStats struct {
+	mu       sync.Mutex
+	path     string
+	accepted map[string]int
+}
+
+// usageStatsFile is the on-disk form of usageStats
+type usageStatsFile struct {
+	Version  int            `json:"version"`
+	Accepted map[string]int `json:"accepted"`
+}
+
+// defaultUsageStatsPath is where stats are kept when the client doesn't
+// say: the user's cache directory, outside any workspace
+func defaultUsageStatsPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "superdb-lsp", "completion-usage.json"), nil
+}
+
+// loadUsageStats reads the stats at path, starting empty if there are none
+func loadUsageStats(path string) (*usageStats, error) {
+	u := &usageStats{path: path, accepted: make(map[string]int)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file usageStatsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for label, n := range file.Accepted {
+		u.accepted[label] = n
+	}
+	return u, nil
+}
+
+// record counts one acceptance of label and saves the stats
+func (u *usageStats) record(label string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.accepted[label]++
+	return u.save()
+}
+
+// save writes the stats, replacing the file so a crash can't leave it
+// half written. The caller holds u.mu.
+func (u *usageStats) save() error {
+	data, err := json.MarshalIndent(usageStatsFile{Version: usageStatsVersion, Accepted: u.accepted}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(u.path), 0o755); err != nil {
+		return err
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.path)
+}
+
+// snapshot returns a copy of the counts
+func (u *usageStats) snapshot() map[string]int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := make(map[string]int, len(u.accepted))
+	for label, n := range u.accepted {
+		counts[label] = n
+	}
+	return counts
+}
+
+// rank sorts items the user has accepted before ahead of the rest, most
+// accepted first, and attaches the command that records an acceptance
+func (u *usageStats) rank(items []CompletionItem) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i := range items {
+		item := &items[i]
+		if n := u.accepted[item.Label]; n > 0 {
+			item.SortText = fmt.Sprintf("0%010d%s", math.MaxInt32-n, item.Label)
+		} else if item.SortText != "" {
+			item.SortText = "1" + item.SortText
+		} else {
+			item.SortText = "1" + item.Label
+		}
+		item.Command = &Command{
+			Title:     "Record completion",
+			Command:   CommandRecordCompletion,
+			Arguments: []interface{}{RecordCompletionArgs{Label: item.Label}},
+		}
+	}
+}
+
+// enableUsageStats turns on completion telemetry, keeping stats at path or
+// the default location. Stats that can't be read leave telemetry off.
+func (s *Server) enableUsageStats(path string) {
+	if path == "" {
+		var err error
+		if path, err = defaultUsageStatsPath(); err != nil {
+			log.Printf("Completion telemetry off: %v", err)
+			return
+		}
+	}
+	usage, err := loadUsageStats(path)
+	if err != nil {
+		log.Printf("Completion telemetry off: %v", err)
+		return
+	}
+	log.Printf("Completion telemetry on: %s", path)
+	s.usage = usage
+}
+
+// recordCompletion counts an accepted completion item. It does nothing
+// when telemetry is off, since a client may still run commands attached to
+// items from before.
+func (s *Server) recordCompletion(_ context.Context, args []json.RawMessage) HandlerResult {
+	var params RecordCompletionArgs
+	if len(args) != 1 {
+		return failure(&RPCError{Code: InvalidParams, Message: "expected one argument"})
+	}
+	if err := json.Unmarshal(args[0], &params); err != nil {
+		return invalidParams(err)
+	}
+	if s.usage == nil || params.Label == "" {
+		return success(nil)
+	}
+	if err := s.usage.record(params.Label); err != nil {
+		log.Printf("Error saving completion stats: %v", err)
+	}
+	return success(nil)
+}
+
+// exportUsageStats returns the completion stats, and with a path also
+// writes them into the workspace
+func (s *Server) exportUsageStats(_ context.Context, args []json.RawMessage) HandlerResult {
+	var params ExportUsageStatsArgs
+	if len(args) > 0 {
+		if err := json.Unmarshal(args[0], &params); err != nil {
+			return invalidParams(err)
+		}
+	}
+	if s.usage == nil {
+		return failure(&RPCError{
+			Code:    RequestFailed,
+			Message: "completion telemetry is off; set completionTelemetry in initializationOptions",
+		})
+	}
+
+	result := UsageStatsResult{Accepted: s.usage.snapshot()}
+	if params.Path == "" {
+		return success(result)
+	}
+	path, err := s.workspacePath(params.Path)
+	if err != nil {
+		return failure(&RPCError{Code: RequestFailed, Message: err.Error()})
+	}
+	data, err := json.MarshalIndent(usageStatsFile{Version: usageStatsVersion, Accepted: result.Accepted}, "", "  ")
+	if err != nil {
+		return failure(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return failure(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return failure(err)
+	}
+
+	log.Printf("Exported completion stats to %s", path)
+	result.URI = pathToURI(path)
+	return success(result)
+}
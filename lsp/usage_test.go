@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageStatsRankAcceptedFirst(t *testing.T) {
+	u, err := loadUsageStats(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatalf("loadUsageStats failed: %v", err)
+	}
+	for _, label := range []string{"sum", "sort", "sort"} {
+		if err := u.record(label); err != nil {
+			t.Fatalf("record failed: %v", err)
+		}
+	}
+
+	items := []CompletionItem{{Label: "abs"}, {Label: "sum"}, {Label: "sort"}}
+	u.rank(items)
+	if !(items[2].SortText < items[1].SortText && items[1].SortText < items[0].SortText) {
+		t.Errorf("Expected sort, then sum, then abs; got %q, %q, %q",
+			items[2].SortText, items[1].SortText, items[0].SortText)
+	}
+	for _, item := range items {
+		if item.Command == nil || item.Command.Command != CommandRecordCompletion {
+			t.Errorf("Expected %s to record its acceptance, got %+v", item.Label, item.Command)
+		}
+	}
+}
+
+func TestUsageStatsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "usage.json")
+	u, err := loadUsageStats(path)
+	if err != nil {
+		t.Fatalf("loadUsageStats failed: %v", err)
+	}
+	if err := u.record("count"); err != nil {
+		t.Fatalf("record failed: %v", err)
+	}
+
+	reloaded, err := loadUsageStats(path)
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if got := reloaded.snapshot(); got["count"] != 1 {
+		t.Errorf("Expected count accepted once after reload, got %v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadUsageStats(path); err == nil {
+		t.Error("Expected an error for a corrupt stats file")
+	}
+}
+
+func TestCompletionTelemetryCommands(t *testing.T) {
+	root := t.TempDir()
+	h := NewTestHelper()
+	opts, _ := json.Marshal(InitializationOptions{
+		CompletionTelemetry:     true,
+		CompletionTelemetryPath: filepath.Join(root, "usage.json"),
+	})
+	if _, err := h.ProcessRequest(1, "initialize", InitializeParams{
+		RootURI:               pathToURI(root),
+		InitializationOptions: opts,
+	}); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	uri := "file:///usage.spq"
+	h.openDocument(t, uri, "so")
+
+	// Accept the item the way a client does, by running its command
+	response, err := h.ProcessRequest(2, "textDocument/completion", CompletionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 0, Character: 2},
+	})
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	resultBytes, _ := json.Marshal(response.Result)
+	var list CompletionList
+	json.Unmarshal(resultBytes, &list)
+	var command *Command
+	for _, item := range list.Items {
+		if item.Label == "sort" {
+			command = item.Command
+		}
+	}
+	if command == nil {
+		t.Fatalf("Expected sort to carry a command, got %+v", list.Items)
+	}
+	args := make([]json.RawMessage, len(command.Arguments))
+	for i, arg := range command.Arguments {
+		args[i], _ = json.Marshal(arg)
+	}
+	if _, err := h.ProcessRequest(3, "workspace/executeCommand", ExecuteCommandParams{
+		Command: command.Command, Arguments: args,
+	}); err != nil {
+		t.Fatalf("recordCompletion failed: %v", err)
+	}
+
+	exportArgs, _ := json.Marshal(ExportUsageStatsArgs{Path: "stats/usage.json"})
+	response, err = h.ProcessRequest(4, "workspace/executeCommand", ExecuteCommandParams{
+		Command:   CommandExportUsageStats,
+		Arguments: []json.RawMessage{exportArgs},
+	})
+	if err != nil || response.Error != nil {
+		t.Fatalf("exportUsageStats failed: %v %+v", err, response.Error)
+	}
+	resultBytes, _ = json.Marshal(response.Result)
+	var result UsageStatsResult
+	json.Unmarshal(resultBytes, &result)
+	if result.Accepted["sort"] != 1 || result.URI != pathToURI(filepath.Join(root, "stats", "usage.json")) {
+		t.Errorf("Unexpected export: %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(root, "stats", "usage.json")); err != nil {
+		t.Errorf("Expected the export to be written: %v", err)
+	}
+}
+
+func TestExportUsageStatsRequiresOptIn(t *testing.T) {
+	h := NewTestHelper()
+	response, err := h.ProcessRequest(1, "workspace/executeCommand", ExecuteCommandParams{
+		Command: CommandExportUsageStats,
+	})
+	if err != nil {
+		t.Fatalf("executeCommand failed: %v", err)
+	}
+	if response.Error == nil || response.Error.Code != RequestFailed {
+		t.Errorf("Expected export to fail with telemetry off, got %+v", response)
+	}
+}
@@ -10,6 +10,13 @@ const Version = "0.51231.1"
 // Updated by /sync command
 const SuperCommit = "5ea0cb5d"
 
+// DialectVersions are the versions of the super language the server tells
+// apart, oldest first, which dialectVersion can target. v1.18.0 is the last
+// release of Zed, the language SuperSQL grew from. A sync keeps the commit
+// it replaces before SuperCommit, since builtins are tagged with the
+// version that introduced them (see dialect.go).
+var DialectVersions = []string{"v1.18.0", SuperCommit}
+
 // FullVersion returns version with super commit as semver build metadata
 func FullVersion() string {
 	if SuperCommit != "" {
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Warm-up handling for clients that open many documents at startup.
+//
+// After the initialized notification the server enters a warm-up phase. The
+// first document opened is assumed to be the one the user is looking at and
+// gets its diagnostics published immediately, as usual. Documents opened
+// after it in the same burst are deferred to a bounded pool of background
+// workers so they don't hold up the main loop. Any request that targets a
+// deferred document promotes it, and the phase ends as soon as the user
+// starts interacting or the burst of opens goes quiet.
+
+// warmupQuietPeriod is how long without a didOpen ends the warm-up phase
+const warmupQuietPeriod = 250 * time.Millisecond
+
+// warmupMaxWorkers bounds how many deferred documents are parsed at once
+const warmupMaxWorkers = 4
+
+// warmupCoordinator tracks the warm-up phase and the documents whose
+// diagnostics are still waiting for a background worker
+type warmupCoordinator struct {
+	mu       sync.Mutex
+	active   bool
+	primary  bool // true once the first (visible) document has been opened
+	lastOpen time.Time
+	pending  map[string]bool
+	now      func() time.Time
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newWarmupCoordinator() *warmupCoordinator {
+	workers := runtime.NumCPU()
+	if workers > warmupMaxWorkers {
+		workers = warmupMaxWorkers
+	}
+	return &warmupCoordinator{
+		pending: make(map[string]bool),
+		now:     time.Now,
+		sem:     make(chan struct{}, workers),
+	}
+}
+
+// begin starts the warm-up phase
+func (w *warmupCoordinator) begin() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active = true
+	w.primary = false
+}
+
+// end stops the warm-up phase; documents already deferred stay queued
+func (w *warmupCoordinator) end() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active = false
+}
+
+// deferOpen decides whether diagnostics for a just-opened document should be
+// deferred. When it returns true the document is marked pending and the
+// caller must schedule it.
+func (w *warmupCoordinator) deferOpen(uri string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.active {
+		return false
+	}
+	now := w.now()
+	if w.primary && now.Sub(w.lastOpen) > warmupQuietPeriod {
+		w.active = false
+		return false
+	}
+	w.lastOpen = now
+	if !w.primary {
+		w.primary = true
+		return false
+	}
+	w.pending[uri] = true
+	return true
+}
+
+// claim removes uri from the pending set and reports whether it was there.
+// Whoever claims a document is responsible for its diagnostics.
+func (w *warmupCoordinator) claim(uri string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.pending[uri] {
+		return false
+	}
+	delete(w.pending, uri)
+	return true
+}
+
+// wait blocks until all scheduled background work has finished
+func (w *warmupCoordinator) wait() {
+	w.wg.Wait()
+}
+
+// scheduleDiagnostics publishes diagnostics for a deferred document from a
+// background worker, reading the document's latest text when it runs
+func (s *Server) scheduleDiagnostics(uri string) {
+	w := s.warmup
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.sem <- struct{}{}
+		defer func() { <-w.sem }()
+
+		// Promoted, changed, or closed while queued
+		if !w.claim(uri) {
+			return
+		}
+		s.sendDiagnostics(uri)
+	}()
+}
+
+// promote ends the warm-up phase and, if uri is still waiting for a
+// background worker, publishes its diagnostics right away
+func (s *Server) promote(uri string) {
+	s.warmup.end()
+	if s.warmup.claim(uri) {
+		log.Printf("Promoting deferred diagnostics: %s", uri)
+		s.sendDiagnostics(uri)
+	}
+}
+
+// sendDiagnostics computes diagnostics for the stored document and writes
+// them directly to the client
+func (s *Server) sendDiagnostics(uri string) {
+	text, version, ok := s.document(uri)
+	if !ok {
+		return
+	}
+	msg, err := s.publishDiagnostics(s.requests.documentContext(uri, version), uri, text, version)
+	if err != nil {
+		log.Printf("Error computing diagnostics for %s: %v", uri, err)
+		return
+	}
+	if msg == nil {
+		return
+	}
+	if err := s.send(msg); err != nil {
+		log.Printf("Error sending diagnostics for %s: %v", uri, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Watched files. When the client can register capabilities dynamically,
+// the server asks it after initialized to report changes to .spq and .sup
+// files made outside the editor, such as by git checkout. A changed query
+// that isn't open is indexed again, or dropped from the index when
+// deleted, and the open documents get fresh diagnostics, since what they
+// read or declare may have changed with it.
+
+// watchedFilesRegistration identifies the registration of the watchers
+const watchedFilesRegistration = "superdb-watched-files"
+
+// watchedGlobs are the files the client is asked to watch
+var watchedGlobs = []string{"**/*.spq", "**/*.sup"}
+
+// registerWatchers asks the client to watch query and data files
+func (s *Server) registerWatchers() {
+	watchers := make([]FileSystemWatcher, len(watchedGlobs))
+	for i, glob := range watchedGlobs {
+		watchers[i] = FileSystemWatcher{GlobPattern: glob}
+	}
+	params := RegistrationParams{Registrations: []Registration{{
+		ID:              watchedFilesRegistration,
+		Method:          "workspace/didChangeWatchedFiles",
+		RegisterOptions: DidChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+	}}}
+	err := s.request("client/registerCapability", params, func(msg RPCMessage) {
+		if msg.Error != nil {
+			log.Printf("Client refused file watchers: %s", msg.Error.Message)
+		}
+	})
+	if err != nil {
+		log.Printf("Error registering file watchers: %v", err)
+	}
+}
+
+// handleDidChangeWatchedFiles processes workspace/didChangeWatchedFiles
+// notifications
+func (s *Server) handleDidChangeWatchedFiles(msg RPCMessage) HandlerResult {
+	var params DidChangeWatchedFilesParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+
+	for _, change := range params.Changes {
+		log.Printf("Watched file changed: %s (type=%d)", change.URI, change.Type)
+		if !strings.EqualFold(path.Ext(change.URI), ".spq") {
+			continue
+		}
+		if _, _, open := s.document(change.URI); open {
+			// The editor's text is what counts
+			continue
+		}
+		s.reindex(change)
+	}
+	if len(params.Changes) > 0 {
+		s.republishDiagnostics()
+	}
+	return HandlerResult{}
+}
+
+// reindex brings the index up to date with a change to a query file that
+// isn't open
+func (s *Server) reindex(change FileEvent) {
+	file, err := uriToPath(change.URI)
+	if err != nil || s.folderOf(file) == "" {
+		return
+	}
+	if change.Type == FileChangeTypeDeleted {
+		s.index.remove(change.URI)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Size() > maxIndexedFileSize {
+		s.index.remove(change.URI)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		s.index.remove(change.URI)
+		return
+	}
+	s.index.update(change.URI, string(data))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// uriToPath converts a file:// URI to a local filesystem path
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("not a file URI: %s", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// workspacePath resolves rel against the workspace root, refusing paths
+// that would land outside it
+func (s *Server) workspacePath(rel string) (string, error) {
+	if s.rootPath == "" {
+		return "", fmt.Errorf("no workspace folder is open")
+	}
+	path := filepath.Join(s.rootPath, filepath.FromSlash(rel))
+	inside, err := filepath.Rel(s.rootPath, path)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path is outside the workspace: %s", rel)
+	}
+	return path, nil
+}
+
+// workspaceFolders returns the paths of the open workspace folders, the
+// root from initialize first
+func (s *Server) workspaceFolders() []string {
+	s.foldersMu.RLock()
+	defer s.foldersMu.RUnlock()
+	if len(s.folders) == 0 && s.rootPath != "" {
+		return []string{s.rootPath}
+	}
+	return append([]string(nil), s.folders...)
+}
+
+// folderOf returns the workspace folder holding file, the innermost when
+// folders nest, or "" when none does
+func (s *Server) folderOf(file string) string {
+	best := ""
+	for _, folder := range s.workspaceFolders() {
+		if _, ok := relativeTo(folder, file); ok && len(folder) > len(best) {
+			best = folder
+		}
+	}
+	return best
+}
+
+// folderRelative returns file relative to its workspace folder,
+// slash-separated, or "" when it isn't in one
+func (s *Server) folderRelative(file string) string {
+	folder := s.folderOf(file)
+	if folder == "" {
+		return ""
+	}
+	rel, _ := relativeTo(folder, file)
+	return rel
+}
+
+// relativeTo returns file relative to dir, slash-separated, and false if
+// file isn't below dir
+func relativeTo(dir, file string) (string, bool) {
+	r, err := filepath.Rel(dir, file)
+	if err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(r), true
+}
+
+// setWorkspaceFolders replaces the open workspace folders. The first is
+// also the root that commands writing into the workspace use.
+func (s *Server) setWorkspaceFolders(folders []string) {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	s.folders = folders
+	s.rootPath = ""
+	if len(folders) > 0 {
+		s.rootPath = folders[0]
+	}
+}
+
+// folderPaths returns the local paths of folders, skipping any that
+// aren't file URIs
+func folderPaths(folders []WorkspaceFolder) []string {
+	var paths []string
+	for _, f := range folders {
+		path, err := uriToPath(f.URI)
+		if err != nil {
+			log.Printf("Ignoring workspace folder: %v", err)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// handleDidChangeWorkspaceFolders processes
+// workspace/didChangeWorkspaceFolders notifications: removed folders leave
+// the index and added ones are indexed
+func (s *Server) handleDidChangeWorkspaceFolders(msg RPCMessage) HandlerResult {
+	var params DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return invalidParams(err)
+	}
+	removed := folderPaths(params.Event.Removed)
+	added := folderPaths(params.Event.Added)
+
+	var folders []string
+	for _, folder := range s.workspaceFolders() {
+		if !slices.Contains(removed, folder) && !slices.Contains(added, folder) {
+			folders = append(folders, folder)
+		}
+	}
+	folders = append(folders, added...)
+	s.setWorkspaceFolders(folders)
+	log.Printf("Workspace folders: %v", folders)
+
+	for _, folder := range removed {
+		s.index.forget(folder, s.folderOf)
+	}
+	if s.initialized {
+		for _, folder := range added {
+			s.indexFolder(folder)
+		}
+	}
+	return HandlerResult{}
+}
+
+// indexFolder indexes folder in the background, showing the progress.
+// The index stops when the server does.
+func (s *Server) indexFolder(folder string) {
+	s.index.build(folder, s.startProgress(s.ctx, "Indexing "+filepath.Base(folder), true))
+}
+
+// pathToURI converts a local filesystem path to a file:// URI
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
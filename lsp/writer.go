@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// outboxSize is how many outgoing messages can queue before senders block
+const outboxSize = 64
+
+// messageWriter owns the client connection. Every outgoing message goes
+// through a single goroutine so frames are never interleaved, and
+// diagnostics pass through a per-document version gate on the way out.
+type messageWriter struct {
+	out    io.Writer
+	outbox chan interface{}
+	done   chan struct{}
+	gate   *versionGate
+
+	mu  sync.Mutex
+	err error // first write error; the connection is unusable after it
+
+	closeMu sync.RWMutex // held by senders so close waits for them
+	closed  bool
+}
+
+func newMessageWriter(out io.Writer, gate *versionGate) *messageWriter {
+	w := &messageWriter{
+		out:    out,
+		outbox: make(chan interface{}, outboxSize),
+		done:   make(chan struct{}),
+		gate:   gate,
+	}
+	go w.loop()
+	return w
+}
+
+func (w *messageWriter) loop() {
+	defer close(w.done)
+	for msg := range w.outbox {
+		if done, ok := msg.(chan struct{}); ok {
+			close(done)
+			continue
+		}
+		if w.failed() != nil {
+			continue
+		}
+		if d, ok := msg.(diagnosticsMessage); ok && !w.gate.allow(d.uri, d.version) {
+			continue
+		}
+		if err := writeMessage(w.out, msg); err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+		}
+	}
+}
+
+// send queues msg for writing and returns the first write error seen so
+// far. Messages sent after close are dropped.
+func (w *messageWriter) send(msg interface{}) error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if !w.closed {
+		w.outbox <- msg
+	}
+	return nil
+}
+
+// flush waits until the messages queued so far are written. It returns
+// at once after close.
+func (w *messageWriter) flush() {
+	done := make(chan struct{})
+	w.closeMu.RLock()
+	if w.closed {
+		w.closeMu.RUnlock()
+		return
+	}
+	w.outbox <- done
+	w.closeMu.RUnlock()
+	<-done
+}
+
+// close flushes queued messages and stops the writer
+func (w *messageWriter) close() error {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.outbox)
+	}
+	w.closeMu.Unlock()
+	<-w.done
+	return w.failed()
+}
+
+func (w *messageWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// diagnosticsMessage is a publishDiagnostics notification tagged with the
+// document version it was computed for. It marshals exactly like the
+// embedded RPCMessage.
+type diagnosticsMessage struct {
+	RPCMessage
+	uri     string
+	version int
+}
+
+// versionGate remembers the newest diagnostics version written per document
+// so results computed for an older version can't overwrite newer ones. It
+// also drops results for a version that superseded reports a later
+// version of, even before that version's diagnostics are written.
+type versionGate struct {
+	mu         sync.Mutex
+	published  map[string]int
+	superseded func(uri string, version int) bool
+}
+
+func newVersionGate() *versionGate {
+	return &versionGate{published: make(map[string]int)}
+}
+
+// allow reports whether diagnostics for version may be published and, if
+// so, records it as the newest
+func (g *versionGate) allow(uri string, version int) bool {
+	if g.superseded != nil && g.superseded(uri, version) {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if last, ok := g.published[uri]; ok && version < last {
+		return false
+	}
+	g.published[uri] = version
+	return true
+}
+
+// forget drops the history for uri, e.g. when it is closed and its
+// versions start over on reopen
+func (g *versionGate) forget(uri string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.published, uri)
+}